@@ -0,0 +1,269 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// configBusqueda es la configuración de texto completo en español que ignora tildes (ver migración)
+const configBusqueda = "es_unaccent"
+
+// Límites de la página de productos
+const (
+	LimiteProductosDefecto = 50
+	LimiteProductosMaximo  = 200
+)
+
+// Órdenes disponibles para la búsqueda de productos
+const (
+	OrdenRelevancia = "relevancia"
+	OrdenNombre     = "nombre"
+	OrdenPrecio     = "precio"
+	OrdenSKU        = "sku"
+)
+
+// ErrCursorInvalido indica que el cursor no es válido o no corresponde al orden pedido
+var ErrCursorInvalido = errors.New("cursor inválido")
+
+// FiltroProductos son los criterios de la búsqueda de productos; los vacíos no filtran
+type FiltroProductos struct {
+	Texto       string
+	CategoriaID uint // incluye las subcategorías
+	ProveedorID uint
+	Estado      *bool
+	PrecioMin   *float64
+	PrecioMax   *float64
+	Orden       string // relevancia (con texto), nombre, precio o sku
+	Descendente bool
+	Limite      int
+	Cursor      string
+	Todos       bool // entrega todos los productos en una sola página, sin límite ni cursor
+}
+
+// PaginaProductos es una página de la búsqueda. Total cuenta todos los productos que cumplen los
+// filtros; Siguiente es el cursor de la página siguiente y viene vacío en la última
+type PaginaProductos struct {
+	Productos []modelos.Producto `json:"productos"`
+	Total     int64              `json:"total"`
+	Limite    int                `json:"limite"`
+	Siguiente string             `json:"siguiente,omitempty"`
+}
+
+// cursorProductos es la posición del último producto entregado en el orden de la búsqueda
+type cursorProductos struct {
+	Orden string      `json:"o"`
+	Desc  bool        `json:"d"`
+	Valor interface{} `json:"v,omitempty"`
+	SKU   string      `json:"s"`
+}
+
+// BuscarProductos busca productos por texto (nombre y descripción, con raíces en español y sin
+// distinguir tildes) y filtros, con paginación por cursor. El SKU también calza por prefijo
+func BuscarProductos(db *gorm.DB, f FiltroProductos) (*PaginaProductos, error) {
+	if err := completarFiltroProductos(&f); err != nil {
+		return nil, err
+	}
+	expresion, err := expresionOrdenProductos(f.Orden)
+	if err != nil {
+		return nil, err
+	}
+	query, err := filtrarProductos(db, f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Orden estable: el SKU desempata y siempre va ascendente
+	comparador, direccion := ">", "ASC"
+	if f.Descendente {
+		comparador, direccion = "<", "DESC"
+	}
+	parametros := map[string]interface{}{"texto": f.Texto}
+	orden := "productos.sku " + direccion
+	if expresion != "" {
+		orden = expresion + " " + direccion + ", productos.sku ASC"
+	}
+
+	if f.Todos {
+		// Sin paginar se cargan los productos directo, sin pasar por la lista de SKU
+		pagina := &PaginaProductos{Productos: []modelos.Producto{}}
+		if err := query.Preload("Proveedor").Preload("Categoria").
+			Preload("Imagenes", func(db *gorm.DB) *gorm.DB { return db.Order("orden, id") }).
+			Clauses(clause.OrderBy{Expression: clause.NamedExpr{SQL: orden, Vars: []interface{}{parametros}}}).
+			Find(&pagina.Productos).Error; err != nil {
+			return nil, err
+		}
+		for _, p := range pagina.Productos {
+			completarURLsImagenes(p.Imagenes)
+		}
+		pagina.Total = int64(len(pagina.Productos))
+		pagina.Limite = len(pagina.Productos)
+		return pagina, nil
+	}
+
+	pagina := &PaginaProductos{Productos: []modelos.Producto{}, Limite: f.Limite}
+	if err := query.Session(&gorm.Session{}).Count(&pagina.Total).Error; err != nil {
+		return nil, err
+	}
+	if f.Cursor != "" {
+		cursor, err := leerCursorProductos(f.Cursor)
+		if err != nil || cursor.Orden != f.Orden || cursor.Desc != f.Descendente || (expresion != "") != (cursor.Valor != nil) {
+			return nil, ErrCursorInvalido
+		}
+		if expresion == "" {
+			query = query.Where("productos.sku "+comparador+" ?", cursor.SKU)
+		} else {
+			query = query.Where(fmt.Sprintf("(%s %s @valor OR (%s = @valor AND productos.sku > @sku))", expresion, comparador, expresion),
+				map[string]interface{}{"texto": f.Texto, "valor": cursor.Valor, "sku": cursor.SKU})
+		}
+	}
+
+	var filas []struct {
+		SKU   string
+		Valor interface{}
+	}
+	seleccion := "productos.sku, NULL AS valor"
+	if expresion != "" {
+		seleccion = "productos.sku, " + expresion + " AS valor"
+	}
+	if err := query.Clauses(
+		clause.Select{Expression: clause.NamedExpr{SQL: seleccion, Vars: []interface{}{parametros}}},
+		clause.OrderBy{Expression: clause.NamedExpr{SQL: orden, Vars: []interface{}{parametros}}},
+	).
+		Limit(f.Limite + 1).
+		Scan(&filas).Error; err != nil {
+		return nil, err
+	}
+	if len(filas) == 0 {
+		return pagina, nil
+	}
+
+	hayMas := len(filas) > f.Limite
+	if hayMas {
+		filas = filas[:f.Limite]
+	}
+	skus := make([]string, 0, len(filas))
+	for _, fila := range filas {
+		skus = append(skus, fila.SKU)
+	}
+	var productos []modelos.Producto
+	if err := db.Preload("Proveedor").Preload("Categoria").
+		Preload("Imagenes", func(db *gorm.DB) *gorm.DB { return db.Order("orden, id") }).
+		Where("sku IN ?", skus).
+		Find(&productos).Error; err != nil {
+		return nil, err
+	}
+	porSKU := make(map[string]modelos.Producto, len(productos))
+	for _, p := range productos {
+		completarURLsImagenes(p.Imagenes)
+		porSKU[p.SKU] = p
+	}
+	for _, sku := range skus {
+		if p, ok := porSKU[sku]; ok {
+			pagina.Productos = append(pagina.Productos, p)
+		}
+	}
+
+	if hayMas {
+		ultima := filas[len(filas)-1]
+		siguiente, err := escribirCursorProductos(cursorProductos{Orden: f.Orden, Desc: f.Descendente, Valor: ultima.Valor, SKU: ultima.SKU})
+		if err != nil {
+			return nil, err
+		}
+		pagina.Siguiente = siguiente
+	}
+	return pagina, nil
+}
+
+// completarFiltroProductos acota el límite y elige el orden por defecto: relevancia si hay texto,
+// si no, nombre
+func completarFiltroProductos(f *FiltroProductos) error {
+	if f.Limite <= 0 {
+		f.Limite = LimiteProductosDefecto
+	}
+	if f.Limite > LimiteProductosMaximo {
+		f.Limite = LimiteProductosMaximo
+	}
+	if f.Orden == "" {
+		f.Orden = OrdenNombre
+		if f.Texto != "" {
+			f.Orden = OrdenRelevancia
+		}
+	}
+	if f.Orden == OrdenRelevancia && f.Texto == "" {
+		return errors.New("el orden por relevancia requiere un texto de búsqueda")
+	}
+	return nil
+}
+
+// expresionOrdenProductos es la expresión SQL por la que se ordena cada producto; el orden por
+// SKU no necesita otra
+func expresionOrdenProductos(orden string) (string, error) {
+	switch orden {
+	case OrdenRelevancia:
+		// Se pasa a float8 para que el valor del cursor se compare exacto
+		return fmt.Sprintf("ts_rank(productos.busqueda, websearch_to_tsquery('%s', @texto))::float8", configBusqueda), nil
+	case OrdenNombre:
+		return "productos.nombre", nil
+	case OrdenPrecio:
+		return "productos.precio", nil
+	case OrdenSKU:
+		return "", nil
+	}
+	return "", fmt.Errorf("orden inválido: %s", orden)
+}
+
+// filtrarProductos arma la consulta de los productos que cumplen los filtros. Se puede reutilizar
+// para varias consultas
+func filtrarProductos(db *gorm.DB, f FiltroProductos) (*gorm.DB, error) {
+	query := db.Model(&modelos.Producto{})
+	if f.Texto != "" {
+		query = query.Where(fmt.Sprintf("(productos.busqueda @@ websearch_to_tsquery('%s', @texto) OR productos.sku ILIKE @prefijo)", configBusqueda),
+			map[string]interface{}{"texto": f.Texto, "prefijo": f.Texto + "%"})
+	}
+	if f.CategoriaID != 0 {
+		arbol, err := cargarArbolCategorias(db)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("productos.categoria_id IN ?", arbol.descendientes(f.CategoriaID))
+	}
+	if f.ProveedorID != 0 {
+		query = query.Where("productos.proveedor_id = ?", f.ProveedorID)
+	}
+	if f.Estado != nil {
+		query = query.Where("productos.estado = ?", *f.Estado)
+	}
+	if f.PrecioMin != nil {
+		query = query.Where("productos.precio >= ?", *f.PrecioMin)
+	}
+	if f.PrecioMax != nil {
+		query = query.Where("productos.precio <= ?", *f.PrecioMax)
+	}
+	return query.Session(&gorm.Session{}), nil
+}
+
+func escribirCursorProductos(c cursorProductos) (string, error) {
+	datos, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(datos), nil
+}
+
+func leerCursorProductos(valor string) (*cursorProductos, error) {
+	datos, err := base64.RawURLEncoding.DecodeString(valor)
+	if err != nil {
+		return nil, err
+	}
+	var c cursorProductos
+	if err := json.Unmarshal(datos, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,324 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Destino de los productos de una categoría eliminada
+const (
+	CategoriaProductosAlPadre = "padre" // pasan a la categoría padre (sin categoría si era raíz)
+	CategoriaProductosSinCat  = "nulo"  // quedan sin categoría
+)
+
+// ErrCategoriaNoEncontrada indica que la categoría pedida no existe
+var ErrCategoriaNoEncontrada = errors.New("categoría no encontrada")
+
+// ErrCategoriaConPromociones indica que la categoría tiene promociones activas o por comenzar
+var ErrCategoriaConPromociones = errors.New("la categoría tiene promociones activas")
+
+// NodoCategoria es una categoría dentro del árbol, con la cantidad de productos asignados
+// directamente y la que incluye a todas sus descendientes
+type NodoCategoria struct {
+	ID             uint            `json:"id"`
+	Nombre         string          `json:"nombre"`
+	PadreID        *uint           `json:"padre_id"`
+	Productos      int             `json:"productos"`
+	ProductosTotal int             `json:"productos_total"`
+	Hijas          []NodoCategoria `json:"hijas"`
+}
+
+// GetCategorias obtiene todas las categorías, sin anidar
+func GetCategorias(db *gorm.DB) ([]modelos.Categoria, error) {
+	var categorias []modelos.Categoria
+	if err := db.Order("nombre").Find(&categorias).Error; err != nil {
+		return nil, err
+	}
+	return categorias, nil
+}
+
+// GetCategoriaByID obtiene una categoría con sus hijas directas
+func GetCategoriaByID(db *gorm.DB, id uint) (*modelos.Categoria, error) {
+	var categoria modelos.Categoria
+	err := db.Preload("Hijas", func(db *gorm.DB) *gorm.DB { return db.Order("nombre") }).
+		First(&categoria, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCategoriaNoEncontrada
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &categoria, nil
+}
+
+// CreateCategoria crea una categoría, como raíz o bajo la categoría padre indicada
+func CreateCategoria(db *gorm.DB, categoria *modelos.Categoria) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		categoria.ID = 0
+		if err := validarCategoria(tx, categoria); err != nil {
+			return err
+		}
+		return tx.Omit("Hijas").Create(categoria).Error
+	})
+}
+
+// UpdateCategoria cambia el nombre y el padre de una categoría; al cambiar el padre se mueve con
+// todo su subárbol
+func UpdateCategoria(db *gorm.DB, id uint, actualizada *modelos.Categoria) (*modelos.Categoria, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		actualizada.ID = id
+		if err := validarCategoria(tx, actualizada); err != nil {
+			return err
+		}
+		return tx.Model(&modelos.Categoria{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"nombre":   actualizada.Nombre,
+			"padre_id": actualizada.PadreID,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetCategoriaByID(db, id)
+}
+
+// MoverCategoria cuelga una categoría, con todo su subárbol, de otro padre (nil la deja como raíz)
+func MoverCategoria(db *gorm.DB, id uint, padreID *uint) (*modelos.Categoria, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		arbol, err := cargarArbolCategorias(tx.Clauses(clause.Locking{Strength: "UPDATE"}))
+		if err != nil {
+			return err
+		}
+		if err := arbol.validarPadre(id, padreID); err != nil {
+			return err
+		}
+		return tx.Model(&modelos.Categoria{}).Where("id = ?", id).Update("padre_id", padreID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetCategoriaByID(db, id)
+}
+
+// DeleteCategoria elimina una categoría. Sus hijas pasan a colgar de su padre y sus productos y
+// productos padre van al padre o quedan sin categoría según el modo (por defecto al padre). No se
+// elimina mientras tenga promociones activas que no hayan terminado; las inactivas o vencidas se
+// eliminan con ella
+func DeleteCategoria(db *gorm.DB, id uint, modo string) error {
+	if modo == "" {
+		modo = CategoriaProductosAlPadre
+	}
+	if modo != CategoriaProductosAlPadre && modo != CategoriaProductosSinCat {
+		return fmt.Errorf("destino de productos inválido: %s", modo)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var categoria modelos.Categoria
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&categoria, id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCategoriaNoEncontrada
+		}
+		if err != nil {
+			return err
+		}
+
+		var promociones int64
+		if err := tx.Model(&modelos.Promocion{}).
+			Where("categoria_id = ? AND activa = ? AND (fin IS NULL OR fin > ?)", id, true, time.Now()).
+			Count(&promociones).Error; err != nil {
+			return err
+		}
+		if promociones > 0 {
+			return ErrCategoriaConPromociones
+		}
+
+		if err := tx.Model(&modelos.Categoria{}).
+			Where("padre_id = ?", id).
+			Update("padre_id", categoria.PadreID).Error; err != nil {
+			return err
+		}
+
+		var destino *uint
+		if modo == CategoriaProductosAlPadre {
+			destino = categoria.PadreID
+		}
+		if err := tx.Model(&modelos.Producto{}).
+			Where("categoria_id = ?", id).
+			Updates(map[string]interface{}{
+				"categoria_id": destino,
+				"version":      gorm.Expr("version + 1"),
+			}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&modelos.ProductoPadre{}).
+			Where("categoria_id = ?", id).
+			Update("categoria_id", destino).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&modelos.Categoria{}, id).Error
+	})
+}
+
+// GetArbolCategorias arma el árbol de categorías con la cantidad de productos de cada nodo. Con
+// raizID devuelve solo ese subárbol
+func GetArbolCategorias(db *gorm.DB, raizID uint) ([]NodoCategoria, error) {
+	var categorias []modelos.Categoria
+	if err := db.Order("nombre").Find(&categorias).Error; err != nil {
+		return nil, err
+	}
+	var conteos []struct {
+		CategoriaID uint
+		Productos   int
+	}
+	if err := db.Model(&modelos.Producto{}).
+		Select("categoria_id, COUNT(*) AS productos").
+		Where("categoria_id IS NOT NULL").
+		Group("categoria_id").
+		Scan(&conteos).Error; err != nil {
+		return nil, err
+	}
+	productos := make(map[uint]int, len(conteos))
+	for _, c := range conteos {
+		productos[c.CategoriaID] = c.Productos
+	}
+
+	hijas := make(map[uint][]modelos.Categoria)
+	var raices []modelos.Categoria
+	encontrada := raizID == 0
+	for _, c := range categorias {
+		if c.ID == raizID {
+			raices = append(raices, c)
+			encontrada = true
+		}
+		if c.PadreID == nil {
+			if raizID == 0 {
+				raices = append(raices, c)
+			}
+			continue
+		}
+		hijas[*c.PadreID] = append(hijas[*c.PadreID], c)
+	}
+	if !encontrada {
+		return nil, ErrCategoriaNoEncontrada
+	}
+
+	var armar func(c modelos.Categoria) NodoCategoria
+	armar = func(c modelos.Categoria) NodoCategoria {
+		nodo := NodoCategoria{
+			ID:             c.ID,
+			Nombre:         c.Nombre,
+			PadreID:        c.PadreID,
+			Productos:      productos[c.ID],
+			ProductosTotal: productos[c.ID],
+			Hijas:          []NodoCategoria{},
+		}
+		for _, h := range hijas[c.ID] {
+			hija := armar(h)
+			nodo.ProductosTotal += hija.ProductosTotal
+			nodo.Hijas = append(nodo.Hijas, hija)
+		}
+		return nodo
+	}
+	arbol := make([]NodoCategoria, 0, len(raices))
+	for _, r := range raices {
+		arbol = append(arbol, armar(r))
+	}
+	return arbol, nil
+}
+
+// validarCategoria normaliza el nombre y revisa que sea único y que el padre exista sin formar
+// un ciclo
+func validarCategoria(tx *gorm.DB, categoria *modelos.Categoria) error {
+	categoria.Nombre = strings.TrimSpace(categoria.Nombre)
+	if categoria.Nombre == "" {
+		return errors.New("el nombre de la categoría es obligatorio")
+	}
+	var repetidas int64
+	if err := tx.Model(&modelos.Categoria{}).
+		Where("LOWER(nombre) = LOWER(?) AND id <> ?", categoria.Nombre, categoria.ID).
+		Count(&repetidas).Error; err != nil {
+		return err
+	}
+	if repetidas > 0 {
+		return fmt.Errorf("ya existe una categoría llamada %s", categoria.Nombre)
+	}
+
+	arbol, err := cargarArbolCategorias(tx.Clauses(clause.Locking{Strength: "UPDATE"}))
+	if err != nil {
+		return err
+	}
+	if categoria.ID == 0 {
+		if categoria.PadreID != nil {
+			if _, ok := arbol[*categoria.PadreID]; !ok {
+				return errors.New("la categoría padre no existe")
+			}
+		}
+		return nil
+	}
+	return arbol.validarPadre(categoria.ID, categoria.PadreID)
+}
+
+// arbolCategorias relaciona cada categoría con su padre (nil para las raíces)
+type arbolCategorias map[uint]*uint
+
+// cargarArbolCategorias lee la relación padre de todas las categorías
+func cargarArbolCategorias(db *gorm.DB) (arbolCategorias, error) {
+	var categorias []modelos.Categoria
+	if err := db.Select("id", "padre_id").Find(&categorias).Error; err != nil {
+		return nil, err
+	}
+	arbol := make(arbolCategorias, len(categorias))
+	for _, c := range categorias {
+		arbol[c.ID] = c.PadreID
+	}
+	return arbol, nil
+}
+
+// validarPadre revisa que la categoría exista y que el nuevo padre exista y no sea ella misma ni
+// una de sus descendientes
+func (a arbolCategorias) validarPadre(id uint, padreID *uint) error {
+	if _, ok := a[id]; !ok {
+		return ErrCategoriaNoEncontrada
+	}
+	if padreID == nil {
+		return nil
+	}
+	if _, ok := a[*padreID]; !ok {
+		return errors.New("la categoría padre no existe")
+	}
+	for _, ancestro := range a.linaje(padreID) {
+		if ancestro == id {
+			return errors.New("una categoría no puede moverse bajo sí misma ni bajo una de sus descendientes")
+		}
+	}
+	return nil
+}
+
+// linaje devuelve la categoría y sus ancestros, de la más específica a la raíz
+func (a arbolCategorias) linaje(id *uint) []uint {
+	var resultado []uint
+	for actual := id; actual != nil && len(resultado) <= len(a); actual = a[*actual] {
+		resultado = append(resultado, *actual)
+	}
+	return resultado
+}
+
+// descendientes devuelve la categoría y todas sus descendientes
+func (a arbolCategorias) descendientes(id uint) []uint {
+	hijas := make(map[uint][]uint)
+	for c, padre := range a {
+		if padre != nil {
+			hijas[*padre] = append(hijas[*padre], c)
+		}
+	}
+	resultado := []uint{id}
+	for i := 0; i < len(resultado) && len(resultado) <= len(a)+1; i++ {
+		resultado = append(resultado, hijas[resultado[i]]...)
+	}
+	return resultado
+}
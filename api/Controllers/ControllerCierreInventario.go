@@ -0,0 +1,237 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StockAFecha es el saldo de un SKU en una sucursal al cierre de un día
+type StockAFecha struct {
+	SKU        string    `json:"sku"`
+	Nombre     string    `json:"nombre"`
+	SucursalID uint      `json:"sucursal_id"`
+	Sucursal   string    `json:"sucursal"`
+	Cantidad   int       `json:"cantidad"`
+	AsOf       time.Time `json:"as_of"`
+	Fuente     string    `json:"fuente"` // kardex, o el cierre mensual desde el que se calculó
+	CierreID   *uint     `json:"cierre_id,omitempty"`
+}
+
+// GetStockAFecha reconstruye el stock al final del día indicado. Si hay un cierre mensual en o
+// antes de esa fecha se parte de sus cifras congeladas y se suman los movimientos posteriores al
+// corte; si no, se descuentan del stock actual los movimientos posteriores a la fecha.
+// sucursalID y sku son filtros opcionales.
+func GetStockAFecha(db *gorm.DB, fecha time.Time, sucursalID uint, sku string) ([]StockAFecha, error) {
+	if fecha.After(time.Now()) {
+		return nil, errors.New("la fecha no puede ser futura")
+	}
+	hasta := fecha.AddDate(0, 0, 1)
+
+	var cierre modelos.CierreInventario
+	err := db.Where("fecha_corte <= ?", hasta).Order("fecha_corte DESC").First(&cierre).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var resultado []StockAFecha
+	if err == nil {
+		resultado, err = stockDesdeCierre(db, &cierre, hasta, sucursalID, sku)
+	} else {
+		resultado, err = stockDesdeKardex(db, hasta, sucursalID, sku)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range resultado {
+		resultado[i].AsOf = fecha
+	}
+	sort.Slice(resultado, func(i, j int) bool {
+		if resultado[i].SucursalID != resultado[j].SucursalID {
+			return resultado[i].SucursalID < resultado[j].SucursalID
+		}
+		return resultado[i].SKU < resultado[j].SKU
+	})
+	return resultado, nil
+}
+
+func stockDesdeCierre(db *gorm.DB, cierre *modelos.CierreInventario, hasta time.Time, sucursalID uint, sku string) ([]StockAFecha, error) {
+	fuente := fmt.Sprintf("cierre %s", cierre.Periodo)
+	if cierre.FechaCorte.Before(hasta) {
+		fuente += " + kardex"
+	}
+	id := cierre.ID
+
+	var lineas []modelos.CierreInventarioLinea
+	query := db.Where("cierre_id = ?", cierre.ID)
+	if sucursalID != 0 {
+		query = query.Where("sucursal_id = ?", sucursalID)
+	}
+	if sku != "" {
+		query = query.Where("sku = ?", sku)
+	}
+	if err := query.Find(&lineas).Error; err != nil {
+		return nil, err
+	}
+	porStock := make(map[claveStock]*StockAFecha, len(lineas))
+	for _, l := range lineas {
+		porStock[claveStock{SKU: l.SKU, SucursalID: l.SucursalID}] = &StockAFecha{
+			SKU:        l.SKU,
+			Nombre:     l.Nombre,
+			SucursalID: l.SucursalID,
+			Sucursal:   l.Sucursal,
+			Cantidad:   l.Cantidad,
+			Fuente:     fuente,
+			CierreID:   &id,
+		}
+	}
+
+	var movimientos []struct {
+		SKU        string
+		SucursalID uint
+		Nombre     string
+		Sucursal   string
+		Cantidad   int
+	}
+	query = db.Table("movimientos_stock AS m").
+		Select("m.sku, m.sucursal_id, p.nombre, su.nombre AS sucursal, SUM(m.cantidad) AS cantidad").
+		Joins("LEFT JOIN productos p ON p.sku = m.sku").
+		Joins("LEFT JOIN sucursales su ON su.id = m.sucursal_id").
+		Where("m.fecha >= ? AND m.fecha < ?", cierre.FechaCorte, hasta).
+		Group("m.sku, m.sucursal_id, p.nombre, su.nombre")
+	if sucursalID != 0 {
+		query = query.Where("m.sucursal_id = ?", sucursalID)
+	}
+	if sku != "" {
+		query = query.Where("m.sku = ?", sku)
+	}
+	if err := query.Scan(&movimientos).Error; err != nil {
+		return nil, err
+	}
+	for _, m := range movimientos {
+		clave := claveStock{SKU: m.SKU, SucursalID: m.SucursalID}
+		s, ok := porStock[clave]
+		if !ok {
+			s = &StockAFecha{SKU: m.SKU, Nombre: m.Nombre, SucursalID: m.SucursalID, Sucursal: m.Sucursal, Fuente: fuente, CierreID: &id}
+			porStock[clave] = s
+		}
+		s.Cantidad += m.Cantidad
+	}
+
+	resultado := make([]StockAFecha, 0, len(porStock))
+	for _, s := range porStock {
+		resultado = append(resultado, *s)
+	}
+	return resultado, nil
+}
+
+func stockDesdeKardex(db *gorm.DB, hasta time.Time, sucursalID uint, sku string) ([]StockAFecha, error) {
+	var resultado []StockAFecha
+	query := db.Table("stock_sucursal AS s").
+		Select(`s.sku, p.nombre, s.sucursal_id, su.nombre AS sucursal,
+			s.cantidad - COALESCE(m.posterior, 0) AS cantidad, 'kardex' AS fuente`).
+		Joins("JOIN productos p ON p.sku = s.sku").
+		Joins("JOIN sucursales su ON su.id = s.sucursal_id").
+		Joins(`LEFT JOIN (
+			SELECT sku, sucursal_id, MIN(fecha) AS primera,
+				SUM(CASE WHEN fecha >= ? THEN cantidad ELSE 0 END) AS posterior
+			FROM movimientos_stock
+			GROUP BY sku, sucursal_id
+		) m ON m.sku = s.sku AND m.sucursal_id = s.sucursal_id`, hasta).
+		// Los registros cuyo primer movimiento es posterior a la fecha todavía no existían
+		Where("m.primera IS NULL OR m.primera < ?", hasta)
+	if sucursalID != 0 {
+		query = query.Where("s.sucursal_id = ?", sucursalID)
+	}
+	if sku != "" {
+		query = query.Where("s.sku = ?", sku)
+	}
+	if err := query.Scan(&resultado).Error; err != nil {
+		return nil, err
+	}
+	return resultado, nil
+}
+
+// GetCierresInventario lista los cierres mensuales sin sus líneas
+func GetCierresInventario(db *gorm.DB) ([]modelos.CierreInventario, error) {
+	var cierres []modelos.CierreInventario
+	if err := db.Order("periodo DESC").Find(&cierres).Error; err != nil {
+		return nil, err
+	}
+	return cierres, nil
+}
+
+// GetCierreInventarioByID obtiene un cierre con su detalle por SKU y sucursal
+func GetCierreInventarioByID(db *gorm.DB, id uint) (*modelos.CierreInventario, error) {
+	var cierre modelos.CierreInventario
+	if err := db.Preload("Lineas", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sucursal_id, sku")
+	}).First(&cierre, id).Error; err != nil {
+		return nil, err
+	}
+	return &cierre, nil
+}
+
+// CreateCierreInventario cierra un mes (AAAA-MM): valoriza el inventario a su último día con el
+// método vigente y guarda el resultado. Los meses se cierran en orden y solo cuando ya terminaron
+func CreateCierreInventario(db *gorm.DB, periodo, usuario string) (*modelos.CierreInventario, error) {
+	inicio, err := time.ParseInLocation("2006-01", periodo, time.Local)
+	if err != nil {
+		return nil, errors.New("período inválido, use el formato AAAA-MM")
+	}
+	corte := inicio.AddDate(0, 1, 0)
+	if corte.After(time.Now()) {
+		return nil, fmt.Errorf("el mes %s aún no termina", periodo)
+	}
+
+	var cierre modelos.CierreInventario
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var ultimo modelos.CierreInventario
+		err := tx.Order("fecha_corte DESC").First(&ultimo).Error
+		if err == nil && !ultimo.FechaCorte.Before(corte) {
+			return fmt.Errorf("ya existe el cierre de %s; los meses se cierran en orden", ultimo.Periodo)
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		reporte, err := GetValorizacion(tx, corte.AddDate(0, 0, -1), MetodoValorizacion(), 0, 0)
+		if err != nil {
+			return err
+		}
+
+		cierre = modelos.CierreInventario{
+			Periodo:    periodo,
+			FechaCorte: corte,
+			Metodo:     reporte.Metodo,
+			TotalValor: reporte.Total,
+			Usuario:    usuario,
+			Fecha:      time.Now(),
+			Lineas:     make([]modelos.CierreInventarioLinea, 0, len(reporte.Lineas)),
+		}
+		for _, l := range reporte.Lineas {
+			cierre.TotalUnidades += l.Cantidad
+			cierre.Lineas = append(cierre.Lineas, modelos.CierreInventarioLinea{
+				SKU:           l.SKU,
+				SucursalID:    l.SucursalID,
+				Nombre:        l.Nombre,
+				Sucursal:      l.Sucursal,
+				CategoriaID:   l.CategoriaID,
+				Categoria:     l.Categoria,
+				Cantidad:      l.Cantidad,
+				CostoUnitario: l.CostoUnitario,
+				Valor:         l.Valor,
+			})
+		}
+		return tx.Create(&cierre).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cierre, nil
+}
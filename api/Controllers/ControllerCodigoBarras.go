@@ -0,0 +1,317 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/phpdave11/gofpdf"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrCodigoBarrasNoEncontrado indica que ningún producto tiene el código de barras pedido
+var ErrCodigoBarrasNoEncontrado = errors.New("código de barras no encontrado")
+
+// Hoja de etiquetas A4 de 3 columnas por 8 filas de 70 x 36 mm, la más común para adhesivos
+const (
+	etiquetaColumnas = 3
+	etiquetaFilas    = 8
+	etiquetaAncho    = 70.0
+	etiquetaAlto     = 36.0
+	etiquetaMargen   = 3.0
+	// EtiquetasMaximas limita el total de etiquetas de una solicitud
+	EtiquetasMaximas = 2000
+)
+
+// EtiquetaProducto pide las etiquetas de un producto; sin copias se imprime una
+type EtiquetaProducto struct {
+	SKU    string `json:"sku" binding:"required"`
+	Copias int    `json:"copias" binding:"min=0"`
+}
+
+// SolicitudEtiquetas es la selección de productos a imprimir. Inicio es la posición de la primera
+// etiqueta en la hoja (desde 0, por filas), para aprovechar hojas ya empezadas
+type SolicitudEtiquetas struct {
+	Productos []EtiquetaProducto `json:"productos" binding:"required,min=1,dive"`
+	Inicio    int                `json:"inicio" binding:"min=0"`
+}
+
+// GetCodigosBarrasProducto lista los códigos de barras de un producto, el principal primero
+func GetCodigosBarrasProducto(db *gorm.DB, sku string) ([]modelos.CodigoBarras, error) {
+	var producto modelos.Producto
+	if err := db.Select("sku").First(&producto, "sku = ?", sku).Error; err != nil {
+		return nil, errors.New("producto no encontrado")
+	}
+	var codigos []modelos.CodigoBarras
+	if err := db.Where("sku = ?", sku).Order("principal DESC, id").Find(&codigos).Error; err != nil {
+		return nil, err
+	}
+	return codigos, nil
+}
+
+// CreateCodigoBarras agrega un código de barras a un producto. El primero queda como principal,
+// y uno nuevo marcado como principal reemplaza al anterior
+func CreateCodigoBarras(db *gorm.DB, sku string, codigo *modelos.CodigoBarras) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var producto modelos.Producto
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("sku").First(&producto, "sku = ?", sku).Error; err != nil {
+			return errors.New("producto no encontrado")
+		}
+		var existentes int64
+		if err := tx.Model(&modelos.CodigoBarras{}).Where("sku = ?", sku).Count(&existentes).Error; err != nil {
+			return err
+		}
+		codigo.ID = 0
+		codigo.SKU = sku
+		codigo.FechaCrea = time.Now()
+		if existentes == 0 {
+			codigo.Principal = true
+		}
+		if err := prepararCodigosBarras(tx, []*modelos.CodigoBarras{codigo}); err != nil {
+			return err
+		}
+		if codigo.Principal && existentes > 0 {
+			if err := tx.Model(&modelos.CodigoBarras{}).Where("sku = ?", sku).Update("principal", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(codigo).Error
+	})
+}
+
+// MarcarCodigoBarrasPrincipal deja un código como el principal del producto
+func MarcarCodigoBarrasPrincipal(db *gorm.DB, sku string, id uint) (*modelos.CodigoBarras, error) {
+	var codigo modelos.CodigoBarras
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND sku = ?", id, sku).First(&codigo).Error; err != nil {
+			return ErrCodigoBarrasNoEncontrado
+		}
+		if err := tx.Model(&modelos.CodigoBarras{}).
+			Where("sku = ?", sku).
+			Update("principal", gorm.Expr("id = ?", id)).Error; err != nil {
+			return err
+		}
+		codigo.Principal = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &codigo, nil
+}
+
+// DeleteCodigoBarras quita un código de un producto. Si era el principal, pasa a serlo el más antiguo
+func DeleteCodigoBarras(db *gorm.DB, sku string, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var codigo modelos.CodigoBarras
+		if err := tx.Where("id = ? AND sku = ?", id, sku).First(&codigo).Error; err != nil {
+			return ErrCodigoBarrasNoEncontrado
+		}
+		if err := tx.Delete(&codigo).Error; err != nil {
+			return err
+		}
+		if !codigo.Principal {
+			return nil
+		}
+		var siguiente modelos.CodigoBarras
+		err := tx.Where("sku = ?", sku).Order("id").First(&siguiente).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Model(&siguiente).Update("principal", true).Error
+	})
+}
+
+// GetProductoPorCodigoBarras busca el producto de un código escaneado. Si el código no está
+// registrado se busca como SKU, que es lo que llevan las etiquetas de productos sin código
+func GetProductoPorCodigoBarras(db *gorm.DB, codigo string) (*modelos.Producto, error) {
+	var registrado modelos.CodigoBarras
+	sku := codigo
+	err := db.Where("codigo = ?", codigo).First(&registrado).Error
+	if err == nil {
+		sku = registrado.SKU
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	producto, err := GetProductoBySKU(db, sku)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCodigoBarrasNoEncontrado
+	}
+	return producto, err
+}
+
+// prepararCodigosBarras valida y normaliza códigos nuevos y revisa que no estén repetidos entre
+// ellos ni asignados a otro producto. Deja un solo principal por producto
+func prepararCodigosBarras(tx *gorm.DB, codigos []*modelos.CodigoBarras) error {
+	if len(codigos) == 0 {
+		return nil
+	}
+	valores := make([]string, 0, len(codigos))
+	vistos := make(map[string]bool, len(codigos))
+	principal := false
+	for _, c := range codigos {
+		codigo, tipo, err := normalizarCodigoBarras(c.Codigo, c.Tipo)
+		if err != nil {
+			return err
+		}
+		if vistos[codigo] {
+			return fmt.Errorf("el código de barras %s está repetido", codigo)
+		}
+		vistos[codigo] = true
+		c.Codigo, c.Tipo = codigo, tipo
+		c.Principal = c.Principal && !principal
+		principal = principal || c.Principal
+		valores = append(valores, codigo)
+	}
+
+	var asignado modelos.CodigoBarras
+	err := tx.Where("codigo IN ?", valores).First(&asignado).Error
+	if err == nil {
+		return fmt.Errorf("el código de barras %s ya está asignado al producto %s", asignado.Codigo, asignado.SKU)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+func algunCodigoPrincipal(codigos []modelos.CodigoBarras) bool {
+	for _, c := range codigos {
+		if c.Principal {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerarEtiquetasProductos escribe en PDF hojas de etiquetas con el nombre, SKU, precio de lista
+// y código de barras principal de cada producto. Los productos sin código llevan su SKU en Code 128
+func GenerarEtiquetasProductos(db *gorm.DB, solicitud SolicitudEtiquetas, w io.Writer) error {
+	skus := make([]string, 0, len(solicitud.Productos))
+	total := 0
+	for i := range solicitud.Productos {
+		if solicitud.Productos[i].Copias == 0 {
+			solicitud.Productos[i].Copias = 1
+		}
+		total += solicitud.Productos[i].Copias
+		skus = append(skus, solicitud.Productos[i].SKU)
+	}
+	if total > EtiquetasMaximas {
+		return fmt.Errorf("no se pueden imprimir más de %d etiquetas por solicitud", EtiquetasMaximas)
+	}
+
+	var productos []modelos.Producto
+	if err := db.Select("sku", "nombre", "precio").
+		Preload("CodigosBarras", func(db *gorm.DB) *gorm.DB { return db.Order("principal DESC, id") }).
+		Where("sku IN ?", skus).
+		Find(&productos).Error; err != nil {
+		return err
+	}
+	porSKU := make(map[string]*modelos.Producto, len(productos))
+	for i := range productos {
+		porSKU[productos[i].SKU] = &productos[i]
+	}
+	for _, sku := range skus {
+		if porSKU[sku] == nil {
+			return fmt.Errorf("producto %s no encontrado", sku)
+		}
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("Etiquetas de productos", false)
+	pdf.SetAutoPageBreak(false, 0)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pageWidth, pageHeight := pdf.GetPageSize()
+	margenX := (pageWidth - etiquetaColumnas*etiquetaAncho) / 2
+	margenY := (pageHeight - etiquetaFilas*etiquetaAlto) / 2
+
+	porHoja := etiquetaColumnas * etiquetaFilas
+	posicion := solicitud.Inicio % porHoja
+	pdf.AddPage()
+	for _, pedido := range solicitud.Productos {
+		producto := porSKU[pedido.SKU]
+		for n := 0; n < pedido.Copias; n++ {
+			if posicion == porHoja {
+				pdf.AddPage()
+				posicion = 0
+			}
+			x := margenX + float64(posicion%etiquetaColumnas)*etiquetaAncho
+			y := margenY + float64(posicion/etiquetaColumnas)*etiquetaAlto
+			dibujarEtiqueta(pdf, tr, producto, x, y)
+			posicion++
+		}
+	}
+	return pdf.Output(w)
+}
+
+// dibujarEtiqueta dibuja una etiqueta con su esquina superior izquierda en (x, y)
+func dibujarEtiqueta(pdf *gofpdf.Fpdf, tr func(string) string, producto *modelos.Producto, x, y float64) {
+	ancho := etiquetaAncho - 2*etiquetaMargen
+	x += etiquetaMargen
+	y += etiquetaMargen
+	pdf.SetTextColor(0, 0, 0)
+
+	// Nombre, recortado para que quepa en una línea
+	pdf.SetFont("Arial", "B", 8)
+	nombre := tr(producto.Nombre)
+	for len(nombre) > 1 && pdf.GetStringWidth(nombre) > ancho {
+		nombre = nombre[:len(nombre)-2] + "."
+	}
+	pdf.SetXY(x, y)
+	pdf.CellFormat(ancho, 4, nombre, "", 0, "L", false, 0, "")
+
+	// SKU a la izquierda y precio a la derecha
+	pdf.SetFont("Arial", "", 7)
+	pdf.SetXY(x, y+5)
+	pdf.CellFormat(ancho/2, 5, tr("SKU: "+producto.SKU), "", 0, "L", false, 0, "")
+	pdf.SetFont("Arial", "B", 12)
+	pdf.SetXY(x+ancho/2, y+4.5)
+	pdf.CellFormat(ancho/2, 6, formatPrecioEtiqueta(producto.Precio), "", 0, "R", false, 0, "")
+
+	codigo, tipo := producto.SKU, CodigoCode128
+	if len(producto.CodigosBarras) > 0 {
+		codigo, tipo = producto.CodigosBarras[0].Codigo, producto.CodigosBarras[0].Tipo
+	}
+	if _, _, err := normalizarCodigoBarras(codigo, tipo); err != nil {
+		// SKU con caracteres que Code 128 no admite: la etiqueta queda sin barras
+		return
+	}
+	dibujarCodigoBarras(pdf, modulosCodigoBarras(codigo, tipo), x, y+12, ancho, 13)
+	pdf.SetFont("Arial", "", 7)
+	pdf.SetXY(x, y+25.5)
+	pdf.CellFormat(ancho, 3.5, codigo, "", 0, "C", false, 0, "")
+}
+
+// dibujarCodigoBarras dibuja los módulos centrados en el ancho disponible, con 10 módulos de zona
+// de silencio a cada lado y a lo sumo 0,33 mm por módulo (el tamaño nominal de EAN-13)
+func dibujarCodigoBarras(pdf *gofpdf.Fpdf, modulos []bool, x, y, ancho, alto float64) {
+	modulo := math.Min(0.33, ancho/float64(len(modulos)+20))
+	x += (ancho - modulo*float64(len(modulos))) / 2
+	pdf.SetFillColor(0, 0, 0)
+	for i := 0; i < len(modulos); {
+		if !modulos[i] {
+			i++
+			continue
+		}
+		inicio := i
+		for i < len(modulos) && modulos[i] {
+			i++
+		}
+		pdf.Rect(x+float64(inicio)*modulo, y, float64(i-inicio)*modulo, alto, "F")
+	}
+}
+
+// formatPrecioEtiqueta muestra el precio sin decimales cuando es entero
+func formatPrecioEtiqueta(precio float64) string {
+	if precio == math.Trunc(precio) {
+		return fmt.Sprintf("$%.0f", precio)
+	}
+	return fmt.Sprintf("$%.2f", precio)
+}
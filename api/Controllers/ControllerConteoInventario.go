@@ -0,0 +1,387 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Estados de un conteo de inventario
+const (
+	ConteoAbierto   = "abierto"
+	ConteoAprobado  = "aprobado"
+	ConteoCancelado = "cancelado"
+)
+
+// Motivos de ajuste aceptados al aprobar un conteo
+var MotivosAjusteConteo = []string{"merma", "robo", "deterioro", "vencimiento", "error_registro", "error_recepcion", "sobrante"}
+
+// NuevoConteo son los datos para abrir un conteo: la sucursal y opcionalmente una categoría o lista de SKU
+type NuevoConteo struct {
+	SucursalID  uint     `json:"sucursal_id" binding:"required"`
+	CategoriaID *uint    `json:"categoria_id"`
+	SKUs        []string `json:"skus"`
+	Observacion string   `json:"observacion"`
+}
+
+// DiferenciaConteo es la variación de un SKU entre lo esperado y lo contado
+type DiferenciaConteo struct {
+	SKU              string  `json:"sku"`
+	Nombre           string  `json:"nombre"`
+	CantidadEsperada int     `json:"cantidad_esperada"`
+	CantidadContada  *int    `json:"cantidad_contada"`
+	Diferencia       int     `json:"diferencia"`
+	Precio           float64 `json:"precio"`
+	ValorDiferencia  float64 `json:"valor_diferencia"`
+	Contadores       int     `json:"contadores"`
+	MotivoAjuste     string  `json:"motivo_ajuste,omitempty"`
+}
+
+// ReporteDiferencias resume las variaciones de un conteo en unidades y en valor
+type ReporteDiferencias struct {
+	ConteoID          uint               `json:"conteo_id"`
+	SucursalID        uint               `json:"sucursal_id"`
+	Estado            string             `json:"estado"`
+	Lineas            []DiferenciaConteo `json:"lineas"`
+	SinContar         int                `json:"sin_contar"`
+	UnidadesFaltantes int                `json:"unidades_faltantes"`
+	UnidadesSobrantes int                `json:"unidades_sobrantes"`
+	ValorFaltante     float64            `json:"valor_faltante"`
+	ValorSobrante     float64            `json:"valor_sobrante"`
+	ValorNeto         float64            `json:"valor_neto"`
+}
+
+func GetConteosInventario(db *gorm.DB, sucursalID uint, estado string) ([]modelos.ConteoInventario, error) {
+	var conteos []modelos.ConteoInventario
+	query := db.Preload("Sucursal").Order("id DESC")
+	if sucursalID != 0 {
+		query = query.Where("sucursal_id = ?", sucursalID)
+	}
+	if estado != "" {
+		query = query.Where("estado = ?", estado)
+	}
+	if err := query.Find(&conteos).Error; err != nil {
+		return nil, err
+	}
+	return conteos, nil
+}
+
+func GetConteoInventarioByID(db *gorm.DB, id uint) (*modelos.ConteoInventario, error) {
+	var conteo modelos.ConteoInventario
+	if err := db.
+		Preload("Sucursal").
+		Preload("Lineas.Producto").
+		Preload("Registros", func(db *gorm.DB) *gorm.DB { return db.Order("fecha") }).
+		First(&conteo, id).Error; err != nil {
+		return nil, err
+	}
+	return &conteo, nil
+}
+
+// CreateConteoInventario abre un conteo congelando la cantidad actual de StockSucursal
+// de cada producto incluido como cantidad esperada
+func CreateConteoInventario(db *gorm.DB, nuevo NuevoConteo, usuario string) (*modelos.ConteoInventario, error) {
+	if nuevo.CategoriaID != nil && len(nuevo.SKUs) > 0 {
+		return nil, errors.New("el conteo se limita por categoría o por lista de SKU, no ambos")
+	}
+
+	conteo := modelos.ConteoInventario{
+		SucursalID:  nuevo.SucursalID,
+		CategoriaID: nuevo.CategoriaID,
+		Estado:      ConteoAbierto,
+		Usuario:     usuario,
+		Observacion: nuevo.Observacion,
+		FechaCrea:   time.Now(),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Se bloquean las filas para que la cantidad congelada sea consistente
+		var stocks []modelos.StockSucursal
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "stock_sucursal"}}).
+			Table("stock_sucursal").
+			Select("stock_sucursal.*").
+			Where("stock_sucursal.sucursal_id = ?", nuevo.SucursalID)
+		if nuevo.CategoriaID != nil {
+			// La categoría incluye sus subcategorías
+			arbol, err := cargarArbolCategorias(tx)
+			if err != nil {
+				return err
+			}
+			query = query.Joins("JOIN productos p ON p.sku = stock_sucursal.sku").
+				Where("p.categoria_id IN ?", arbol.descendientes(*nuevo.CategoriaID))
+		}
+		if len(nuevo.SKUs) > 0 {
+			query = query.Where("stock_sucursal.sku IN ?", nuevo.SKUs)
+		}
+		if err := query.Find(&stocks).Error; err != nil {
+			return err
+		}
+		if len(stocks) == 0 {
+			return errors.New("no hay productos con stock registrado para el alcance del conteo")
+		}
+		if len(nuevo.SKUs) > 0 && len(stocks) != len(nuevo.SKUs) {
+			encontrados := make(map[string]bool)
+			for _, s := range stocks {
+				encontrados[s.SKU] = true
+			}
+			for _, sku := range nuevo.SKUs {
+				if !encontrados[sku] {
+					return fmt.Errorf("el producto %s no tiene stock registrado en la sucursal %d", sku, nuevo.SucursalID)
+				}
+			}
+		}
+
+		if err := tx.Omit("Sucursal", "Lineas", "Registros").Create(&conteo).Error; err != nil {
+			return err
+		}
+		lineas := make([]modelos.ConteoInventarioLinea, 0, len(stocks))
+		for _, s := range stocks {
+			lineas = append(lineas, modelos.ConteoInventarioLinea{
+				ConteoID:         conteo.ID,
+				SKU:              s.SKU,
+				CantidadEsperada: s.Cantidad,
+			})
+		}
+		return tx.Omit("Producto").Create(&lineas).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetConteoInventarioByID(db, conteo.ID)
+}
+
+// RegistrarConteo guarda las cantidades contadas por un contador. Si el mismo contador vuelve a
+// informar un SKU se reemplaza su cantidad anterior; la cantidad contada de la línea es la suma
+// de lo informado por todos los contadores (cada uno cuenta una parte de la bodega).
+func RegistrarConteo(db *gorm.DB, id uint, contador string, lineas []LineaRecepcion) (*modelos.ConteoInventario, error) {
+	if contador == "" {
+		return nil, errors.New("el contador es obligatorio")
+	}
+	if len(lineas) == 0 {
+		return nil, errors.New("debe informar al menos una cantidad contada")
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		conteo, err := bloquearConteo(tx, id)
+		if err != nil {
+			return err
+		}
+		if conteo.Estado != ConteoAbierto {
+			return errors.New("solo se pueden registrar cantidades en conteos abiertos")
+		}
+
+		incluidos := make(map[string]bool)
+		for _, l := range conteo.Lineas {
+			incluidos[l.SKU] = true
+		}
+
+		ahora := time.Now()
+		for _, l := range lineas {
+			if !incluidos[l.SKU] {
+				return fmt.Errorf("el producto %s no forma parte del conteo", l.SKU)
+			}
+			if l.Cantidad < 0 {
+				return fmt.Errorf("la cantidad contada del producto %s no puede ser negativa", l.SKU)
+			}
+
+			registro := modelos.RegistroConteo{ConteoID: id, SKU: l.SKU, Contador: contador, Cantidad: l.Cantidad, Fecha: ahora}
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "conteo_id"}, {Name: "sku"}, {Name: "contador"}},
+				DoUpdates: clause.AssignmentColumns([]string{"cantidad", "fecha"}),
+			}).Create(&registro).Error; err != nil {
+				return err
+			}
+
+			var total int
+			if err := tx.Model(&modelos.RegistroConteo{}).
+				Select("COALESCE(SUM(cantidad), 0)").
+				Where("conteo_id = ? AND sku = ?", id, l.SKU).
+				Scan(&total).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&modelos.ConteoInventarioLinea{}).
+				Where("conteo_id = ? AND sku = ?", id, l.SKU).
+				Update("cantidad_contada", total).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetConteoInventarioByID(db, id)
+}
+
+// GetDiferenciasConteo arma el reporte de variaciones en unidades y en valor según Producto.Precio
+func GetDiferenciasConteo(db *gorm.DB, id uint) (*ReporteDiferencias, error) {
+	conteo, err := GetConteoInventarioByID(db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	contadores := make(map[string]int)
+	for _, r := range conteo.Registros {
+		contadores[r.SKU]++
+	}
+
+	reporte := &ReporteDiferencias{
+		ConteoID:   conteo.ID,
+		SucursalID: conteo.SucursalID,
+		Estado:     conteo.Estado,
+		Lineas:     make([]DiferenciaConteo, 0, len(conteo.Lineas)),
+	}
+	for _, l := range conteo.Lineas {
+		d := DiferenciaConteo{
+			SKU:              l.SKU,
+			Nombre:           l.Producto.Nombre,
+			CantidadEsperada: l.CantidadEsperada,
+			CantidadContada:  l.CantidadContada,
+			Precio:           l.Producto.Precio,
+			Contadores:       contadores[l.SKU],
+			MotivoAjuste:     l.MotivoAjuste,
+		}
+		if l.CantidadContada == nil {
+			reporte.SinContar++
+		} else {
+			d.Diferencia = *l.CantidadContada - l.CantidadEsperada
+			d.ValorDiferencia = float64(d.Diferencia) * l.Producto.Precio
+		}
+
+		if d.Diferencia < 0 {
+			reporte.UnidadesFaltantes -= d.Diferencia
+			reporte.ValorFaltante -= d.ValorDiferencia
+		} else {
+			reporte.UnidadesSobrantes += d.Diferencia
+			reporte.ValorSobrante += d.ValorDiferencia
+		}
+		reporte.ValorNeto += d.ValorDiferencia
+		reporte.Lineas = append(reporte.Lineas, d)
+	}
+
+	sort.Slice(reporte.Lineas, func(i, j int) bool {
+		return reporte.Lineas[i].SKU < reporte.Lineas[j].SKU
+	})
+	return reporte, nil
+}
+
+// AprobarConteo aplica al stock las diferencias de todas las líneas contadas en una sola
+// transacción. Cada ajuste lleva un motivo: el indicado para su SKU o el motivo general.
+// Se aplica la diferencia y no la cantidad contada, para no pisar los movimientos
+// registrados después de abrir el conteo.
+func AprobarConteo(db *gorm.DB, id uint, motivo string, motivos map[string]string, usuario string) (*ReporteDiferencias, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		conteo, err := bloquearConteo(tx, id)
+		if err != nil {
+			return err
+		}
+		if conteo.Estado != ConteoAbierto {
+			return errors.New("solo se pueden aprobar conteos abiertos")
+		}
+
+		for sku := range motivos {
+			encontrado := false
+			for _, l := range conteo.Lineas {
+				if l.SKU == sku {
+					encontrado = true
+					break
+				}
+			}
+			if !encontrado {
+				return fmt.Errorf("el producto %s no forma parte del conteo", sku)
+			}
+		}
+
+		for _, l := range conteo.Lineas {
+			if l.CantidadContada == nil {
+				continue
+			}
+			diferencia := *l.CantidadContada - l.CantidadEsperada
+			if diferencia == 0 {
+				continue
+			}
+
+			motivoLinea := motivos[l.SKU]
+			if motivoLinea == "" {
+				motivoLinea = motivo
+			}
+			if motivoLinea == "" {
+				return fmt.Errorf("falta el motivo de ajuste del producto %s", l.SKU)
+			}
+			if !motivoAjusteValido(motivoLinea) {
+				return fmt.Errorf("motivo de ajuste no válido para el producto %s: %s", l.SKU, motivoLinea)
+			}
+
+			if err := RegistrarMovimientoStock(tx, &modelos.MovimientoStock{
+				SKU:        l.SKU,
+				SucursalID: conteo.SucursalID,
+				Tipo:       MovimientoAjuste,
+				Cantidad:   diferencia,
+				Usuario:    usuario,
+				Referencia: fmt.Sprintf("conteo #%d: %s", conteo.ID, motivoLinea),
+			}); err != nil {
+				return err
+			}
+			if err := tx.Model(&modelos.ConteoInventarioLinea{}).
+				Where("conteo_id = ? AND sku = ?", conteo.ID, l.SKU).
+				Update("motivo_ajuste", motivoLinea).Error; err != nil {
+				return err
+			}
+		}
+
+		ahora := time.Now()
+		return tx.Model(conteo).Updates(map[string]interface{}{
+			"estado":           ConteoAprobado,
+			"fecha_aprobacion": ahora,
+			"aprobado_por":     usuario,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetDiferenciasConteo(db, id)
+}
+
+// CancelarConteo descarta un conteo abierto sin tocar el stock
+func CancelarConteo(db *gorm.DB, id uint) error {
+	result := db.Model(&modelos.ConteoInventario{}).
+		Where("id = ? AND estado = ?", id, ConteoAbierto).
+		Update("estado", ConteoCancelado)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("el conteo no existe o no está abierto")
+	}
+	return nil
+}
+
+func motivoAjusteValido(motivo string) bool {
+	for _, m := range MotivosAjusteConteo {
+		if m == motivo {
+			return true
+		}
+	}
+	return false
+}
+
+// bloquearConteo carga el conteo con sus líneas bloqueando la fila para la transacción
+func bloquearConteo(tx *gorm.DB, id uint) (*modelos.ConteoInventario, error) {
+	var conteo modelos.ConteoInventario
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&conteo, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("conteo de inventario no encontrado")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Where("conteo_id = ?", id).Find(&conteo.Lineas).Error; err != nil {
+		return nil, err
+	}
+	return &conteo, nil
+}
@@ -13,6 +13,7 @@ import (
 	"os"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 //"strconv"
@@ -23,6 +24,8 @@ type DespachoConTotales struct {
 	CantidadItems     int     `json:"cantidad_items"`
 	TotalKg           float64 `json:"total_kg"`
 	TotalPrecio       float64 `json:"total_precio"`
+	TotalCosto        float64 `json:"total_costo"`
+	Margen            float64 `json:"margen"` // total_precio - total_costo
 	IVA               float64
 	ValorDespacho     float64                     `json:"valor_despacho"`
 	ProductosDespacho []ProductoDespachoDetallado `json:"items"`
@@ -34,39 +37,59 @@ type Unidad struct {
 	Peso       float64
 	Volumen    float64
 	SucursalID uint
+	Cantidad   int // unidades base que contiene el bulto (más de una si es un embalaje)
 }
 
-func CreateDespacho(db *gorm.DB, despacho *modelos.Despacho, productos []modelos.ProductosDespacho) error {
-	return db.Transaction(func(tx *gorm.DB) error {
+// claveStock identifica el stock de un SKU en una sucursal
+type claveStock struct {
+	SKU        string
+	SucursalID uint
+}
+
+// CreateDespacho registra un despacho y descuenta su stock. Antes de escribir nada revisa todas las
+// líneas: si a alguna le falta stock devuelve ErrStockInsuficiente junto con el detalle por SKU
+func CreateDespacho(db *gorm.DB, despacho *modelos.Despacho, productos []modelos.ProductosDespacho, usuario string) (*ValidacionDespacho, error) {
+	var validacion *ValidacionDespacho
+	err := db.Transaction(func(tx *gorm.DB) error {
 		// Validar que la fecha de despacho no sea en el pasado
 		if despacho.FechaDespacho.Before(time.Now().Add(-24 * time.Hour)) {
 			return errors.New("la fecha de despacho no puede ser en el pasado")
 		}
 
+		if err := prepararLineasDespacho(tx, productos); err != nil {
+			return err
+		}
+		var err error
+		validacion, err = validarStockDespacho(tx, despacho, productos)
+		if err != nil {
+			return err
+		}
+		if !validacion.Valido {
+			return ErrStockInsuficiente
+		}
+
 		if err := tx.Create(despacho).Error; err != nil {
 			return err
 		}
 
-		for _, p := range productos {
+		for i := range productos {
+			p := &productos[i]
 			p.DespachoID = despacho.ID
-
-			if err := tx.Create(&p).Error; err != nil {
+			if err := tx.Omit("Lotes", "Series", "Componentes").Create(p).Error; err != nil {
 				return err
 			}
+		}
 
-			res := tx.Model(&modelos.StockSucursal{}).
-				Where("producto_id = ? AND sucursal_id = ?", p.ProductoID, despacho.Origen).
-				Update("cantidad", gorm.Expr("cantidad - ?", p.Cantidad))
-
-			if res.Error != nil {
-				return res.Error
-			}
-			if res.RowsAffected == 0 {
-				return errors.New("no se encontró stock suficiente para el producto " + p.ProductoID)
+		// Descontar stock de la sucursal de origen consumiendo las reservas de la cotización. Se hace
+		// con todas las líneas ya creadas, porque un kit agrega a las de sus componentes
+		for _, p := range productos {
+			if err := salidaDespacho(tx, despacho, p.ProductoID, despacho.Origen, p.Cantidad, p.NumerosSerie, usuario); err != nil {
+				return err
 			}
 		}
 		return nil
 	})
+	return validacion, err
 }
 
 func GetDespachos(db *gorm.DB) ([]DespachoConTotales, error) {
@@ -84,6 +107,9 @@ func GetDespachos(db *gorm.DB) ([]DespachoConTotales, error) {
 		Preload("OrigenSucursal.Tipo").
 		Preload("DestinoDirCliente.Cliente.Tipo").
 		Preload("ProductosDespacho.Producto").
+		Preload("ProductosDespacho.Lotes").
+		Preload("ProductosDespacho.Series").
+		Preload("ProductosDespacho.Componentes.Componente").
 		Find(&despachos).Error
 	if err != nil {
 		return nil, errors.New("error al consultar despachos en la base de datos: " + err.Error())
@@ -95,12 +121,18 @@ func GetDespachos(db *gorm.DB) ([]DespachoConTotales, error) {
 		totalKg := 0.0
 		totalItems := 0
 		totalPrecio := 0.0
+		totalCosto := 0.0
 		var productosDetallados []ProductoDespachoDetallado
 
 		for _, producto := range despacho.ProductosDespacho {
+			// Las líneas de componentes que solo salieron dentro de kits se muestran bajo el kit
+			if producto.Cantidad == 0 {
+				continue
+			}
 			totalItems += producto.Cantidad
 			totalKg += float64(producto.Cantidad) * producto.Producto.Peso
 			totalPrecio += float64(producto.Cantidad) * producto.Producto.Precio
+			totalCosto += producto.CostoTotal
 
 			// Crear producto detallado
 			detallado := ProductoDespachoDetallado{
@@ -117,6 +149,13 @@ func GetDespachos(db *gorm.DB) ([]DespachoConTotales, error) {
 				Precio:      producto.Producto.Precio,
 				PesoTotal:   producto.Producto.Peso * float64(producto.Cantidad),
 				PrecioTotal: producto.Producto.Precio * float64(producto.Cantidad),
+				Lotes:       lotesDespachoKit(producto.Lotes, ""),
+				Series:      seriesDespachoKit(producto.Series, ""),
+				CostoTotal:  producto.CostoTotal,
+				Componentes: componentesDetallados(producto, despacho.ProductosDespacho),
+			}
+			if producto.Cantidad > 0 {
+				detallado.CostoUnitario = producto.CostoTotal / float64(producto.Cantidad)
 			}
 			productosDetallados = append(productosDetallados, detallado)
 		}
@@ -126,6 +165,8 @@ func GetDespachos(db *gorm.DB) ([]DespachoConTotales, error) {
 			CantidadItems:     totalItems,
 			TotalKg:           totalKg,
 			TotalPrecio:       totalPrecio,
+			TotalCosto:        totalCosto,
+			Margen:            totalPrecio - totalCosto,
 			ProductosDespacho: productosDetallados,
 		})
 	}
@@ -141,6 +182,9 @@ func GetDespachoByID(db *gorm.DB, id uint) (*DespachoConTotales, error) {
 		Preload("OrigenSucursal.Tipo").
 		Preload("DestinoDirCliente.Cliente.Tipo").
 		Preload("ProductosDespacho.Producto.Proveedor").
+		Preload("ProductosDespacho.Lotes").
+		Preload("ProductosDespacho.Series").
+		Preload("ProductosDespacho.Componentes.Componente").
 		First(&despacho, "id = ?", id).Error
 	if err != nil {
 		return nil, err
@@ -149,12 +193,18 @@ func GetDespachoByID(db *gorm.DB, id uint) (*DespachoConTotales, error) {
 	var totalKg float64
 	var totalItems int
 	var totalPrecio float64
+	var totalCosto float64
 	var productosDetallados []ProductoDespachoDetallado
 
 	for _, p := range despacho.ProductosDespacho {
+		// Las líneas de componentes que solo salieron dentro de kits se muestran bajo el kit
+		if p.Cantidad == 0 {
+			continue
+		}
 		totalItems += p.Cantidad
 		totalKg += float64(p.Cantidad) * p.Producto.Peso
 		totalPrecio += float64(p.Cantidad) * p.Producto.Precio
+		totalCosto += p.CostoTotal
 
 		// Crear producto detallado
 		detallado := ProductoDespachoDetallado{
@@ -171,6 +221,13 @@ func GetDespachoByID(db *gorm.DB, id uint) (*DespachoConTotales, error) {
 			Precio:      p.Producto.Precio,
 			PesoTotal:   p.Producto.Peso * float64(p.Cantidad),
 			PrecioTotal: p.Producto.Precio * float64(p.Cantidad),
+			Lotes:       lotesDespachoKit(p.Lotes, ""),
+			Series:      seriesDespachoKit(p.Series, ""),
+			CostoTotal:  p.CostoTotal,
+			Componentes: componentesDetallados(p, despacho.ProductosDespacho),
+		}
+		if p.Cantidad > 0 {
+			detallado.CostoUnitario = p.CostoTotal / float64(p.Cantidad)
 		}
 		productosDetallados = append(productosDetallados, detallado)
 	}
@@ -182,31 +239,46 @@ func GetDespachoByID(db *gorm.DB, id uint) (*DespachoConTotales, error) {
 		CantidadItems:     totalItems,
 		TotalKg:           totalKg,
 		TotalPrecio:       totalPrecio,
+		TotalCosto:        totalCosto,
+		Margen:            totalPrecio - totalCosto,
 		IVA:               iva,
 		ProductosDespacho: productosDetallados,
 	}
 	return &resultado, nil
 }
 
-func UpdateDespacho(db *gorm.DB, id uint, actualizado *modelos.Despacho) error {
-	var existente modelos.Despacho
-	if err := db.First(&existente, id).Error; err != nil {
-		return errors.New("despacho no encontrado")
-	}
-	return db.Model(&existente).Updates(actualizado).Error
+func UpdateDespacho(db *gorm.DB, id uint, actualizado *modelos.Despacho, version uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existente modelos.Despacho
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existente, id).Error; err != nil {
+			return errors.New("despacho no encontrado")
+		}
+		if existente.Version != version {
+			return ErrConflictoVersion
+		}
+		actualizado.Version = existente.Version + 1
+		return tx.Model(&existente).Updates(actualizado).Error
+	})
 }
 
-func DeleteDespacho(db *gorm.DB, id uint) error {
-	return db.Delete(&modelos.Despacho{}, id).Error
+// DeleteDespacho elimina un despacho devolviendo al stock lo que salió con él y reactivando las
+// reservas que consumió
+func DeleteDespacho(db *gorm.DB, id uint, usuario string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var despacho modelos.Despacho
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&despacho, id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("despacho no encontrado")
+		}
+		if err != nil {
+			return err
+		}
+		return revertirDespacho(tx, &despacho, usuario)
+	})
 }
 
 // para devolver el modelo despacho creado hay que cambiar los 0 de los return 0,err por un nil, a parte de la firma de la funcion
 func CalcularDespacho(db *gorm.DB, cotID uint, dirClienteID uint) (float64, error) {
-	// Se eliminan previamente los despachos existentes para esta cotización (si los hay)
-	if err := db.Where("cotizacion_id = ?", cotID).Delete(&modelos.Despacho{}).Error; err != nil {
-		return 0, err
-	}
-
 	// Se buscan los ítems de la cotización con sus productos y la cotización en sí
 	var items []modelos.CotizacionItem
 	err := db.
@@ -221,6 +293,11 @@ func CalcularDespacho(db *gorm.DB, cotID uint, dirClienteID uint) (float64, erro
 	if len(items) == 0 {
 		return 0, errors.New("no hay productos en la cotización")
 	}
+	// Las cantidades se pasan a unidad base en memoria; se guardan dentro de la transacción
+	cambiados, err := cantidadesBaseItems(db, items)
+	if err != nil {
+		return 0, err
+	}
 
 	var tiposDisponibles []modelos.TipoCamion
 	if err := db.Order("peso_maximo ASC").Find(&tiposDisponibles).Error; err != nil {
@@ -230,19 +307,20 @@ func CalcularDespacho(db *gorm.DB, cotID uint, dirClienteID uint) (float64, erro
 		return 0, errors.New("no hay tipos de camión disponibles")
 	}
 
+	skus := make([]string, 0, len(items))
+	for _, item := range items {
+		skus = append(skus, item.ProductoID)
+	}
+	embalajes, err := unidadesPorSKU(db, skus)
+	if err != nil {
+		return 0, err
+	}
+
 	var unidades []Unidad
 
-	// 🧮 Se desglosan los ítems en unidades individuales (uno por cantidad), calculando su volumen
+	// 🧮 Se desglosan los ítems en bultos: embalajes completos con su propio peso y volumen, y el resto en unidades sueltas
 	for _, item := range items {
-		vol := item.Producto.Largo * item.Producto.Ancho * item.Producto.Alto / 1_000_000
-		for i := 0; i < item.Cantidad; i++ {
-			unidades = append(unidades, Unidad{
-				SKU:        item.Producto.SKU,
-				Peso:       item.Producto.Peso,
-				Volumen:    vol,
-				SucursalID: item.SucursalID,
-			})
-		}
+		unidades = append(unidades, desglosarEmbalajes(item.Producto, embalajes[item.ProductoID], item.Cantidad, item.SucursalID)...)
 	}
 
 	// 🏠 Se obtiene la dirección de destino del cliente
@@ -300,59 +378,85 @@ func CalcularDespacho(db *gorm.DB, cotID uint, dirClienteID uint) (float64, erro
 
 	var despachos []modelos.Despacho
 
-	// 🚛 Por cada grupo de unidades, se crea un despacho nuevo
-	for _, grupo := range grupos {
-		var tipoCamionID uint = 0
-		for _, tipo := range tiposDisponibles {
-			if tipo.PesoMaximo >= pesoTotal(grupo) && tipo.Volumen >= volumenTotal(grupo) {
-				tipoCamionID = tipo.ID
-				break
-			}
-		}
-		if tipoCamionID == 0 {
-			return 0, errors.New("no hay tipo de camión disponible para un grupo de productos")
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := guardarCantidadesItems(tx, items, cambiados); err != nil {
+			return err
 		}
 
-		var camion modelos.Camion
-		if err := db.Where("tipo_id = ? AND activo = true", tipoCamionID).First(&camion).Error; err != nil {
-			return 0, fmt.Errorf("no hay camiones disponibles del tipo %d", tipoCamionID)
+		// Se eliminan los despachos existentes para esta cotización (si los hay), devolviendo su stock
+		if err := revertirDespachosCotizacion(tx, cotID, items[0].Cotizacion.UserID); err != nil {
+			return err
 		}
 
-		// Crear el despacho con la información del grupo
-		despacho := modelos.Despacho{
-			CotizacionID:  cotID,
-			CamionID:      camion.ID,
-			Origen:        grupo[0].SucursalID,
-			Destino:       destino.ID,
-			FechaDespacho: time.Now().AddDate(0, 0, 1),            // Fecha de despacho al día siguiente
-			ValorDespacho: costoTotalEnvio / float64(len(grupos)), // repartir el total entre camiones
-			Estado:        "pendiente",                            // Estado inicial
-		}
+		// 🚛 Por cada grupo de unidades, se crea un despacho nuevo
+		for _, grupo := range grupos {
+			var tipoCamionID uint = 0
+			for _, tipo := range tiposDisponibles {
+				if tipo.PesoMaximo >= pesoTotal(grupo) && tipo.Volumen >= volumenTotal(grupo) {
+					tipoCamionID = tipo.ID
+					break
+				}
+			}
+			if tipoCamionID == 0 {
+				return errors.New("no hay tipo de camión disponible para un grupo de productos")
+			}
 
-		if err := db.Create(&despacho).Error; err != nil {
-			return 0, err
-		}
+			var camion modelos.Camion
+			if err := tx.Where("tipo_id = ? AND activo = true", tipoCamionID).First(&camion).Error; err != nil {
+				return fmt.Errorf("no hay camiones disponibles del tipo %d", tipoCamionID)
+			}
 
-		// 🧮 Se agrupan las unidades por SKU para registrar la cantidad total por producto en el despacho
-		mapSKU := make(map[string]int)
-		for _, u := range grupo {
-			mapSKU[u.SKU]++
-		}
+			// Crear el despacho con la información del grupo
+			despacho := modelos.Despacho{
+				CotizacionID:  cotID,
+				CamionID:      camion.ID,
+				Origen:        grupo[0].SucursalID,
+				Destino:       destino.ID,
+				FechaDespacho: time.Now().AddDate(0, 0, 1),            // Fecha de despacho al día siguiente
+				ValorDespacho: costoTotalEnvio / float64(len(grupos)), // repartir el total entre camiones
+				Estado:        "pendiente",                            // Estado inicial
+			}
 
-		// 📦 Se crea el detalle del despacho (productos_despacho) por SKU y cantidad
-		for sku, cantidad := range mapSKU {
-			prod := modelos.ProductosDespacho{
-				DespachoID: despacho.ID,
-				ProductoID: sku,
-				Cantidad:   cantidad,
+			if err := tx.Create(&despacho).Error; err != nil {
+				return err
 			}
-			if err := db.Create(&prod).Error; err != nil {
-				return 0, err
+
+			// 🧮 Se agrupan las unidades por SKU para registrar la cantidad total por producto en el despacho
+			mapSKU := make(map[string]int)
+			for _, u := range grupo {
+				mapSKU[u.SKU] += u.Cantidad
+			}
+
+			// 📦 Se crea el detalle del despacho (productos_despacho) por SKU y cantidad
+			for sku, cantidad := range mapSKU {
+				prod := modelos.ProductosDespacho{
+					DespachoID: despacho.ID,
+					ProductoID: sku,
+					Cantidad:   cantidad,
+				}
+				if err := tx.Create(&prod).Error; err != nil {
+					return err
+				}
+			}
+
+			// 📉 Se descuenta el stock de cada sucursal de origen consumiendo las reservas de la cotización
+			salidas := make(map[claveStock]int)
+			for _, u := range grupo {
+				salidas[claveStock{SKU: u.SKU, SucursalID: u.SucursalID}] += u.Cantidad
+			}
+			for clave, cantidad := range salidas {
+				if err := salidaDespacho(tx, &despacho, clave.SKU, clave.SucursalID, cantidad, nil, items[0].Cotizacion.UserID); err != nil {
+					return err
+				}
 			}
-		}
 
-		// 🧾 Se guarda el despacho generado
-		despachos = append(despachos, despacho)
+			// 🧾 Se guarda el despacho generado
+			despachos = append(despachos, despacho)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
 
 	return costoTotalEnvio, nil
@@ -380,7 +484,10 @@ func AprobarDespacho(db *gorm.DB, cotID uint) error {
 	// Se actualiza el estado del despacho a "aprobado" para la cotización especificada
 	result := db.Model(&modelos.Despacho{}).
 		Where("cotizacion_id = ?", cotID).
-		Update("estado", "aprobado")
+		Updates(map[string]interface{}{
+			"estado":  "aprobado",
+			"version": gorm.Expr("version + 1"),
+		})
 
 	if result.RowsAffected == 0 {
 		return errors.New("no se encontró despacho para la cotización especificada")
@@ -396,7 +503,10 @@ func CambiarEstadoDespachosPorCotizacion(db *gorm.DB, cotizacionID uint, estado
 	}
 	result := db.Model(&modelos.Despacho{}).
 		Where("cotizacion_id = ?", cotizacionID).
-		Update("estado", estado)
+		Updates(map[string]interface{}{
+			"estado":  estado,
+			"version": gorm.Expr("version + 1"),
+		})
 	if result.Error != nil {
 		return result.Error
 	}
@@ -600,6 +710,7 @@ func ActualizarDistanciaDespacho(db *gorm.DB, id uint, distancia, tiempo string)
 		Updates(map[string]interface{}{
 			"distancia_calculada": distancia,
 			"tiempo_estimado":     tiempo,
+			"version":             gorm.Expr("version + 1"),
 		})
 
 	if result.Error != nil {
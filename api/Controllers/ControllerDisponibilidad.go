@@ -0,0 +1,233 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"backend-inventario/services"
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DisponibilidadSucursal es el stock de un SKU en una sucursal, con la distancia al cliente
+type DisponibilidadSucursal struct {
+	SucursalID uint   `json:"sucursal_id"`
+	Sucursal   string `json:"sucursal"`
+	Direccion  string `json:"direccion"`
+	Comuna     string `json:"comuna"`
+	Ciudad     string `json:"ciudad"`
+	EnMano     int    `json:"en_mano"`
+	Reservado  int    `json:"reservado"`
+	Disponible int    `json:"disponible"`
+
+	// Solo cuando se indica la dirección del cliente
+	Distancia        string `json:"distancia,omitempty"`
+	Duracion         string `json:"duracion,omitempty"`
+	DistanciaMetros  *int   `json:"distancia_metros,omitempty"`
+	DuracionSegundos *int   `json:"duracion_segundos,omitempty"`
+	ErrorDistancia   string `json:"error_distancia,omitempty"`
+}
+
+// DisponibilidadProveedor es el stock del proveedor que puede cubrir un pedido pendiente
+type DisponibilidadProveedor struct {
+	ProveedorID  uint      `json:"proveedor_id"`
+	Marca        string    `json:"marca"`
+	Stock        int       `json:"stock"`
+	FechaIngreso time.Time `json:"fecha_ingreso"`
+}
+
+// DisponibilidadProducto resume la disponibilidad de un SKU en toda la red
+type DisponibilidadProducto struct {
+	SKU              string                    `json:"sku"`
+	Nombre           string                    `json:"nombre"`
+	EsKit            bool                      `json:"es_kit"` // disponibilidad calculada desde sus componentes
+	TotalEnMano      int                       `json:"total_en_mano"`
+	TotalReservado   int                       `json:"total_reservado"`
+	TotalDisponible  int                       `json:"total_disponible"`
+	TotalProveedores int                       `json:"total_proveedores"`
+	Sucursales       []DisponibilidadSucursal  `json:"sucursales"`
+	Proveedores      []DisponibilidadProveedor `json:"proveedores"`
+}
+
+// Errores de validación de la consulta de disponibilidad
+var (
+	ErrSinSKUs                = errors.New("debe indicar al menos un SKU")
+	ErrDirClienteNoEncontrada = errors.New("dirección de cliente no encontrada")
+)
+
+type filaDisponibilidad struct {
+	SKU string
+	DisponibilidadSucursal
+}
+
+// GetDisponibilidad calcula en la base de datos el stock en mano, reservado y disponible de cada
+// SKU por sucursal, más el stock de proveedores como respaldo. Si se indica una dirección de
+// cliente, agrega la distancia y el tiempo de viaje desde cada sucursal y las ordena por cercanía.
+// Los kits informan los kits completos que se pueden armar con el stock de sus componentes.
+// Devuelve aparte los SKU que no existen.
+func GetDisponibilidad(db *gorm.DB, skus []string, dirClienteID uint) ([]DisponibilidadProducto, []string, error) {
+	if len(skus) == 0 {
+		return nil, nil, ErrSinSKUs
+	}
+
+	var productos []modelos.Producto
+	if err := db.Select("sku", "nombre", "es_kit").Where("sku IN ?", skus).Find(&productos).Error; err != nil {
+		return nil, nil, err
+	}
+	porSKU := make(map[string]*DisponibilidadProducto, len(productos))
+	var kits []string
+	for _, p := range productos {
+		porSKU[p.SKU] = &DisponibilidadProducto{
+			SKU:         p.SKU,
+			Nombre:      p.Nombre,
+			EsKit:       p.EsKit,
+			Sucursales:  []DisponibilidadSucursal{},
+			Proveedores: []DisponibilidadProveedor{},
+		}
+		if p.EsKit {
+			kits = append(kits, p.SKU)
+		}
+	}
+
+	var filas []filaDisponibilidad
+	if err := db.Table("stock_sucursal AS s").
+		Select(`s.sku, s.sucursal_id, su.nombre AS sucursal, su.direccion, su.comuna, su.ciudad,
+			s.cantidad AS en_mano, COALESCE(r.reservado, 0) AS reservado,
+			s.cantidad - COALESCE(r.reservado, 0) AS disponible`).
+		Joins("JOIN sucursales su ON su.id = s.sucursal_id").
+		Joins(`LEFT JOIN (
+			SELECT sku, sucursal_id, SUM(cantidad) AS reservado
+			FROM reservas_stock
+			WHERE estado = ? AND fecha_expira > ? AND sku IN ?
+			GROUP BY sku, sucursal_id
+		) r ON r.sku = s.sku AND r.sucursal_id = s.sucursal_id`, ReservaActiva, time.Now(), skus).
+		Where("s.sku IN ?", skus).
+		Order("s.sku, disponible DESC, su.nombre").
+		Scan(&filas).Error; err != nil {
+		return nil, nil, err
+	}
+	filasKits, err := disponibilidadKits(db, kits)
+	if err != nil {
+		return nil, nil, err
+	}
+	filas = append(filas, filasKits...)
+
+	var proveedores []struct {
+		SKU string
+		DisponibilidadProveedor
+	}
+	if err := db.Table("stock_proveedor AS sp").
+		Select("sp.sku, sp.proveedor_id, p.marca, sp.stock, sp.fecha_ingreso").
+		Joins("JOIN proveedores p ON p.id = sp.proveedor_id").
+		Where("sp.sku IN ? AND sp.stock > 0", skus).
+		Order("sp.sku, sp.stock DESC").
+		Scan(&proveedores).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var distancias map[uint]*services.DistanciaCalculada
+	var erroresDistancia map[uint]string
+	if dirClienteID != 0 {
+		var destino modelos.DirCliente
+		err := db.First(&destino, dirClienteID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrDirClienteNoEncontrada
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		distancias, erroresDistancia = distanciasASucursales(filas, destino)
+	}
+
+	for _, f := range filas {
+		d, ok := porSKU[f.SKU]
+		if !ok {
+			continue
+		}
+		linea := f.DisponibilidadSucursal
+		if dc, ok := distancias[linea.SucursalID]; ok {
+			metros, segundos := dc.DistanciaMetros, dc.DuracionSegundos
+			linea.Distancia = dc.Distancia
+			linea.Duracion = dc.Duracion
+			linea.DistanciaMetros = &metros
+			linea.DuracionSegundos = &segundos
+		} else if msg, ok := erroresDistancia[linea.SucursalID]; ok {
+			linea.ErrorDistancia = msg
+		}
+		d.TotalEnMano += linea.EnMano
+		d.TotalReservado += linea.Reservado
+		d.TotalDisponible += linea.Disponible
+		d.Sucursales = append(d.Sucursales, linea)
+	}
+	for _, p := range proveedores {
+		if d, ok := porSKU[p.SKU]; ok {
+			d.TotalProveedores += p.Stock
+			d.Proveedores = append(d.Proveedores, p.DisponibilidadProveedor)
+		}
+	}
+
+	resultado := make([]DisponibilidadProducto, 0, len(porSKU))
+	var noEncontrados []string
+	vistos := make(map[string]bool, len(skus))
+	for _, sku := range skus {
+		if vistos[sku] {
+			continue
+		}
+		vistos[sku] = true
+		d, ok := porSKU[sku]
+		if !ok {
+			noEncontrados = append(noEncontrados, sku)
+			continue
+		}
+		if distancias != nil {
+			ordenarPorCercania(d.Sucursales)
+		}
+		resultado = append(resultado, *d)
+	}
+	return resultado, noEncontrados, nil
+}
+
+// distanciasASucursales consulta una sola vez la ruta desde cada sucursal involucrada
+func distanciasASucursales(filas []filaDisponibilidad, destino modelos.DirCliente) (map[uint]*services.DistanciaCalculada, map[uint]string) {
+	maps := services.NewGoogleMapsService()
+	destinoStr := services.FormatearDireccionCompleta(destino.Direccion, destino.Comuna, destino.Ciudad)
+
+	distancias := make(map[uint]*services.DistanciaCalculada)
+	errores := make(map[uint]string)
+	for _, f := range filas {
+		id := f.SucursalID
+		if _, ok := distancias[id]; ok {
+			continue
+		}
+		if _, ok := errores[id]; ok {
+			continue
+		}
+		origen := services.FormatearDireccionCompleta(f.Direccion, f.Comuna, f.Ciudad)
+		dc, err := maps.CalcularDistancia(origen, destinoStr)
+		if err != nil {
+			errores[id] = err.Error()
+			continue
+		}
+		distancias[id] = dc
+	}
+	return distancias, errores
+}
+
+// ordenarPorCercania deja primero las sucursales con stock disponible y, entre ellas,
+// las de menor tiempo de viaje. Las que no tienen ruta calculada van al final de su grupo.
+func ordenarPorCercania(sucursales []DisponibilidadSucursal) {
+	sort.SliceStable(sucursales, func(i, j int) bool {
+		a, b := sucursales[i], sucursales[j]
+		if (a.Disponible > 0) != (b.Disponible > 0) {
+			return a.Disponible > 0
+		}
+		if (a.DuracionSegundos == nil) != (b.DuracionSegundos == nil) {
+			return a.DuracionSegundos != nil
+		}
+		if a.DuracionSegundos == nil {
+			return false
+		}
+		return *a.DuracionSegundos < *b.DuracionSegundos
+	})
+}
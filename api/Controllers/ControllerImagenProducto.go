@@ -0,0 +1,312 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"backend-inventario/services"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	// Decodificadores de los formatos aceptados
+	_ "image/gif"
+	_ "image/png"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// TamanoMaximoImagen limita cada imagen subida a 5 MB
+	TamanoMaximoImagen = 5 << 20
+	// memoriaMaximaImagen limita lo que ocupa la imagen decodificada: una imagen de pocos MB
+	// comprimida puede ocupar cientos al decodificarla y el pod tiene 128 MiB
+	memoriaMaximaImagen = 48 << 20
+	// LadoMiniatura es el lado máximo, en píxeles, de las miniaturas
+	LadoMiniatura = 320
+)
+
+// tiposImagen son los formatos aceptados, según el contenido del archivo, con su extensión
+var tiposImagen = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// ErrImagenInvalida indica que el archivo no es una imagen en un formato aceptado
+var ErrImagenInvalida = errors.New("el archivo debe ser una imagen JPEG, PNG o GIF")
+
+// ErrImagenNoEncontrada indica que el producto no tiene la imagen pedida
+var ErrImagenNoEncontrada = errors.New("imagen no encontrada")
+
+// GetImagenesProducto lista las imágenes de un producto en su orden de presentación
+func GetImagenesProducto(db *gorm.DB, sku string) ([]modelos.ProductoImagen, error) {
+	var producto modelos.Producto
+	if err := db.Select("sku").First(&producto, "sku = ?", sku).Error; err != nil {
+		return nil, errors.New("producto no encontrado")
+	}
+	var imagenes []modelos.ProductoImagen
+	if err := db.Where("sku = ?", sku).Order("orden, id").Find(&imagenes).Error; err != nil {
+		return nil, err
+	}
+	completarURLsImagenes(imagenes)
+	return imagenes, nil
+}
+
+// SubirImagenProducto valida la imagen por su contenido, genera la miniatura y guarda ambos
+// archivos. La imagen queda al final del orden del producto
+func SubirImagenProducto(db *gorm.DB, almacen services.Almacenamiento, sku, nombre string, contenido []byte, usuario string) (*modelos.ProductoImagen, error) {
+	if len(contenido) > TamanoMaximoImagen {
+		return nil, fmt.Errorf("la imagen supera el máximo de %d MB", TamanoMaximoImagen>>20)
+	}
+	tipo := http.DetectContentType(contenido)
+	extension, ok := tiposImagen[tipo]
+	if !ok {
+		return nil, ErrImagenInvalida
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(contenido))
+	if err != nil {
+		return nil, ErrImagenInvalida
+	}
+	if porPixel := bytesPorPixel(config.ColorModel); config.Width*config.Height*porPixel > memoriaMaximaImagen {
+		return nil, fmt.Errorf("la imagen es demasiado grande: en este formato no puede superar los %.1f megapíxeles",
+			float64(memoriaMaximaImagen/porPixel)/1_000_000)
+	}
+	original, _, err := image.Decode(bytes.NewReader(contenido))
+	if err != nil {
+		return nil, ErrImagenInvalida
+	}
+	miniatura, err := generarMiniatura(original, LadoMiniatura)
+	if err != nil {
+		return nil, err
+	}
+
+	imagen := modelos.ProductoImagen{
+		SKU:         sku,
+		Nombre:      nombre,
+		ContentType: tipo,
+		Tamano:      int64(len(contenido)),
+		Ancho:       config.Width,
+		Alto:        config.Height,
+		Usuario:     usuario,
+		FechaCrea:   time.Now(),
+	}
+	var guardadas []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var producto modelos.Producto
+		if err := tx.Select("sku").First(&producto, "sku = ?", sku).Error; err != nil {
+			return errors.New("producto no encontrado")
+		}
+		if err := tx.Model(&modelos.ProductoImagen{}).
+			Select("COALESCE(MAX(orden) + 1, 0)").
+			Where("sku = ?", sku).
+			Scan(&imagen.Orden).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&imagen).Error; err != nil {
+			return err
+		}
+
+		// Las claves usan el ID y no el SKU, que puede traer caracteres no válidos en una ruta
+		imagen.Clave = fmt.Sprintf("productos/%d%s", imagen.ID, extension)
+		imagen.ClaveMiniatura = fmt.Sprintf("productos/%d_min.jpg", imagen.ID)
+		for clave, datos := range map[string][]byte{imagen.Clave: contenido, imagen.ClaveMiniatura: miniatura} {
+			if err := almacen.Guardar(clave, datos); err != nil {
+				return err
+			}
+			guardadas = append(guardadas, clave)
+		}
+		return tx.Model(&imagen).Updates(map[string]interface{}{
+			"clave":           imagen.Clave,
+			"clave_miniatura": imagen.ClaveMiniatura,
+		}).Error
+	})
+	if err != nil {
+		eliminarArchivos(almacen, guardadas...)
+		return nil, err
+	}
+	completarURLImagen(&imagen)
+	return &imagen, nil
+}
+
+// OrdenarImagenesProducto fija el orden de las imágenes de un producto. Deben venir todas sus
+// imágenes, una vez cada una; la primera pasa a ser la principal
+func OrdenarImagenesProducto(db *gorm.DB, sku string, ids []uint) ([]modelos.ProductoImagen, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var actuales []uint
+		if err := tx.Model(&modelos.ProductoImagen{}).Where("sku = ?", sku).Pluck("id", &actuales).Error; err != nil {
+			return err
+		}
+		pendientes := make(map[uint]bool, len(actuales))
+		for _, id := range actuales {
+			pendientes[id] = true
+		}
+		if len(ids) != len(actuales) {
+			return fmt.Errorf("deben indicarse las %d imágenes del producto", len(actuales))
+		}
+		for _, id := range ids {
+			if !pendientes[id] {
+				return fmt.Errorf("la imagen %d no pertenece al producto o está repetida", id)
+			}
+			delete(pendientes, id)
+		}
+		for orden, id := range ids {
+			if err := tx.Model(&modelos.ProductoImagen{}).Where("id = ?", id).Update("orden", orden).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetImagenesProducto(db, sku)
+}
+
+// DeleteImagenProducto elimina una imagen y sus archivos
+func DeleteImagenProducto(db *gorm.DB, almacen services.Almacenamiento, sku string, id uint) error {
+	var imagen modelos.ProductoImagen
+	if err := db.Where("id = ? AND sku = ?", id, sku).First(&imagen).Error; err != nil {
+		return ErrImagenNoEncontrada
+	}
+	if err := db.Delete(&imagen).Error; err != nil {
+		return err
+	}
+	eliminarArchivos(almacen, imagen.Clave, imagen.ClaveMiniatura)
+	return nil
+}
+
+// GetImagenProducto busca una imagen del producto indicado
+func GetImagenProducto(db *gorm.DB, sku string, id uint) (*modelos.ProductoImagen, error) {
+	var imagen modelos.ProductoImagen
+	err := db.Where("id = ? AND sku = ?", id, sku).First(&imagen).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrImagenNoEncontrada
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &imagen, nil
+}
+
+// LeerImagenProducto entrega el contenido de una imagen o de su miniatura, con su tipo
+func LeerImagenProducto(almacen services.Almacenamiento, imagen *modelos.ProductoImagen, miniatura bool) ([]byte, string, error) {
+	clave, tipo := imagen.Clave, imagen.ContentType
+	if miniatura {
+		clave, tipo = imagen.ClaveMiniatura, "image/jpeg"
+	}
+	contenido, err := almacen.Leer(clave)
+	if errors.Is(err, services.ErrArchivoNoEncontrado) {
+		return nil, "", ErrImagenNoEncontrada
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return contenido, tipo, nil
+}
+
+// EliminarArchivosImagenesProducto borra del almacenamiento los archivos de las imágenes de un
+// producto. Se usa al eliminar el producto, cuyas filas de imágenes se borran en cascada
+func EliminarArchivosImagenesProducto(almacen services.Almacenamiento, imagenes []modelos.ProductoImagen) {
+	for _, imagen := range imagenes {
+		eliminarArchivos(almacen, imagen.Clave, imagen.ClaveMiniatura)
+	}
+}
+
+// eliminarArchivos borra archivos del almacenamiento; un archivo que no se pudo borrar queda
+// huérfano pero no impide la operación, así que solo se registra
+func eliminarArchivos(almacen services.Almacenamiento, claves ...string) {
+	for _, clave := range claves {
+		if clave == "" {
+			continue
+		}
+		if err := almacen.Eliminar(clave); err != nil {
+			log.Printf("No se pudo eliminar el archivo %s: %v", clave, err)
+		}
+	}
+}
+
+// completarURLsImagenes arma las rutas desde donde se sirven las imágenes y sus miniaturas
+func completarURLsImagenes(imagenes []modelos.ProductoImagen) {
+	for i := range imagenes {
+		completarURLImagen(&imagenes[i])
+	}
+}
+
+func completarURLImagen(imagen *modelos.ProductoImagen) {
+	imagen.URL = fmt.Sprintf("/api/productos/%s/imagenes/%d", url.PathEscape(imagen.SKU), imagen.ID)
+	imagen.URLMiniatura = imagen.URL + "/miniatura"
+}
+
+// bytesPorPixel estima la memoria por píxel de una imagen decodificada con el modelo de color dado
+func bytesPorPixel(modelo color.Model) int {
+	if _, ok := modelo.(color.Palette); ok {
+		return 1
+	}
+	switch modelo {
+	case color.GrayModel:
+		return 1
+	case color.Gray16Model:
+		return 2
+	case color.YCbCrModel:
+		return 3
+	case color.RGBA64Model, color.NRGBA64Model:
+		return 8
+	}
+	return 4
+}
+
+// generarMiniatura reduce la imagen para que su lado mayor no supere el indicado, promediando los
+// píxeles de origen que cubre cada píxel de destino, y la codifica en JPEG. Las transparencias
+// quedan sobre fondo blanco. Las imágenes más chicas no se agrandan
+func generarMiniatura(original image.Image, lado int) ([]byte, error) {
+	limites := original.Bounds()
+	ancho, alto := limites.Dx(), limites.Dy()
+	if ancho == 0 || alto == 0 {
+		return nil, ErrImagenInvalida
+	}
+	destAncho, destAlto := ancho, alto
+	if ancho > lado || alto > lado {
+		if ancho >= alto {
+			destAncho, destAlto = lado, max(1, alto*lado/ancho)
+		} else {
+			destAncho, destAlto = max(1, ancho*lado/alto), lado
+		}
+	}
+
+	// Se promedia directo desde la original, sin copiarla completa a RGBA
+	miniatura := image.NewRGBA(image.Rect(0, 0, destAncho, destAlto))
+	for y := 0; y < destAlto; y++ {
+		y0, y1 := y*alto/destAlto, max((y+1)*alto/destAlto, y*alto/destAlto+1)
+		for x := 0; x < destAncho; x++ {
+			x0, x1 := x*ancho/destAncho, max((x+1)*ancho/destAncho, x*ancho/destAncho+1)
+			var r, g, b, n int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := original.At(limites.Min.X+sx, limites.Min.Y+sy).RGBA()
+					fondo := 0xffff - ca
+					r += int((cr + fondo) >> 8)
+					g += int((cg + fondo) >> 8)
+					b += int((cb + fondo) >> 8)
+					n++
+				}
+			}
+			i := miniatura.PixOffset(x, y)
+			miniatura.Pix[i] = uint8(r / n)
+			miniatura.Pix[i+1] = uint8(g / n)
+			miniatura.Pix[i+2] = uint8(b / n)
+			miniatura.Pix[i+3] = 0xff
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, miniatura, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
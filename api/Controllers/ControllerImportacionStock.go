@@ -0,0 +1,446 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Formatos aceptados en la carga y descarga masiva de stock
+const (
+	FormatoCSV  = "csv"
+	FormatoXLSX = "xlsx"
+)
+
+// ErrImportacionConErrores indica que el archivo tiene filas inválidas y no se aplicó nada
+var ErrImportacionConErrores = errors.New("el archivo tiene filas con errores; no se aplicó ningún cambio")
+
+// FilaArchivoStock es una fila leída del archivo, antes de validar
+type FilaArchivoStock struct {
+	Fila     int
+	SKU      string
+	Sucursal string
+	Cantidad string
+}
+
+// FilaImportacion es el resultado de validar una fila: lo que hay hoy, lo que quedará y sus errores
+type FilaImportacion struct {
+	Fila           int      `json:"fila"`
+	SKU            string   `json:"sku"`
+	SucursalID     uint     `json:"sucursal_id"`
+	Sucursal       string   `json:"sucursal"`
+	CantidadActual int      `json:"cantidad_actual"`
+	CantidadNueva  int      `json:"cantidad_nueva"`
+	Diferencia     int      `json:"diferencia"`
+	Nuevo          bool     `json:"nuevo"` // no existía registro de stock en la sucursal
+	Errores        []string `json:"errores,omitempty"`
+}
+
+// ResultadoImportacion es la vista previa (o el resultado) de una carga masiva
+type ResultadoImportacion struct {
+	ImportacionID *uint             `json:"importacion_id,omitempty"`
+	Archivo       string            `json:"archivo"`
+	Formato       string            `json:"formato"`
+	Aplicada      bool              `json:"aplicada"`
+	Filas         int               `json:"filas"`
+	Validas       int               `json:"validas"`
+	ConErrores    int               `json:"con_errores"`
+	Creados       int               `json:"creados"`
+	Ajustados     int               `json:"ajustados"`
+	SinCambios    int               `json:"sin_cambios"`
+	UnidadesNetas int               `json:"unidades_netas"`
+	Detalle       []FilaImportacion `json:"detalle"`
+}
+
+// LeerArchivoStock lee un CSV o XLSX con las columnas sku, sucursal (ID o nombre) y cantidad.
+// La primera fila debe traer los encabezados; se ignoran las columnas adicionales
+func LeerArchivoStock(nombre string, contenido []byte) ([]FilaArchivoStock, string, error) {
+	var formato string
+	var celdas [][]string
+	switch strings.ToLower(filepath.Ext(nombre)) {
+	case ".csv", ".txt":
+		formato = FormatoCSV
+		filas, err := leerCSV(contenido)
+		if err != nil {
+			return nil, formato, err
+		}
+		celdas = filas
+	case ".xlsx":
+		formato = FormatoXLSX
+		filas, err := leerXLSX(bytes.NewReader(contenido), int64(len(contenido)))
+		if err != nil {
+			return nil, formato, err
+		}
+		celdas = filas
+	default:
+		return nil, "", errors.New("formato no soportado, use un archivo .csv o .xlsx")
+	}
+
+	if len(celdas) == 0 {
+		return nil, formato, errors.New("el archivo está vacío")
+	}
+
+	colSKU, colSucursal, colCantidad := -1, -1, -1
+	for i, encabezado := range celdas[0] {
+		switch strings.ToLower(strings.TrimSpace(encabezado)) {
+		case "sku":
+			colSKU = i
+		case "sucursal_id", "sucursal":
+			if colSucursal == -1 {
+				colSucursal = i
+			}
+		case "cantidad":
+			colCantidad = i
+		}
+	}
+	if colSKU == -1 || colSucursal == -1 || colCantidad == -1 {
+		return nil, formato, errors.New("el archivo debe tener las columnas sku, sucursal (o sucursal_id) y cantidad")
+	}
+
+	celda := func(fila []string, col int) string {
+		if col < len(fila) {
+			return strings.TrimSpace(fila[col])
+		}
+		return ""
+	}
+
+	filas := make([]FilaArchivoStock, 0, len(celdas)-1)
+	for i, fila := range celdas[1:] {
+		f := FilaArchivoStock{
+			Fila:     i + 2, // la fila 1 son los encabezados
+			SKU:      celda(fila, colSKU),
+			Sucursal: celda(fila, colSucursal),
+			Cantidad: celda(fila, colCantidad),
+		}
+		if f.SKU == "" && f.Sucursal == "" && f.Cantidad == "" {
+			continue
+		}
+		filas = append(filas, f)
+	}
+	if len(filas) == 0 {
+		return nil, formato, errors.New("el archivo no tiene filas de datos")
+	}
+	return filas, formato, nil
+}
+
+// leerCSV acepta separador coma o punto y coma (el de Excel en configuración regional chilena)
+func leerCSV(contenido []byte) ([][]string, error) {
+	contenido = bytes.TrimPrefix(contenido, []byte("\xef\xbb\xbf"))
+	primeraLinea := contenido
+	if i := bytes.IndexByte(contenido, '\n'); i >= 0 {
+		primeraLinea = contenido[:i]
+	}
+
+	r := csv.NewReader(bytes.NewReader(contenido))
+	if bytes.Count(primeraLinea, []byte(";")) > bytes.Count(primeraLinea, []byte(",")) {
+		r.Comma = ';'
+	}
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	filas, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("CSV inválido: %v", err)
+	}
+	return filas, nil
+}
+
+// ValidarImportacionStock arma la vista previa de la carga sin escribir nada
+func ValidarImportacionStock(db *gorm.DB, archivo, formato string, filas []FilaArchivoStock) (*ResultadoImportacion, error) {
+	return validarImportacion(db, archivo, formato, filas, false)
+}
+
+// ImportarStock valida nuevamente el archivo y, si no hay errores, deja cada registro con la
+// cantidad indicada en una sola transacción. Las diferencias quedan en el kardex como ajustes
+// con referencia a la importación, que se registra para auditoría
+func ImportarStock(db *gorm.DB, archivo, formato string, filas []FilaArchivoStock, usuario string) (*ResultadoImportacion, error) {
+	var resultado *ResultadoImportacion
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		resultado, err = validarImportacion(tx, archivo, formato, filas, true)
+		if err != nil {
+			return err
+		}
+		if resultado.ConErrores > 0 {
+			return ErrImportacionConErrores
+		}
+
+		importacion := modelos.ImportacionStock{
+			Archivo:       archivo,
+			Formato:       formato,
+			Filas:         resultado.Filas,
+			Creados:       resultado.Creados,
+			Ajustados:     resultado.Ajustados,
+			SinCambios:    resultado.SinCambios,
+			UnidadesNetas: resultado.UnidadesNetas,
+			Usuario:       usuario,
+			Fecha:         time.Now(),
+		}
+		if err := tx.Create(&importacion).Error; err != nil {
+			return err
+		}
+		referencia := fmt.Sprintf("importacion #%d", importacion.ID)
+
+		for _, f := range resultado.Detalle {
+			if f.Nuevo {
+				if err := tx.Omit("Producto", "Sucursal").Create(&modelos.StockSucursal{
+					SKU:        f.SKU,
+					SucursalID: f.SucursalID,
+				}).Error; err != nil {
+					return err
+				}
+			}
+			if f.Diferencia == 0 {
+				continue
+			}
+			if err := RegistrarMovimientoStock(tx, &modelos.MovimientoStock{
+				SKU:        f.SKU,
+				SucursalID: f.SucursalID,
+				Tipo:       MovimientoAjuste,
+				Cantidad:   f.Diferencia,
+				Usuario:    usuario,
+				Referencia: referencia,
+			}); err != nil {
+				return fmt.Errorf("fila %d: %v", f.Fila, err)
+			}
+		}
+
+		id := importacion.ID
+		resultado.ImportacionID = &id
+		resultado.Aplicada = true
+		return nil
+	})
+	if errors.Is(err, ErrImportacionConErrores) {
+		return resultado, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resultado, nil
+}
+
+func validarImportacion(db *gorm.DB, archivo, formato string, filas []FilaArchivoStock, bloquear bool) (*ResultadoImportacion, error) {
+	resultado := &ResultadoImportacion{
+		Archivo: archivo,
+		Formato: formato,
+		Filas:   len(filas),
+		Detalle: make([]FilaImportacion, 0, len(filas)),
+	}
+
+	skus := make([]string, 0, len(filas))
+	for _, f := range filas {
+		if f.SKU != "" {
+			skus = append(skus, f.SKU)
+		}
+	}
+
+	productos := make(map[string]modelos.Producto)
+	if len(skus) > 0 {
+		var lista []modelos.Producto
+		if err := db.Select("sku", "nombre", "serializado", "es_kit").Where("sku IN ?", skus).Find(&lista).Error; err != nil {
+			return nil, err
+		}
+		for _, p := range lista {
+			productos[p.SKU] = p
+		}
+	}
+
+	var sucursales []modelos.Sucursal
+	if err := db.Select("id", "nombre").Find(&sucursales).Error; err != nil {
+		return nil, err
+	}
+	sucursalPorID := make(map[uint]modelos.Sucursal, len(sucursales))
+	sucursalPorNombre := make(map[string][]modelos.Sucursal, len(sucursales))
+	for _, s := range sucursales {
+		sucursalPorID[s.ID] = s
+		nombre := strings.ToLower(strings.TrimSpace(s.Nombre))
+		sucursalPorNombre[nombre] = append(sucursalPorNombre[nombre], s)
+	}
+
+	actuales := make(map[claveStock]int)
+	if len(skus) > 0 {
+		var stocks []modelos.StockSucursal
+		query := db.Select("sku", "sucursal_id", "cantidad").Where("sku IN ?", skus)
+		if bloquear {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+		if err := query.Find(&stocks).Error; err != nil {
+			return nil, err
+		}
+		for _, s := range stocks {
+			actuales[claveStock{SKU: s.SKU, SucursalID: s.SucursalID}] = s.Cantidad
+		}
+	}
+
+	vistas := make(map[claveStock]int, len(filas))
+	for _, f := range filas {
+		linea := FilaImportacion{Fila: f.Fila, SKU: f.SKU}
+
+		producto, existeProducto := productos[f.SKU]
+		switch {
+		case f.SKU == "":
+			linea.Errores = append(linea.Errores, "falta el SKU")
+		case !existeProducto:
+			linea.Errores = append(linea.Errores, fmt.Sprintf("el producto %s no existe", f.SKU))
+		case producto.EsKit:
+			linea.Errores = append(linea.Errores, fmt.Sprintf("el producto %s es un kit; su stock se calcula desde sus componentes", f.SKU))
+		}
+
+		var sucursal modelos.Sucursal
+		encontrada := false
+		if f.Sucursal == "" {
+			linea.Errores = append(linea.Errores, "falta la sucursal")
+		} else if id, err := strconv.ParseUint(f.Sucursal, 10, 64); err == nil {
+			if sucursal, encontrada = sucursalPorID[uint(id)]; !encontrada {
+				linea.Errores = append(linea.Errores, fmt.Sprintf("la sucursal %s no existe", f.Sucursal))
+			}
+		} else {
+			switch coincidencias := sucursalPorNombre[strings.ToLower(f.Sucursal)]; len(coincidencias) {
+			case 0:
+				linea.Errores = append(linea.Errores, fmt.Sprintf("la sucursal %s no existe", f.Sucursal))
+			case 1:
+				sucursal, encontrada = coincidencias[0], true
+			default:
+				linea.Errores = append(linea.Errores, fmt.Sprintf("hay varias sucursales llamadas %q, use el ID", f.Sucursal))
+			}
+		}
+		if encontrada {
+			linea.SucursalID = sucursal.ID
+			linea.Sucursal = sucursal.Nombre
+		}
+
+		cantidad, err := strconv.Atoi(f.Cantidad)
+		if err != nil {
+			// Las planillas suelen guardar enteros como "10.0"
+			if v, errFloat := strconv.ParseFloat(f.Cantidad, 64); errFloat == nil && v == float64(int(v)) {
+				cantidad, err = int(v), nil
+			}
+		}
+		cantidadValida := err == nil && cantidad >= 0
+		switch {
+		case err != nil:
+			linea.Errores = append(linea.Errores, fmt.Sprintf("cantidad %q no es un número entero", f.Cantidad))
+		case cantidad < 0:
+			linea.Errores = append(linea.Errores, "la cantidad no puede ser negativa")
+		default:
+			linea.CantidadNueva = cantidad
+		}
+
+		if existeProducto && !producto.EsKit && encontrada {
+			clave := claveStock{SKU: f.SKU, SucursalID: sucursal.ID}
+			if anterior, repetida := vistas[clave]; repetida {
+				linea.Errores = append(linea.Errores, fmt.Sprintf("el producto y la sucursal ya vienen en la fila %d", anterior))
+			} else {
+				vistas[clave] = f.Fila
+			}
+
+			actual, existe := actuales[clave]
+			linea.CantidadActual = actual
+			linea.Nuevo = !existe
+			linea.Diferencia = linea.CantidadNueva - actual
+			if producto.Serializado && linea.Diferencia != 0 && cantidadValida {
+				linea.Errores = append(linea.Errores, fmt.Sprintf("el producto %s es serializado: las diferencias deben registrarse con sus números de serie", f.SKU))
+			}
+		}
+
+		if len(linea.Errores) > 0 {
+			linea.Diferencia = 0
+			resultado.ConErrores++
+		} else {
+			resultado.Validas++
+			switch {
+			case linea.Nuevo:
+				resultado.Creados++
+			case linea.Diferencia != 0:
+				resultado.Ajustados++
+			default:
+				resultado.SinCambios++
+			}
+			resultado.UnidadesNetas += linea.Diferencia
+		}
+		resultado.Detalle = append(resultado.Detalle, linea)
+	}
+	return resultado, nil
+}
+
+// GetImportacionesStock lista las cargas masivas aplicadas, de la más reciente a la más antigua
+func GetImportacionesStock(db *gorm.DB) ([]modelos.ImportacionStock, error) {
+	var importaciones []modelos.ImportacionStock
+	if err := db.Order("fecha DESC").Find(&importaciones).Error; err != nil {
+		return nil, err
+	}
+	return importaciones, nil
+}
+
+// GetFilasExportacionStock arma la planilla del stock vigente (opcionalmente de una sucursal),
+// con los mismos encabezados que acepta la importación
+func GetFilasExportacionStock(db *gorm.DB, sucursalID uint) ([][]interface{}, error) {
+	var filas []struct {
+		SKU           string
+		Nombre        string
+		SucursalID    uint
+		Sucursal      string
+		Cantidad      int
+		Reservado     int
+		CostoPromedio float64
+	}
+	query := db.Table("stock_sucursal AS s").
+		Select(`s.sku, p.nombre, s.sucursal_id, su.nombre AS sucursal, s.cantidad,
+			COALESCE(r.reservado, 0) AS reservado, s.costo_promedio`).
+		Joins("JOIN productos p ON p.sku = s.sku").
+		Joins("JOIN sucursales su ON su.id = s.sucursal_id").
+		Joins(`LEFT JOIN (
+			SELECT sku, sucursal_id, SUM(cantidad) AS reservado
+			FROM reservas_stock
+			WHERE estado = ? AND fecha_expira > ?
+			GROUP BY sku, sucursal_id
+		) r ON r.sku = s.sku AND r.sucursal_id = s.sucursal_id`, ReservaActiva, time.Now()).
+		Order("su.nombre, s.sku")
+	if sucursalID != 0 {
+		query = query.Where("s.sucursal_id = ?", sucursalID)
+	}
+	if err := query.Scan(&filas).Error; err != nil {
+		return nil, err
+	}
+
+	resultado := make([][]interface{}, 0, len(filas)+1)
+	resultado = append(resultado, []interface{}{"sku", "nombre", "sucursal_id", "sucursal", "cantidad", "reservado", "disponible", "costo_promedio"})
+	for _, f := range filas {
+		resultado = append(resultado, []interface{}{
+			f.SKU, f.Nombre, f.SucursalID, f.Sucursal, f.Cantidad, f.Reservado, f.Cantidad - f.Reservado, redondearCosto(f.CostoPromedio),
+		})
+	}
+	return resultado, nil
+}
+
+// EscribirExportacionStock escribe la planilla en el formato pedido
+func EscribirExportacionStock(w io.Writer, formato string, filas [][]interface{}) error {
+	switch formato {
+	case FormatoXLSX:
+		return escribirXLSX(w, "Stock", filas)
+	case FormatoCSV:
+		cw := csv.NewWriter(w)
+		for _, fila := range filas {
+			registro := make([]string, len(fila))
+			for i, v := range fila {
+				registro[i] = fmt.Sprint(v)
+			}
+			if err := cw.Write(registro); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return errors.New("formato no soportado, use csv o xlsx")
+	}
+}
@@ -0,0 +1,267 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetComponentesKit obtiene la lista de materiales de un kit
+func GetComponentesKit(db *gorm.DB, sku string) ([]modelos.KitComponente, error) {
+	var producto modelos.Producto
+	if err := db.Select("sku").First(&producto, "sku = ?", sku).Error; err != nil {
+		return nil, errors.New("producto no encontrado")
+	}
+	var componentes []modelos.KitComponente
+	if err := db.Preload("Componente").
+		Where("kit_sku = ?", sku).
+		Order("componente_sku").
+		Find(&componentes).Error; err != nil {
+		return nil, err
+	}
+	return componentes, nil
+}
+
+// SetComponentesKit reemplaza la lista de materiales de un producto. Con al menos un componente
+// el producto pasa a ser un kit; con la lista vacía deja de serlo. Un kit no lleva stock propio,
+// por lo que no puede tener stock en mano al convertirse
+func SetComponentesKit(db *gorm.DB, sku string, componentes []modelos.KitComponente) ([]modelos.KitComponente, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var producto modelos.Producto
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("sku", "es_kit").
+			First(&producto, "sku = ?", sku).Error; err != nil {
+			return errors.New("producto no encontrado")
+		}
+		if err := validarComponentesKit(tx, sku, componentes); err != nil {
+			return err
+		}
+
+		esKit := len(componentes) > 0
+		if esKit && !producto.EsKit {
+			var enMano int64
+			if err := tx.Model(&modelos.StockSucursal{}).
+				Where("sku = ? AND cantidad <> 0", sku).
+				Count(&enMano).Error; err != nil {
+				return err
+			}
+			if enMano > 0 {
+				return fmt.Errorf("el producto %s tiene stock en mano; un kit no lleva stock propio", sku)
+			}
+		}
+
+		if err := tx.Where("kit_sku = ?", sku).Delete(&modelos.KitComponente{}).Error; err != nil {
+			return err
+		}
+		if esKit {
+			if err := tx.Omit("Componente").Create(&componentes).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&modelos.Producto{}).Where("sku = ?", sku).Updates(map[string]interface{}{
+			"es_kit":  esKit,
+			"version": gorm.Expr("version + 1"),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetComponentesKit(db, sku)
+}
+
+// validarComponentesKit revisa la lista de materiales y completa el SKU del kit en cada línea.
+// Los componentes deben existir, no repetirse y no ser kits a su vez
+func validarComponentesKit(tx *gorm.DB, kitSKU string, componentes []modelos.KitComponente) error {
+	vistos := make(map[string]bool, len(componentes))
+	skus := make([]string, 0, len(componentes))
+	for i := range componentes {
+		c := &componentes[i]
+		c.KitSKU = kitSKU
+		if c.ComponenteSKU == "" {
+			return errors.New("el SKU del componente es obligatorio")
+		}
+		if c.ComponenteSKU == kitSKU {
+			return errors.New("un kit no puede contenerse a sí mismo")
+		}
+		if c.Cantidad <= 0 {
+			return fmt.Errorf("la cantidad del componente %s debe ser mayor que cero", c.ComponenteSKU)
+		}
+		if vistos[c.ComponenteSKU] {
+			return fmt.Errorf("el componente %s está repetido", c.ComponenteSKU)
+		}
+		vistos[c.ComponenteSKU] = true
+		skus = append(skus, c.ComponenteSKU)
+	}
+	if len(skus) == 0 {
+		return nil
+	}
+
+	var productos []modelos.Producto
+	if err := tx.Select("sku", "es_kit").Where("sku IN ?", skus).Find(&productos).Error; err != nil {
+		return err
+	}
+	existentes := make(map[string]bool, len(productos))
+	for _, p := range productos {
+		if p.EsKit {
+			return fmt.Errorf("el componente %s es un kit; los kits no se anidan", p.SKU)
+		}
+		existentes[p.SKU] = true
+	}
+	for _, sku := range skus {
+		if !existentes[sku] {
+			return fmt.Errorf("el componente %s no existe", sku)
+		}
+	}
+	return nil
+}
+
+// componentesPorKit carga de una vez la lista de materiales de los kits entre los SKU indicados.
+// Los SKU que no son kits no aparecen en el resultado
+func componentesPorKit(db *gorm.DB, skus []string) (map[string][]modelos.KitComponente, error) {
+	resultado := make(map[string][]modelos.KitComponente)
+	if len(skus) == 0 {
+		return resultado, nil
+	}
+	var componentes []modelos.KitComponente
+	if err := db.Where("kit_sku IN ?", skus).Order("kit_sku, componente_sku").Find(&componentes).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range componentes {
+		resultado[c.KitSKU] = append(resultado[c.KitSKU], c)
+	}
+	return resultado, nil
+}
+
+// lineaStock es una cantidad pedida de un SKU (producto o kit) en una sucursal
+type lineaStock struct {
+	claveStock
+	Cantidad int
+}
+
+// stockRequerido expande los kits de las líneas en sus componentes y suma lo que se necesita de
+// cada SKU en cada sucursal, en el orden en que aparece por primera vez
+func stockRequerido(tx *gorm.DB, lineas []lineaStock) ([]claveStock, map[claveStock]int, error) {
+	skus := make([]string, 0, len(lineas))
+	for _, l := range lineas {
+		skus = append(skus, l.SKU)
+	}
+	kits, err := componentesPorKit(tx, skus)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var orden []claveStock
+	cantidades := make(map[claveStock]int)
+	sumar := func(sku string, sucursalID uint, cantidad int) {
+		clave := claveStock{SKU: sku, SucursalID: sucursalID}
+		if _, ok := cantidades[clave]; !ok {
+			orden = append(orden, clave)
+		}
+		cantidades[clave] += cantidad
+	}
+	for _, l := range lineas {
+		componentes, esKit := kits[l.SKU]
+		if !esKit {
+			sumar(l.SKU, l.SucursalID, l.Cantidad)
+			continue
+		}
+		for _, c := range componentes {
+			sumar(c.ComponenteSKU, l.SucursalID, l.Cantidad*c.Cantidad)
+		}
+	}
+	return orden, cantidades, nil
+}
+
+// disponibilidadKits calcula cuántos kits completos se pueden armar en cada sucursal a partir del
+// stock de sus componentes: el mínimo, entre los componentes, de las unidades disponibles dividido
+// por las que lleva cada kit. Solo aparecen las sucursales con stock de algún componente
+func disponibilidadKits(db *gorm.DB, kits []string) ([]filaDisponibilidad, error) {
+	var filas []filaDisponibilidad
+	if len(kits) == 0 {
+		return filas, nil
+	}
+	if err := db.Table("kit_componentes AS kc").
+		Select(`kc.kit_sku AS sku, su.id AS sucursal_id, su.nombre AS sucursal, su.direccion, su.comuna, su.ciudad,
+			MIN(COALESCE(s.cantidad, 0) / kc.cantidad) AS en_mano,
+			MIN(GREATEST(COALESCE(s.cantidad, 0) - COALESCE(r.reservado, 0), 0) / kc.cantidad) AS disponible`).
+		Joins("CROSS JOIN sucursales su").
+		Joins("LEFT JOIN stock_sucursal s ON s.sku = kc.componente_sku AND s.sucursal_id = su.id").
+		Joins(`LEFT JOIN (
+			SELECT sku, sucursal_id, SUM(cantidad) AS reservado
+			FROM reservas_stock
+			WHERE estado = ? AND fecha_expira > ?
+			GROUP BY sku, sucursal_id
+		) r ON r.sku = kc.componente_sku AND r.sucursal_id = su.id`, ReservaActiva, time.Now()).
+		Where("kc.kit_sku IN ?", kits).
+		Group("kc.kit_sku, su.id, su.nombre, su.direccion, su.comuna, su.ciudad").
+		Having("COUNT(s.sku) > 0").
+		Order("kc.kit_sku, disponible DESC, su.nombre").
+		Scan(&filas).Error; err != nil {
+		return nil, err
+	}
+	// Lo reservado de un kit son los kits que se podrían armar con el stock en mano pero no con el disponible
+	for i := range filas {
+		filas[i].Reservado = filas[i].EnMano - filas[i].Disponible
+	}
+	return filas, nil
+}
+
+// salidaKit descuenta del stock los componentes de los kits despachados. Cada componente queda
+// como línea del despacho (con sus lotes y series) y la composición se guarda junto al kit, cuyo
+// costo es la suma del costo de sus componentes
+func salidaKit(tx *gorm.DB, despacho *modelos.Despacho, kitSKU string, sucursalID uint, cantidad int, usuario string) error {
+	var componentes []modelos.KitComponente
+	if err := tx.Where("kit_sku = ?", kitSKU).Order("componente_sku").Find(&componentes).Error; err != nil {
+		return err
+	}
+	if len(componentes) == 0 {
+		return fmt.Errorf("el kit %s no tiene componentes", kitSKU)
+	}
+
+	var costoKit float64
+	for _, c := range componentes {
+		unidades := cantidad * c.Cantidad
+		linea := modelos.ProductosDespacho{
+			DespachoID:     despacho.ID,
+			ProductoID:     c.ComponenteSKU,
+			CantidadEnKits: unidades,
+		}
+		if err := tx.Omit("Lotes", "Series", "Componentes").Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "despacho_id"}, {Name: "sku"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"cantidad_en_kits": gorm.Expr("productos_despacho.cantidad_en_kits + ?", unidades)}),
+		}).Create(&linea).Error; err != nil {
+			return err
+		}
+
+		mov, err := descontarDespacho(tx, despacho, c.ComponenteSKU, kitSKU, sucursalID, unidades, nil, usuario)
+		if err != nil {
+			return err
+		}
+		costo := float64(unidades) * mov.CostoUnitario
+		costoKit += costo
+
+		if err := tx.Omit("Componente").Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "despacho_id"}, {Name: "kit_sku"}, {Name: "sku"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"cantidad":    gorm.Expr("productos_despacho_componente.cantidad + ?", unidades),
+				"costo_total": gorm.Expr("productos_despacho_componente.costo_total + ?", costo),
+			}),
+		}).Create(&modelos.ProductosDespachoComponente{
+			DespachoID: despacho.ID,
+			KitSKU:     kitSKU,
+			SKU:        c.ComponenteSKU,
+			Cantidad:   unidades,
+			CostoTotal: costo,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&modelos.ProductosDespacho{}).
+		Where("despacho_id = ? AND sku = ?", despacho.ID, kitSKU).
+		Update("costo_total", gorm.Expr("costo_total + ?", costoKit)).Error
+}
@@ -0,0 +1,344 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoteSinIdentificar agrupa el stock de productos con lotes que ingresó sin número de lote
+// (stock previo a activar el control por lotes, ajustes positivos, sobrantes)
+const LoteSinIdentificar = "SIN-LOTE"
+
+// LotePorVencer es un lote con stock cuya fecha de vencimiento está dentro del plazo consultado
+type LotePorVencer struct {
+	LoteID           uint      `json:"lote_id"`
+	SKU              string    `json:"sku"`
+	Nombre           string    `json:"nombre"`
+	SucursalID       uint      `json:"sucursal_id"`
+	Sucursal         string    `json:"sucursal"`
+	Lote             string    `json:"lote"`
+	FechaVencimiento time.Time `json:"fecha_vencimiento"`
+	DiasRestantes    int       `json:"dias_restantes"` // negativo si ya venció
+	Cantidad         int       `json:"cantidad"`
+	Valor            float64   `json:"valor"`
+}
+
+// GetLotesStock lista los lotes con stock de un SKU en una sucursal en orden FEFO
+func GetLotesStock(db *gorm.DB, sku string, sucursalID uint) ([]modelos.StockLote, error) {
+	var lotes []modelos.StockLote
+	if err := db.Where("sku = ? AND sucursal_id = ? AND cantidad > 0", sku, sucursalID).
+		Order("fecha_vencimiento ASC NULLS LAST, id ASC").
+		Find(&lotes).Error; err != nil {
+		return nil, err
+	}
+	return lotes, nil
+}
+
+// inicioDia es la medianoche local del día de t. Los vencimientos se comparan por día local: en
+// Chile la medianoche UTC cae en la tarde del día anterior
+func inicioDia(t time.Time) time.Time {
+	anio, mes, dia := t.In(time.Local).Date()
+	return time.Date(anio, mes, dia, 0, 0, 0, 0, time.Local)
+}
+
+// GetLotesPorVencer lista los lotes con stock que vencen dentro de los próximos días,
+// incluidos los ya vencidos, ordenados por fecha de vencimiento
+func GetLotesPorVencer(db *gorm.DB, dias int, sucursalID uint) ([]LotePorVencer, error) {
+	hoy := inicioDia(time.Now())
+	limite := hoy.AddDate(0, 0, dias+1)
+
+	var lotes []modelos.StockLote
+	query := db.Preload("Producto").Preload("Sucursal").
+		Where("cantidad > 0 AND fecha_vencimiento IS NOT NULL AND fecha_vencimiento < ?", limite)
+	if sucursalID != 0 {
+		query = query.Where("sucursal_id = ?", sucursalID)
+	}
+	if err := query.Order("fecha_vencimiento ASC, sku ASC").Find(&lotes).Error; err != nil {
+		return nil, err
+	}
+
+	resultado := make([]LotePorVencer, 0, len(lotes))
+	for _, l := range lotes {
+		resultado = append(resultado, LotePorVencer{
+			LoteID:           l.ID,
+			SKU:              l.SKU,
+			Nombre:           l.Producto.Nombre,
+			SucursalID:       l.SucursalID,
+			Sucursal:         l.Sucursal.Nombre,
+			Lote:             l.Lote,
+			FechaVencimiento: *l.FechaVencimiento,
+			DiasRestantes:    int(math.Round(inicioDia(*l.FechaVencimiento).Sub(hoy).Hours() / 24)), // redondeo por cambios de horario
+			Cantidad:         l.Cantidad,
+			Valor:            float64(l.Cantidad) * l.Producto.Precio,
+		})
+	}
+	return resultado, nil
+}
+
+// aplicarLotes reparte un movimiento de un producto con control por lotes entre sus lotes y deja
+// el detalle en mov.Lotes. Si el movimiento ya trae el reparto (por ejemplo al anular un despacho)
+// se respeta; las entradas van al lote indicado y las salidas consumen FEFO (primero en vencer,
+// primero en salir). Los despachos no toman lotes vencidos.
+func aplicarLotes(tx *gorm.DB, mov *modelos.MovimientoStock, producto *modelos.Producto, stockAnterior int) error {
+	if !producto.ManejaLotes {
+		mov.Lotes = nil
+		return nil
+	}
+
+	if err := cuadrarLotes(tx, mov.SKU, mov.SucursalID, stockAnterior); err != nil {
+		return err
+	}
+
+	switch {
+	case len(mov.Lotes) > 0:
+		total := 0
+		for i := range mov.Lotes {
+			l := &mov.Lotes[i]
+			var lote modelos.StockLote
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("id = ? AND sku = ? AND sucursal_id = ?", l.LoteID, mov.SKU, mov.SucursalID).
+				First(&lote).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("el lote %d no corresponde al producto %s en la sucursal %d", l.LoteID, mov.SKU, mov.SucursalID)
+			}
+			if err != nil {
+				return err
+			}
+			if lote.Cantidad+l.Cantidad < 0 {
+				return fmt.Errorf("stock insuficiente en el lote %s del producto %s", lote.Lote, mov.SKU)
+			}
+			if err := tx.Model(&lote).Update("cantidad", lote.Cantidad+l.Cantidad).Error; err != nil {
+				return err
+			}
+			total += l.Cantidad
+		}
+		if total != mov.Cantidad {
+			return fmt.Errorf("el reparto por lotes del producto %s no coincide con la cantidad del movimiento", mov.SKU)
+		}
+		return nil
+
+	case mov.Cantidad > 0:
+		numero := mov.Lote
+		if numero == "" {
+			if mov.Tipo == MovimientoRecepcion {
+				return fmt.Errorf("el producto %s maneja lotes: debe indicar el lote recibido", mov.SKU)
+			}
+			numero = LoteSinIdentificar
+		}
+		lote, err := obtenerLote(tx, mov.SKU, mov.SucursalID, numero, mov.FechaFabricacion, mov.FechaVencimiento)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(lote).Update("cantidad", lote.Cantidad+mov.Cantidad).Error; err != nil {
+			return err
+		}
+		mov.Lote = numero
+		mov.Lotes = []modelos.MovimientoLote{{LoteID: lote.ID, Cantidad: mov.Cantidad}}
+		return nil
+
+	default:
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("sku = ? AND sucursal_id = ? AND cantidad > 0", mov.SKU, mov.SucursalID)
+		if mov.Lote != "" {
+			query = query.Where("lote = ?", mov.Lote)
+		}
+		if mov.Tipo == MovimientoDespacho {
+			query = query.Where("(fecha_vencimiento IS NULL OR fecha_vencimiento >= ?)", inicioDia(time.Now()))
+		}
+		var lotes []modelos.StockLote
+		if err := query.Order("fecha_vencimiento ASC NULLS LAST, id ASC").Find(&lotes).Error; err != nil {
+			return err
+		}
+
+		restante := -mov.Cantidad
+		mov.Lotes = nil
+		for _, lote := range lotes {
+			if restante == 0 {
+				break
+			}
+			tomar := lote.Cantidad
+			if tomar > restante {
+				tomar = restante
+			}
+			if err := tx.Model(&lote).Update("cantidad", lote.Cantidad-tomar).Error; err != nil {
+				return err
+			}
+			mov.Lotes = append(mov.Lotes, modelos.MovimientoLote{LoteID: lote.ID, Cantidad: -tomar})
+			restante -= tomar
+		}
+		if restante > 0 {
+			if mov.Lote != "" {
+				return fmt.Errorf("stock insuficiente en el lote %s del producto %s", mov.Lote, mov.SKU)
+			}
+			if mov.Tipo == MovimientoDespacho {
+				return fmt.Errorf("stock vigente insuficiente para el producto %s en la sucursal %d: faltan %d unidades no vencidas", mov.SKU, mov.SucursalID, restante)
+			}
+			return fmt.Errorf("stock por lotes insuficiente para el producto %s en la sucursal %d", mov.SKU, mov.SucursalID)
+		}
+		return nil
+	}
+}
+
+// cuadrarLotes lleva al lote sin identificar el stock que no está asignado a ningún lote,
+// por ejemplo el que existía antes de activar el control por lotes del producto
+func cuadrarLotes(tx *gorm.DB, sku string, sucursalID uint, stock int) error {
+	var enLotes int
+	if err := tx.Model(&modelos.StockLote{}).
+		Select("COALESCE(SUM(cantidad), 0)").
+		Where("sku = ? AND sucursal_id = ?", sku, sucursalID).
+		Scan(&enLotes).Error; err != nil {
+		return err
+	}
+	if enLotes >= stock {
+		return nil
+	}
+
+	lote, err := obtenerLote(tx, sku, sucursalID, LoteSinIdentificar, nil, nil)
+	if err != nil {
+		return err
+	}
+	return tx.Model(lote).Update("cantidad", lote.Cantidad+stock-enLotes).Error
+}
+
+// obtenerLote busca el lote de un SKU en una sucursal bloqueándolo, y lo crea si no existe
+func obtenerLote(tx *gorm.DB, sku string, sucursalID uint, numero string, fabricacion, vencimiento *time.Time) (*modelos.StockLote, error) {
+	nuevo := modelos.StockLote{
+		SKU:              sku,
+		SucursalID:       sucursalID,
+		Lote:             numero,
+		FechaFabricacion: fabricacion,
+		FechaVencimiento: vencimiento,
+		FechaIngreso:     time.Now(),
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Omit("Producto", "Sucursal").
+		Create(&nuevo).Error; err != nil {
+		return nil, err
+	}
+
+	var lote modelos.StockLote
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("sku = ? AND sucursal_id = ? AND lote = ?", sku, sucursalID, numero).
+		First(&lote).Error; err != nil {
+		return nil, err
+	}
+
+	// Un lote que ya existía sin fechas las toma de la nueva recepción
+	cambios := map[string]interface{}{}
+	if lote.FechaFabricacion == nil && fabricacion != nil {
+		cambios["fecha_fabricacion"] = fabricacion
+		lote.FechaFabricacion = fabricacion
+	}
+	if lote.FechaVencimiento == nil && vencimiento != nil {
+		cambios["fecha_vencimiento"] = vencimiento
+		lote.FechaVencimiento = vencimiento
+	}
+	if len(cambios) > 0 {
+		if err := tx.Model(&lote).Updates(cambios).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &lote, nil
+}
+
+// registrarLotesDespacho guarda en la línea del despacho los lotes de los que salió la mercadería.
+// kitSKU indica el kit en que salió, vacío si salió suelta
+func registrarLotesDespacho(tx *gorm.DB, despachoID uint, sku, kitSKU string, lotes []modelos.MovimientoLote) error {
+	for _, ml := range lotes {
+		var lote modelos.StockLote
+		if err := tx.First(&lote, ml.LoteID).Error; err != nil {
+			return err
+		}
+		linea := modelos.ProductosDespachoLote{
+			DespachoID:       despachoID,
+			SKU:              sku,
+			KitSKU:           kitSKU,
+			LoteID:           lote.ID,
+			Lote:             lote.Lote,
+			FechaVencimiento: lote.FechaVencimiento,
+			Cantidad:         -ml.Cantidad,
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "despacho_id"}, {Name: "sku"}, {Name: "kit_sku"}, {Name: "lote_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"cantidad": gorm.Expr("productos_despacho_lote.cantidad + ?", linea.Cantidad)}),
+		}).Create(&linea).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lotesRecepcionTransferencia arma el reparto por lotes de lo recibido de una transferencia: los
+// lotes despachados desde el origen que aún no se recibieron, en orden FEFO. Lo recibido por
+// sobre lo despachado queda en el lote sin identificar.
+func lotesRecepcionTransferencia(tx *gorm.DB, t *modelos.Transferencia, sku string, cantidad int) ([]modelos.MovimientoLote, error) {
+	type loteTransferido struct {
+		Lote             string
+		FechaFabricacion *time.Time
+		FechaVencimiento *time.Time
+		Cantidad         int
+	}
+	referencia := fmt.Sprintf("transferencia #%d", t.ID)
+
+	var despachados []loteTransferido
+	if err := tx.Table("movimiento_lote AS ml").
+		Select("l.lote, l.fecha_fabricacion, l.fecha_vencimiento, -SUM(ml.cantidad) AS cantidad").
+		Joins("JOIN movimientos_stock m ON m.id = ml.movimiento_id").
+		Joins("JOIN stock_lote l ON l.id = ml.lote_id").
+		Where("m.tipo = ? AND m.referencia = ? AND m.sku = ? AND m.sucursal_id = ?", MovimientoTransferenciaSalida, referencia, sku, t.OrigenID).
+		Group("l.lote, l.fecha_fabricacion, l.fecha_vencimiento").
+		Order("l.fecha_vencimiento ASC NULLS LAST, l.lote ASC").
+		Scan(&despachados).Error; err != nil {
+		return nil, err
+	}
+	if len(despachados) == 0 {
+		return nil, nil
+	}
+
+	var recibidos []loteTransferido
+	if err := tx.Table("movimiento_lote AS ml").
+		Select("l.lote, SUM(ml.cantidad) AS cantidad").
+		Joins("JOIN movimientos_stock m ON m.id = ml.movimiento_id").
+		Joins("JOIN stock_lote l ON l.id = ml.lote_id").
+		Where("m.tipo = ? AND m.referencia = ? AND m.sku = ? AND m.sucursal_id = ?", MovimientoTransferenciaEntrada, referencia, sku, t.DestinoID).
+		Group("l.lote").
+		Scan(&recibidos).Error; err != nil {
+		return nil, err
+	}
+	yaRecibido := make(map[string]int)
+	for _, r := range recibidos {
+		yaRecibido[r.Lote] = r.Cantidad
+	}
+
+	var reparto []modelos.MovimientoLote
+	restante := cantidad
+	for _, d := range despachados {
+		pendiente := d.Cantidad - yaRecibido[d.Lote]
+		if restante == 0 || pendiente <= 0 {
+			continue
+		}
+		if pendiente > restante {
+			pendiente = restante
+		}
+		lote, err := obtenerLote(tx, sku, t.DestinoID, d.Lote, d.FechaFabricacion, d.FechaVencimiento)
+		if err != nil {
+			return nil, err
+		}
+		reparto = append(reparto, modelos.MovimientoLote{LoteID: lote.ID, Cantidad: pendiente})
+		restante -= pendiente
+	}
+	if restante > 0 {
+		lote, err := obtenerLote(tx, sku, t.DestinoID, LoteSinIdentificar, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		reparto = append(reparto, modelos.MovimientoLote{LoteID: lote.ID, Cantidad: restante})
+	}
+	return reparto, nil
+}
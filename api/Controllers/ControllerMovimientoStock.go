@@ -0,0 +1,196 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Tipos de movimiento de stock
+const (
+	MovimientoDespacho   = "despacho"
+	MovimientoAjuste     = "ajuste"
+	MovimientoRecepcion  = "recepcion"
+	MovimientoDevolucion = "devolucion"
+)
+
+// KardexLinea es un movimiento con su saldo acumulado para la vista de kardex
+type KardexLinea struct {
+	modelos.MovimientoStock
+	Entrada int `json:"entrada"`
+	Salida  int `json:"salida"`
+	Saldo   int `json:"saldo"`
+}
+
+// Kardex es la respuesta del historial de movimientos de un SKU en una sucursal
+type Kardex struct {
+	SKU          string        `json:"sku"`
+	SucursalID   uint          `json:"sucursal_id"`
+	SaldoInicial int           `json:"saldo_inicial"`
+	SaldoFinal   int           `json:"saldo_final"`
+	Movimientos  []KardexLinea `json:"movimientos"`
+}
+
+// RegistrarMovimientoStock aplica la variación de stock del movimiento y lo registra.
+// Debe llamarse dentro de la misma transacción que la operación que origina el cambio.
+func RegistrarMovimientoStock(tx *gorm.DB, mov *modelos.MovimientoStock) error {
+	if mov.Cantidad == 0 {
+		return errors.New("la cantidad del movimiento no puede ser cero")
+	}
+
+	var stock modelos.StockSucursal
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("sku = ? AND sucursal_id = ?", mov.SKU, mov.SucursalID).
+		First(&stock).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("no existe stock del producto %s en la sucursal %d", mov.SKU, mov.SucursalID)
+	}
+	if err != nil {
+		return err
+	}
+
+	saldo := stock.Cantidad + mov.Cantidad
+	if saldo < 0 {
+		return fmt.Errorf("stock insuficiente para el producto %s en la sucursal %d", mov.SKU, mov.SucursalID)
+	}
+	var producto modelos.Producto
+	if err := tx.Select("sku", "maneja_lotes", "serializado", "es_kit").Where("sku = ?", mov.SKU).First(&producto).Error; err != nil {
+		return err
+	}
+	if producto.EsKit {
+		return fmt.Errorf("el producto %s es un kit; su stock se mueve a través de sus componentes", mov.SKU)
+	}
+	if err := aplicarLotes(tx, mov, &producto, stock.Cantidad); err != nil {
+		return err
+	}
+	if err := aplicarSeries(tx, mov, &producto); err != nil {
+		return err
+	}
+	if err := aplicarUbicaciones(tx, mov, stock.Cantidad); err != nil {
+		return err
+	}
+	if err := aplicarCosto(tx, mov, &stock); err != nil {
+		return err
+	}
+
+	// La actualización es condicional: aunque otra transacción se saltara el bloqueo,
+	// el stock nunca queda bajo cero
+	result := tx.Model(&modelos.StockSucursal{}).
+		Where("sku = ? AND sucursal_id = ? AND cantidad + ? >= 0", mov.SKU, mov.SucursalID, mov.Cantidad).
+		Updates(map[string]interface{}{
+			"cantidad":       gorm.Expr("cantidad + ?", mov.Cantidad),
+			"costo_promedio": stock.CostoPromedio,
+			"version":        gorm.Expr("version + 1"),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("stock insuficiente para el producto %s en la sucursal %d", mov.SKU, mov.SucursalID)
+	}
+
+	mov.SaldoResultante = saldo
+	if mov.Fecha.IsZero() {
+		mov.Fecha = time.Now()
+	}
+	return tx.Create(mov).Error
+}
+
+// CreateMovimientoStockEnUnidad registra un movimiento manual cuya cantidad y costo vienen en otra
+// unidad del producto; la conversión a unidades base debe ser exacta
+func CreateMovimientoStockEnUnidad(db *gorm.DB, mov *modelos.MovimientoStock, unidad string) error {
+	if unidad != "" {
+		factor, err := factorUnidad(db, mov.SKU, unidad)
+		if err != nil {
+			return err
+		}
+		cantidad, err := cantidadBase(db, mov.SKU, unidad, float64(mov.Cantidad), true)
+		if err != nil {
+			return err
+		}
+		mov.Cantidad = cantidad
+		mov.CostoUnitario = redondearCosto(mov.CostoUnitario / factor)
+	}
+	return CreateMovimientoStock(db, mov)
+}
+
+// CreateMovimientoStock registra un movimiento manual (recepción, devolución o ajuste). Si ingresan
+// unidades, advierte en el movimiento cuando la sucursal queda sobre su capacidad
+func CreateMovimientoStock(db *gorm.DB, mov *modelos.MovimientoStock) error {
+	switch mov.Tipo {
+	case MovimientoRecepcion, MovimientoDevolucion:
+		if mov.Cantidad <= 0 {
+			return errors.New("la cantidad de una recepción o devolución debe ser positiva")
+		}
+	case MovimientoAjuste:
+	default:
+		return errors.New("tipo de movimiento no permitido")
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if mov.Cantidad > 0 {
+			advertencias, err := advertenciasCapacidad(tx, mov.SucursalID, map[string]int{mov.SKU: mov.Cantidad})
+			if err != nil {
+				return err
+			}
+			mov.Advertencias = advertencias
+		}
+		return RegistrarMovimientoStock(tx, mov)
+	})
+}
+
+// GetKardex obtiene los movimientos de un SKU en una sucursal entre dos fechas, con saldo acumulado.
+// Las fechas son opcionales; si se omiten no se filtra por ese extremo.
+func GetKardex(db *gorm.DB, sucursalID uint, sku string, desde, hasta *time.Time) (*Kardex, error) {
+	kardex := &Kardex{SKU: sku, SucursalID: sucursalID}
+
+	// Sin movimientos en el rango, el saldo inicial es el del último movimiento anterior
+	if desde != nil {
+		var anterior modelos.MovimientoStock
+		err := db.Where("sku = ? AND sucursal_id = ? AND fecha < ?", sku, sucursalID, *desde).
+			Order("fecha DESC, id DESC").
+			First(&anterior).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		kardex.SaldoInicial = anterior.SaldoResultante
+	}
+
+	query := db.Where("sku = ? AND sucursal_id = ?", sku, sucursalID)
+	if desde != nil {
+		query = query.Where("fecha >= ?", *desde)
+	}
+	if hasta != nil {
+		query = query.Where("fecha < ?", *hasta)
+	}
+
+	var movimientos []modelos.MovimientoStock
+	if err := query.Preload("Lotes.Lote").Preload("Ubicaciones.Ubicacion").Order("fecha ASC, id ASC").Find(&movimientos).Error; err != nil {
+		return nil, err
+	}
+
+	// Si hay movimientos en el rango, el saldo inicial se deriva del primero
+	if len(movimientos) > 0 {
+		kardex.SaldoInicial = movimientos[0].SaldoResultante - movimientos[0].Cantidad
+	}
+
+	saldo := kardex.SaldoInicial
+	kardex.Movimientos = make([]KardexLinea, 0, len(movimientos))
+	for _, m := range movimientos {
+		saldo += m.Cantidad
+		linea := KardexLinea{MovimientoStock: m, Saldo: saldo}
+		if m.Cantidad > 0 {
+			linea.Entrada = m.Cantidad
+		} else {
+			linea.Salida = -m.Cantidad
+		}
+		kardex.Movimientos = append(kardex.Movimientos, linea)
+	}
+	kardex.SaldoFinal = saldo
+
+	return kardex, nil
+}
@@ -0,0 +1,328 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Estados de una orden de compra
+const (
+	OrdenCompraBorrador  = "borrador"
+	OrdenCompraEmitida   = "emitida"
+	OrdenCompraParcial   = "parcial"
+	OrdenCompraRecibida  = "recibida"
+	OrdenCompraCancelada = "cancelada"
+)
+
+func GetOrdenesCompra(db *gorm.DB, estado string, proveedorID uint) ([]modelos.OrdenCompra, error) {
+	var ordenes []modelos.OrdenCompra
+	query := db.
+		Preload("Proveedor").
+		Preload("Sucursal").
+		Preload("Lineas.Producto").
+		Order("id DESC")
+	if estado != "" {
+		query = query.Where("estado = ?", estado)
+	}
+	if proveedorID != 0 {
+		query = query.Where("proveedor_id = ?", proveedorID)
+	}
+	if err := query.Find(&ordenes).Error; err != nil {
+		return nil, err
+	}
+	return ordenes, nil
+}
+
+func GetOrdenCompraByID(db *gorm.DB, id uint) (*modelos.OrdenCompra, error) {
+	var orden modelos.OrdenCompra
+	if err := db.
+		Preload("Proveedor").
+		Preload("Sucursal").
+		Preload("Lineas.Producto").
+		Preload("Recepciones.Lineas").
+		First(&orden, id).Error; err != nil {
+		return nil, err
+	}
+	return &orden, nil
+}
+
+func validarOrdenCompra(o *modelos.OrdenCompra) error {
+	if o.ProveedorID == 0 {
+		return errors.New("el proveedor es obligatorio")
+	}
+	if o.SucursalID == 0 {
+		return errors.New("la sucursal de destino es obligatoria")
+	}
+	if len(o.Lineas) == 0 {
+		return errors.New("la orden de compra debe tener al menos una línea")
+	}
+	vistos := make(map[string]bool)
+	for _, l := range o.Lineas {
+		if l.SKU == "" {
+			return errors.New("todas las líneas deben indicar un SKU")
+		}
+		if l.Cantidad <= 0 {
+			return fmt.Errorf("la cantidad del producto %s debe ser mayor a cero", l.SKU)
+		}
+		if l.CostoUnitario < 0 {
+			return fmt.Errorf("el costo unitario del producto %s no puede ser negativo", l.SKU)
+		}
+		if vistos[l.SKU] {
+			return fmt.Errorf("el producto %s está repetido en la orden de compra", l.SKU)
+		}
+		vistos[l.SKU] = true
+	}
+	return nil
+}
+
+// CreateOrdenCompra crea una orden de compra en estado borrador
+func CreateOrdenCompra(db *gorm.DB, nueva *modelos.OrdenCompra) error {
+	if err := validarOrdenCompra(nueva); err != nil {
+		return err
+	}
+	nueva.ID = 0
+	nueva.Estado = OrdenCompraBorrador
+	nueva.FechaCrea = time.Now()
+	nueva.Recepciones = nil
+	for i := range nueva.Lineas {
+		nueva.Lineas[i].CantidadRecibida = 0
+	}
+	return db.Omit("Proveedor", "Sucursal", "Lineas.Producto").Create(nueva).Error
+}
+
+// UpdateOrdenCompra reemplaza los datos y líneas de una orden de compra en borrador
+func UpdateOrdenCompra(db *gorm.DB, id uint, actualizada *modelos.OrdenCompra) (*modelos.OrdenCompra, error) {
+	if err := validarOrdenCompra(actualizada); err != nil {
+		return nil, err
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		existente, err := bloquearOrdenCompra(tx, id)
+		if err != nil {
+			return err
+		}
+		if existente.Estado != OrdenCompraBorrador {
+			return errors.New("solo se pueden modificar órdenes de compra en borrador")
+		}
+
+		if err := tx.Model(existente).Updates(map[string]interface{}{
+			"proveedor_id":   actualizada.ProveedorID,
+			"sucursal_id":    actualizada.SucursalID,
+			"fecha_esperada": actualizada.FechaEsperada,
+			"observacion":    actualizada.Observacion,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("orden_compra_id = ?", id).Delete(&modelos.OrdenCompraLinea{}).Error; err != nil {
+			return err
+		}
+		for _, l := range actualizada.Lineas {
+			linea := modelos.OrdenCompraLinea{OrdenCompraID: id, SKU: l.SKU, Cantidad: l.Cantidad, CostoUnitario: l.CostoUnitario}
+			if err := tx.Omit("Producto").Create(&linea).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetOrdenCompraByID(db, id)
+}
+
+// DeleteOrdenCompra elimina una orden de compra en borrador
+func DeleteOrdenCompra(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		existente, err := bloquearOrdenCompra(tx, id)
+		if err != nil {
+			return err
+		}
+		if existente.Estado != OrdenCompraBorrador {
+			return errors.New("solo se pueden eliminar órdenes de compra en borrador")
+		}
+		return tx.Delete(existente).Error
+	})
+}
+
+// CambiarEstadoOrdenCompra emite o cancela una orden de compra
+func CambiarEstadoOrdenCompra(db *gorm.DB, id uint, estado string) (*modelos.OrdenCompra, error) {
+	var permitidos []string
+	switch estado {
+	case OrdenCompraEmitida:
+		permitidos = []string{OrdenCompraBorrador}
+	case OrdenCompraCancelada:
+		permitidos = []string{OrdenCompraBorrador, OrdenCompraEmitida}
+	default:
+		return nil, errors.New("estado no permitido")
+	}
+
+	result := db.Model(&modelos.OrdenCompra{}).
+		Where("id = ? AND estado IN ?", id, permitidos).
+		Update("estado", estado)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("la orden de compra no existe o no puede pasar a estado %s", estado)
+	}
+	return GetOrdenCompraByID(db, id)
+}
+
+// RecibirOrdenCompra registra una recepción de mercadería: ingresa el stock en la sucursal de destino
+// y lo descuenta del stock del proveedor, todo en una transacción. Se aceptan entregas parciales y
+// cantidades distintas a las pedidas; la orden queda recibida al completarse o al cerrarla.
+func RecibirOrdenCompra(db *gorm.DB, id uint, recibidas []LineaRecepcion, cerrar bool, observacion, usuario string) (*modelos.OrdenCompra, error) {
+	if len(recibidas) == 0 && !cerrar {
+		return nil, errors.New("la recepción debe tener al menos una línea")
+	}
+	if err := ValidarLineasRecepcion(recibidas); err != nil {
+		return nil, err
+	}
+
+	var advertencias []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		orden, err := bloquearOrdenCompra(tx, id)
+		if err != nil {
+			return err
+		}
+		if orden.Estado != OrdenCompraEmitida && orden.Estado != OrdenCompraParcial {
+			return errors.New("solo se pueden recibir órdenes de compra emitidas o parcialmente recibidas")
+		}
+
+		lineas := make(map[string]*modelos.OrdenCompraLinea)
+		for i := range orden.Lineas {
+			lineas[orden.Lineas[i].SKU] = &orden.Lineas[i]
+		}
+
+		recepcion := modelos.RecepcionCompra{
+			OrdenCompraID: orden.ID,
+			Fecha:         time.Now(),
+			Usuario:       usuario,
+			Observacion:   observacion,
+		}
+		if len(recibidas) > 0 {
+			if err := tx.Omit("Lineas").Create(&recepcion).Error; err != nil {
+				return err
+			}
+		}
+
+		referencia := fmt.Sprintf("orden de compra #%d", orden.ID)
+		for _, r := range recibidas {
+			linea, ok := lineas[r.SKU]
+			if !ok {
+				return fmt.Errorf("el producto %s no pertenece a la orden de compra", r.SKU)
+			}
+			if r.Cantidad <= 0 {
+				return fmt.Errorf("la cantidad recibida del producto %s debe ser mayor a cero", r.SKU)
+			}
+
+			if err := tx.Create(&modelos.RecepcionCompraLinea{
+				RecepcionID: recepcion.ID,
+				SKU:         r.SKU,
+				Lote:        r.Lote,
+				Cantidad:    r.Cantidad,
+			}).Error; err != nil {
+				return err
+			}
+
+			if err := asegurarStockSucursal(tx, r.SKU, orden.SucursalID); err != nil {
+				return err
+			}
+			if err := RegistrarMovimientoStock(tx, &modelos.MovimientoStock{
+				SKU:              r.SKU,
+				SucursalID:       orden.SucursalID,
+				Tipo:             MovimientoRecepcion,
+				Cantidad:         r.Cantidad,
+				Usuario:          usuario,
+				Referencia:       referencia,
+				Lote:             r.Lote,
+				FechaFabricacion: r.FechaFabricacion,
+				FechaVencimiento: r.FechaVencimiento,
+				NumerosSerie:     r.Series,
+				CostoUnitario:    linea.CostoUnitario,
+			}); err != nil {
+				return err
+			}
+
+			// El stock del proveedor no puede quedar negativo aunque entregue más de lo informado
+			if err := tx.Model(&modelos.StockProveedor{}).
+				Where("proveedor_id = ? AND sku = ?", orden.ProveedorID, r.SKU).
+				Update("stock", gorm.Expr("GREATEST(stock - ?, 0)", r.Cantidad)).Error; err != nil {
+				return err
+			}
+
+			linea.CantidadRecibida += r.Cantidad
+			if err := tx.Model(&modelos.OrdenCompraLinea{}).
+				Where("orden_compra_id = ? AND sku = ?", orden.ID, r.SKU).
+				Update("cantidad_recibida", linea.CantidadRecibida).Error; err != nil {
+				return err
+			}
+		}
+		if len(recibidas) > 0 {
+			if advertencias, err = advertenciasCapacidad(tx, orden.SucursalID, nil); err != nil {
+				return err
+			}
+		}
+
+		completa := true
+		for _, l := range orden.Lineas {
+			if l.CantidadRecibida < l.Cantidad {
+				completa = false
+				break
+			}
+		}
+		estado := OrdenCompraParcial
+		if completa || cerrar {
+			estado = OrdenCompraRecibida
+		}
+		return tx.Model(orden).Update("estado", estado).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	orden, err := GetOrdenCompraByID(db, id)
+	if err != nil {
+		return nil, err
+	}
+	orden.Advertencias = advertencias
+	return orden, nil
+}
+
+// ValidarLineasRecepcion revisa que una recepción no repita un SKU con el mismo lote; las
+// cantidades de un mismo lote deben venir en una sola línea
+func ValidarLineasRecepcion(recibidas []LineaRecepcion) error {
+	vistas := make(map[[2]string]bool, len(recibidas))
+	for _, r := range recibidas {
+		clave := [2]string{r.SKU, r.Lote}
+		if vistas[clave] {
+			if r.Lote == "" {
+				return fmt.Errorf("el producto %s viene en más de una línea; indique su cantidad en una sola", r.SKU)
+			}
+			return fmt.Errorf("el lote %s del producto %s viene en más de una línea; indique su cantidad en una sola", r.Lote, r.SKU)
+		}
+		vistas[clave] = true
+	}
+	return nil
+}
+
+// bloquearOrdenCompra carga la orden con sus líneas bloqueando la fila para la transacción
+func bloquearOrdenCompra(tx *gorm.DB, id uint) (*modelos.OrdenCompra, error) {
+	var orden modelos.OrdenCompra
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&orden, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("orden de compra no encontrada")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Where("orden_compra_id = ?", id).Find(&orden.Lineas).Error; err != nil {
+		return nil, err
+	}
+	return &orden, nil
+}
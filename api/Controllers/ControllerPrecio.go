@@ -0,0 +1,465 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Estados de un cambio de precio programado
+const (
+	CambioPrecioProgramado = "programado"
+	CambioPrecioAplicado   = "aplicado"
+	CambioPrecioCancelado  = "cancelado"
+)
+
+// PrecioEnFecha es el precio de lista de un SKU en un momento dado y el tramo del historial del
+// que sale. SinHistorial indica que el momento es anterior al historial y se informa el primer
+// precio conocido
+type PrecioEnFecha struct {
+	SKU          string     `json:"sku"`
+	Fecha        time.Time  `json:"fecha"`
+	Precio       float64    `json:"precio"`
+	VigenteDesde *time.Time `json:"vigente_desde,omitempty"`
+	VigenteHasta *time.Time `json:"vigente_hasta,omitempty"`
+	SinHistorial bool       `json:"sin_historial,omitempty"`
+}
+
+// ItemRepreciado compara el precio de un ítem cotizado en la fecha de la cotización con el actual
+type ItemRepreciado struct {
+	SKU            string  `json:"sku"`
+	Nombre         string  `json:"nombre"`
+	SucursalID     uint    `json:"sucursal_id"`
+	Cantidad       int     `json:"cantidad"`
+	Precio         float64 `json:"precio"`
+	PrecioActual   float64 `json:"precio_actual"`
+	Subtotal       float64 `json:"subtotal"`
+	SubtotalActual float64 `json:"subtotal_actual"`
+	SinHistorial   bool    `json:"sin_historial,omitempty"`
+}
+
+// CotizacionRepreciada es una cotización valorizada con los precios de lista vigentes en una fecha
+type CotizacionRepreciada struct {
+	CotizacionID uint             `json:"cotizacion_id"`
+	Fecha        time.Time        `json:"fecha"`
+	Items        []ItemRepreciado `json:"items"`
+	Total        float64          `json:"total"`
+	TotalActual  float64          `json:"total_actual"`
+	Diferencia   float64          `json:"diferencia"` // total actual menos total en la fecha
+}
+
+// GetHistorialPrecios lista los tramos de precio de un producto, del más reciente al más antiguo.
+// Incluye los cambios programados a futuro
+func GetHistorialPrecios(db *gorm.DB, sku string) ([]modelos.PrecioProducto, error) {
+	var producto modelos.Producto
+	if err := db.Select("sku").First(&producto, "sku = ?", sku).Error; err != nil {
+		return nil, errors.New("producto no encontrado")
+	}
+	var tramos []modelos.PrecioProducto
+	if err := db.Where("sku = ?", sku).Order("vigente_desde DESC").Find(&tramos).Error; err != nil {
+		return nil, err
+	}
+	return tramos, nil
+}
+
+// GetPrecioEnFecha obtiene el precio de lista de un producto vigente en un momento
+func GetPrecioEnFecha(db *gorm.DB, sku string, fecha time.Time) (*PrecioEnFecha, error) {
+	var producto modelos.Producto
+	if err := db.Select("sku", "precio").First(&producto, "sku = ?", sku).Error; err != nil {
+		return nil, errors.New("producto no encontrado")
+	}
+	return precioListaEn(db, &producto, fecha)
+}
+
+// precioListaEn busca en el historial el tramo vigente en la fecha. Antes del primer tramo se usa
+// el primer precio conocido, y sin historial, el precio del producto
+func precioListaEn(db *gorm.DB, producto *modelos.Producto, fecha time.Time) (*PrecioEnFecha, error) {
+	resultado := &PrecioEnFecha{SKU: producto.SKU, Fecha: fecha}
+	var tramo modelos.PrecioProducto
+	err := db.Where("sku = ? AND vigente_desde <= ? AND (vigente_hasta IS NULL OR vigente_hasta > ?)", producto.SKU, fecha, fecha).
+		Order("vigente_desde DESC").
+		First(&tramo).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		resultado.SinHistorial = true
+		err = db.Where("sku = ?", producto.SKU).Order("vigente_desde ASC").First(&tramo).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			resultado.Precio = producto.Precio
+			return resultado, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	resultado.Precio = tramo.Precio
+	resultado.VigenteDesde = &tramo.VigenteDesde
+	resultado.VigenteHasta = tramo.VigenteHasta
+	return resultado, nil
+}
+
+// registrarPrecio agrega al historial de un producto un precio vigente desde la fecha indicada. El
+// tramo que la contiene se corta ahí y el nuevo llega hasta el siguiente tramo ya registrado (un
+// cambio programado posterior), o queda abierto. Un cambio programado no puede empezar en el mismo
+// instante que otro tramo del producto
+func registrarPrecio(tx *gorm.DB, sku string, precio float64, desde time.Time, usuario, motivo string, cambioID *uint) error {
+	// Postgres guarda microsegundos; se trunca para que las comparaciones de vigencia sean exactas
+	desde = desde.Truncate(time.Microsecond)
+
+	var producto modelos.Producto
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("sku").First(&producto, "sku = ?", sku).Error; err != nil {
+		return fmt.Errorf("producto %s no encontrado", sku)
+	}
+	var tramos []modelos.PrecioProducto
+	if err := tx.Where("sku = ?", sku).Order("vigente_desde ASC").Find(&tramos).Error; err != nil {
+		return err
+	}
+
+	var actual, siguiente *modelos.PrecioProducto
+	for i := range tramos {
+		t := &tramos[i]
+		if !t.VigenteDesde.After(desde) {
+			actual = t
+		} else if siguiente == nil {
+			siguiente = t
+		}
+	}
+	if actual != nil && actual.VigenteDesde.Equal(desde) {
+		// Dos cambios programados para el mismo instante se pisarían y al cancelar uno se perdería
+		// el otro; solo se reemplaza un precio manual con otro precio manual
+		if actual.CambioID != nil {
+			return fmt.Errorf("el producto %s ya tiene el cambio de precio %d programado para ese momento", sku, *actual.CambioID)
+		}
+		if cambioID != nil {
+			return fmt.Errorf("el producto %s ya tiene un precio registrado para ese momento", sku)
+		}
+		return tx.Model(actual).Updates(map[string]interface{}{
+			"precio":  precio,
+			"usuario": usuario,
+			"motivo":  motivo,
+		}).Error
+	}
+
+	nuevo := modelos.PrecioProducto{
+		SKU:          sku,
+		Precio:       precio,
+		VigenteDesde: desde,
+		CambioID:     cambioID,
+		Usuario:      usuario,
+		Motivo:       motivo,
+		FechaCrea:    time.Now(),
+	}
+	if siguiente != nil {
+		nuevo.VigenteHasta = &siguiente.VigenteDesde
+	}
+	if actual != nil {
+		if err := tx.Model(actual).Update("vigente_hasta", desde).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Omit("Producto").Create(&nuevo).Error
+}
+
+// GetCambiosPrecio lista los cambios de precio, opcionalmente solo los de un estado
+func GetCambiosPrecio(db *gorm.DB, estado string) ([]modelos.CambioPrecio, error) {
+	var cambios []modelos.CambioPrecio
+	query := db.Order("vigente_desde DESC, id DESC")
+	if estado != "" {
+		query = query.Where("estado = ?", estado)
+	}
+	if err := query.Find(&cambios).Error; err != nil {
+		return nil, err
+	}
+	return cambios, nil
+}
+
+// GetCambioPrecioByID obtiene un cambio de precio con los tramos de precio que creó
+func GetCambioPrecioByID(db *gorm.DB, id uint) (*modelos.CambioPrecio, error) {
+	var cambio modelos.CambioPrecio
+	if err := db.Preload("Precios", func(db *gorm.DB) *gorm.DB { return db.Order("sku") }).
+		First(&cambio, id).Error; err != nil {
+		return nil, errors.New("cambio de precio no encontrado")
+	}
+	return &cambio, nil
+}
+
+// ProgramarCambioPrecio registra un cambio de precio para una fecha futura (o inmediato si no se
+// indica fecha). El precio nuevo de cada producto se calcula sobre el que tendrá vigente en esa
+// fecha y se agrega a su historial; al llegar la fecha pasa al precio del producto
+func ProgramarCambioPrecio(db *gorm.DB, cambio *modelos.CambioPrecio, usuario string) (*modelos.CambioPrecio, error) {
+	if err := validarCambioPrecio(cambio); err != nil {
+		return nil, err
+	}
+	ahora := time.Now()
+	if cambio.VigenteDesde.IsZero() {
+		cambio.VigenteDesde = ahora
+	}
+	// Se tolera un minuto de desfase del reloj del cliente; antes de eso el cambio reescribiría la historia
+	if cambio.VigenteDesde.Before(ahora.Add(-time.Minute)) {
+		return nil, errors.New("no se puede programar un cambio de precio en el pasado")
+	}
+	if cambio.VigenteDesde.Before(ahora) {
+		cambio.VigenteDesde = ahora
+	}
+	cambio.VigenteDesde = cambio.VigenteDesde.Truncate(time.Microsecond)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		productos, err := productosCambioPrecio(tx, cambio)
+		if err != nil {
+			return err
+		}
+
+		cambio.ID = 0
+		cambio.Estado = CambioPrecioProgramado
+		cambio.Productos = len(productos)
+		cambio.Usuario = usuario
+		cambio.FechaCrea = ahora
+		cambio.FechaAplicado = nil
+		if err := tx.Omit("Precios").Create(cambio).Error; err != nil {
+			return err
+		}
+
+		for i := range productos {
+			precio, err := precioCambiado(tx, &productos[i], cambio)
+			if err != nil {
+				return err
+			}
+			if err := registrarPrecio(tx, productos[i].SKU, precio, cambio.VigenteDesde, usuario, cambio.Motivo, &cambio.ID); err != nil {
+				return err
+			}
+		}
+
+		if cambio.VigenteDesde.After(ahora) {
+			return nil
+		}
+		if _, err := aplicarPreciosVigentes(tx, ahora); err != nil {
+			return err
+		}
+		cambio.Estado = CambioPrecioAplicado
+		cambio.FechaAplicado = &ahora
+		return tx.Model(cambio).Updates(map[string]interface{}{
+			"estado":         cambio.Estado,
+			"fecha_aplicado": ahora,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetCambioPrecioByID(db, cambio.ID)
+}
+
+// validarCambioPrecio revisa que el cambio apunte a un solo grupo de productos y que indique un
+// precio (solo para un SKU) o un porcentaje
+func validarCambioPrecio(cambio *modelos.CambioPrecio) error {
+	if cambio.SKU != nil && *cambio.SKU == "" {
+		cambio.SKU = nil
+	}
+	destinos := 0
+	for _, indicado := range []bool{cambio.SKU != nil, cambio.CategoriaID != nil, cambio.ProveedorID != nil} {
+		if indicado {
+			destinos++
+		}
+	}
+	if destinos != 1 {
+		return errors.New("el cambio de precio debe indicar un SKU, una categoría o un proveedor")
+	}
+	if (cambio.Precio == nil) == (cambio.Porcentaje == nil) {
+		return errors.New("debe indicar el precio nuevo o el porcentaje de cambio, no ambos")
+	}
+	if cambio.Precio != nil {
+		if cambio.SKU == nil {
+			return errors.New("los cambios por categoría o proveedor se indican en porcentaje")
+		}
+		if *cambio.Precio < 0 {
+			return errors.New("el precio no puede ser negativo")
+		}
+	}
+	if cambio.Porcentaje != nil && (*cambio.Porcentaje <= -100 || *cambio.Porcentaje == 0) {
+		return errors.New("el porcentaje debe ser distinto de cero y mayor que -100")
+	}
+	return nil
+}
+
+// productosCambioPrecio carga los productos alcanzados por el cambio; una categoría incluye sus
+// subcategorías
+func productosCambioPrecio(tx *gorm.DB, cambio *modelos.CambioPrecio) ([]modelos.Producto, error) {
+	query := tx.Select("sku", "precio").Order("sku")
+	switch {
+	case cambio.SKU != nil:
+		query = query.Where("sku = ?", *cambio.SKU)
+	case cambio.CategoriaID != nil:
+		arbol, err := cargarArbolCategorias(tx)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := arbol[*cambio.CategoriaID]; !ok {
+			return nil, ErrCategoriaNoEncontrada
+		}
+		query = query.Where("categoria_id IN ?", arbol.descendientes(*cambio.CategoriaID))
+	default:
+		query = query.Where("proveedor_id = ?", *cambio.ProveedorID)
+	}
+	var productos []modelos.Producto
+	if err := query.Find(&productos).Error; err != nil {
+		return nil, err
+	}
+	if len(productos) == 0 {
+		return nil, errors.New("el cambio de precio no alcanza a ningún producto")
+	}
+	return productos, nil
+}
+
+// precioCambiado calcula el precio nuevo de un producto: el indicado, o el porcentaje aplicado al
+// precio que el producto tendrá vigente en la fecha del cambio
+func precioCambiado(tx *gorm.DB, producto *modelos.Producto, cambio *modelos.CambioPrecio) (float64, error) {
+	if cambio.Precio != nil {
+		return *cambio.Precio, nil
+	}
+	base, err := precioListaEn(tx, producto, cambio.VigenteDesde)
+	if err != nil {
+		return 0, err
+	}
+	return math.Round(base.Precio*(1+*cambio.Porcentaje/100)*100) / 100, nil
+}
+
+// CancelarCambioPrecio anula un cambio programado que aún no entra en vigencia: quita del historial
+// sus tramos y extiende los anteriores hasta donde llegaban
+func CancelarCambioPrecio(db *gorm.DB, id uint) (*modelos.CambioPrecio, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var cambio modelos.CambioPrecio
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cambio, id).Error; err != nil {
+			return errors.New("cambio de precio no encontrado")
+		}
+		if cambio.Estado != CambioPrecioProgramado || !cambio.VigenteDesde.After(time.Now()) {
+			return errors.New("solo se pueden cancelar cambios de precio programados que aún no entran en vigencia")
+		}
+
+		var tramos []modelos.PrecioProducto
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("cambio_id = ?", id).Find(&tramos).Error; err != nil {
+			return err
+		}
+		for _, t := range tramos {
+			if err := tx.Model(&modelos.PrecioProducto{}).
+				Where("sku = ? AND vigente_hasta = ?", t.SKU, t.VigenteDesde).
+				Update("vigente_hasta", t.VigenteHasta).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&modelos.PrecioProducto{}, t.ID).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&cambio).Update("estado", CambioPrecioCancelado).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetCambioPrecioByID(db, id)
+}
+
+// AplicarCambiosPrecio lleva al precio de cada producto el de su tramo vigente y marca como
+// aplicados los cambios programados que ya entraron en vigencia. Devuelve cuántos productos cambiaron
+func AplicarCambiosPrecio(db *gorm.DB) (int64, error) {
+	var actualizados int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		ahora := time.Now()
+		var err error
+		if actualizados, err = aplicarPreciosVigentes(tx, ahora); err != nil {
+			return err
+		}
+		return tx.Model(&modelos.CambioPrecio{}).
+			Where("estado = ? AND vigente_desde <= ?", CambioPrecioProgramado, ahora).
+			Updates(map[string]interface{}{
+				"estado":         CambioPrecioAplicado,
+				"fecha_aplicado": ahora,
+			}).Error
+	})
+	return actualizados, err
+}
+
+// aplicarPreciosVigentes copia al producto el precio de su tramo vigente cuando difiere
+func aplicarPreciosVigentes(tx *gorm.DB, ahora time.Time) (int64, error) {
+	res := tx.Exec(`UPDATE productos p
+		SET precio = t.precio, version = p.version + 1
+		FROM precios_producto t
+		WHERE t.sku = p.sku
+			AND t.vigente_desde <= ? AND (t.vigente_hasta IS NULL OR t.vigente_hasta > ?)
+			AND p.precio <> t.precio`, ahora, ahora)
+	return res.RowsAffected, res.Error
+}
+
+// ProgramarAplicacionPrecios revisa en segundo plano, cada intervalo, si hay cambios de precio que
+// entraron en vigencia. No parte si aún no existen las tablas del historial de precios
+func ProgramarAplicacionPrecios(db *gorm.DB, intervalo time.Duration) {
+	if !db.Migrator().HasTable(&modelos.PrecioProducto{}) || !db.Migrator().HasTable(&modelos.CambioPrecio{}) {
+		log.Println("Aplicación de cambios de precio desactivada: faltan las tablas del historial de precios (ejecute las migraciones)")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(intervalo)
+		defer ticker.Stop()
+		for ; ; <-ticker.C {
+			actualizados, err := AplicarCambiosPrecio(db)
+			if err != nil {
+				log.Printf("Error al aplicar cambios de precio programados: %v", err)
+				continue
+			}
+			if actualizados > 0 {
+				log.Printf("Cambios de precio aplicados a %d productos", actualizados)
+			}
+		}
+	}()
+}
+
+// RepreciarCotizacion valoriza los ítems de una cotización con los precios de lista vigentes en
+// la fecha indicada (por defecto, la de creación de la cotización) y los compara con los actuales
+func RepreciarCotizacion(db *gorm.DB, cotizacionID uint, fecha *time.Time) (*CotizacionRepreciada, error) {
+	var cotizacion modelos.Cotizacion
+	if err := db.Select("id", "fecha_crea").First(&cotizacion, cotizacionID).Error; err != nil {
+		return nil, errors.New("cotización no encontrada")
+	}
+	if fecha == nil {
+		fecha = &cotizacion.FechaCrea
+	}
+	var items []modelos.CotizacionItem
+	if err := db.Preload("Producto", func(db *gorm.DB) *gorm.DB { return db.Select("sku", "nombre", "precio") }).
+		Where("cotizacion_id = ?", cotizacionID).
+		Order("producto_id, sucursal_id").
+		Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	resultado := &CotizacionRepreciada{CotizacionID: cotizacionID, Fecha: *fecha, Items: []ItemRepreciado{}}
+	ahora := time.Now()
+	for _, item := range items {
+		enFecha, err := precioListaEn(db, &item.Producto, *fecha)
+		if err != nil {
+			return nil, err
+		}
+		actual, err := precioListaEn(db, &item.Producto, ahora)
+		if err != nil {
+			return nil, err
+		}
+		linea := ItemRepreciado{
+			SKU:            item.ProductoID,
+			Nombre:         item.Producto.Nombre,
+			SucursalID:     item.SucursalID,
+			Cantidad:       item.Cantidad,
+			Precio:         enFecha.Precio,
+			PrecioActual:   actual.Precio,
+			Subtotal:       math.Round(enFecha.Precio*float64(item.Cantidad)*100) / 100,
+			SubtotalActual: math.Round(actual.Precio*float64(item.Cantidad)*100) / 100,
+			SinHistorial:   enFecha.SinHistorial,
+		}
+		resultado.Total += linea.Subtotal
+		resultado.TotalActual += linea.SubtotalActual
+		resultado.Items = append(resultado.Items, linea)
+	}
+	resultado.Total = math.Round(resultado.Total*100) / 100
+	resultado.TotalActual = math.Round(resultado.TotalActual*100) / 100
+	resultado.Diferencia = math.Round((resultado.TotalActual-resultado.Total)*100) / 100
+	return resultado, nil
+}
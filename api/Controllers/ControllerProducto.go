@@ -3,47 +3,148 @@ package Controllers
 import (
 	modelos "backend-inventario/api/Models"
 	"errors"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// GetProductos obtiene todos los productos
-func GetProductos(db *gorm.DB) ([]modelos.Producto, error) {
-	var productos []modelos.Producto
-	if err := db.
-		Preload("Proveedor").
-		Preload("Categoria").
-		Find(&productos).Error; err != nil {
-		return nil, err
-	}
-	return productos, nil
-}
-
 // GetProductoBySKU obtiene un producto por su SKU
 func GetProductoBySKU(db *gorm.DB, sku string) (*modelos.Producto, error) {
 	var producto modelos.Producto
 	if err := db.
 		Preload("Proveedor").
 		Preload("Categoria").
+		Preload("Unidades", func(db *gorm.DB) *gorm.DB {
+			return db.Order("factor ASC")
+		}).
+		Preload("Componentes.Componente").
+		Preload("Imagenes", func(db *gorm.DB) *gorm.DB {
+			return db.Order("orden, id")
+		}).
+		Preload("CodigosBarras", func(db *gorm.DB) *gorm.DB {
+			return db.Order("principal DESC, id")
+		}).
+		Preload("Variante.Atributo").
 		First(&producto, "sku = ?", sku).Error; err != nil {
 		return nil, err
 	}
+	completarURLsImagenes(producto.Imagenes)
 	return &producto, nil
 }
 
-// CreateProducto crea un nuevo producto
-func CreateProducto(db *gorm.DB, producto *modelos.Producto) error {
-	return db.Create(producto).Error
+// CreateProducto crea un nuevo producto, junto con sus unidades alternativas, sus códigos de
+// barras y, si es un kit, su lista de materiales
+func CreateProducto(db *gorm.DB, producto *modelos.Producto, usuario string) error {
+	for i := range producto.Unidades {
+		if err := normalizarUnidad(producto, &producto.Unidades[i]); err != nil {
+			return err
+		}
+	}
+	if err := validarComponentesKit(db, producto.SKU, producto.Componentes); err != nil {
+		return err
+	}
+	producto.EsKit = len(producto.Componentes) > 0
+	// Las imágenes se suben aparte, con sus archivos, y las variantes se asignan desde el padre
+	producto.Imagenes = nil
+	producto.PadreID = nil
+	producto.Variante = nil
+	codigos := make([]*modelos.CodigoBarras, 0, len(producto.CodigosBarras))
+	for i := range producto.CodigosBarras {
+		producto.CodigosBarras[i].ID = 0
+		producto.CodigosBarras[i].FechaCrea = time.Now()
+		codigos = append(codigos, &producto.CodigosBarras[i])
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := prepararCodigosBarras(tx, codigos); err != nil {
+			return err
+		}
+		if len(codigos) > 0 && !algunCodigoPrincipal(producto.CodigosBarras) {
+			codigos[0].Principal = true
+		}
+		if err := tx.Omit("Componentes.Componente").Create(producto).Error; err != nil {
+			return err
+		}
+		return registrarPrecio(tx, producto.SKU, producto.Precio, time.Now(), usuario, "precio inicial", nil)
+	})
+}
+
+// ErrCambioLotesConStock indica que se quiso cambiar el control por lotes de un producto con stock,
+// que quedaría sin lotes o con stock fuera de ellos
+var ErrCambioLotesConStock = errors.New("no se puede cambiar el control por lotes de un producto con stock")
+
+// ErrCambioSerializadoConStock indica que se quiso cambiar el control por series de un producto con
+// stock o con series disponibles, que quedarían sin correspondencia
+var ErrCambioSerializadoConStock = errors.New("no se puede cambiar el control por series de un producto con stock o series disponibles")
+
+// ActualizacionProducto es el cuerpo de la actualización de un producto. El control por lotes y
+// por series va como puntero para distinguir un campo omitido de un false: si no viene, no se toca
+type ActualizacionProducto struct {
+	modelos.Producto
+	ManejaLotes *bool `json:"maneja_lotes"`
+	Serializado *bool `json:"serializado"`
 }
 
-// UpdateProducto actualiza un producto existente
-func UpdateProducto(db *gorm.DB, sku string, nuevo *modelos.Producto) (*modelos.Producto, error) {
+// UpdateProducto actualiza un producto existente. Un cambio de precio queda en el historial desde ahora
+func UpdateProducto(db *gorm.DB, sku string, nuevo *ActualizacionProducto, version uint, usuario string) (*modelos.Producto, error) {
 	var existente modelos.Producto
-	if err := db.First(&existente, "sku = ?", sku).Error; err != nil {
-		return nil, errors.New("producto no encontrado")
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existente, "sku = ?", sku).Error; err != nil {
+			return errors.New("producto no encontrado")
+		}
+		if existente.Version != version {
+			return ErrConflictoVersion
+		}
+		// Updates con struct ignora el precio en cero, así que solo cuenta un precio indicado y distinto
+		cambioPrecio := nuevo.Precio != 0 && nuevo.Precio != existente.Precio
+		if err := actualizarProducto(tx, &existente, nuevo); err != nil {
+			return err
+		}
+		if cambioPrecio {
+			if err := registrarPrecio(tx, sku, nuevo.Precio, time.Now(), usuario, "actualización del producto", nil); err != nil {
+				return err
+			}
+		}
+		existente.Version = version + 1
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return &existente, nil
+}
 
-	err := db.Model(&existente).Updates(modelos.Producto{
+func actualizarProducto(db *gorm.DB, existente *modelos.Producto, nuevo *ActualizacionProducto) error {
+	// Los cambios de control se calculan antes de Updates, que modifica existente
+	control := map[string]interface{}{"version": gorm.Expr("version + 1")}
+	if nuevo.ManejaLotes != nil && *nuevo.ManejaLotes != existente.ManejaLotes {
+		conStock, err := productoConStock(db, existente.SKU)
+		if err != nil {
+			return err
+		}
+		if conStock {
+			return ErrCambioLotesConStock
+		}
+		control["maneja_lotes"] = *nuevo.ManejaLotes
+	}
+	if nuevo.Serializado != nil && *nuevo.Serializado != existente.Serializado {
+		conStock, err := productoConStock(db, existente.SKU)
+		if err != nil {
+			return err
+		}
+		var disponibles int64
+		if err := db.Model(&modelos.NumeroSerie{}).
+			Where("sku = ? AND estado = ?", existente.SKU, SerieDisponible).
+			Count(&disponibles).Error; err != nil {
+			return err
+		}
+		if conStock || disponibles > 0 {
+			return ErrCambioSerializadoConStock
+		}
+		control["serializado"] = *nuevo.Serializado
+	}
+
+	err := db.Model(existente).Updates(modelos.Producto{
 		Nombre:      nuevo.Nombre,
 		Descripcion: nuevo.Descripcion,
 		ProveedorID: nuevo.ProveedorID,
@@ -52,13 +153,26 @@ func UpdateProducto(db *gorm.DB, sku string, nuevo *modelos.Producto) (*modelos.
 		Ancho:       nuevo.Ancho,
 		Alto:        nuevo.Alto,
 		Precio:      nuevo.Precio,
+		UnidadBase:  nuevo.UnidadBase,
 	}).Error
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &existente, nil
+	// Updates con struct ignora los false, por eso el control por lotes y series se actualiza aparte
+	return db.Model(existente).Updates(control).Error
+}
+
+// productoConStock indica si el producto tiene unidades en alguna sucursal
+func productoConStock(db *gorm.DB, sku string) (bool, error) {
+	var conStock int64
+	if err := db.Model(&modelos.StockSucursal{}).
+		Where("sku = ? AND cantidad > 0", sku).
+		Count(&conStock).Error; err != nil {
+		return false, err
+	}
+	return conStock > 0, nil
 }
 
 // DeleteProducto elimina un producto
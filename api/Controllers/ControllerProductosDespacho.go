@@ -21,6 +21,74 @@ type ProductoDespachoDetallado struct {
 	Precio      float64 `json:"precio"`
 	PesoTotal   float64 `json:"peso_total"`   // peso * cantidad
 	PrecioTotal float64 `json:"precio_total"` // precio * cantidad
+
+	CostoUnitario float64 `json:"costo_unitario"`
+	CostoTotal    float64 `json:"costo_total"` // costo de la mercadería despachada
+
+	Lotes  []modelos.ProductosDespachoLote  `json:"lotes,omitempty"`
+	Series []modelos.ProductosDespachoSerie `json:"series,omitempty"`
+
+	// Solo en kits: los componentes con que salió, con sus lotes y series
+	Componentes []ComponenteDespachoDetallado `json:"componentes,omitempty"`
+}
+
+// ComponenteDespachoDetallado es un componente de un kit despachado
+type ComponenteDespachoDetallado struct {
+	SKU        string                           `json:"sku"`
+	Nombre     string                           `json:"nombre"`
+	Cantidad   int                              `json:"cantidad"`
+	CostoTotal float64                          `json:"costo_total"`
+	Lotes      []modelos.ProductosDespachoLote  `json:"lotes,omitempty"`
+	Series     []modelos.ProductosDespachoSerie `json:"series,omitempty"`
+}
+
+// componentesDetallados arma los componentes de una línea de kit. Los lotes y series se toman de
+// la línea del componente en el mismo despacho, solo los que salieron dentro de este kit
+func componentesDetallados(kit modelos.ProductosDespacho, lineas []modelos.ProductosDespacho) []ComponenteDespachoDetallado {
+	if len(kit.Componentes) == 0 {
+		return nil
+	}
+	porSKU := make(map[string]modelos.ProductosDespacho, len(lineas))
+	for _, l := range lineas {
+		porSKU[l.ProductoID] = l
+	}
+	resultado := make([]ComponenteDespachoDetallado, 0, len(kit.Componentes))
+	for _, c := range kit.Componentes {
+		linea := porSKU[c.SKU]
+		resultado = append(resultado, ComponenteDespachoDetallado{
+			SKU:        c.SKU,
+			Nombre:     c.Componente.Nombre,
+			Cantidad:   c.Cantidad,
+			CostoTotal: c.CostoTotal,
+			Lotes:      lotesDespachoKit(linea.Lotes, kit.ProductoID),
+			Series:     seriesDespachoKit(linea.Series, kit.ProductoID),
+		})
+	}
+	return resultado
+}
+
+// lotesDespachoKit filtra los lotes de una línea que salieron en el kit indicado; con kitSKU vacío,
+// los que salieron sueltos
+func lotesDespachoKit(lotes []modelos.ProductosDespachoLote, kitSKU string) []modelos.ProductosDespachoLote {
+	var resultado []modelos.ProductosDespachoLote
+	for _, l := range lotes {
+		if l.KitSKU == kitSKU {
+			resultado = append(resultado, l)
+		}
+	}
+	return resultado
+}
+
+// seriesDespachoKit filtra las series de una línea que salieron en el kit indicado; con kitSKU
+// vacío, las que salieron sueltas
+func seriesDespachoKit(series []modelos.ProductosDespachoSerie, kitSKU string) []modelos.ProductosDespachoSerie {
+	var resultado []modelos.ProductosDespachoSerie
+	for _, s := range series {
+		if s.KitSKU == kitSKU {
+			resultado = append(resultado, s)
+		}
+	}
+	return resultado
 }
 
 // GetProductosDespacho obtiene todos los productos de despacho con información relacionada
@@ -0,0 +1,261 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PromocionAplicada es una promoción que participó en el precio efectivo
+type PromocionAplicada struct {
+	ID         uint    `json:"id"`
+	Nombre     string  `json:"nombre"`
+	Descuento  float64 `json:"descuento"`
+	Prioridad  int     `json:"prioridad"`
+	Acumulable bool    `json:"acumulable"`
+}
+
+// PrecioEfectivo es el precio de un SKU para una sucursal y un tipo de cliente en un momento dado
+type PrecioEfectivo struct {
+	SKU           string              `json:"sku"`
+	SucursalID    uint                `json:"sucursal_id,omitempty"`
+	TipoClienteID uint                `json:"tipo_cliente_id,omitempty"`
+	Fecha         time.Time           `json:"fecha"`
+	PrecioLista   float64             `json:"precio_lista"`
+	Descuento     float64             `json:"descuento"` // porcentaje total sobre el precio de lista
+	PrecioFinal   float64             `json:"precio_final"`
+	Promociones   []PromocionAplicada `json:"promociones"`
+}
+
+// GetPromociones lista las promociones, opcionalmente de un SKU y solo las vigentes en una fecha
+func GetPromociones(db *gorm.DB, sku string, vigentesEn *time.Time) ([]modelos.Promocion, error) {
+	var promociones []modelos.Promocion
+	query := db.Order("inicio DESC, id DESC")
+	if sku != "" {
+		query = query.Where("sku = ?", sku)
+	}
+	if vigentesEn != nil {
+		query = query.Where("activa = ? AND inicio <= ? AND (fin IS NULL OR fin > ?)", true, *vigentesEn, *vigentesEn)
+	}
+	if err := query.Find(&promociones).Error; err != nil {
+		return nil, err
+	}
+	return promociones, nil
+}
+
+// GetPromocionByID obtiene una promoción con los registros a los que apunta
+func GetPromocionByID(db *gorm.DB, id uint) (*modelos.Promocion, error) {
+	var promocion modelos.Promocion
+	if err := db.Preload("Producto").Preload("Categoria").Preload("Sucursal").Preload("TipoCliente").
+		First(&promocion, id).Error; err != nil {
+		return nil, err
+	}
+	return &promocion, nil
+}
+
+// CreatePromocion crea una promoción
+func CreatePromocion(db *gorm.DB, promocion *modelos.Promocion, usuario string) error {
+	promocion.ID = 0
+	promocion.Usuario = usuario
+	promocion.FechaCrea = time.Now()
+	if err := validarPromocion(db, promocion); err != nil {
+		return err
+	}
+	return db.Omit("Producto", "Categoria", "Sucursal", "TipoCliente").Create(promocion).Error
+}
+
+// UpdatePromocion reemplaza las condiciones de una promoción
+func UpdatePromocion(db *gorm.DB, id uint, actualizada *modelos.Promocion) (*modelos.Promocion, error) {
+	var existente modelos.Promocion
+	if err := db.First(&existente, id).Error; err != nil {
+		return nil, errors.New("promoción no encontrada")
+	}
+	actualizada.ID = existente.ID
+	actualizada.Usuario = existente.Usuario
+	actualizada.FechaCrea = existente.FechaCrea
+	if err := validarPromocion(db, actualizada); err != nil {
+		return nil, err
+	}
+	if err := db.Omit("Producto", "Categoria", "Sucursal", "TipoCliente").Save(actualizada).Error; err != nil {
+		return nil, err
+	}
+	return actualizada, nil
+}
+
+// DeletePromocion elimina una promoción
+func DeletePromocion(db *gorm.DB, id uint) error {
+	result := db.Delete(&modelos.Promocion{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("promoción no encontrada")
+	}
+	return nil
+}
+
+// validarPromocion revisa el período y que existan el producto, la categoría, la sucursal y el
+// tipo de cliente indicados. Un filtro vacío se guarda como nulo
+func validarPromocion(db *gorm.DB, p *modelos.Promocion) error {
+	if p.Descuento <= 0 || p.Descuento > 100 {
+		return errors.New("el descuento debe estar entre 0 y 100")
+	}
+	if p.Inicio.IsZero() {
+		return errors.New("debe indicar el inicio de la promoción")
+	}
+	if p.Fin != nil && !p.Fin.After(p.Inicio) {
+		return errors.New("el fin de la promoción debe ser posterior a su inicio")
+	}
+	if p.SKU != nil && *p.SKU == "" {
+		p.SKU = nil
+	}
+
+	referencias := []struct {
+		valido bool
+		modelo interface{}
+		id     interface{}
+		campo  string
+		nombre string
+	}{
+		{p.SKU != nil, &modelos.Producto{}, p.SKU, "sku", "el producto"},
+		{p.CategoriaID != nil, &modelos.Categoria{}, p.CategoriaID, "id", "la categoría"},
+		{p.SucursalID != nil, &modelos.Sucursal{}, p.SucursalID, "id", "la sucursal"},
+		{p.TipoClienteID != nil, &modelos.TipoCliente{}, p.TipoClienteID, "id", "el tipo de cliente"},
+	}
+	for _, r := range referencias {
+		if !r.valido {
+			continue
+		}
+		var existe int64
+		if err := db.Model(r.modelo).Where(r.campo+" = ?", r.id).Count(&existe).Error; err != nil {
+			return err
+		}
+		if existe == 0 {
+			return fmt.Errorf("no existe %s indicado en la promoción", r.nombre)
+		}
+	}
+	return nil
+}
+
+// GetPrecioEfectivo calcula el precio de un SKU en una fecha para una sucursal y un tipo de cliente
+// (0 si no se indican; entonces solo aplican las promociones sin ese filtro). Si se indica el RUT
+// del cliente se usa su tipo
+func GetPrecioEfectivo(db *gorm.DB, sku string, sucursalID uint, rutCliente string, tipoClienteID uint, fecha time.Time) (*PrecioEfectivo, error) {
+	var producto modelos.Producto
+	if err := db.Select("sku", "precio", "categoria_id").First(&producto, "sku = ?", sku).Error; err != nil {
+		return nil, errors.New("producto no encontrado")
+	}
+	if rutCliente != "" {
+		var cliente modelos.Cliente
+		if err := db.Select("rut", "tipo_id").First(&cliente, "rut = ?", rutCliente).Error; err != nil {
+			return nil, errors.New("cliente no encontrado")
+		}
+		tipoClienteID = cliente.TipoID
+	}
+
+	vigentes, err := promocionesVigentes(db, fecha, sku)
+	if err != nil {
+		return nil, err
+	}
+	categorias, err := cargarArbolCategorias(db)
+	if err != nil {
+		return nil, err
+	}
+	// El precio de lista es el del historial en esa fecha, que puede diferir del actual
+	lista, err := precioListaEn(db, &producto, fecha)
+	if err != nil {
+		return nil, err
+	}
+	promociones := promocionesAplicables(vigentes, &producto, categorias, sucursalID, tipoClienteID)
+	final, aplicadas := aplicarPromociones(lista.Precio, promociones)
+
+	precio := &PrecioEfectivo{
+		SKU:           sku,
+		SucursalID:    sucursalID,
+		TipoClienteID: tipoClienteID,
+		Fecha:         fecha,
+		PrecioLista:   lista.Precio,
+		PrecioFinal:   final,
+		Promociones:   aplicadas,
+	}
+	if lista.Precio > 0 {
+		precio.Descuento = math.Round((1-final/lista.Precio)*10000) / 100
+	}
+	return precio, nil
+}
+
+// promocionesVigentes carga las promociones activas en la fecha, de la de mayor a la de menor
+// prioridad. Con sku solo trae las de ese producto o sin producto indicado
+func promocionesVigentes(db *gorm.DB, fecha time.Time, sku string) ([]modelos.Promocion, error) {
+	query := db.Where("activa = ? AND inicio <= ? AND (fin IS NULL OR fin > ?)", true, fecha, fecha)
+	if sku != "" {
+		query = query.Where("sku IS NULL OR sku = ?", sku)
+	}
+	var promociones []modelos.Promocion
+	if err := query.Order("prioridad DESC, descuento DESC, id ASC").Find(&promociones).Error; err != nil {
+		return nil, err
+	}
+	return promociones, nil
+}
+
+// promocionesAplicables deja, en el mismo orden, las promociones cuyos filtros calzan con el
+// producto, la sucursal y el tipo de cliente. Una promoción de categoría alcanza también a las
+// subcategorías. Sucursal o tipo de cliente 0 solo calzan con las promociones sin ese filtro
+func promocionesAplicables(promociones []modelos.Promocion, producto *modelos.Producto, categorias arbolCategorias, sucursalID, tipoClienteID uint) []modelos.Promocion {
+	linaje := categorias.linaje(producto.CategoriaID)
+	var resultado []modelos.Promocion
+	for _, p := range promociones {
+		if p.SKU != nil && *p.SKU != producto.SKU {
+			continue
+		}
+		if p.CategoriaID != nil && !contieneCategoria(linaje, *p.CategoriaID) {
+			continue
+		}
+		if p.SucursalID != nil && *p.SucursalID != sucursalID {
+			continue
+		}
+		if p.TipoClienteID != nil && *p.TipoClienteID != tipoClienteID {
+			continue
+		}
+		resultado = append(resultado, p)
+	}
+	return resultado
+}
+
+// contieneCategoria indica si la categoría está en el linaje del producto
+func contieneCategoria(linaje []uint, id uint) bool {
+	for _, c := range linaje {
+		if c == id {
+			return true
+		}
+	}
+	return false
+}
+
+// aplicarPromociones aplica las promociones ya ordenadas por prioridad. La primera siempre aplica;
+// si es acumulable se le suman las demás acumulables, en cascada sobre el precio ya rebajado
+func aplicarPromociones(precio float64, promociones []modelos.Promocion) (float64, []PromocionAplicada) {
+	aplicadas := []PromocionAplicada{}
+	final := precio
+	for i, p := range promociones {
+		if i > 0 && !p.Acumulable {
+			continue
+		}
+		final *= 1 - p.Descuento/100
+		aplicadas = append(aplicadas, PromocionAplicada{
+			ID:         p.ID,
+			Nombre:     p.Nombre,
+			Descuento:  p.Descuento,
+			Prioridad:  p.Prioridad,
+			Acumulable: p.Acumulable,
+		})
+		if !p.Acumulable {
+			break
+		}
+	}
+	return math.Round(final*100) / 100, aplicadas
+}
@@ -0,0 +1,241 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// ParametrosReposicion son los niveles de stock configurables por SKU y sucursal
+type ParametrosReposicion struct {
+	StockMinimo  int `json:"stock_minimo" binding:"min=0"`
+	StockMaximo  int `json:"stock_maximo" binding:"min=0"`
+	PuntoReorden int `json:"punto_reorden" binding:"min=0"`
+}
+
+// ProveedorSugerido es el proveedor recomendado para reponer un SKU
+type ProveedorSugerido struct {
+	ProveedorID     uint   `json:"proveedor_id"`
+	Marca           string `json:"marca"`
+	StockDisponible int    `json:"stock_disponible"`
+	CubreSugerido   bool   `json:"cubre_sugerido"`
+	EsHabitual      bool   `json:"es_habitual"` // proveedor registrado en el producto
+}
+
+// SugerenciaReposicion es un SKU bajo su punto de reorden con la cantidad sugerida a pedir
+type SugerenciaReposicion struct {
+	SKU              string             `json:"sku"`
+	Nombre           string             `json:"nombre"`
+	SucursalID       uint               `json:"sucursal_id"`
+	Sucursal         string             `json:"sucursal"`
+	Cantidad         int                `json:"cantidad"`
+	Reservado        int                `json:"reservado"`
+	EnTransito       int                `json:"en_transito"` // transferencias y órdenes de compra pendientes
+	Posicion         int                `json:"posicion"`    // cantidad - reservado + en tránsito
+	StockMinimo      int                `json:"stock_minimo"`
+	StockMaximo      int                `json:"stock_maximo"`
+	PuntoReorden     int                `json:"punto_reorden"`
+	CantidadSugerida int                `json:"cantidad_sugerida"`
+	Proveedor        *ProveedorSugerido `json:"proveedor,omitempty"`
+}
+
+// UpdateParametrosReposicion define el mínimo, máximo y punto de reorden de un SKU en una sucursal
+func UpdateParametrosReposicion(db *gorm.DB, sku string, sucursalID uint, p ParametrosReposicion) error {
+	if p.StockMaximo > 0 && p.StockMaximo < p.StockMinimo {
+		return errors.New("el stock máximo no puede ser menor al mínimo")
+	}
+	if p.StockMaximo > 0 && p.PuntoReorden > p.StockMaximo {
+		return errors.New("el punto de reorden no puede superar el stock máximo")
+	}
+
+	result := db.Model(&modelos.StockSucursal{}).
+		Where("sku = ? AND sucursal_id = ?", sku, sucursalID).
+		Updates(map[string]interface{}{
+			"stock_minimo":  p.StockMinimo,
+			"stock_maximo":  p.StockMaximo,
+			"punto_reorden": p.PuntoReorden,
+			"version":       gorm.Expr("version + 1"),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("registro de stock no encontrado")
+	}
+	return nil
+}
+
+// GetSugerenciasReposicion lista los SKU cuya posición de stock está en o bajo su punto de reorden,
+// con la cantidad sugerida para volver al máximo y el mejor proveedor para pedirla.
+func GetSugerenciasReposicion(db *gorm.DB, sucursalID uint) ([]SugerenciaReposicion, error) {
+	var stocks []modelos.StockSucursal
+	query := db.Preload("Producto.Proveedor").
+		Preload("Sucursal").
+		Where("punto_reorden > 0")
+	if sucursalID != 0 {
+		query = query.Where("sucursal_id = ?", sucursalID)
+	}
+	if err := query.Find(&stocks).Error; err != nil {
+		return nil, err
+	}
+
+	reservas, err := reservasPorStock(db, "", sucursalID)
+	if err != nil {
+		return nil, err
+	}
+	entrantes, err := stockEntrantePorSucursal(db, sucursalID)
+	if err != nil {
+		return nil, err
+	}
+
+	var sugerencias []SugerenciaReposicion
+	skus := make(map[string]bool)
+	for _, s := range stocks {
+		clave := claveStock{SKU: s.SKU, SucursalID: s.SucursalID}
+		posicion := s.Cantidad - reservas[clave] + entrantes[clave]
+		if posicion > s.PuntoReorden {
+			continue
+		}
+
+		objetivo := s.StockMaximo
+		if objetivo < s.PuntoReorden {
+			objetivo = s.PuntoReorden
+		}
+		if objetivo < s.StockMinimo {
+			objetivo = s.StockMinimo
+		}
+		sugerida := objetivo - posicion
+		if sugerida <= 0 {
+			continue
+		}
+
+		sugerencias = append(sugerencias, SugerenciaReposicion{
+			SKU:              s.SKU,
+			Nombre:           s.Producto.Nombre,
+			SucursalID:       s.SucursalID,
+			Sucursal:         s.Sucursal.Nombre,
+			Cantidad:         s.Cantidad,
+			Reservado:        reservas[clave],
+			EnTransito:       entrantes[clave],
+			Posicion:         posicion,
+			StockMinimo:      s.StockMinimo,
+			StockMaximo:      s.StockMaximo,
+			PuntoReorden:     s.PuntoReorden,
+			CantidadSugerida: sugerida,
+		})
+		skus[s.SKU] = true
+	}
+	if len(sugerencias) == 0 {
+		return []SugerenciaReposicion{}, nil
+	}
+
+	// Stock de proveedores para los SKU a reponer
+	lista := make([]string, 0, len(skus))
+	for sku := range skus {
+		lista = append(lista, sku)
+	}
+	var stockProveedores []modelos.StockProveedor
+	if err := db.Preload("Proveedor").
+		Where("sku IN ?", lista).
+		Find(&stockProveedores).Error; err != nil {
+		return nil, err
+	}
+	porSKU := make(map[string][]modelos.StockProveedor)
+	for _, sp := range stockProveedores {
+		porSKU[sp.ProductoID] = append(porSKU[sp.ProductoID], sp)
+	}
+
+	productos := make(map[string]modelos.Producto)
+	for _, s := range stocks {
+		productos[s.SKU] = s.Producto
+	}
+	for i := range sugerencias {
+		sug := &sugerencias[i]
+		sug.Proveedor = mejorProveedor(productos[sug.SKU], porSKU[sug.SKU], sug.CantidadSugerida)
+	}
+
+	sort.Slice(sugerencias, func(i, j int) bool {
+		if sugerencias[i].SucursalID != sugerencias[j].SucursalID {
+			return sugerencias[i].SucursalID < sugerencias[j].SucursalID
+		}
+		return sugerencias[i].SKU < sugerencias[j].SKU
+	})
+	return sugerencias, nil
+}
+
+// mejorProveedor elige el proveedor para reponer: el habitual del producto si cubre la cantidad,
+// si no el que tenga más stock disponible, y como último recurso el habitual aunque no tenga stock
+func mejorProveedor(producto modelos.Producto, candidatos []modelos.StockProveedor, cantidad int) *ProveedorSugerido {
+	var habitual, mayor *modelos.StockProveedor
+	for i := range candidatos {
+		c := &candidatos[i]
+		if c.ProveedorID == producto.ProveedorID {
+			habitual = c
+		}
+		if c.Stock > 0 && (mayor == nil || c.Stock > mayor.Stock) {
+			mayor = c
+		}
+	}
+
+	elegido := mayor
+	if habitual != nil && habitual.Stock >= cantidad {
+		elegido = habitual
+	}
+	if elegido != nil {
+		return &ProveedorSugerido{
+			ProveedorID:     elegido.ProveedorID,
+			Marca:           elegido.Proveedor.Marca,
+			StockDisponible: elegido.Stock,
+			CubreSugerido:   elegido.Stock >= cantidad,
+			EsHabitual:      elegido.ProveedorID == producto.ProveedorID,
+		}
+	}
+
+	if producto.ProveedorID == 0 {
+		return nil
+	}
+	return &ProveedorSugerido{
+		ProveedorID: producto.ProveedorID,
+		Marca:       producto.Proveedor.Marca,
+		EsHabitual:  true,
+	}
+}
+
+// stockEntrantePorSucursal suma las unidades en tránsito hacia cada sucursal y las
+// pendientes de recibir de órdenes de compra emitidas
+func stockEntrantePorSucursal(db *gorm.DB, sucursalID uint) (map[claveStock]int, error) {
+	enTransito, err := GetStockEnTransito(db, sucursalID)
+	if err != nil {
+		return nil, err
+	}
+	entrantes := make(map[claveStock]int)
+	for _, t := range enTransito {
+		if sucursalID != 0 && t.DestinoID != sucursalID {
+			continue
+		}
+		entrantes[claveStock{SKU: t.SKU, SucursalID: t.DestinoID}] += t.EnTransito
+	}
+
+	var pendientes []struct {
+		SKU        string
+		SucursalID uint
+		Pendiente  int
+	}
+	query := db.Table("orden_compra_linea AS l").
+		Select("l.sku, o.sucursal_id, SUM(l.cantidad - l.cantidad_recibida) AS pendiente").
+		Joins("JOIN ordenes_compra o ON o.id = l.orden_compra_id").
+		Where("o.estado IN ? AND l.cantidad > l.cantidad_recibida", []string{OrdenCompraEmitida, OrdenCompraParcial}).
+		Group("l.sku, o.sucursal_id")
+	if sucursalID != 0 {
+		query = query.Where("o.sucursal_id = ?", sucursalID)
+	}
+	if err := query.Scan(&pendientes).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range pendientes {
+		entrantes[claveStock{SKU: p.SKU, SucursalID: p.SucursalID}] += p.Pendiente
+	}
+	return entrantes, nil
+}
@@ -0,0 +1,363 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"backend-inventario/config"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Estados de una reserva de stock
+const (
+	ReservaActiva    = "activa"
+	ReservaConsumida = "consumida"
+	ReservaLiberada  = "liberada"
+	ReservaExpirada  = "expirada"
+)
+
+// VigenciaReservas obtiene la vigencia por defecto de las reservas desde RESERVA_VIGENCIA_HORAS (72 horas si no está definida)
+func VigenciaReservas() time.Duration {
+	horas, err := strconv.Atoi(config.GetEnv("RESERVA_VIGENCIA_HORAS", "72"))
+	if err != nil || horas <= 0 {
+		horas = 72
+	}
+	return time.Duration(horas) * time.Hour
+}
+
+// GetReservas obtiene las reservas, opcionalmente filtradas por cotización y estado
+func GetReservas(db *gorm.DB, cotizacionID uint, estado string) ([]modelos.ReservaStock, error) {
+	if err := ExpirarReservas(db); err != nil {
+		return nil, err
+	}
+
+	var reservas []modelos.ReservaStock
+	query := db.Order("id DESC")
+	if cotizacionID != 0 {
+		query = query.Where("cotizacion_id = ?", cotizacionID)
+	}
+	if estado != "" {
+		query = query.Where("estado = ?", estado)
+	}
+	if err := query.Find(&reservas).Error; err != nil {
+		return nil, err
+	}
+	return reservas, nil
+}
+
+// ExpirarReservas marca como expiradas las reservas activas cuya vigencia ya terminó
+func ExpirarReservas(db *gorm.DB) error {
+	return db.Model(&modelos.ReservaStock{}).
+		Where("estado = ? AND fecha_expira <= ?", ReservaActiva, time.Now()).
+		Update("estado", ReservaExpirada).Error
+}
+
+// ReservarCotizacion crea las reservas de stock para los ítems de una cotización.
+// Las reservas activas previas de la cotización se reemplazan. Si algún ítem no tiene
+// stock disponible suficiente no se reserva nada.
+func ReservarCotizacion(db *gorm.DB, cotizacionID uint, vigencia time.Duration, usuario string) ([]modelos.ReservaStock, error) {
+	if vigencia <= 0 {
+		vigencia = VigenciaReservas()
+	}
+
+	var reservas []modelos.ReservaStock
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var items []modelos.CotizacionItem
+		if err := tx.Where("cotizacion_id = ?", cotizacionID).Find(&items).Error; err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return errors.New("no hay productos en la cotización")
+		}
+		if err := normalizarItemsCotizacion(tx, items); err != nil {
+			return err
+		}
+
+		if err := liberarReservasCotizacion(tx, cotizacionID); err != nil {
+			return err
+		}
+
+		// Los kits se reservan como sus componentes, que son los que tienen stock
+		lineas := make([]lineaStock, 0, len(items))
+		for _, item := range items {
+			lineas = append(lineas, lineaStock{claveStock{SKU: item.ProductoID, SucursalID: item.SucursalID}, item.Cantidad})
+		}
+		orden, requerido, err := stockRequerido(tx, lineas)
+		if err != nil {
+			return err
+		}
+
+		ahora := time.Now()
+		for _, clave := range orden {
+			cantidad := requerido[clave]
+			disponible, err := stockDisponible(tx, clave.SKU, clave.SucursalID)
+			if err != nil {
+				return err
+			}
+			if disponible < cantidad {
+				return fmt.Errorf("stock disponible insuficiente para el producto %s en la sucursal %d: solicitado %d, disponible %d",
+					clave.SKU, clave.SucursalID, cantidad, disponible)
+			}
+
+			reserva := modelos.ReservaStock{
+				CotizacionID: cotizacionID,
+				SKU:          clave.SKU,
+				SucursalID:   clave.SucursalID,
+				Cantidad:     cantidad,
+				Estado:       ReservaActiva,
+				Usuario:      usuario,
+				FechaCrea:    ahora,
+				FechaExpira:  ahora.Add(vigencia),
+			}
+			if err := tx.Create(&reserva).Error; err != nil {
+				return err
+			}
+			reservas = append(reservas, reserva)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservas, nil
+}
+
+// LiberarReservasCotizacion libera las reservas activas de una cotización (por ejemplo, al ser rechazada)
+func LiberarReservasCotizacion(db *gorm.DB, cotizacionID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return liberarReservasCotizacion(tx, cotizacionID)
+	})
+}
+
+func liberarReservasCotizacion(tx *gorm.DB, cotizacionID uint) error {
+	return tx.Model(&modelos.ReservaStock{}).
+		Where("cotizacion_id = ? AND estado = ?", cotizacionID, ReservaActiva).
+		Update("estado", ReservaLiberada).Error
+}
+
+// stockDisponible bloquea el registro de stock y devuelve lo disponible (en mano menos reservado)
+func stockDisponible(tx *gorm.DB, sku string, sucursalID uint) (int, error) {
+	var stock modelos.StockSucursal
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("sku = ? AND sucursal_id = ?", sku, sucursalID).
+		First(&stock).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	reservado, err := stockReservado(tx, sku, sucursalID)
+	if err != nil {
+		return 0, err
+	}
+	return stock.Cantidad - reservado, nil
+}
+
+// stockReservado suma las reservas activas y vigentes de un SKU en una sucursal
+func stockReservado(tx *gorm.DB, sku string, sucursalID uint) (int, error) {
+	var reservado int
+	err := tx.Model(&modelos.ReservaStock{}).
+		Select("COALESCE(SUM(cantidad), 0)").
+		Where("sku = ? AND sucursal_id = ? AND estado = ? AND fecha_expira > ?", sku, sucursalID, ReservaActiva, time.Now()).
+		Scan(&reservado).Error
+	return reservado, err
+}
+
+// verificarReservas comprueba que el saldo resultante de una salida siga cubriendo el stock reservado
+func verificarReservas(tx *gorm.DB, sku string, sucursalID uint, saldo int) error {
+	reservado, err := stockReservado(tx, sku, sucursalID)
+	if err != nil {
+		return err
+	}
+	if saldo < reservado {
+		return fmt.Errorf("stock disponible insuficiente para el producto %s en la sucursal %d: hay %d unidades reservadas",
+			sku, sucursalID, reservado)
+	}
+	return nil
+}
+
+// consumirReservas descuenta de las reservas activas de la cotización la cantidad despachada
+// y devuelve la cantidad que no estaba reservada
+func consumirReservas(tx *gorm.DB, cotizacionID uint, sku string, sucursalID uint, cantidad int, despachoID uint) (int, error) {
+	var reservas []modelos.ReservaStock
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("cotizacion_id = ? AND sku = ? AND sucursal_id = ? AND estado = ? AND fecha_expira > ?",
+			cotizacionID, sku, sucursalID, ReservaActiva, time.Now()).
+		Order("fecha_crea ASC").
+		Find(&reservas).Error; err != nil {
+		return 0, err
+	}
+
+	pendiente := cantidad
+	for _, r := range reservas {
+		if pendiente == 0 {
+			break
+		}
+		if r.Cantidad <= pendiente {
+			pendiente -= r.Cantidad
+			if err := tx.Model(&r).Updates(map[string]interface{}{
+				"estado":      ReservaConsumida,
+				"despacho_id": despachoID,
+			}).Error; err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		// Consumo parcial: la parte despachada queda como reserva consumida y el resto sigue activo
+		if err := tx.Model(&r).Update("cantidad", r.Cantidad-pendiente).Error; err != nil {
+			return 0, err
+		}
+		consumida := r
+		consumida.ID = 0
+		consumida.Cantidad = pendiente
+		consumida.Estado = ReservaConsumida
+		consumida.DespachoID = &despachoID
+		if err := tx.Omit("Cotizacion", "Producto", "Sucursal").Create(&consumida).Error; err != nil {
+			return 0, err
+		}
+		pendiente = 0
+	}
+	return pendiente, nil
+}
+
+// salidaDespacho descuenta del stock la cantidad despachada consumiendo primero las reservas de la cotización.
+// Lo no reservado solo puede salir del stock disponible. Los kits descuentan sus componentes
+func salidaDespacho(tx *gorm.DB, despacho *modelos.Despacho, sku string, sucursalID uint, cantidad int, series []string, usuario string) error {
+	var producto modelos.Producto
+	if err := tx.Select("sku", "es_kit").First(&producto, "sku = ?", sku).Error; err != nil {
+		return fmt.Errorf("producto %s no encontrado", sku)
+	}
+	if producto.EsKit {
+		if len(series) > 0 {
+			return fmt.Errorf("el kit %s no lleva números de serie propios", sku)
+		}
+		return salidaKit(tx, despacho, sku, sucursalID, cantidad, usuario)
+	}
+
+	mov, err := descontarDespacho(tx, despacho, sku, "", sucursalID, cantidad, series, usuario)
+	if err != nil {
+		return err
+	}
+	// Costo de la mercadería despachada junto al valor de venta de la línea
+	return tx.Model(&modelos.ProductosDespacho{}).
+		Where("despacho_id = ? AND sku = ?", despacho.ID, sku).
+		Update("costo_total", gorm.Expr("costo_total + ?", float64(cantidad)*mov.CostoUnitario)).Error
+}
+
+// descontarDespacho registra la salida de un SKU con stock propio: consume las reservas, genera el
+// movimiento y deja en la línea del despacho los lotes y series que salieron, marcados con el kit
+// en que salieron (kitSKU vacío si el SKU sale suelto)
+func descontarDespacho(tx *gorm.DB, despacho *modelos.Despacho, sku, kitSKU string, sucursalID uint, cantidad int, series []string, usuario string) (*modelos.MovimientoStock, error) {
+	noReservado, err := consumirReservas(tx, despacho.CotizacionID, sku, sucursalID, cantidad, despacho.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	mov := modelos.MovimientoStock{
+		SKU:          sku,
+		SucursalID:   sucursalID,
+		Tipo:         MovimientoDespacho,
+		Cantidad:     -cantidad,
+		Usuario:      usuario,
+		Referencia:   fmt.Sprintf("despacho #%d", despacho.ID),
+		DespachoID:   &despacho.ID,
+		NumerosSerie: series,
+	}
+	if err := RegistrarMovimientoStock(tx, &mov); err != nil {
+		return nil, err
+	}
+	if err := registrarLotesDespacho(tx, despacho.ID, sku, kitSKU, mov.Lotes); err != nil {
+		return nil, err
+	}
+	if err := registrarSeriesDespacho(tx, despacho.ID, sku, kitSKU, mov.Series); err != nil {
+		return nil, err
+	}
+	if noReservado > 0 {
+		if err := verificarReservas(tx, sku, sucursalID, mov.SaldoResultante); err != nil {
+			return nil, err
+		}
+	}
+	return &mov, nil
+}
+
+// revertirDespachosCotizacion devuelve al stock lo descontado por los despachos de una cotización,
+// reactiva las reservas que consumieron y elimina los despachos. Solo se revierten despachos
+// pendientes: si alguno ya fue aprobado o entregado no se toca ninguno
+func revertirDespachosCotizacion(tx *gorm.DB, cotizacionID uint, usuario string) error {
+	var despachos []modelos.Despacho
+	if err := tx.Where("cotizacion_id = ?", cotizacionID).Find(&despachos).Error; err != nil {
+		return err
+	}
+	for _, d := range despachos {
+		if d.Estado != "pendiente" {
+			return fmt.Errorf("la cotización ya tiene el despacho #%d %s; no se puede recalcular", d.ID, d.Estado)
+		}
+	}
+	for i := range despachos {
+		if err := revertirDespacho(tx, &despachos[i], usuario); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revertirDespacho devuelve al stock lo descontado por un despacho pendiente, reactiva las
+// reservas que consumió y lo elimina
+func revertirDespacho(tx *gorm.DB, d *modelos.Despacho, usuario string) error {
+	if d.Estado != "pendiente" {
+		return fmt.Errorf("el despacho #%d está %s; solo se pueden anular despachos pendientes", d.ID, d.Estado)
+	}
+	var movimientos []modelos.MovimientoStock
+	if err := tx.Preload("Lotes").Preload("Series.Serie").Preload("Ubicaciones").
+		Where("tipo = ? AND despacho_id = ?", MovimientoDespacho, d.ID).
+		Find(&movimientos).Error; err != nil {
+		return err
+	}
+	for _, m := range movimientos {
+		// La mercadería vuelve a los mismos lotes y ubicaciones, y con las mismas series con que salió
+		var lotes []modelos.MovimientoLote
+		for _, l := range m.Lotes {
+			lotes = append(lotes, modelos.MovimientoLote{LoteID: l.LoteID, Cantidad: -l.Cantidad})
+		}
+		var ubicaciones []modelos.MovimientoUbicacion
+		for _, u := range m.Ubicaciones {
+			ubicaciones = append(ubicaciones, modelos.MovimientoUbicacion{UbicacionID: u.UbicacionID, Cantidad: -u.Cantidad})
+		}
+		var series []string
+		for _, s := range m.Series {
+			series = append(series, s.Serie.Serie)
+		}
+		if err := RegistrarMovimientoStock(tx, &modelos.MovimientoStock{
+			SKU:           m.SKU,
+			SucursalID:    m.SucursalID,
+			Tipo:          MovimientoDevolucion,
+			Cantidad:      -m.Cantidad,
+			Usuario:       usuario,
+			Referencia:    fmt.Sprintf("anulación despacho #%d", d.ID),
+			DespachoID:    &d.ID,
+			Lotes:         lotes,
+			NumerosSerie:  series,
+			Ubicaciones:   ubicaciones,
+			CostoUnitario: m.CostoUnitario,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Model(&modelos.ReservaStock{}).
+		Where("despacho_id = ? AND estado = ?", d.ID, ReservaConsumida).
+		Updates(map[string]interface{}{
+			"estado":      ReservaActiva,
+			"despacho_id": nil,
+		}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&modelos.Despacho{}, d.ID).Error
+}
@@ -0,0 +1,322 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Estados de una unidad serializada
+const (
+	SerieDisponible = "disponible"
+	SerieEnTransito = "en_transito"
+	SerieDespachada = "despachada"
+	SerieBaja       = "baja"
+)
+
+// EventoSerie es un paso en la historia de una unidad serializada
+type EventoSerie struct {
+	Fecha      time.Time `json:"fecha"`
+	Tipo       string    `json:"tipo"`
+	SucursalID uint      `json:"sucursal_id"`
+	Sucursal   string    `json:"sucursal"`
+	Referencia string    `json:"referencia"`
+	Usuario    string    `json:"usuario"`
+
+	// Solo en salidas por despacho
+	DespachoID *uint  `json:"despacho_id,omitempty"`
+	RutCliente string `json:"rut_cliente,omitempty"`
+	Cliente    string `json:"cliente,omitempty"`
+	Destino    string `json:"destino,omitempty"`
+}
+
+// HistorialSerie es la ficha de una unidad con todos sus movimientos
+type HistorialSerie struct {
+	modelos.NumeroSerie
+	Eventos []EventoSerie `json:"eventos"`
+}
+
+// GetSeriesStock lista las unidades disponibles de un SKU en una sucursal
+func GetSeriesStock(db *gorm.DB, sku string, sucursalID uint) ([]modelos.NumeroSerie, error) {
+	var series []modelos.NumeroSerie
+	if err := db.Where("sku = ? AND sucursal_id = ? AND estado = ?", sku, sucursalID, SerieDisponible).
+		Order("fecha_ingreso ASC, id ASC").
+		Find(&series).Error; err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+// RegistrarSeriesExistentes asigna números de serie al stock que ya estaba en la sucursal
+// antes de marcar el producto como serializado, sin mover la cantidad
+func RegistrarSeriesExistentes(db *gorm.DB, sku string, sucursalID uint, series []string) ([]modelos.NumeroSerie, error) {
+	if len(series) == 0 {
+		return nil, errors.New("debe indicar al menos un número de serie")
+	}
+	if err := validarListaSeries(sku, series); err != nil {
+		return nil, err
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var producto modelos.Producto
+		if err := tx.Select("sku", "serializado").Where("sku = ?", sku).First(&producto).Error; err != nil {
+			return errors.New("producto no encontrado")
+		}
+		if !producto.Serializado {
+			return fmt.Errorf("el producto %s no es serializado", sku)
+		}
+
+		var stock modelos.StockSucursal
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("sku = ? AND sucursal_id = ?", sku, sucursalID).
+			First(&stock).Error; err != nil {
+			return fmt.Errorf("no existe stock del producto %s en la sucursal %d", sku, sucursalID)
+		}
+
+		var registradas int64
+		if err := tx.Model(&modelos.NumeroSerie{}).
+			Where("sku = ? AND sucursal_id = ? AND estado = ?", sku, sucursalID, SerieDisponible).
+			Count(&registradas).Error; err != nil {
+			return err
+		}
+		if int(registradas)+len(series) > stock.Cantidad {
+			return fmt.Errorf("la sucursal tiene %d unidades y ya hay %d series registradas", stock.Cantidad, registradas)
+		}
+
+		ahora := time.Now()
+		for _, s := range series {
+			var existente int64
+			if err := tx.Model(&modelos.NumeroSerie{}).Where("sku = ? AND serie = ?", sku, s).Count(&existente).Error; err != nil {
+				return err
+			}
+			if existente > 0 {
+				return fmt.Errorf("la serie %s del producto %s ya está registrada", s, sku)
+			}
+			nueva := modelos.NumeroSerie{SKU: sku, Serie: s, SucursalID: &sucursalID, Estado: SerieDisponible, FechaIngreso: ahora}
+			if err := tx.Omit("Producto", "Sucursal").Create(&nueva).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetSeriesStock(db, sku, sucursalID)
+}
+
+// GetHistorialSerie busca una unidad por su número de serie (opcionalmente acotado a un SKU)
+// y arma su historia: recepción, sucursales por las que pasó, despachos y clientes
+func GetHistorialSerie(db *gorm.DB, serie, sku string) ([]HistorialSerie, error) {
+	var unidades []modelos.NumeroSerie
+	query := db.Preload("Producto").Preload("Sucursal").Where("serie = ?", serie)
+	if sku != "" {
+		query = query.Where("sku = ?", sku)
+	}
+	if err := query.Find(&unidades).Error; err != nil {
+		return nil, err
+	}
+	if len(unidades) == 0 {
+		return nil, errors.New("número de serie no encontrado")
+	}
+
+	resultado := make([]HistorialSerie, 0, len(unidades))
+	for _, u := range unidades {
+		var movimientos []modelos.MovimientoStock
+		if err := db.Preload("Sucursal").
+			Joins("JOIN movimiento_serie ms ON ms.movimiento_id = movimientos_stock.id").
+			Where("ms.serie_id = ?", u.ID).
+			Order("movimientos_stock.fecha ASC, movimientos_stock.id ASC").
+			Find(&movimientos).Error; err != nil {
+			return nil, err
+		}
+
+		// Despachos en los que salió la unidad, con su cliente y dirección de destino
+		var despachos []modelos.Despacho
+		if err := db.Preload("Cotizacion.Cliente").
+			Preload("DestinoDirCliente").
+			Joins("JOIN productos_despacho_serie pds ON pds.despacho_id = despacho.id").
+			Where("pds.serie_id = ?", u.ID).
+			Find(&despachos).Error; err != nil {
+			return nil, err
+		}
+		porID := make(map[uint]modelos.Despacho)
+		for _, d := range despachos {
+			porID[d.ID] = d
+		}
+
+		historial := HistorialSerie{NumeroSerie: u, Eventos: make([]EventoSerie, 0, len(movimientos))}
+		for _, m := range movimientos {
+			evento := EventoSerie{
+				Fecha:      m.Fecha,
+				Tipo:       m.Tipo,
+				SucursalID: m.SucursalID,
+				Sucursal:   m.Sucursal.Nombre,
+				Referencia: m.Referencia,
+				Usuario:    m.Usuario,
+			}
+			if d, ok := porID[despachoMovimiento(m)]; ok && m.Tipo == MovimientoDespacho {
+				id := d.ID
+				evento.DespachoID = &id
+				evento.RutCliente = d.Cotizacion.RutCliente
+				evento.Cliente = d.Cotizacion.Cliente.Nombre
+				evento.Destino = fmt.Sprintf("%s, %s, %s", d.DestinoDirCliente.Direccion, d.DestinoDirCliente.Comuna, d.DestinoDirCliente.Ciudad)
+			}
+			historial.Eventos = append(historial.Eventos, evento)
+		}
+		resultado = append(resultado, historial)
+	}
+	return resultado, nil
+}
+
+// aplicarSeries valida y mueve las unidades serializadas de un movimiento, dejando el detalle en
+// mov.Series. Las entradas registran o reingresan cada serie en la sucursal y las salidas exigen
+// series disponibles en ella. En despachos sin series indicadas se toman las más antiguas.
+func aplicarSeries(tx *gorm.DB, mov *modelos.MovimientoStock, producto *modelos.Producto) error {
+	if !producto.Serializado {
+		mov.Series = nil
+		return nil
+	}
+
+	cantidad := mov.Cantidad
+	if cantidad < 0 {
+		cantidad = -cantidad
+	}
+
+	series := mov.NumerosSerie
+	if len(series) == 0 && mov.Tipo == MovimientoDespacho && mov.Cantidad < 0 {
+		var disponibles []modelos.NumeroSerie
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("sku = ? AND sucursal_id = ? AND estado = ?", mov.SKU, mov.SucursalID, SerieDisponible).
+			Order("fecha_ingreso ASC, id ASC").
+			Limit(cantidad).
+			Find(&disponibles).Error; err != nil {
+			return err
+		}
+		for _, d := range disponibles {
+			series = append(series, d.Serie)
+		}
+	}
+	if len(series) != cantidad {
+		return fmt.Errorf("el producto %s es serializado: debe indicar %d números de serie y se indicaron %d", mov.SKU, cantidad, len(series))
+	}
+	if err := validarListaSeries(mov.SKU, series); err != nil {
+		return err
+	}
+
+	mov.Series = make([]modelos.MovimientoSerie, 0, len(series))
+	for _, s := range series {
+		var unidad modelos.NumeroSerie
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("sku = ? AND serie = ?", mov.SKU, s).
+			First(&unidad).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		encontrada := err == nil
+
+		if mov.Cantidad > 0 {
+			if !encontrada {
+				unidad = modelos.NumeroSerie{SKU: mov.SKU, Serie: s, Estado: SerieDisponible, FechaIngreso: time.Now()}
+			} else if unidad.Estado == SerieDisponible {
+				return fmt.Errorf("la serie %s del producto %s ya está en stock", s, mov.SKU)
+			}
+			sucursalID := mov.SucursalID
+			unidad.SucursalID = &sucursalID
+			unidad.Estado = SerieDisponible
+		} else {
+			if !encontrada || unidad.Estado != SerieDisponible || unidad.SucursalID == nil || *unidad.SucursalID != mov.SucursalID {
+				return fmt.Errorf("la serie %s del producto %s no está disponible en la sucursal %d", s, mov.SKU, mov.SucursalID)
+			}
+			unidad.SucursalID = nil
+			switch mov.Tipo {
+			case MovimientoDespacho:
+				unidad.Estado = SerieDespachada
+			case MovimientoTransferenciaSalida:
+				unidad.Estado = SerieEnTransito
+			default:
+				unidad.Estado = SerieBaja
+			}
+		}
+
+		if encontrada {
+			if err := tx.Model(&unidad).Updates(map[string]interface{}{
+				"sucursal_id": unidad.SucursalID,
+				"estado":      unidad.Estado,
+			}).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Omit("Producto", "Sucursal").Create(&unidad).Error; err != nil {
+			return err
+		}
+		mov.Series = append(mov.Series, modelos.MovimientoSerie{SerieID: unidad.ID})
+	}
+	return nil
+}
+
+// despachoMovimiento es el despacho del movimiento, o cero si no tiene
+func despachoMovimiento(m modelos.MovimientoStock) uint {
+	if m.DespachoID == nil {
+		return 0
+	}
+	return *m.DespachoID
+}
+
+// registrarSeriesDespacho guarda en la línea del despacho las unidades que salieron. kitSKU indica
+// el kit en que salieron, vacío si salieron sueltas
+func registrarSeriesDespacho(tx *gorm.DB, despachoID uint, sku, kitSKU string, series []modelos.MovimientoSerie) error {
+	for _, ms := range series {
+		var unidad modelos.NumeroSerie
+		if err := tx.First(&unidad, ms.SerieID).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&modelos.ProductosDespachoSerie{
+			DespachoID: despachoID,
+			SKU:        sku,
+			SerieID:    unidad.ID,
+			Serie:      unidad.Serie,
+			KitSKU:     kitSKU,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seriesEnTransferencia devuelve las series de un SKU que salieron con la transferencia
+// y todavía no se recibieron
+func seriesEnTransferencia(tx *gorm.DB, t *modelos.Transferencia, sku string) (map[string]bool, error) {
+	var series []string
+	if err := tx.Table("numeros_serie AS ns").
+		Select("ns.serie").
+		Joins("JOIN movimiento_serie ms ON ms.serie_id = ns.id").
+		Joins("JOIN movimientos_stock m ON m.id = ms.movimiento_id").
+		Where("m.tipo = ? AND m.referencia = ? AND m.sku = ? AND ns.estado = ?",
+			MovimientoTransferenciaSalida, fmt.Sprintf("transferencia #%d", t.ID), sku, SerieEnTransito).
+		Scan(&series).Error; err != nil {
+		return nil, err
+	}
+	resultado := make(map[string]bool, len(series))
+	for _, s := range series {
+		resultado[s] = true
+	}
+	return resultado, nil
+}
+
+func validarListaSeries(sku string, series []string) error {
+	vistas := make(map[string]bool, len(series))
+	for _, s := range series {
+		if s == "" {
+			return fmt.Errorf("hay números de serie vacíos para el producto %s", sku)
+		}
+		if vistas[s] {
+			return fmt.Errorf("la serie %s del producto %s está repetida", s, sku)
+		}
+		vistas[s] = true
+	}
+	return nil
+}
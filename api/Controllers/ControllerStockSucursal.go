@@ -2,12 +2,31 @@ package Controllers
 
 import (
 	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"math"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// StockSucursalDisponible incluye el stock en mano, lo reservado por cotizaciones y lo disponible
+type StockSucursalDisponible struct {
+	modelos.StockSucursal
+	Reservado  int `json:"reservado"`
+	Disponible int `json:"disponible"`
+
+	// Descuento vigente hoy por promociones para el público general, y el precio resultante
+	Descuento   float64 `json:"descuento"`
+	PrecioFinal float64 `json:"precio_final"`
+
+	// Stock en mano expresado en cada unidad alternativa del producto
+	Equivalencias []Equivalencia `json:"equivalencias,omitempty"`
+}
+
 // GetStockSucursal obtiene todos los registros de stock por sucursal
-func GetStockSucursal(db *gorm.DB) ([]modelos.StockSucursal, error) {
+func GetStockSucursal(db *gorm.DB) ([]StockSucursalDisponible, error) {
 	var stocks []modelos.StockSucursal
 	if err := db.
 		Preload("Producto.Categoria").
@@ -16,11 +35,47 @@ func GetStockSucursal(db *gorm.DB) ([]modelos.StockSucursal, error) {
 		Find(&stocks).Error; err != nil {
 		return nil, err
 	}
-	return stocks, nil
+
+	reservas, err := reservasPorStock(db, "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var unidades []modelos.ProductoUnidad
+	if err := db.Order("factor ASC").Find(&unidades).Error; err != nil {
+		return nil, err
+	}
+	unidadesSKU := make(map[string][]modelos.ProductoUnidad)
+	for _, u := range unidades {
+		unidadesSKU[u.SKU] = append(unidadesSKU[u.SKU], u)
+	}
+
+	promociones, err := promocionesVigentes(db, time.Now(), "")
+	if err != nil {
+		return nil, err
+	}
+	categorias, err := cargarArbolCategorias(db)
+	if err != nil {
+		return nil, err
+	}
+
+	resultado := make([]StockSucursalDisponible, 0, len(stocks))
+	for _, s := range stocks {
+		reservado := reservas[claveStock{SKU: s.SKU, SucursalID: s.SucursalID}]
+		disponible := StockSucursalDisponible{
+			StockSucursal: s,
+			Reservado:     reservado,
+			Disponible:    s.Cantidad - reservado,
+			Equivalencias: equivalencias(unidadesSKU[s.SKU], s.Cantidad),
+		}
+		disponible.aplicarPromociones(promociones, categorias)
+		resultado = append(resultado, disponible)
+	}
+	return resultado, nil
 }
 
 // GetStockSucursalByID obtiene un registro de stock por su ID
-func GetStockSucursalByID(db *gorm.DB, sucursalID uint, sku string) (*modelos.StockSucursal, error) {
+func GetStockSucursalByID(db *gorm.DB, sucursalID uint, sku string) (*StockSucursalDisponible, error) {
 	var stock modelos.StockSucursal
 	if err := db.
 		Where("sucursal_id = ? AND sku = ?", sucursalID, sku).
@@ -30,22 +85,194 @@ func GetStockSucursalByID(db *gorm.DB, sucursalID uint, sku string) (*modelos.St
 		First(&stock).Error; err != nil {
 		return nil, err
 	}
-	return &stock, nil
+
+	reservado, err := stockReservado(db, sku, sucursalID)
+	if err != nil {
+		return nil, err
+	}
+	unidades, err := GetUnidadesProducto(db, sku)
+	if err != nil {
+		return nil, err
+	}
+	promociones, err := promocionesVigentes(db, time.Now(), sku)
+	if err != nil {
+		return nil, err
+	}
+	categorias, err := cargarArbolCategorias(db)
+	if err != nil {
+		return nil, err
+	}
+	disponible := &StockSucursalDisponible{
+		StockSucursal: stock,
+		Reservado:     reservado,
+		Disponible:    stock.Cantidad - reservado,
+		Equivalencias: equivalencias(unidades, stock.Cantidad),
+	}
+	disponible.aplicarPromociones(promociones, categorias)
+	return disponible, nil
 }
 
-// CreateStockSucursal crea un nuevo registro de stock
-func CreateStockSucursal(db *gorm.DB, nuevo *modelos.StockSucursal) error {
-	return db.Create(nuevo).Error
+// aplicarPromociones completa el descuento y el precio final del stock con las promociones vigentes
+func (s *StockSucursalDisponible) aplicarPromociones(vigentes []modelos.Promocion, categorias arbolCategorias) {
+	precio := s.Producto.Precio
+	aplicables := promocionesAplicables(vigentes, &s.Producto, categorias, s.SucursalID, 0)
+	s.PrecioFinal, _ = aplicarPromociones(precio, aplicables)
+	if precio > 0 {
+		s.Descuento = math.Round((1-s.PrecioFinal/precio)*10000) / 100
+	}
 }
 
-// UpdateStockSucursal actualiza un registro de stock existente
-func UpdateStockSucursal(db *gorm.DB, sku string, sucursalID uint, actualizado *modelos.StockSucursal) error {
-	return db.Model(&modelos.StockSucursal{}).
-		Where("sku = ? AND sucursal_id = ?", sku, sucursalID).
-		Updates(actualizado).Error
+// reservasPorStock suma las reservas activas y vigentes agrupadas por SKU y sucursal.
+// Los filtros son opcionales.
+func reservasPorStock(db *gorm.DB, sku string, sucursalID uint) (map[claveStock]int, error) {
+	var filas []struct {
+		SKU        string
+		SucursalID uint
+		Reservado  int
+	}
+	query := db.Model(&modelos.ReservaStock{}).
+		Select("sku, sucursal_id, SUM(cantidad) AS reservado").
+		Where("estado = ? AND fecha_expira > ?", ReservaActiva, time.Now()).
+		Group("sku, sucursal_id")
+	if sku != "" {
+		query = query.Where("sku = ?", sku)
+	}
+	if sucursalID != 0 {
+		query = query.Where("sucursal_id = ?", sucursalID)
+	}
+	if err := query.Scan(&filas).Error; err != nil {
+		return nil, err
+	}
+
+	reservas := make(map[claveStock]int, len(filas))
+	for _, f := range filas {
+		reservas[claveStock{SKU: f.SKU, SucursalID: f.SucursalID}] = f.Reservado
+	}
+	return reservas, nil
+}
+
+// CreateStockSucursal crea un nuevo registro de stock y registra su saldo inicial en el kardex
+func CreateStockSucursal(db *gorm.DB, nuevo *modelos.StockSucursal, usuario string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var producto modelos.Producto
+		if err := tx.Select("sku", "es_kit").First(&producto, "sku = ?", nuevo.SKU).Error; err != nil {
+			return errors.New("producto no encontrado")
+		}
+		if producto.EsKit {
+			return fmt.Errorf("el producto %s es un kit; su stock se calcula desde sus componentes", nuevo.SKU)
+		}
+		if err := tx.Create(nuevo).Error; err != nil {
+			return err
+		}
+		if nuevo.Cantidad == 0 {
+			return nil
+		}
+		// El stock inicial entra al costo promedio informado, como primera capa FIFO
+		if nuevo.CostoPromedio > 0 {
+			if err := tx.Create(&modelos.CapaCosto{
+				SKU:           nuevo.SKU,
+				SucursalID:    nuevo.SucursalID,
+				Fecha:         time.Now(),
+				Cantidad:      nuevo.Cantidad,
+				Restante:      nuevo.Cantidad,
+				CostoUnitario: nuevo.CostoPromedio,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&modelos.MovimientoStock{
+			SKU:             nuevo.SKU,
+			SucursalID:      nuevo.SucursalID,
+			Tipo:            MovimientoAjuste,
+			Cantidad:        nuevo.Cantidad,
+			SaldoResultante: nuevo.Cantidad,
+			Usuario:         usuario,
+			Referencia:      "stock inicial",
+			Fecha:           time.Now(),
+			CostoUnitario:   nuevo.CostoPromedio,
+		}).Error
+	})
+}
+
+// ErrCampoStockNoEditable indica que se intentó cambiar por el ajuste de stock un campo que tiene
+// su propio endpoint
+var ErrCampoStockNoEditable = errors.New("por este endpoint solo se puede cambiar la cantidad")
+
+// ActualizacionStockSucursal es el cuerpo del ajuste de stock. Los demás campos se aceptan solo si
+// vienen con el valor vigente (por ejemplo, el registro tal como se leyó)
+type ActualizacionStockSucursal struct {
+	Cantidad      *int     `json:"cantidad" binding:"required,min=0"`
+	StockMinimo   *int     `json:"stock_minimo"`
+	StockMaximo   *int     `json:"stock_maximo"`
+	PuntoReorden  *int     `json:"punto_reorden"`
+	CostoPromedio *float64 `json:"costo_promedio"`
 }
 
-// DeleteStockSucursal elimina un registro de stock
+// UpdateStockSucursal actualiza la cantidad de un registro de stock existente.
+// La diferencia de cantidad se registra como un ajuste manual en el kardex.
+func UpdateStockSucursal(db *gorm.DB, sku string, sucursalID uint, actualizado *ActualizacionStockSucursal, version uint, usuario string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existente modelos.StockSucursal
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("sku = ? AND sucursal_id = ?", sku, sucursalID).
+			First(&existente).Error; err != nil {
+			return err
+		}
+		if existente.Version != version {
+			return ErrConflictoVersion
+		}
+		if (actualizado.StockMinimo != nil && *actualizado.StockMinimo != existente.StockMinimo) ||
+			(actualizado.StockMaximo != nil && *actualizado.StockMaximo != existente.StockMaximo) ||
+			(actualizado.PuntoReorden != nil && *actualizado.PuntoReorden != existente.PuntoReorden) ||
+			(actualizado.CostoPromedio != nil && *actualizado.CostoPromedio != existente.CostoPromedio) {
+			return ErrCampoStockNoEditable
+		}
+
+		delta := *actualizado.Cantidad - existente.Cantidad
+		if delta == 0 {
+			return nil
+		}
+		return RegistrarMovimientoStock(tx, &modelos.MovimientoStock{
+			SKU:        sku,
+			SucursalID: sucursalID,
+			Tipo:       MovimientoAjuste,
+			Cantidad:   delta,
+			Usuario:    usuario,
+			Referencia: "ajuste manual",
+		})
+	})
+}
+
+// ErrStockConSaldo indica que se intentó eliminar un registro de stock que aún tiene unidades
+var ErrStockConSaldo = errors.New("el registro de stock aún tiene unidades; ajústelo a cero antes de eliminarlo")
+
+// DeleteStockSucursal elimina un registro de stock. Solo se permite con la cantidad en cero, para
+// que el kardex explique la salida de las unidades y no queden lotes ni series disponibles
 func DeleteStockSucursal(db *gorm.DB, sku string, sucursalID uint) error {
-	return db.Where("sku = ? AND sucursal_id = ?", sku, sucursalID).Delete(&modelos.StockSucursal{}).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existente modelos.StockSucursal
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("sku = ? AND sucursal_id = ?", sku, sucursalID).
+			First(&existente).Error; err != nil {
+			return err
+		}
+		if existente.Cantidad > 0 {
+			return ErrStockConSaldo
+		}
+		var series int64
+		if err := tx.Model(&modelos.NumeroSerie{}).
+			Where("sku = ? AND sucursal_id = ? AND estado = ?", sku, sucursalID, SerieDisponible).
+			Count(&series).Error; err != nil {
+			return err
+		}
+		if series > 0 {
+			return ErrStockConSaldo
+		}
+
+		// El reparto por ubicaciones deja de tener sentido sin el registro de stock
+		if err := tx.Where("sku = ? AND sucursal_id = ?", sku, sucursalID).Delete(&modelos.StockUbicacion{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("sku = ? AND sucursal_id = ?", sku, sucursalID).Delete(&modelos.StockSucursal{}).Error
+	})
 }
@@ -2,6 +2,8 @@ package Controllers
 
 import (
 	modelos "backend-inventario/api/Models"
+	"fmt"
+	"math"
 
 	"gorm.io/gorm"
 )
@@ -15,16 +17,16 @@ func GetSucursales(db *gorm.DB) ([]modelos.Sucursal, error) {
 	return sucursales, nil
 }
 
-// GetBodegas obtiene solo las sucursales que son bodegas
+// GetBodegas obtiene solo las sucursales cuyo tipo es bodega
 func GetBodegas(db *gorm.DB) ([]modelos.Sucursal, error) {
 	var bodegas []modelos.Sucursal
-
-	// Por ahora, devolvemos todas las sucursales para test
-	// TODO: Aplicar filtro por tipo bodega una vez que verifiquemos que funciona
-	if err := db.Preload("Tipo").Find(&bodegas).Error; err != nil {
+	if err := db.Preload("Tipo").
+		Joins("JOIN tipo_sucursal ts ON ts.id = sucursales.tipo_id").
+		Where("ts.es_bodega = ?", true).
+		Order("sucursales.nombre").
+		Find(&bodegas).Error; err != nil {
 		return nil, err
 	}
-
 	return bodegas, nil
 }
 
@@ -62,3 +64,143 @@ func UpdateSucursal(db *gorm.DB, id uint, actualizada *modelos.Sucursal) (*model
 func DeleteSucursal(db *gorm.DB, id uint) error {
 	return db.Delete(&modelos.Sucursal{}, id).Error
 }
+
+// UnidadPallet es el código de la unidad de embalaje con que se cuentan las posiciones de pallet
+const UnidadPallet = "PALLET"
+
+// UtilizacionBodega compara el stock en mano de una sucursal con su capacidad. Los porcentajes
+// solo se informan cuando la capacidad correspondiente está definida
+type UtilizacionBodega struct {
+	SucursalID         uint     `json:"sucursal_id"`
+	Sucursal           string   `json:"sucursal"`
+	CapacidadVolumen   float64  `json:"capacidad_volumen"`
+	VolumenUsado       float64  `json:"volumen_usado"`
+	PorcentajeVolumen  *float64 `json:"porcentaje_volumen,omitempty"`
+	CapacidadPeso      float64  `json:"capacidad_peso"`
+	PesoUsado          float64  `json:"peso_usado"`
+	PorcentajePeso     *float64 `json:"porcentaje_peso,omitempty"`
+	PosicionesPallet   int      `json:"posiciones_pallet"`
+	PalletsUsados      int      `json:"pallets_usados"`
+	PorcentajePallets  *float64 `json:"porcentaje_pallets,omitempty"`
+	SKUsSinDimensiones int      `json:"skus_sin_dimensiones"` // con stock pero sin peso o medidas
+	SKUsSinPallet      int      `json:"skus_sin_pallet"`      // con stock pero sin unidad PALLET
+	Advertencias       []string `json:"advertencias"`
+}
+
+// GetUtilizacionBodegas calcula la utilización de las bodegas, o de una sucursal si se indica.
+// El volumen (m³) y el peso (kg) salen del stock en mano por las medidas (cm) y el peso del
+// producto; los pallets, del stock dividido por la unidad PALLET del producto, redondeado hacia arriba
+func GetUtilizacionBodegas(db *gorm.DB, sucursalID uint) ([]UtilizacionBodega, error) {
+	query := db.Table("sucursales AS su").
+		Select(`su.id AS sucursal_id, su.nombre AS sucursal,
+			su.capacidad_volumen, su.capacidad_peso, su.posiciones_pallet,
+			COALESCE(SUM(s.cantidad * p.largo * p.ancho * p.alto / 1000000), 0) AS volumen_usado,
+			COALESCE(SUM(s.cantidad * p.peso), 0) AS peso_usado,
+			COALESCE(SUM(CEIL(s.cantidad / pu.factor)), 0) AS pallets_usados,
+			COUNT(s.sku) FILTER (WHERE p.peso = 0 OR p.largo * p.ancho * p.alto = 0) AS skus_sin_dimensiones,
+			COUNT(s.sku) FILTER (WHERE pu.id IS NULL) AS skus_sin_pallet`).
+		Joins("LEFT JOIN stock_sucursal s ON s.sucursal_id = su.id AND s.cantidad > 0").
+		Joins("LEFT JOIN productos p ON p.sku = s.sku").
+		Joins("LEFT JOIN producto_unidades pu ON pu.sku = s.sku AND pu.codigo = ?", UnidadPallet).
+		Group("su.id, su.nombre, su.capacidad_volumen, su.capacidad_peso, su.posiciones_pallet").
+		Order("su.nombre")
+	if sucursalID != 0 {
+		query = query.Where("su.id = ?", sucursalID)
+	} else {
+		query = query.Joins("JOIN tipo_sucursal ts ON ts.id = su.tipo_id").Where("ts.es_bodega = ?", true)
+	}
+
+	var resultado []UtilizacionBodega
+	if err := query.Scan(&resultado).Error; err != nil {
+		return nil, err
+	}
+	for i := range resultado {
+		resultado[i].calcularPorcentajes()
+	}
+	return resultado, nil
+}
+
+// calcularPorcentajes redondea lo usado, calcula el porcentaje de cada capacidad definida y
+// advierte las que están excedidas
+func (u *UtilizacionBodega) calcularPorcentajes() {
+	u.VolumenUsado = math.Round(u.VolumenUsado*1000) / 1000
+	u.PesoUsado = math.Round(u.PesoUsado*100) / 100
+	u.Advertencias = []string{}
+
+	porcentaje := func(usado, capacidad float64) *float64 {
+		if capacidad <= 0 {
+			return nil
+		}
+		p := math.Round(usado/capacidad*10000) / 100
+		return &p
+	}
+	u.PorcentajeVolumen = porcentaje(u.VolumenUsado, u.CapacidadVolumen)
+	u.PorcentajePeso = porcentaje(u.PesoUsado, u.CapacidadPeso)
+	u.PorcentajePallets = porcentaje(float64(u.PalletsUsados), float64(u.PosicionesPallet))
+
+	if u.CapacidadVolumen > 0 && u.VolumenUsado > u.CapacidadVolumen {
+		u.Advertencias = append(u.Advertencias, fmt.Sprintf("%s supera su capacidad de volumen: %.3f de %.2f m³", u.Sucursal, u.VolumenUsado, u.CapacidadVolumen))
+	}
+	if u.CapacidadPeso > 0 && u.PesoUsado > u.CapacidadPeso {
+		u.Advertencias = append(u.Advertencias, fmt.Sprintf("%s supera su capacidad de peso: %.2f de %.2f kg", u.Sucursal, u.PesoUsado, u.CapacidadPeso))
+	}
+	if u.PosicionesPallet > 0 && u.PalletsUsados > u.PosicionesPallet {
+		u.Advertencias = append(u.Advertencias, fmt.Sprintf("%s supera sus posiciones de pallet: %d de %d", u.Sucursal, u.PalletsUsados, u.PosicionesPallet))
+	}
+}
+
+// advertenciasCapacidad revisa, dentro de la transacción de un ingreso, si la sucursal de destino
+// queda sobre su capacidad. Entrantes son las unidades por SKU que aún no se registran y se suman
+// al stock en mano; es nil si los movimientos ya se registraron. No impide el ingreso
+func advertenciasCapacidad(tx *gorm.DB, sucursalID uint, entrantes map[string]int) ([]string, error) {
+	utilizacion, err := GetUtilizacionBodegas(tx, sucursalID)
+	if err != nil {
+		return nil, err
+	}
+	if len(utilizacion) == 0 {
+		return nil, nil
+	}
+	u := &utilizacion[0]
+	if len(entrantes) > 0 {
+		if err := u.sumarEntrantes(tx, entrantes); err != nil {
+			return nil, err
+		}
+		u.calcularPorcentajes()
+	}
+	return u.Advertencias, nil
+}
+
+// sumarEntrantes agrega a lo usado el volumen, el peso y los pallets de las unidades que entran.
+// Los pallets se cuentan como la diferencia entre los que ocupará el SKU y los que ya ocupa
+func (u *UtilizacionBodega) sumarEntrantes(tx *gorm.DB, entrantes map[string]int) error {
+	skus := make([]string, 0, len(entrantes))
+	for sku := range entrantes {
+		skus = append(skus, sku)
+	}
+	var filas []struct {
+		SKU     string
+		Actual  int
+		Volumen float64 // m³ por unidad
+		Peso    float64
+		Factor  float64 // unidades por pallet; cero si el producto no tiene unidad PALLET
+	}
+	if err := tx.Table("productos AS p").
+		Select(`p.sku, GREATEST(COALESCE(s.cantidad, 0), 0) AS actual,
+			p.largo * p.ancho * p.alto / 1000000 AS volumen, p.peso,
+			COALESCE(pu.factor, 0) AS factor`).
+		Joins("LEFT JOIN stock_sucursal s ON s.sku = p.sku AND s.sucursal_id = ?", u.SucursalID).
+		Joins("LEFT JOIN producto_unidades pu ON pu.sku = p.sku AND pu.codigo = ?", UnidadPallet).
+		Where("p.sku IN ?", skus).
+		Scan(&filas).Error; err != nil {
+		return err
+	}
+	for _, f := range filas {
+		cantidad := entrantes[f.SKU]
+		u.VolumenUsado += float64(cantidad) * f.Volumen
+		u.PesoUsado += float64(cantidad) * f.Peso
+		if f.Factor > 0 {
+			u.PalletsUsados += int(math.Ceil(float64(f.Actual+cantidad)/f.Factor) - math.Ceil(float64(f.Actual)/f.Factor))
+		}
+	}
+	return nil
+}
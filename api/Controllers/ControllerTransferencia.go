@@ -0,0 +1,412 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Estados de una transferencia entre sucursales
+const (
+	TransferenciaBorrador   = "borrador"
+	TransferenciaDespachada = "despachada"
+	TransferenciaEnTransito = "en_transito"
+	TransferenciaRecibida   = "recibida"
+)
+
+// Tipos de movimiento de stock generados por transferencias
+const (
+	MovimientoTransferenciaSalida  = "transferencia_salida"
+	MovimientoTransferenciaEntrada = "transferencia_entrada"
+)
+
+// LineaRecepcion indica la cantidad recibida de un SKU en una recepción (transferencia u orden de compra)
+type LineaRecepcion struct {
+	SKU      string `json:"sku"`
+	Cantidad int    `json:"cantidad"`
+
+	// Solo para productos que manejan lotes en recepciones de compra
+	Lote             string     `json:"lote,omitempty"`
+	FechaFabricacion *time.Time `json:"fecha_fabricacion,omitempty"`
+	FechaVencimiento *time.Time `json:"fecha_vencimiento,omitempty"`
+
+	// Números de serie recibidos, obligatorios para productos serializados
+	Series []string `json:"series,omitempty"`
+}
+
+// StockEnTransito resume las unidades despachadas y aún no recibidas por SKU y sucursal
+type StockEnTransito struct {
+	SKU        string `json:"sku"`
+	OrigenID   uint   `json:"origen_id"`
+	DestinoID  uint   `json:"destino_id"`
+	EnTransito int    `json:"en_transito"`
+}
+
+func GetTransferencias(db *gorm.DB, estado string) ([]modelos.Transferencia, error) {
+	var transferencias []modelos.Transferencia
+	query := db.
+		Preload("Origen").
+		Preload("Destino").
+		Preload("Lineas.Producto").
+		Order("id DESC")
+	if estado != "" {
+		query = query.Where("estado = ?", estado)
+	}
+	if err := query.Find(&transferencias).Error; err != nil {
+		return nil, err
+	}
+	return transferencias, nil
+}
+
+func GetTransferenciaByID(db *gorm.DB, id uint) (*modelos.Transferencia, error) {
+	var transferencia modelos.Transferencia
+	if err := db.
+		Preload("Origen").
+		Preload("Destino").
+		Preload("Lineas.Producto").
+		Preload("Discrepancias").
+		First(&transferencia, id).Error; err != nil {
+		return nil, err
+	}
+	return &transferencia, nil
+}
+
+func validarTransferencia(t *modelos.Transferencia) error {
+	if t.OrigenID == 0 || t.DestinoID == 0 {
+		return errors.New("la sucursal de origen y destino son obligatorias")
+	}
+	if t.OrigenID == t.DestinoID {
+		return errors.New("la sucursal de origen y destino no pueden ser la misma")
+	}
+	if len(t.Lineas) == 0 {
+		return errors.New("la transferencia debe tener al menos una línea")
+	}
+	vistos := make(map[string]bool)
+	for _, l := range t.Lineas {
+		if l.SKU == "" {
+			return errors.New("todas las líneas deben indicar un SKU")
+		}
+		if l.Cantidad <= 0 {
+			return fmt.Errorf("la cantidad del producto %s debe ser mayor a cero", l.SKU)
+		}
+		if vistos[l.SKU] {
+			return fmt.Errorf("el producto %s está repetido en la transferencia", l.SKU)
+		}
+		vistos[l.SKU] = true
+	}
+	return nil
+}
+
+// CreateTransferencia crea una transferencia en estado borrador
+func CreateTransferencia(db *gorm.DB, nueva *modelos.Transferencia) error {
+	if err := validarTransferencia(nueva); err != nil {
+		return err
+	}
+	nueva.ID = 0
+	nueva.Estado = TransferenciaBorrador
+	nueva.FechaCrea = time.Now()
+	nueva.FechaDespacho = nil
+	nueva.FechaRecepcion = nil
+	for i := range nueva.Lineas {
+		nueva.Lineas[i].CantidadRecibida = 0
+	}
+	return db.Omit("Origen", "Destino", "Lineas.Producto").Create(nueva).Error
+}
+
+// UpdateTransferencia reemplaza los datos y líneas de una transferencia que aún está en borrador
+func UpdateTransferencia(db *gorm.DB, id uint, actualizada *modelos.Transferencia) (*modelos.Transferencia, error) {
+	if err := validarTransferencia(actualizada); err != nil {
+		return nil, err
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		existente, err := bloquearTransferencia(tx, id)
+		if err != nil {
+			return err
+		}
+		if existente.Estado != TransferenciaBorrador {
+			return errors.New("solo se pueden modificar transferencias en borrador")
+		}
+
+		if err := tx.Model(existente).Updates(map[string]interface{}{
+			"origen_id":   actualizada.OrigenID,
+			"destino_id":  actualizada.DestinoID,
+			"observacion": actualizada.Observacion,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("transferencia_id = ?", id).Delete(&modelos.TransferenciaLinea{}).Error; err != nil {
+			return err
+		}
+		for _, l := range actualizada.Lineas {
+			linea := modelos.TransferenciaLinea{TransferenciaID: id, SKU: l.SKU, Cantidad: l.Cantidad}
+			if err := tx.Omit("Producto").Create(&linea).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetTransferenciaByID(db, id)
+}
+
+// DeleteTransferencia elimina una transferencia en borrador
+func DeleteTransferencia(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		existente, err := bloquearTransferencia(tx, id)
+		if err != nil {
+			return err
+		}
+		if existente.Estado != TransferenciaBorrador {
+			return errors.New("solo se pueden eliminar transferencias en borrador")
+		}
+		return tx.Delete(existente).Error
+	})
+}
+
+// DespacharTransferencia descuenta el stock de la sucursal de origen; desde aquí las unidades quedan en tránsito
+func DespacharTransferencia(db *gorm.DB, id uint, series map[string][]string, usuario string) (*modelos.Transferencia, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		t, err := bloquearTransferencia(tx, id)
+		if err != nil {
+			return err
+		}
+		if t.Estado != TransferenciaBorrador {
+			return errors.New("solo se pueden despachar transferencias en borrador")
+		}
+
+		for sku := range series {
+			encontrado := false
+			for _, l := range t.Lineas {
+				if l.SKU == sku {
+					encontrado = true
+					break
+				}
+			}
+			if !encontrado {
+				return fmt.Errorf("el producto %s no pertenece a la transferencia", sku)
+			}
+		}
+
+		for _, l := range t.Lineas {
+			mov := modelos.MovimientoStock{
+				SKU:          l.SKU,
+				SucursalID:   t.OrigenID,
+				Tipo:         MovimientoTransferenciaSalida,
+				Cantidad:     -l.Cantidad,
+				Usuario:      usuario,
+				Referencia:   fmt.Sprintf("transferencia #%d", t.ID),
+				NumerosSerie: series[l.SKU],
+			}
+			if err := RegistrarMovimientoStock(tx, &mov); err != nil {
+				return err
+			}
+			// No se puede trasladar stock reservado para cotizaciones
+			if err := verificarReservas(tx, l.SKU, t.OrigenID, mov.SaldoResultante); err != nil {
+				return err
+			}
+		}
+
+		ahora := time.Now()
+		return tx.Model(t).Updates(map[string]interface{}{
+			"estado":         TransferenciaDespachada,
+			"fecha_despacho": ahora,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetTransferenciaByID(db, id)
+}
+
+// MarcarTransferenciaEnTransito indica que la transferencia despachada ya salió hacia el destino
+func MarcarTransferenciaEnTransito(db *gorm.DB, id uint) (*modelos.Transferencia, error) {
+	result := db.Model(&modelos.Transferencia{}).
+		Where("id = ? AND estado = ?", id, TransferenciaDespachada).
+		Update("estado", TransferenciaEnTransito)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("solo se pueden marcar en tránsito transferencias despachadas")
+	}
+	return GetTransferenciaByID(db, id)
+}
+
+// RecibirTransferencia ingresa en la sucursal de destino las cantidades recibidas.
+// Se admiten recepciones parciales, nunca más de lo despachado; al cerrar (o al completar todas
+// las líneas) la transferencia queda recibida y los faltantes se registran como discrepancias.
+func RecibirTransferencia(db *gorm.DB, id uint, recibidas []LineaRecepcion, cerrar bool, motivo, usuario string) (*modelos.Transferencia, error) {
+	var advertencias []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		t, err := bloquearTransferencia(tx, id)
+		if err != nil {
+			return err
+		}
+		if t.Estado != TransferenciaDespachada && t.Estado != TransferenciaEnTransito {
+			return errors.New("solo se pueden recibir transferencias despachadas o en tránsito")
+		}
+
+		lineas := make(map[string]*modelos.TransferenciaLinea)
+		for i := range t.Lineas {
+			lineas[t.Lineas[i].SKU] = &t.Lineas[i]
+		}
+
+		for _, r := range recibidas {
+			linea, ok := lineas[r.SKU]
+			if !ok {
+				return fmt.Errorf("el producto %s no pertenece a la transferencia", r.SKU)
+			}
+			if r.Cantidad <= 0 {
+				return fmt.Errorf("la cantidad recibida del producto %s debe ser mayor a cero", r.SKU)
+			}
+			// No puede llegar más de lo que salió del origen
+			if linea.CantidadRecibida+r.Cantidad > linea.Cantidad {
+				return fmt.Errorf("la cantidad recibida del producto %s supera lo despachado: quedan %d por recibir", r.SKU, linea.Cantidad-linea.CantidadRecibida)
+			}
+
+			if err := asegurarStockSucursal(tx, r.SKU, t.DestinoID); err != nil {
+				return err
+			}
+			lotes, err := lotesRecepcionTransferencia(tx, t, r.SKU, r.Cantidad)
+			if err != nil {
+				return err
+			}
+			// Lo recibido entra al costo con que salió del origen
+			var costo float64
+			if err := tx.Model(&modelos.MovimientoStock{}).
+				Select("COALESCE(MAX(costo_unitario), 0)").
+				Where("tipo = ? AND referencia = ? AND sku = ?", MovimientoTransferenciaSalida, fmt.Sprintf("transferencia #%d", t.ID), r.SKU).
+				Scan(&costo).Error; err != nil {
+				return err
+			}
+			// Solo se pueden recibir las series que salieron con esta transferencia
+			if len(r.Series) > 0 {
+				enTransito, err := seriesEnTransferencia(tx, t, r.SKU)
+				if err != nil {
+					return err
+				}
+				for _, s := range r.Series {
+					if !enTransito[s] {
+						return fmt.Errorf("la serie %s del producto %s no viene en la transferencia", s, r.SKU)
+					}
+				}
+			}
+			if err := RegistrarMovimientoStock(tx, &modelos.MovimientoStock{
+				SKU:           r.SKU,
+				SucursalID:    t.DestinoID,
+				Tipo:          MovimientoTransferenciaEntrada,
+				Cantidad:      r.Cantidad,
+				Usuario:       usuario,
+				Referencia:    fmt.Sprintf("transferencia #%d", t.ID),
+				Lotes:         lotes,
+				NumerosSerie:  r.Series,
+				CostoUnitario: costo,
+			}); err != nil {
+				return err
+			}
+
+			linea.CantidadRecibida += r.Cantidad
+			if err := tx.Model(&modelos.TransferenciaLinea{}).
+				Where("transferencia_id = ? AND sku = ?", t.ID, r.SKU).
+				Update("cantidad_recibida", linea.CantidadRecibida).Error; err != nil {
+				return err
+			}
+		}
+		if len(recibidas) > 0 {
+			if advertencias, err = advertenciasCapacidad(tx, t.DestinoID, nil); err != nil {
+				return err
+			}
+		}
+
+		completa := true
+		for _, l := range t.Lineas {
+			if l.CantidadRecibida != l.Cantidad {
+				completa = false
+				break
+			}
+		}
+		if !cerrar && !completa {
+			return tx.Model(t).Update("estado", TransferenciaEnTransito).Error
+		}
+
+		ahora := time.Now()
+		for _, l := range t.Lineas {
+			if l.CantidadRecibida == l.Cantidad {
+				continue
+			}
+			if err := tx.Create(&modelos.DiscrepanciaTransferencia{
+				TransferenciaID: t.ID,
+				SKU:             l.SKU,
+				Cantidad:        l.CantidadRecibida - l.Cantidad,
+				Motivo:          motivo,
+				Usuario:         usuario,
+				Fecha:           ahora,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(t).Updates(map[string]interface{}{
+			"estado":          TransferenciaRecibida,
+			"fecha_recepcion": ahora,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	t, err := GetTransferenciaByID(db, id)
+	if err != nil {
+		return nil, err
+	}
+	t.Advertencias = advertencias
+	return t, nil
+}
+
+// GetStockEnTransito obtiene las unidades despachadas pendientes de recepción.
+// Si se indica una sucursal, se filtran las transferencias donde es origen o destino.
+func GetStockEnTransito(db *gorm.DB, sucursalID uint) ([]StockEnTransito, error) {
+	var resultado []StockEnTransito
+	query := db.Table("transferencia_linea AS l").
+		Select("l.sku, t.origen_id, t.destino_id, SUM(l.cantidad - l.cantidad_recibida) AS en_transito").
+		Joins("JOIN transferencias t ON t.id = l.transferencia_id").
+		Where("t.estado IN ?", []string{TransferenciaDespachada, TransferenciaEnTransito}).
+		Group("l.sku, t.origen_id, t.destino_id").
+		Having("SUM(l.cantidad - l.cantidad_recibida) > 0")
+	if sucursalID != 0 {
+		query = query.Where("(t.origen_id = ? OR t.destino_id = ?)", sucursalID, sucursalID)
+	}
+	if err := query.Scan(&resultado).Error; err != nil {
+		return nil, err
+	}
+	return resultado, nil
+}
+
+// bloquearTransferencia carga la transferencia con sus líneas bloqueando la fila para la transacción
+func bloquearTransferencia(tx *gorm.DB, id uint) (*modelos.Transferencia, error) {
+	var t modelos.Transferencia
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&t, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("transferencia no encontrada")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Where("transferencia_id = ?", id).Find(&t.Lineas).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// asegurarStockSucursal crea el registro de stock en cero si el SKU aún no existe en la sucursal
+func asegurarStockSucursal(tx *gorm.DB, sku string, sucursalID uint) error {
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Omit("Producto", "Sucursal").
+		Create(&modelos.StockSucursal{SKU: sku, SucursalID: sucursalID}).Error
+}
@@ -0,0 +1,439 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UbicacionRecepcion es el código del área de recepción que toda sucursal tiene: ahí entra lo
+// recibido sin ubicación indicada y el stock que existía antes de manejar ubicaciones
+const UbicacionRecepcion = "RECEPCION"
+
+// Tipos de traslado dentro de una sucursal
+const (
+	TrasladoUbicado = "ubicado"  // desde recepción a su ubicación de almacenaje
+	TrasladoInterno = "traslado" // entre dos ubicaciones
+)
+
+// GetUbicaciones lista las ubicaciones de una sucursal ordenadas por código, opcionalmente de una zona
+func GetUbicaciones(db *gorm.DB, sucursalID uint, zona string, soloActivas bool) ([]modelos.Ubicacion, error) {
+	var ubicaciones []modelos.Ubicacion
+	query := db.Order("sucursal_id, codigo")
+	if sucursalID != 0 {
+		query = query.Where("sucursal_id = ?", sucursalID)
+	}
+	if zona != "" {
+		query = query.Where("zona = ?", strings.ToUpper(zona))
+	}
+	if soloActivas {
+		query = query.Where("activa = ?", true)
+	}
+	if err := query.Find(&ubicaciones).Error; err != nil {
+		return nil, err
+	}
+	return ubicaciones, nil
+}
+
+// GetUbicacionByID obtiene una ubicación
+func GetUbicacionByID(db *gorm.DB, id uint) (*modelos.Ubicacion, error) {
+	var ubicacion modelos.Ubicacion
+	if err := db.First(&ubicacion, id).Error; err != nil {
+		return nil, err
+	}
+	return &ubicacion, nil
+}
+
+// CreateUbicacion crea una ubicación en una sucursal
+func CreateUbicacion(db *gorm.DB, ubicacion *modelos.Ubicacion) error {
+	var sucursal modelos.Sucursal
+	if err := db.Select("id").First(&sucursal, ubicacion.SucursalID).Error; err != nil {
+		return errors.New("sucursal no encontrada")
+	}
+	ubicacion.ID = 0
+	ubicacion.Recepcion = false
+	ubicacion.Activa = true
+	if err := normalizarUbicacion(db, ubicacion); err != nil {
+		return err
+	}
+	return db.Omit("Sucursal").Create(ubicacion).Error
+}
+
+// UpdateUbicacion cambia las partes del código de una ubicación o la activa y desactiva. Una
+// ubicación con stock no se puede desactivar
+func UpdateUbicacion(db *gorm.DB, id uint, actualizada *modelos.Ubicacion) (*modelos.Ubicacion, error) {
+	var existente modelos.Ubicacion
+	if err := db.First(&existente, id).Error; err != nil {
+		return nil, errors.New("ubicación no encontrada")
+	}
+	if existente.Recepcion {
+		return nil, errors.New("el área de recepción no se puede modificar")
+	}
+	actualizada.ID = existente.ID
+	actualizada.SucursalID = existente.SucursalID
+	actualizada.Recepcion = false
+	if err := normalizarUbicacion(db, actualizada); err != nil {
+		return nil, err
+	}
+	if existente.Activa && !actualizada.Activa {
+		enUbicacion, err := stockEnUbicacion(db, id)
+		if err != nil {
+			return nil, err
+		}
+		if enUbicacion > 0 {
+			return nil, fmt.Errorf("la ubicación %s tiene %d unidades; trasládelas antes de desactivarla", existente.Codigo, enUbicacion)
+		}
+	}
+	if err := db.Model(&existente).Select("codigo", "zona", "pasillo", "rack", "nivel", "activa").Updates(actualizada).Error; err != nil {
+		return nil, err
+	}
+	return GetUbicacionByID(db, id)
+}
+
+// DeleteUbicacion elimina una ubicación sin stock ni historial de movimientos; las que ya se
+// usaron se desactivan
+func DeleteUbicacion(db *gorm.DB, id uint) error {
+	var ubicacion modelos.Ubicacion
+	if err := db.First(&ubicacion, id).Error; err != nil {
+		return errors.New("ubicación no encontrada")
+	}
+	if ubicacion.Recepcion {
+		return errors.New("el área de recepción no se puede eliminar")
+	}
+	var usos int64
+	if err := db.Model(&modelos.MovimientoUbicacion{}).Where("ubicacion_id = ?", id).Count(&usos).Error; err != nil {
+		return err
+	}
+	if usos == 0 {
+		if err := db.Model(&modelos.TrasladoUbicacion{}).Where("origen_id = ? OR destino_id = ?", id, id).Count(&usos).Error; err != nil {
+			return err
+		}
+	}
+	if usos > 0 {
+		return fmt.Errorf("la ubicación %s tiene movimientos registrados; desactívela en lugar de eliminarla", ubicacion.Codigo)
+	}
+	enUbicacion, err := stockEnUbicacion(db, id)
+	if err != nil {
+		return err
+	}
+	if enUbicacion > 0 {
+		return fmt.Errorf("la ubicación %s tiene stock", ubicacion.Codigo)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("ubicacion_id = ?", id).Delete(&modelos.StockUbicacion{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&ubicacion).Error
+	})
+}
+
+// normalizarUbicacion pasa a mayúsculas las partes de la ubicación y arma su código
+func normalizarUbicacion(db *gorm.DB, u *modelos.Ubicacion) error {
+	u.Zona = strings.ToUpper(strings.TrimSpace(u.Zona))
+	u.Pasillo = strings.ToUpper(strings.TrimSpace(u.Pasillo))
+	u.Rack = strings.ToUpper(strings.TrimSpace(u.Rack))
+	u.Nivel = strings.ToUpper(strings.TrimSpace(u.Nivel))
+	if u.Zona == "" {
+		return errors.New("la zona es obligatoria")
+	}
+	// Las partes son jerárquicas: no hay rack sin pasillo ni nivel sin rack
+	if (u.Rack != "" && u.Pasillo == "") || (u.Nivel != "" && u.Rack == "") {
+		return errors.New("la ubicación debe indicar zona, pasillo, rack y nivel en ese orden")
+	}
+	partes := []string{u.Zona}
+	for _, p := range []string{u.Pasillo, u.Rack, u.Nivel} {
+		if p != "" {
+			partes = append(partes, p)
+		}
+	}
+	u.Codigo = strings.Join(partes, "-")
+	if u.Codigo == UbicacionRecepcion {
+		return fmt.Errorf("el código %s está reservado para el área de recepción", UbicacionRecepcion)
+	}
+
+	var repetidas int64
+	if err := db.Model(&modelos.Ubicacion{}).
+		Where("sucursal_id = ? AND codigo = ? AND id <> ?", u.SucursalID, u.Codigo, u.ID).
+		Count(&repetidas).Error; err != nil {
+		return err
+	}
+	if repetidas > 0 {
+		return fmt.Errorf("ya existe la ubicación %s en la sucursal", u.Codigo)
+	}
+	return nil
+}
+
+func stockEnUbicacion(db *gorm.DB, ubicacionID uint) (int, error) {
+	var cantidad int
+	err := db.Model(&modelos.StockUbicacion{}).
+		Select("COALESCE(SUM(cantidad), 0)").
+		Where("ubicacion_id = ?", ubicacionID).
+		Scan(&cantidad).Error
+	return cantidad, err
+}
+
+// GetStockUbicaciones lista dónde está el stock: por SKU, sucursal y/o ubicación. Solo incluye
+// las ubicaciones con unidades
+func GetStockUbicaciones(db *gorm.DB, sku string, sucursalID, ubicacionID uint) ([]modelos.StockUbicacion, error) {
+	var stock []modelos.StockUbicacion
+	query := db.Preload("Ubicacion").
+		Joins("JOIN ubicaciones u ON u.id = stock_ubicacion.ubicacion_id").
+		Where("stock_ubicacion.cantidad > 0").
+		Order("stock_ubicacion.sucursal_id, u.codigo, stock_ubicacion.sku")
+	if sku != "" {
+		query = query.Where("stock_ubicacion.sku = ?", sku)
+	}
+	if sucursalID != 0 {
+		query = query.Where("stock_ubicacion.sucursal_id = ?", sucursalID)
+	}
+	if ubicacionID != 0 {
+		query = query.Where("stock_ubicacion.ubicacion_id = ?", ubicacionID)
+	}
+	if err := query.Find(&stock).Error; err != nil {
+		return nil, err
+	}
+	return stock, nil
+}
+
+// GetTrasladosUbicacion lista los traslados internos, del más reciente al más antiguo
+func GetTrasladosUbicacion(db *gorm.DB, sucursalID uint, sku string) ([]modelos.TrasladoUbicacion, error) {
+	var traslados []modelos.TrasladoUbicacion
+	query := db.Preload("Origen").Preload("Destino").Order("fecha DESC, id DESC")
+	if sucursalID != 0 {
+		query = query.Where("sucursal_id = ?", sucursalID)
+	}
+	if sku != "" {
+		query = query.Where("sku = ?", sku)
+	}
+	if err := query.Find(&traslados).Error; err != nil {
+		return nil, err
+	}
+	return traslados, nil
+}
+
+// TrasladarStock mueve unidades de un SKU entre dos ubicaciones de la misma sucursal. Sin origen
+// se toman del área de recepción (ubicado de lo recibido). El stock de la sucursal no cambia
+func TrasladarStock(db *gorm.DB, traslado *modelos.TrasladoUbicacion) error {
+	if traslado.Cantidad <= 0 {
+		return errors.New("la cantidad a trasladar debe ser mayor que cero")
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		// El bloqueo del stock de la sucursal ordena los traslados con los movimientos del SKU
+		var stock modelos.StockSucursal
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("sku = ? AND sucursal_id = ?", traslado.SKU, traslado.SucursalID).
+			First(&stock).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("no existe stock del producto %s en la sucursal %d", traslado.SKU, traslado.SucursalID)
+		}
+		if err != nil {
+			return err
+		}
+		if err := cuadrarUbicaciones(tx, traslado.SKU, traslado.SucursalID, stock.Cantidad); err != nil {
+			return err
+		}
+
+		var origen *modelos.Ubicacion
+		if traslado.OrigenID == 0 {
+			origen, err = ubicacionRecepcion(tx, traslado.SucursalID)
+		} else {
+			origen, err = ubicacionDeSucursal(tx, traslado.OrigenID, traslado.SucursalID, false)
+		}
+		if err != nil {
+			return err
+		}
+		destino, err := ubicacionDeSucursal(tx, traslado.DestinoID, traslado.SucursalID, true)
+		if err != nil {
+			return err
+		}
+		if origen.ID == destino.ID {
+			return errors.New("el origen y el destino del traslado son la misma ubicación")
+		}
+
+		if err := moverStockUbicacion(tx, traslado.SKU, traslado.SucursalID, origen.ID, -traslado.Cantidad); err != nil {
+			return fmt.Errorf("stock insuficiente del producto %s en la ubicación %s", traslado.SKU, origen.Codigo)
+		}
+		if err := moverStockUbicacion(tx, traslado.SKU, traslado.SucursalID, destino.ID, traslado.Cantidad); err != nil {
+			return err
+		}
+
+		traslado.ID = 0
+		traslado.OrigenID = origen.ID
+		traslado.Tipo = TrasladoInterno
+		if origen.Recepcion {
+			traslado.Tipo = TrasladoUbicado
+		}
+		traslado.Fecha = time.Now()
+		if err := tx.Omit("Origen", "Destino").Create(traslado).Error; err != nil {
+			return err
+		}
+		traslado.Origen = *origen
+		traslado.Destino = *destino
+		return nil
+	})
+}
+
+// ubicacionDeSucursal carga una ubicación comprobando que pertenezca a la sucursal. Para recibir
+// mercadería además debe estar activa
+func ubicacionDeSucursal(tx *gorm.DB, id, sucursalID uint, entrada bool) (*modelos.Ubicacion, error) {
+	var ubicacion modelos.Ubicacion
+	if err := tx.Where("id = ? AND sucursal_id = ?", id, sucursalID).First(&ubicacion).Error; err != nil {
+		return nil, fmt.Errorf("la ubicación %d no pertenece a la sucursal %d", id, sucursalID)
+	}
+	if entrada && !ubicacion.Activa {
+		return nil, fmt.Errorf("la ubicación %s está inactiva", ubicacion.Codigo)
+	}
+	return &ubicacion, nil
+}
+
+// ubicacionRecepcion obtiene el área de recepción de la sucursal, creándola si no existe
+func ubicacionRecepcion(tx *gorm.DB, sucursalID uint) (*modelos.Ubicacion, error) {
+	nueva := modelos.Ubicacion{
+		SucursalID: sucursalID,
+		Codigo:     UbicacionRecepcion,
+		Zona:       UbicacionRecepcion,
+		Recepcion:  true,
+		Activa:     true,
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Omit("Sucursal").Create(&nueva).Error; err != nil {
+		return nil, err
+	}
+	var ubicacion modelos.Ubicacion
+	if err := tx.Where("sucursal_id = ? AND codigo = ?", sucursalID, UbicacionRecepcion).First(&ubicacion).Error; err != nil {
+		return nil, err
+	}
+	return &ubicacion, nil
+}
+
+// moverStockUbicacion suma (o resta) unidades de un SKU en una ubicación. La resta es condicional
+// para que el stock de la ubicación nunca quede bajo cero
+func moverStockUbicacion(tx *gorm.DB, sku string, sucursalID, ubicacionID uint, cantidad int) error {
+	if cantidad > 0 {
+		return tx.Omit("Producto", "Ubicacion").Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "sku"}, {Name: "ubicacion_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"cantidad": gorm.Expr("stock_ubicacion.cantidad + ?", cantidad)}),
+		}).Create(&modelos.StockUbicacion{
+			SKU:         sku,
+			UbicacionID: ubicacionID,
+			SucursalID:  sucursalID,
+			Cantidad:    cantidad,
+		}).Error
+	}
+	result := tx.Model(&modelos.StockUbicacion{}).
+		Where("sku = ? AND ubicacion_id = ? AND cantidad + ? >= 0", sku, ubicacionID, cantidad).
+		Update("cantidad", gorm.Expr("cantidad + ?", cantidad))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("stock insuficiente del producto %s en la ubicación %d", sku, ubicacionID)
+	}
+	return nil
+}
+
+// cuadrarUbicaciones lleva al área de recepción el stock que no está en ninguna ubicación, por
+// ejemplo el que existía antes de manejar ubicaciones
+func cuadrarUbicaciones(tx *gorm.DB, sku string, sucursalID uint, stock int) error {
+	var ubicado int
+	if err := tx.Model(&modelos.StockUbicacion{}).
+		Select("COALESCE(SUM(cantidad), 0)").
+		Where("sku = ? AND sucursal_id = ?", sku, sucursalID).
+		Scan(&ubicado).Error; err != nil {
+		return err
+	}
+	if ubicado >= stock {
+		return nil
+	}
+	recepcion, err := ubicacionRecepcion(tx, sucursalID)
+	if err != nil {
+		return err
+	}
+	return moverStockUbicacion(tx, sku, sucursalID, recepcion.ID, stock-ubicado)
+}
+
+// aplicarUbicaciones reparte un movimiento entre las ubicaciones de la sucursal y deja el detalle
+// en mov.Ubicaciones. Si el movimiento ya trae el reparto (al anular un despacho) se respeta; las
+// entradas van a la ubicación indicada o a recepción, y las salidas toman de la ubicación indicada
+// o recorren las ubicaciones de almacenaje por código, dejando recepción para el final
+func aplicarUbicaciones(tx *gorm.DB, mov *modelos.MovimientoStock, stockAnterior int) error {
+	if err := cuadrarUbicaciones(tx, mov.SKU, mov.SucursalID, stockAnterior); err != nil {
+		return err
+	}
+
+	switch {
+	case len(mov.Ubicaciones) > 0:
+		total := 0
+		for _, u := range mov.Ubicaciones {
+			if _, err := ubicacionDeSucursal(tx, u.UbicacionID, mov.SucursalID, false); err != nil {
+				return err
+			}
+			if err := moverStockUbicacion(tx, mov.SKU, mov.SucursalID, u.UbicacionID, u.Cantidad); err != nil {
+				return err
+			}
+			total += u.Cantidad
+		}
+		if total != mov.Cantidad {
+			return fmt.Errorf("el reparto por ubicaciones del producto %s no coincide con la cantidad del movimiento", mov.SKU)
+		}
+		return nil
+
+	case mov.Cantidad > 0:
+		var destino *modelos.Ubicacion
+		var err error
+		if mov.UbicacionID != nil {
+			destino, err = ubicacionDeSucursal(tx, *mov.UbicacionID, mov.SucursalID, true)
+		} else {
+			destino, err = ubicacionRecepcion(tx, mov.SucursalID)
+		}
+		if err != nil {
+			return err
+		}
+		if err := moverStockUbicacion(tx, mov.SKU, mov.SucursalID, destino.ID, mov.Cantidad); err != nil {
+			return err
+		}
+		mov.Ubicaciones = []modelos.MovimientoUbicacion{{UbicacionID: destino.ID, Cantidad: mov.Cantidad}}
+		return nil
+
+	default:
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "stock_ubicacion"}}).
+			Select("stock_ubicacion.*").
+			Joins("JOIN ubicaciones u ON u.id = stock_ubicacion.ubicacion_id").
+			Where("stock_ubicacion.sku = ? AND stock_ubicacion.sucursal_id = ? AND stock_ubicacion.cantidad > 0", mov.SKU, mov.SucursalID)
+		if mov.UbicacionID != nil {
+			query = query.Where("stock_ubicacion.ubicacion_id = ?", *mov.UbicacionID)
+		}
+		var ubicaciones []modelos.StockUbicacion
+		if err := query.Order("u.recepcion ASC, u.codigo ASC").Find(&ubicaciones).Error; err != nil {
+			return err
+		}
+
+		restante := -mov.Cantidad
+		mov.Ubicaciones = nil
+		for _, u := range ubicaciones {
+			if restante == 0 {
+				break
+			}
+			tomar := u.Cantidad
+			if tomar > restante {
+				tomar = restante
+			}
+			if err := moverStockUbicacion(tx, mov.SKU, mov.SucursalID, u.UbicacionID, -tomar); err != nil {
+				return err
+			}
+			mov.Ubicaciones = append(mov.Ubicaciones, modelos.MovimientoUbicacion{UbicacionID: u.UbicacionID, Cantidad: -tomar})
+			restante -= tomar
+		}
+		if restante > 0 {
+			if mov.UbicacionID != nil {
+				return fmt.Errorf("stock insuficiente del producto %s en la ubicación indicada", mov.SKU)
+			}
+			return fmt.Errorf("stock por ubicaciones insuficiente para el producto %s en la sucursal %d", mov.SKU, mov.SucursalID)
+		}
+		return nil
+	}
+}
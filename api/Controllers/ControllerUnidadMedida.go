@@ -0,0 +1,306 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Equivalencia expresa una cantidad en unidades base en otra unidad del producto
+type Equivalencia struct {
+	Unidad   string  `json:"unidad"`
+	Cantidad float64 `json:"cantidad"`
+}
+
+// Conversion es el resultado de convertir una cantidad entre dos unidades del mismo producto
+type Conversion struct {
+	SKU             string  `json:"sku"`
+	Cantidad        float64 `json:"cantidad"`
+	De              string  `json:"de"`
+	A               string  `json:"a"`
+	Resultado       float64 `json:"resultado"`
+	CantidadBase    float64 `json:"cantidad_base"`
+	UnidadBase      string  `json:"unidad_base"`
+	UnidadesEnteras int     `json:"unidades_enteras"` // unidades base enteras necesarias para cubrir la cantidad
+}
+
+// GetUnidadesProducto lista las unidades alternativas de un producto, de la menor a la mayor
+func GetUnidadesProducto(db *gorm.DB, sku string) ([]modelos.ProductoUnidad, error) {
+	var unidades []modelos.ProductoUnidad
+	if err := db.Where("sku = ?", sku).Order("factor ASC").Find(&unidades).Error; err != nil {
+		return nil, err
+	}
+	return unidades, nil
+}
+
+// CreateUnidadProducto agrega una unidad alternativa a un producto
+func CreateUnidadProducto(db *gorm.DB, sku string, unidad *modelos.ProductoUnidad) error {
+	var producto modelos.Producto
+	if err := db.Select("sku", "unidad_base").First(&producto, "sku = ?", sku).Error; err != nil {
+		return errors.New("producto no encontrado")
+	}
+	unidad.ID = 0
+	unidad.SKU = sku
+	if err := normalizarUnidad(&producto, unidad); err != nil {
+		return err
+	}
+	return db.Create(unidad).Error
+}
+
+// UpdateUnidadProducto modifica el factor, nombre o medidas de una unidad
+func UpdateUnidadProducto(db *gorm.DB, sku string, id uint, actualizada *modelos.ProductoUnidad) (*modelos.ProductoUnidad, error) {
+	var producto modelos.Producto
+	if err := db.Select("sku", "unidad_base").First(&producto, "sku = ?", sku).Error; err != nil {
+		return nil, errors.New("producto no encontrado")
+	}
+	var existente modelos.ProductoUnidad
+	if err := db.Where("id = ? AND sku = ?", id, sku).First(&existente).Error; err != nil {
+		return nil, errors.New("unidad no encontrada")
+	}
+	actualizada.ID = existente.ID
+	actualizada.SKU = sku
+	if err := normalizarUnidad(&producto, actualizada); err != nil {
+		return nil, err
+	}
+	if err := db.Save(actualizada).Error; err != nil {
+		return nil, err
+	}
+	return actualizada, nil
+}
+
+// DeleteUnidadProducto elimina una unidad alternativa
+func DeleteUnidadProducto(db *gorm.DB, sku string, id uint) error {
+	result := db.Where("id = ? AND sku = ?", id, sku).Delete(&modelos.ProductoUnidad{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("unidad no encontrada")
+	}
+	return nil
+}
+
+// normalizarUnidad valida una unidad antes de guardarla. Los embalajes deben contener un número
+// entero de unidades base, para que la planificación de carga pueda armar bultos completos
+func normalizarUnidad(producto *modelos.Producto, unidad *modelos.ProductoUnidad) error {
+	unidad.Codigo = strings.ToUpper(strings.TrimSpace(unidad.Codigo))
+	if unidad.Codigo == "" {
+		return errors.New("el código de la unidad es obligatorio")
+	}
+	base := producto.UnidadBase
+	if base == "" {
+		base = "UN"
+	}
+	if unidad.Codigo == strings.ToUpper(base) {
+		return fmt.Errorf("%s es la unidad base del producto", unidad.Codigo)
+	}
+	if unidad.Factor <= 0 {
+		return errors.New("el factor de conversión debe ser mayor que cero")
+	}
+	if unidad.Nombre == "" {
+		unidad.Nombre = unidad.Codigo
+	}
+	if unidad.Embalaje {
+		if unidad.Factor < 1 || unidad.Factor != math.Trunc(unidad.Factor) {
+			return errors.New("un embalaje debe contener un número entero de unidades base")
+		}
+		if unidad.Peso <= 0 || unidad.Largo <= 0 || unidad.Ancho <= 0 || unidad.Alto <= 0 {
+			return errors.New("un embalaje debe tener peso y dimensiones")
+		}
+	}
+	return nil
+}
+
+// factorUnidad devuelve cuántas unidades base contiene la unidad indicada. La unidad vacía o la
+// unidad base del producto tienen factor 1
+func factorUnidad(db *gorm.DB, sku, codigo string) (float64, error) {
+	codigo = strings.ToUpper(strings.TrimSpace(codigo))
+	if codigo == "" {
+		return 1, nil
+	}
+	var producto modelos.Producto
+	if err := db.Select("sku", "unidad_base").First(&producto, "sku = ?", sku).Error; err != nil {
+		return 0, fmt.Errorf("producto %s no encontrado", sku)
+	}
+	if codigo == strings.ToUpper(producto.UnidadBase) {
+		return 1, nil
+	}
+	var unidad modelos.ProductoUnidad
+	if err := db.Where("sku = ? AND codigo = ?", sku, codigo).First(&unidad).Error; err != nil {
+		return 0, fmt.Errorf("el producto %s no tiene la unidad %s", sku, codigo)
+	}
+	return unidad.Factor, nil
+}
+
+// cantidadBase convierte una cantidad expresada en otra unidad a unidades base. En ventas se
+// redondea hacia arriba (10 m² de cerámica son las cajas que cubran 10 m²); en movimientos de
+// stock la conversión debe ser exacta
+func cantidadBase(db *gorm.DB, sku, codigo string, cantidad float64, exacta bool) (int, error) {
+	factor, err := factorUnidad(db, sku, codigo)
+	if err != nil {
+		return 0, err
+	}
+	// Se redondea a 4 decimales antes de comparar, por el factor guardado como numeric(12,4)
+	base := math.Round(cantidad*factor*10000) / 10000
+	if exacta && base != math.Trunc(base) {
+		return 0, fmt.Errorf("%g %s del producto %s no corresponden a un número entero de unidades base", cantidad, codigo, sku)
+	}
+	return int(math.Ceil(base)), nil
+}
+
+// ConvertirCantidad convierte una cantidad entre dos unidades del mismo producto (vacío es la base)
+func ConvertirCantidad(db *gorm.DB, sku string, cantidad float64, de, a string) (*Conversion, error) {
+	var producto modelos.Producto
+	if err := db.Select("sku", "unidad_base").First(&producto, "sku = ?", sku).Error; err != nil {
+		return nil, errors.New("producto no encontrado")
+	}
+	if de == "" {
+		de = producto.UnidadBase
+	}
+	if a == "" {
+		a = producto.UnidadBase
+	}
+	factorDe, err := factorUnidad(db, sku, de)
+	if err != nil {
+		return nil, err
+	}
+	factorA, err := factorUnidad(db, sku, a)
+	if err != nil {
+		return nil, err
+	}
+
+	base := cantidad * factorDe
+	return &Conversion{
+		SKU:             sku,
+		Cantidad:        cantidad,
+		De:              strings.ToUpper(de),
+		A:               strings.ToUpper(a),
+		Resultado:       math.Round(base/factorA*10000) / 10000,
+		CantidadBase:    math.Round(base*10000) / 10000,
+		UnidadBase:      producto.UnidadBase,
+		UnidadesEnteras: int(math.Ceil(math.Round(base*10000) / 10000)),
+	}, nil
+}
+
+// unidadesPorSKU carga de una vez las unidades alternativas de varios productos
+func unidadesPorSKU(db *gorm.DB, skus []string) (map[string][]modelos.ProductoUnidad, error) {
+	resultado := make(map[string][]modelos.ProductoUnidad)
+	if len(skus) == 0 {
+		return resultado, nil
+	}
+	var unidades []modelos.ProductoUnidad
+	if err := db.Where("sku IN ?", skus).Order("factor ASC").Find(&unidades).Error; err != nil {
+		return nil, err
+	}
+	for _, u := range unidades {
+		resultado[u.SKU] = append(resultado[u.SKU], u)
+	}
+	return resultado, nil
+}
+
+// equivalencias expresa una cantidad en unidades base en cada unidad alternativa del producto
+func equivalencias(unidades []modelos.ProductoUnidad, cantidad int) []Equivalencia {
+	if len(unidades) == 0 {
+		return nil
+	}
+	resultado := make([]Equivalencia, 0, len(unidades))
+	for _, u := range unidades {
+		resultado = append(resultado, Equivalencia{
+			Unidad:   u.Codigo,
+			Cantidad: math.Round(float64(cantidad)/u.Factor*10000) / 10000,
+		})
+	}
+	return resultado
+}
+
+// desglosarEmbalajes arma los bultos físicos de un ítem para la planificación de carga: primero
+// los embalajes más grandes completos (pallets, luego cajas) y el resto como unidades sueltas
+func desglosarEmbalajes(producto modelos.Producto, unidades []modelos.ProductoUnidad, cantidad int, sucursalID uint) []Unidad {
+	embalajes := make([]modelos.ProductoUnidad, 0, len(unidades))
+	for _, u := range unidades {
+		if u.Embalaje && u.Factor >= 1 {
+			embalajes = append(embalajes, u)
+		}
+	}
+	sort.Slice(embalajes, func(i, j int) bool {
+		return embalajes[i].Factor > embalajes[j].Factor
+	})
+
+	var bultos []Unidad
+	resto := cantidad
+	for _, e := range embalajes {
+		factor := int(e.Factor)
+		vol := e.Largo * e.Ancho * e.Alto / 1_000_000
+		for ; resto >= factor; resto -= factor {
+			bultos = append(bultos, Unidad{
+				SKU:        producto.SKU,
+				Peso:       e.Peso,
+				Volumen:    vol,
+				SucursalID: sucursalID,
+				Cantidad:   factor,
+			})
+		}
+	}
+
+	vol := producto.Largo * producto.Ancho * producto.Alto / 1_000_000
+	for i := 0; i < resto; i++ {
+		bultos = append(bultos, Unidad{
+			SKU:        producto.SKU,
+			Peso:       producto.Peso,
+			Volumen:    vol,
+			SucursalID: sucursalID,
+			Cantidad:   1,
+		})
+	}
+	return bultos
+}
+
+// normalizarItemsCotizacion recalcula la cantidad en unidades base de los ítems cotizados en otra
+// unidad y la guarda si cambió, para que reservas y despachos trabajen siempre en la base
+func normalizarItemsCotizacion(tx *gorm.DB, items []modelos.CotizacionItem) error {
+	cambiados, err := cantidadesBaseItems(tx, items)
+	if err != nil {
+		return err
+	}
+	return guardarCantidadesItems(tx, items, cambiados)
+}
+
+// cantidadesBaseItems recalcula en memoria la cantidad en unidades base de los ítems cotizados en
+// otra unidad, sin guardarla. Devuelve las posiciones de los ítems que cambiaron
+func cantidadesBaseItems(db *gorm.DB, items []modelos.CotizacionItem) ([]int, error) {
+	var cambiados []int
+	for i := range items {
+		item := &items[i]
+		if item.Unidad == "" || item.CantidadUnidad <= 0 {
+			continue
+		}
+		cantidad, err := cantidadBase(db, item.ProductoID, item.Unidad, item.CantidadUnidad, false)
+		if err != nil {
+			return nil, err
+		}
+		if cantidad == item.Cantidad {
+			continue
+		}
+		item.Cantidad = cantidad
+		cambiados = append(cambiados, i)
+	}
+	return cambiados, nil
+}
+
+// guardarCantidadesItems guarda la cantidad base de los ítems indicados
+func guardarCantidadesItems(tx *gorm.DB, items []modelos.CotizacionItem, cambiados []int) error {
+	for _, i := range cambiados {
+		item := items[i]
+		if err := tx.Model(&modelos.CotizacionItem{}).
+			Where("cotizacion_id = ? AND producto_id = ? AND sucursal_id = ?", item.CotizacionID, item.ProductoID, item.SucursalID).
+			Update("cantidad", item.Cantidad).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
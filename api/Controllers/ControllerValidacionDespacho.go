@@ -0,0 +1,177 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrStockInsuficiente indica que alguna línea del despacho no tiene stock suficiente y no se registró nada
+var ErrStockInsuficiente = errors.New("stock insuficiente para el despacho; no se registró ningún cambio")
+
+// StockDespacho compara lo que pide un despacho de un SKU con lo que puede salir de la sucursal de
+// origen. Los kits se informan como sus componentes
+type StockDespacho struct {
+	SKU        string `json:"sku"`
+	Nombre     string `json:"nombre"`
+	SucursalID uint   `json:"sucursal_id"`
+	Solicitado int    `json:"solicitado"`
+	Disponible int    `json:"disponible"`
+	Faltante   int    `json:"faltante"`
+}
+
+// ValidacionDespacho es el resultado de revisar el stock de todas las líneas de un despacho
+type ValidacionDespacho struct {
+	Valido    bool            `json:"valido"`
+	Productos []StockDespacho `json:"productos"`
+	Faltantes []StockDespacho `json:"faltantes"`
+}
+
+// ValidarDespacho revisa, sin registrar nada, si la sucursal de origen puede cubrir todas las
+// líneas del despacho
+func ValidarDespacho(db *gorm.DB, despacho *modelos.Despacho, productos []modelos.ProductosDespacho) (*ValidacionDespacho, error) {
+	var validacion *ValidacionDespacho
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := prepararLineasDespacho(tx, productos); err != nil {
+			return err
+		}
+		var err error
+		validacion, err = validarStockDespacho(tx, despacho, productos)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return validacion, nil
+}
+
+// prepararLineasDespacho pasa a unidades base las líneas pedidas en otra unidad y revisa que las
+// cantidades sean positivas y que cada SKU venga una sola vez
+func prepararLineasDespacho(tx *gorm.DB, productos []modelos.ProductosDespacho) error {
+	if len(productos) == 0 {
+		return errors.New("el despacho no tiene productos")
+	}
+	vistos := make(map[string]bool, len(productos))
+	for i := range productos {
+		p := &productos[i]
+		if vistos[p.ProductoID] {
+			return fmt.Errorf("el producto %s está repetido en el despacho", p.ProductoID)
+		}
+		vistos[p.ProductoID] = true
+
+		// Líneas pedidas en otra unidad (pallet, caja, m²) se despachan en unidades base enteras
+		if p.Unidad != "" {
+			cantidad, err := cantidadBase(tx, p.ProductoID, p.Unidad, p.CantidadUnidad, false)
+			if err != nil {
+				return err
+			}
+			p.Cantidad = cantidad
+		}
+		if p.Cantidad <= 0 {
+			return fmt.Errorf("la cantidad del producto %s debe ser mayor que cero", p.ProductoID)
+		}
+	}
+	return nil
+}
+
+// validarStockDespacho bloquea el stock de cada SKU involucrado y lo compara con lo pedido. Lo
+// disponible es el stock en mano menos lo reservado por otras cotizaciones, sin contar lotes vencidos
+func validarStockDespacho(tx *gorm.DB, despacho *modelos.Despacho, productos []modelos.ProductosDespacho) (*ValidacionDespacho, error) {
+	if despacho.Origen == 0 {
+		return nil, errors.New("debe indicar la sucursal de origen del despacho")
+	}
+	lineas := make([]lineaStock, 0, len(productos))
+	for _, p := range productos {
+		lineas = append(lineas, lineaStock{claveStock{SKU: p.ProductoID, SucursalID: despacho.Origen}, p.Cantidad})
+	}
+	orden, requerido, err := stockRequerido(tx, lineas)
+	if err != nil {
+		return nil, err
+	}
+
+	skus := make([]string, 0, len(orden))
+	for _, clave := range orden {
+		skus = append(skus, clave.SKU)
+	}
+	var lista []modelos.Producto
+	if err := tx.Select("sku", "nombre", "maneja_lotes").Where("sku IN ?", skus).Find(&lista).Error; err != nil {
+		return nil, err
+	}
+	porSKU := make(map[string]modelos.Producto, len(lista))
+	for _, p := range lista {
+		porSKU[p.SKU] = p
+	}
+
+	validacion := &ValidacionDespacho{Valido: true, Productos: []StockDespacho{}, Faltantes: []StockDespacho{}}
+	for _, clave := range orden {
+		producto, ok := porSKU[clave.SKU]
+		if !ok {
+			return nil, fmt.Errorf("producto %s no encontrado", clave.SKU)
+		}
+		disponible, err := stockDisponibleDespacho(tx, &producto, clave.SucursalID, despacho.CotizacionID)
+		if err != nil {
+			return nil, err
+		}
+		linea := StockDespacho{
+			SKU:        clave.SKU,
+			Nombre:     producto.Nombre,
+			SucursalID: clave.SucursalID,
+			Solicitado: requerido[clave],
+			Disponible: disponible,
+		}
+		if linea.Solicitado > linea.Disponible {
+			linea.Faltante = linea.Solicitado - linea.Disponible
+			validacion.Valido = false
+			validacion.Faltantes = append(validacion.Faltantes, linea)
+		}
+		validacion.Productos = append(validacion.Productos, linea)
+	}
+	return validacion, nil
+}
+
+// stockDisponibleDespacho bloquea el stock de un SKU y devuelve lo que puede salir en un despacho
+// de la cotización: las reservas de la propia cotización sí se pueden usar
+func stockDisponibleDespacho(tx *gorm.DB, producto *modelos.Producto, sucursalID, cotizacionID uint) (int, error) {
+	var stock modelos.StockSucursal
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("sku = ? AND sucursal_id = ?", producto.SKU, sucursalID).
+		First(&stock).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	enMano := stock.Cantidad
+	if producto.ManejaLotes {
+		// Los despachos no toman lotes vencidos
+		var vencido int
+		if err := tx.Model(&modelos.StockLote{}).
+			Select("COALESCE(SUM(cantidad), 0)").
+			Where("sku = ? AND sucursal_id = ? AND fecha_vencimiento < ?", producto.SKU, sucursalID, inicioDia(time.Now())).
+			Scan(&vencido).Error; err != nil {
+			return 0, err
+		}
+		enMano -= vencido
+	}
+
+	var reservado int
+	if err := tx.Model(&modelos.ReservaStock{}).
+		Select("COALESCE(SUM(cantidad), 0)").
+		Where("sku = ? AND sucursal_id = ? AND cotizacion_id <> ? AND estado = ? AND fecha_expira > ?",
+			producto.SKU, sucursalID, cotizacionID, ReservaActiva, time.Now()).
+		Scan(&reservado).Error; err != nil {
+		return 0, err
+	}
+
+	disponible := enMano - reservado
+	if disponible < 0 {
+		disponible = 0
+	}
+	return disponible, nil
+}
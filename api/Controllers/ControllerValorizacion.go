@@ -0,0 +1,409 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"backend-inventario/config"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Métodos de valorización de inventario
+const (
+	ValorizacionPromedio = "promedio"
+	ValorizacionFIFO     = "fifo"
+)
+
+// ValorizacionLinea es el valor del stock de un SKU en una sucursal a la fecha del reporte
+type ValorizacionLinea struct {
+	SKU           string  `json:"sku"`
+	Nombre        string  `json:"nombre"`
+	SucursalID    uint    `json:"sucursal_id"`
+	Sucursal      string  `json:"sucursal"`
+	CategoriaID   *uint   `json:"categoria_id,omitempty"`
+	Categoria     string  `json:"categoria,omitempty"`
+	Cantidad      int     `json:"cantidad"`
+	CostoUnitario float64 `json:"costo_unitario"`
+	Valor         float64 `json:"valor"`
+}
+
+// ValorizacionGrupo acumula cantidad y valor por sucursal o por categoría
+type ValorizacionGrupo struct {
+	ID       uint    `json:"id"` // 0 para productos sin categoría
+	Nombre   string  `json:"nombre"`
+	Cantidad int     `json:"cantidad"`
+	Valor    float64 `json:"valor"`
+}
+
+// ReporteValorizacion es la valorización del inventario a una fecha con un método de costeo
+type ReporteValorizacion struct {
+	Fecha        time.Time           `json:"fecha"`
+	Metodo       string              `json:"metodo"`
+	Total        float64             `json:"total"`
+	PorSucursal  []ValorizacionGrupo `json:"por_sucursal"`
+	PorCategoria []ValorizacionGrupo `json:"por_categoria"`
+	Lineas       []ValorizacionLinea `json:"lineas"`
+
+	// Presente cuando la fecha corresponde a un mes cerrado y las cifras salen del cierre
+	CierreID *uint `json:"cierre_id,omitempty"`
+}
+
+// MetodoValorizacion devuelve el método con que se costean las salidas, configurable con
+// METODO_VALORIZACION (promedio o fifo)
+func MetodoValorizacion() string {
+	if config.GetEnv("METODO_VALORIZACION", ValorizacionPromedio) == ValorizacionFIFO {
+		return ValorizacionFIFO
+	}
+	return ValorizacionPromedio
+}
+
+// aplicarCosto costea un movimiento antes de registrarlo. Las entradas usan el costo informado
+// (o el promedio vigente si no traen costo), recalculan el promedio ponderado y abren una capa
+// FIFO; las salidas consumen capas FIFO y quedan costeadas según el método configurado.
+func aplicarCosto(tx *gorm.DB, mov *modelos.MovimientoStock, stock *modelos.StockSucursal) error {
+	if mov.Cantidad > 0 {
+		costo := mov.CostoUnitario
+		if costo <= 0 {
+			costo = stock.CostoPromedio
+		}
+		if costo <= 0 {
+			ultimo, err := ultimoCostoConocido(tx, mov.SKU)
+			if err != nil {
+				return err
+			}
+			costo = ultimo
+		}
+		mov.CostoUnitario = redondearCosto(costo)
+
+		if total := stock.Cantidad + mov.Cantidad; total > 0 {
+			stock.CostoPromedio = redondearCosto((float64(stock.Cantidad)*stock.CostoPromedio + float64(mov.Cantidad)*mov.CostoUnitario) / float64(total))
+		}
+		return tx.Create(&modelos.CapaCosto{
+			SKU:           mov.SKU,
+			SucursalID:    mov.SucursalID,
+			Fecha:         time.Now(),
+			Cantidad:      mov.Cantidad,
+			Restante:      mov.Cantidad,
+			CostoUnitario: mov.CostoUnitario,
+		}).Error
+	}
+
+	costoFIFO, err := consumirCapas(tx, mov.SKU, mov.SucursalID, -mov.Cantidad, stock.CostoPromedio)
+	if err != nil {
+		return err
+	}
+	if MetodoValorizacion() == ValorizacionFIFO {
+		mov.CostoUnitario = redondearCosto(costoFIFO / float64(-mov.Cantidad))
+	} else {
+		mov.CostoUnitario = stock.CostoPromedio
+	}
+	return nil
+}
+
+// consumirCapas descuenta unidades de las capas de costo más antiguas y devuelve su costo total.
+// El stock que no tiene capa (anterior al costeo) se valoriza al promedio vigente.
+func consumirCapas(tx *gorm.DB, sku string, sucursalID uint, cantidad int, costoPromedio float64) (float64, error) {
+	var capas []modelos.CapaCosto
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("sku = ? AND sucursal_id = ? AND restante > 0", sku, sucursalID).
+		Order("fecha ASC, id ASC").
+		Find(&capas).Error; err != nil {
+		return 0, err
+	}
+
+	total := 0.0
+	restante := cantidad
+	for _, capa := range capas {
+		if restante == 0 {
+			break
+		}
+		tomar := capa.Restante
+		if tomar > restante {
+			tomar = restante
+		}
+		if err := tx.Model(&capa).Update("restante", capa.Restante-tomar).Error; err != nil {
+			return 0, err
+		}
+		total += float64(tomar) * capa.CostoUnitario
+		restante -= tomar
+	}
+	total += float64(restante) * costoPromedio
+	return total, nil
+}
+
+// ultimoCostoConocido busca el último costo de entrada del SKU en cualquier sucursal
+func ultimoCostoConocido(tx *gorm.DB, sku string) (float64, error) {
+	var mov modelos.MovimientoStock
+	err := tx.Select("costo_unitario").
+		Where("sku = ? AND cantidad > 0 AND costo_unitario > 0", sku).
+		Order("fecha DESC, id DESC").
+		First(&mov).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return mov.CostoUnitario, err
+}
+
+// GetValorizacion valoriza el inventario al cierre de la fecha indicada reconstruyendo el costo
+// de cada SKU y sucursal desde los movimientos. Los costos de entrada son los registrados; las
+// salidas se recalculan con el método pedido, así ambos métodos sirven para cualquier fecha.
+func GetValorizacion(db *gorm.DB, fecha time.Time, metodo string, sucursalID, categoriaID uint) (*ReporteValorizacion, error) {
+	if metodo == "" {
+		metodo = MetodoValorizacion()
+	}
+	if metodo != ValorizacionPromedio && metodo != ValorizacionFIFO {
+		return nil, errors.New("método de valorización no válido: use promedio o fifo")
+	}
+	hasta := fecha.AddDate(0, 0, 1)
+
+	// La categoría incluye sus subcategorías
+	var categorias []uint
+	if categoriaID != 0 {
+		arbol, err := cargarArbolCategorias(db)
+		if err != nil {
+			return nil, err
+		}
+		categorias = arbol.descendientes(categoriaID)
+	}
+
+	// Un mes cerrado se informa siempre con las cifras congeladas en su cierre
+	var cierre modelos.CierreInventario
+	err := db.Where("fecha_corte = ? AND metodo = ?", hasta, metodo).First(&cierre).Error
+	if err == nil {
+		return reporteDesdeCierre(db, &cierre, fecha, sucursalID, categorias)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var stocks []modelos.StockSucursal
+	query := db.Preload("Producto.Categoria").Preload("Sucursal")
+	if sucursalID != 0 {
+		query = query.Where("sucursal_id = ?", sucursalID)
+	}
+	if err := query.Find(&stocks).Error; err != nil {
+		return nil, err
+	}
+
+	var movimientos []modelos.MovimientoStock
+	query = db.Where("fecha < ?", hasta)
+	if sucursalID != 0 {
+		query = query.Where("sucursal_id = ?", sucursalID)
+	}
+	if err := query.Order("fecha ASC, id ASC").Find(&movimientos).Error; err != nil {
+		return nil, err
+	}
+	porStock := make(map[claveStock][]modelos.MovimientoStock)
+	for _, m := range movimientos {
+		clave := claveStock{SKU: m.SKU, SucursalID: m.SucursalID}
+		porStock[clave] = append(porStock[clave], m)
+	}
+
+	// SKU con movimientos posteriores a la fecha pero ninguno anterior no tenían stock a esa fecha
+	var posteriores []claveStock
+	if err := db.Model(&modelos.MovimientoStock{}).
+		Select("DISTINCT sku, sucursal_id").
+		Where("fecha >= ?", hasta).
+		Scan(&posteriores).Error; err != nil {
+		return nil, err
+	}
+	conMovimientosPosteriores := make(map[claveStock]bool)
+	for _, c := range posteriores {
+		conMovimientosPosteriores[c] = true
+	}
+
+	enCategoria := make(map[uint]bool, len(categorias))
+	for _, id := range categorias {
+		enCategoria[id] = true
+	}
+
+	reporte := &ReporteValorizacion{Fecha: fecha, Metodo: metodo, Lineas: []ValorizacionLinea{}}
+	for _, s := range stocks {
+		if categoriaID != 0 && (s.Producto.CategoriaID == nil || !enCategoria[*s.Producto.CategoriaID]) {
+			continue
+		}
+		clave := claveStock{SKU: s.SKU, SucursalID: s.SucursalID}
+
+		var cantidad int
+		var valor float64
+		if movs, ok := porStock[clave]; ok {
+			cantidad, valor = reconstruirCosto(movs, metodo, s.CostoPromedio)
+		} else if !conMovimientosPosteriores[clave] {
+			// Stock sin movimientos registrados: se asume la cantidad actual al costo vigente
+			cantidad, valor = s.Cantidad, float64(s.Cantidad)*s.CostoPromedio
+		}
+		if cantidad == 0 {
+			continue
+		}
+
+		reporte.Lineas = append(reporte.Lineas, ValorizacionLinea{
+			SKU:           s.SKU,
+			Nombre:        s.Producto.Nombre,
+			SucursalID:    s.SucursalID,
+			Sucursal:      s.Sucursal.Nombre,
+			CategoriaID:   s.Producto.CategoriaID,
+			Categoria:     s.Producto.Categoria.Nombre,
+			Cantidad:      cantidad,
+			CostoUnitario: redondearCosto(valor / float64(cantidad)),
+			Valor:         math.Round(valor*100) / 100,
+		})
+	}
+
+	completarReporte(reporte)
+	return reporte, nil
+}
+
+// reporteDesdeCierre arma el reporte con las líneas congeladas del cierre; categorias vacía no filtra
+func reporteDesdeCierre(db *gorm.DB, cierre *modelos.CierreInventario, fecha time.Time, sucursalID uint, categorias []uint) (*ReporteValorizacion, error) {
+	var lineas []modelos.CierreInventarioLinea
+	query := db.Where("cierre_id = ?", cierre.ID)
+	if sucursalID != 0 {
+		query = query.Where("sucursal_id = ?", sucursalID)
+	}
+	if len(categorias) > 0 {
+		query = query.Where("categoria_id IN ?", categorias)
+	}
+	if err := query.Find(&lineas).Error; err != nil {
+		return nil, err
+	}
+
+	id := cierre.ID
+	reporte := &ReporteValorizacion{Fecha: fecha, Metodo: cierre.Metodo, Lineas: make([]ValorizacionLinea, 0, len(lineas)), CierreID: &id}
+	for _, l := range lineas {
+		reporte.Lineas = append(reporte.Lineas, ValorizacionLinea{
+			SKU:           l.SKU,
+			Nombre:        l.Nombre,
+			SucursalID:    l.SucursalID,
+			Sucursal:      l.Sucursal,
+			CategoriaID:   l.CategoriaID,
+			Categoria:     l.Categoria,
+			Cantidad:      l.Cantidad,
+			CostoUnitario: l.CostoUnitario,
+			Valor:         l.Valor,
+		})
+	}
+	completarReporte(reporte)
+	return reporte, nil
+}
+
+// completarReporte calcula el total y los subtotales por sucursal y categoría a partir de las líneas
+func completarReporte(reporte *ReporteValorizacion) {
+	sucursales := make(map[uint]*ValorizacionGrupo)
+	categorias := make(map[uint]*ValorizacionGrupo)
+	reporte.Total = 0
+	for _, linea := range reporte.Lineas {
+		reporte.Total += linea.Valor
+
+		g, ok := sucursales[linea.SucursalID]
+		if !ok {
+			g = &ValorizacionGrupo{ID: linea.SucursalID, Nombre: linea.Sucursal}
+			sucursales[linea.SucursalID] = g
+		}
+		g.Cantidad += linea.Cantidad
+		g.Valor += linea.Valor
+
+		var idCategoria uint
+		nombreCategoria := "Sin categoría"
+		if linea.CategoriaID != nil {
+			idCategoria = *linea.CategoriaID
+			nombreCategoria = linea.Categoria
+		}
+		g, ok = categorias[idCategoria]
+		if !ok {
+			g = &ValorizacionGrupo{ID: idCategoria, Nombre: nombreCategoria}
+			categorias[idCategoria] = g
+		}
+		g.Cantidad += linea.Cantidad
+		g.Valor += linea.Valor
+	}
+
+	reporte.Total = math.Round(reporte.Total*100) / 100
+	reporte.PorSucursal = ordenarGrupos(sucursales)
+	reporte.PorCategoria = ordenarGrupos(categorias)
+	sort.Slice(reporte.Lineas, func(i, j int) bool {
+		if reporte.Lineas[i].SucursalID != reporte.Lineas[j].SucursalID {
+			return reporte.Lineas[i].SucursalID < reporte.Lineas[j].SucursalID
+		}
+		return reporte.Lineas[i].SKU < reporte.Lineas[j].SKU
+	})
+}
+
+// reconstruirCosto recorre los movimientos de un SKU en una sucursal y devuelve la cantidad y el
+// valor final según el método. El saldo anterior al primer movimiento se valoriza al costo del
+// primer ingreso conocido, o al costo promedio actual si nunca hubo uno.
+func reconstruirCosto(movimientos []modelos.MovimientoStock, metodo string, costoActual float64) (int, float64) {
+	type capa struct {
+		cantidad int
+		costo    float64
+	}
+
+	costoApertura := costoActual
+	for _, m := range movimientos {
+		if m.Cantidad > 0 && m.CostoUnitario > 0 {
+			costoApertura = m.CostoUnitario
+			break
+		}
+	}
+
+	cantidad := movimientos[0].SaldoResultante - movimientos[0].Cantidad
+	promedio := costoApertura
+	var capas []capa
+	if cantidad > 0 {
+		capas = append(capas, capa{cantidad: cantidad, costo: costoApertura})
+	}
+
+	for _, m := range movimientos {
+		if m.Cantidad > 0 {
+			costo := m.CostoUnitario
+			if costo <= 0 {
+				costo = promedio
+			}
+			if cantidad+m.Cantidad > 0 {
+				promedio = (float64(cantidad)*promedio + float64(m.Cantidad)*costo) / float64(cantidad+m.Cantidad)
+			}
+			capas = append(capas, capa{cantidad: m.Cantidad, costo: costo})
+			cantidad += m.Cantidad
+			continue
+		}
+
+		salida := -m.Cantidad
+		cantidad -= salida
+		for salida > 0 && len(capas) > 0 {
+			if capas[0].cantidad > salida {
+				capas[0].cantidad -= salida
+				break
+			}
+			salida -= capas[0].cantidad
+			capas = capas[1:]
+		}
+	}
+
+	if metodo == ValorizacionPromedio {
+		return cantidad, float64(cantidad) * promedio
+	}
+	valor := 0.0
+	for _, c := range capas {
+		valor += float64(c.cantidad) * c.costo
+	}
+	return cantidad, valor
+}
+
+func ordenarGrupos(grupos map[uint]*ValorizacionGrupo) []ValorizacionGrupo {
+	resultado := make([]ValorizacionGrupo, 0, len(grupos))
+	for _, g := range grupos {
+		g.Valor = math.Round(g.Valor*100) / 100
+		resultado = append(resultado, *g)
+	}
+	sort.Slice(resultado, func(i, j int) bool {
+		return resultado[i].ID < resultado[j].ID
+	})
+	return resultado
+}
+
+func redondearCosto(costo float64) float64 {
+	return math.Round(costo*10000) / 10000
+}
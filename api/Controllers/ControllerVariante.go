@@ -0,0 +1,610 @@
+package Controllers
+
+import (
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrProductoPadreNoEncontrado indica que el producto padre pedido no existe
+var ErrProductoPadreNoEncontrado = errors.New("producto padre no encontrado")
+
+// AtributoMatriz es un eje de la matriz de variantes con los valores que toman sus variantes
+type AtributoMatriz struct {
+	ID      uint     `json:"id"`
+	Nombre  string   `json:"nombre"`
+	Valores []string `json:"valores"`
+}
+
+// CeldaVariante es una variante dentro de la matriz. Valores va en el mismo orden que los atributos
+type CeldaVariante struct {
+	SKU     string   `json:"sku"`
+	Nombre  string   `json:"nombre"`
+	Valores []string `json:"valores"`
+	Precio  float64  `json:"precio"`
+	Estado  bool     `json:"estado"`
+	Stock   int      `json:"stock"` // suma de todas las sucursales, en unidad base
+}
+
+// MatrizVariantes presenta las variantes de un padre por sus atributos, por ejemplo colores por
+// tamaños, para armar la grilla de selección del catálogo
+type MatrizVariantes struct {
+	Atributos []AtributoMatriz `json:"atributos"`
+	Variantes []CeldaVariante  `json:"variantes"`
+}
+
+// GrupoProductos es un elemento del catálogo agrupado: un producto padre con sus variantes o un
+// producto suelto
+type GrupoProductos struct {
+	Padre       *modelos.ProductoPadre `json:"padre,omitempty"`
+	Producto    *modelos.Producto      `json:"producto,omitempty"`
+	Variantes   []modelos.Producto     `json:"variantes,omitempty"`
+	Matriz      *MatrizVariantes       `json:"matriz,omitempty"`
+	PrecioDesde float64                `json:"precio_desde"`
+	PrecioHasta float64                `json:"precio_hasta"`
+}
+
+// PaginaGruposProductos es una página del catálogo agrupado; Total cuenta grupos, no productos
+type PaginaGruposProductos struct {
+	Grupos    []GrupoProductos `json:"grupos"`
+	Total     int64            `json:"total"`
+	Limite    int              `json:"limite"`
+	Siguiente string           `json:"siguiente,omitempty"`
+}
+
+// GetProductosPadre lista los productos padre con sus atributos
+func GetProductosPadre(db *gorm.DB) ([]modelos.ProductoPadre, error) {
+	var padres []modelos.ProductoPadre
+	if err := db.Preload("Atributos", ordenAtributos).Order("nombre").Find(&padres).Error; err != nil {
+		return nil, err
+	}
+	return padres, nil
+}
+
+// GetProductoPadreByID obtiene un producto padre con sus atributos y sus variantes
+func GetProductoPadreByID(db *gorm.DB, id uint) (*modelos.ProductoPadre, error) {
+	var padre modelos.ProductoPadre
+	err := db.Preload("Categoria").
+		Preload("Atributos", ordenAtributos).
+		Preload("Variantes", func(db *gorm.DB) *gorm.DB { return db.Order("sku") }).
+		Preload("Variantes.Variante").
+		First(&padre, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrProductoPadreNoEncontrado
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &padre, nil
+}
+
+// GetMatrizVariantes arma la matriz de variantes de un producto padre
+func GetMatrizVariantes(db *gorm.DB, id uint) (*MatrizVariantes, error) {
+	padre, err := GetProductoPadreByID(db, id)
+	if err != nil {
+		return nil, err
+	}
+	return armarMatrizVariantes(db, padre.Atributos, padre.Variantes)
+}
+
+// CreateProductoPadre crea un producto padre con sus atributos de variante. Las variantes se
+// asignan después, con productos ya creados
+func CreateProductoPadre(db *gorm.DB, padre *modelos.ProductoPadre) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		padre.ID = 0
+		padre.Variantes = nil
+		if err := validarProductoPadre(tx, padre); err != nil {
+			return err
+		}
+		if err := normalizarAtributosVariante(padre.Atributos); err != nil {
+			return err
+		}
+		return tx.Omit("Categoria").Create(padre).Error
+	})
+}
+
+// UpdateProductoPadre cambia el nombre, la descripción y la categoría de un producto padre. Los
+// atributos solo se pueden reemplazar mientras no tenga variantes
+func UpdateProductoPadre(db *gorm.DB, id uint, actualizado *modelos.ProductoPadre) (*modelos.ProductoPadre, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var padre modelos.ProductoPadre
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&padre, id).Error; err != nil {
+			return ErrProductoPadreNoEncontrado
+		}
+		if err := validarProductoPadre(tx, actualizado); err != nil {
+			return err
+		}
+		if err := tx.Model(&padre).Updates(map[string]interface{}{
+			"nombre":       actualizado.Nombre,
+			"descripcion":  actualizado.Descripcion,
+			"categoria_id": actualizado.CategoriaID,
+		}).Error; err != nil {
+			return err
+		}
+		if len(actualizado.Atributos) == 0 {
+			return nil
+		}
+
+		var variantes int64
+		if err := tx.Model(&modelos.Producto{}).Where("padre_id = ?", id).Count(&variantes).Error; err != nil {
+			return err
+		}
+		if variantes > 0 {
+			return errors.New("los atributos solo se pueden cambiar mientras el producto padre no tenga variantes")
+		}
+		if err := normalizarAtributosVariante(actualizado.Atributos); err != nil {
+			return err
+		}
+		if err := tx.Where("padre_id = ?", id).Delete(&modelos.AtributoVariante{}).Error; err != nil {
+			return err
+		}
+		for i := range actualizado.Atributos {
+			actualizado.Atributos[i].PadreID = id
+		}
+		return tx.Create(&actualizado.Atributos).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetProductoPadreByID(db, id)
+}
+
+// DeleteProductoPadre elimina un producto padre. Sus variantes siguen existiendo como productos
+// sueltos y pierden sus valores de atributo
+func DeleteProductoPadre(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var padre modelos.ProductoPadre
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&padre, id).Error; err != nil {
+			return ErrProductoPadreNoEncontrado
+		}
+		if err := tx.Model(&modelos.Producto{}).
+			Where("padre_id = ?", id).
+			Updates(map[string]interface{}{
+				"padre_id": nil,
+				"version":  gorm.Expr("version + 1"),
+			}).Error; err != nil {
+			return err
+		}
+		// Los valores de las variantes se borran en cascada con los atributos
+		return tx.Delete(&padre).Error
+	})
+}
+
+// AsignarVariante hace de un producto una variante del padre, con un valor para cada atributo del
+// padre (por nombre). La combinación de valores no puede repetirse entre las variantes. Un producto
+// que ya es variante del mismo padre cambia sus valores
+func AsignarVariante(db *gorm.DB, padreID uint, sku string, valores map[string]string) (*modelos.Producto, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var padre modelos.ProductoPadre
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Atributos", ordenAtributos).
+			First(&padre, padreID).Error; err != nil {
+			return ErrProductoPadreNoEncontrado
+		}
+		var producto modelos.Producto
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("sku", "padre_id").
+			First(&producto, "sku = ?", sku).Error; err != nil {
+			return errors.New("producto no encontrado")
+		}
+		if producto.PadreID != nil && *producto.PadreID != padreID {
+			return fmt.Errorf("el producto %s ya es variante del producto padre %d", sku, *producto.PadreID)
+		}
+
+		nuevos, err := valoresVariante(padre.Atributos, valores)
+		if err != nil {
+			return err
+		}
+		if err := validarCombinacionVariante(tx, padre.Atributos, padreID, sku, nuevos); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&modelos.Producto{}).Where("sku = ?", sku).Updates(map[string]interface{}{
+			"padre_id": padreID,
+			"version":  gorm.Expr("version + 1"),
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("sku = ?", sku).Delete(&modelos.VarianteValor{}).Error; err != nil {
+			return err
+		}
+		for i := range nuevos {
+			nuevos[i].SKU = sku
+		}
+		return tx.Omit("Atributo").Create(&nuevos).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetProductoBySKU(db, sku)
+}
+
+// QuitarVariante separa una variante de su padre; el producto sigue existiendo como producto suelto
+func QuitarVariante(db *gorm.DB, padreID uint, sku string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&modelos.Producto{}).
+			Where("sku = ? AND padre_id = ?", sku, padreID).
+			Updates(map[string]interface{}{
+				"padre_id": nil,
+				"version":  gorm.Expr("version + 1"),
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("el producto %s no es variante del producto padre %d", sku, padreID)
+		}
+		return tx.Where("sku = ?", sku).Delete(&modelos.VarianteValor{}).Error
+	})
+}
+
+// BuscarProductosAgrupados busca en el catálogo con los mismos filtros y órdenes que
+// BuscarProductos, pero entrega un grupo por producto padre en lugar de una fila por variante.
+// Un grupo aparece si alguna de sus variantes cumple los filtros y trae solo esas variantes. Para
+// ordenar, el grupo toma el nombre del padre, el menor precio, el menor SKU o la mayor relevancia
+// de sus variantes
+func BuscarProductosAgrupados(db *gorm.DB, f FiltroProductos) (*PaginaGruposProductos, error) {
+	if err := completarFiltroProductos(&f); err != nil {
+		return nil, err
+	}
+	var agregado string
+	switch f.Orden {
+	case OrdenRelevancia:
+		expresion, _ := expresionOrdenProductos(f.Orden)
+		agregado = "MAX(" + expresion + ")"
+	case OrdenNombre:
+		agregado = "MIN(COALESCE(productos_padre.nombre, productos.nombre))"
+	case OrdenPrecio:
+		agregado = "MIN(productos.precio)"
+	case OrdenSKU:
+		agregado = "MIN(productos.sku)"
+	default:
+		return nil, fmt.Errorf("orden inválido: %s", f.Orden)
+	}
+	query, err := filtrarProductos(db, f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clave del grupo: P y el ID del padre, o S y el SKU de un producto suelto
+	clave := "COALESCE('P' || productos.padre_id::text, 'S' || productos.sku)"
+	grupos := query.
+		Joins("LEFT JOIN productos_padre ON productos_padre.id = productos.padre_id").
+		Clauses(clause.Select{Expression: clause.NamedExpr{
+			SQL:  clave + " AS clave, " + agregado + " AS valor",
+			Vars: []interface{}{map[string]interface{}{"texto": f.Texto}},
+		}}).
+		Group(clave)
+
+	pagina := &PaginaGruposProductos{Grupos: []GrupoProductos{}, Limite: f.Limite}
+	if err := db.Table("(?) AS g", grupos).Count(&pagina.Total).Error; err != nil {
+		return nil, err
+	}
+
+	comparador, direccion := ">", "ASC"
+	if f.Descendente {
+		comparador, direccion = "<", "DESC"
+	}
+	consulta := db.Table("(?) AS g", grupos)
+	if f.Cursor != "" {
+		cursor, err := leerCursorProductos(f.Cursor)
+		if err != nil || cursor.Orden != f.Orden || cursor.Desc != f.Descendente || cursor.Valor == nil {
+			return nil, ErrCursorInvalido
+		}
+		consulta = consulta.Where("(g.valor "+comparador+" ? OR (g.valor = ? AND g.clave > ?))", cursor.Valor, cursor.Valor, cursor.SKU)
+	}
+	var filas []struct {
+		Clave string
+		Valor interface{}
+	}
+	if err := consulta.Select("g.clave, g.valor").
+		Order("g.valor " + direccion + ", g.clave ASC").
+		Limit(f.Limite + 1).
+		Scan(&filas).Error; err != nil {
+		return nil, err
+	}
+	if len(filas) == 0 {
+		return pagina, nil
+	}
+	hayMas := len(filas) > f.Limite
+	if hayMas {
+		filas = filas[:f.Limite]
+	}
+
+	var padreIDs []uint
+	var skus []string
+	for _, fila := range filas {
+		if strings.HasPrefix(fila.Clave, "P") {
+			id, err := strconv.ParseUint(fila.Clave[1:], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			padreIDs = append(padreIDs, uint(id))
+		} else {
+			skus = append(skus, fila.Clave[1:])
+		}
+	}
+
+	// Variantes de los padres de la página que cumplen los filtros
+	variantesPorPadre := make(map[uint][]modelos.Producto)
+	if len(padreIDs) > 0 {
+		var skusVariantes []string
+		if err := query.Where("productos.padre_id IN ?", padreIDs).Pluck("productos.sku", &skusVariantes).Error; err != nil {
+			return nil, err
+		}
+		skus = append(skus, skusVariantes...)
+	}
+	porSKU := make(map[string]*modelos.Producto, len(skus))
+	var productos []modelos.Producto
+	if len(skus) > 0 {
+		if err := db.Preload("Proveedor").Preload("Categoria").
+			Preload("Imagenes", func(db *gorm.DB) *gorm.DB { return db.Order("orden, id") }).
+			Preload("Variante").
+			Where("sku IN ?", skus).
+			Order("sku").
+			Find(&productos).Error; err != nil {
+			return nil, err
+		}
+	}
+	for i := range productos {
+		completarURLsImagenes(productos[i].Imagenes)
+		if productos[i].PadreID != nil {
+			variantesPorPadre[*productos[i].PadreID] = append(variantesPorPadre[*productos[i].PadreID], productos[i])
+		} else {
+			porSKU[productos[i].SKU] = &productos[i]
+		}
+	}
+	padres := make(map[uint]*modelos.ProductoPadre, len(padreIDs))
+	if len(padreIDs) > 0 {
+		var lista []modelos.ProductoPadre
+		if err := db.Preload("Categoria").Preload("Atributos", ordenAtributos).
+			Where("id IN ?", padreIDs).
+			Find(&lista).Error; err != nil {
+			return nil, err
+		}
+		for i := range lista {
+			padres[lista[i].ID] = &lista[i]
+		}
+	}
+
+	for _, fila := range filas {
+		var grupo GrupoProductos
+		if strings.HasPrefix(fila.Clave, "P") {
+			id, _ := strconv.ParseUint(fila.Clave[1:], 10, 64)
+			padre, ok := padres[uint(id)]
+			if !ok {
+				continue
+			}
+			grupo.Padre = padre
+			grupo.Variantes = variantesPorPadre[padre.ID]
+			matriz, err := armarMatrizVariantes(db, padre.Atributos, grupo.Variantes)
+			if err != nil {
+				return nil, err
+			}
+			grupo.Matriz = matriz
+		} else {
+			producto, ok := porSKU[fila.Clave[1:]]
+			if !ok {
+				continue
+			}
+			grupo.Producto = producto
+			grupo.Variantes = []modelos.Producto{*producto}
+		}
+		grupo.PrecioDesde, grupo.PrecioHasta = rangoPrecios(grupo.Variantes)
+		if grupo.Producto != nil {
+			grupo.Variantes = nil
+		}
+		pagina.Grupos = append(pagina.Grupos, grupo)
+	}
+
+	if hayMas {
+		ultima := filas[len(filas)-1]
+		siguiente, err := escribirCursorProductos(cursorProductos{Orden: f.Orden, Desc: f.Descendente, Valor: ultima.Valor, SKU: ultima.Clave})
+		if err != nil {
+			return nil, err
+		}
+		pagina.Siguiente = siguiente
+	}
+	return pagina, nil
+}
+
+func ordenAtributos(db *gorm.DB) *gorm.DB {
+	return db.Order("orden, id")
+}
+
+// validarProductoPadre normaliza el nombre y revisa la categoría
+func validarProductoPadre(tx *gorm.DB, padre *modelos.ProductoPadre) error {
+	padre.Nombre = strings.TrimSpace(padre.Nombre)
+	if padre.Nombre == "" {
+		return errors.New("el nombre del producto padre es obligatorio")
+	}
+	if padre.CategoriaID != nil {
+		var categoria modelos.Categoria
+		if err := tx.Select("id").First(&categoria, *padre.CategoriaID).Error; err != nil {
+			return ErrCategoriaNoEncontrada
+		}
+	}
+	return nil
+}
+
+// normalizarAtributosVariante revisa que haya al menos un atributo, sin nombres repetidos, y los
+// ordena según vienen
+func normalizarAtributosVariante(atributos []modelos.AtributoVariante) error {
+	if len(atributos) == 0 {
+		return errors.New("el producto padre debe tener al menos un atributo de variante, como color o talla")
+	}
+	vistos := make(map[string]bool, len(atributos))
+	for i := range atributos {
+		a := &atributos[i]
+		a.ID = 0
+		a.Nombre = strings.TrimSpace(a.Nombre)
+		if a.Nombre == "" {
+			return errors.New("el nombre del atributo es obligatorio")
+		}
+		if vistos[strings.ToLower(a.Nombre)] {
+			return fmt.Errorf("el atributo %s está repetido", a.Nombre)
+		}
+		vistos[strings.ToLower(a.Nombre)] = true
+		a.Orden = i
+	}
+	return nil
+}
+
+// valoresVariante traduce los valores indicados por nombre de atributo (sin distinguir
+// mayúsculas) a filas de valores, exigiendo un valor para cada atributo y ninguno de más
+func valoresVariante(atributos []modelos.AtributoVariante, valores map[string]string) ([]modelos.VarianteValor, error) {
+	porNombre := make(map[string]string, len(valores))
+	for nombre, valor := range valores {
+		porNombre[strings.ToLower(strings.TrimSpace(nombre))] = strings.TrimSpace(valor)
+	}
+	resultado := make([]modelos.VarianteValor, 0, len(atributos))
+	for _, a := range atributos {
+		valor, ok := porNombre[strings.ToLower(a.Nombre)]
+		if !ok || valor == "" {
+			return nil, fmt.Errorf("falta el valor del atributo %s", a.Nombre)
+		}
+		delete(porNombre, strings.ToLower(a.Nombre))
+		resultado = append(resultado, modelos.VarianteValor{AtributoID: a.ID, Valor: valor})
+	}
+	for nombre := range porNombre {
+		return nil, fmt.Errorf("el producto padre no tiene el atributo %s", nombre)
+	}
+	return resultado, nil
+}
+
+// validarCombinacionVariante revisa que ninguna otra variante del padre tenga los mismos valores
+func validarCombinacionVariante(tx *gorm.DB, atributos []modelos.AtributoVariante, padreID uint, sku string, valores []modelos.VarianteValor) error {
+	var existentes []modelos.VarianteValor
+	if err := tx.Joins("JOIN productos ON productos.sku = variante_valores.sku").
+		Where("productos.padre_id = ? AND variante_valores.sku <> ?", padreID, sku).
+		Find(&existentes).Error; err != nil {
+		return err
+	}
+	combinaciones := make(map[string]map[uint]string)
+	for _, v := range existentes {
+		if combinaciones[v.SKU] == nil {
+			combinaciones[v.SKU] = make(map[uint]string)
+		}
+		combinaciones[v.SKU][v.AtributoID] = strings.ToLower(v.Valor)
+	}
+	for otro, combinacion := range combinaciones {
+		igual := len(combinacion) == len(valores)
+		for _, v := range valores {
+			igual = igual && combinacion[v.AtributoID] == strings.ToLower(v.Valor)
+		}
+		if igual {
+			partes := make([]string, 0, len(valores))
+			for i, v := range valores {
+				partes = append(partes, atributos[i].Nombre+" "+v.Valor)
+			}
+			return fmt.Errorf("la variante %s ya tiene %s", otro, strings.Join(partes, ", "))
+		}
+	}
+	return nil
+}
+
+// armarMatrizVariantes ordena los valores de cada atributo (los numéricos por su número, como los
+// diámetros) y ubica cada variante con su stock total
+func armarMatrizVariantes(db *gorm.DB, atributos []modelos.AtributoVariante, variantes []modelos.Producto) (*MatrizVariantes, error) {
+	matriz := &MatrizVariantes{
+		Atributos: make([]AtributoMatriz, 0, len(atributos)),
+		Variantes: make([]CeldaVariante, 0, len(variantes)),
+	}
+	skus := make([]string, 0, len(variantes))
+	for _, v := range variantes {
+		skus = append(skus, v.SKU)
+	}
+	stock := make(map[string]int, len(variantes))
+	if len(skus) > 0 {
+		var totales []struct {
+			SKU      string
+			Cantidad int
+		}
+		if err := db.Model(&modelos.StockSucursal{}).
+			Select("sku, SUM(cantidad) AS cantidad").
+			Where("sku IN ?", skus).
+			Group("sku").
+			Scan(&totales).Error; err != nil {
+			return nil, err
+		}
+		for _, t := range totales {
+			stock[t.SKU] = t.Cantidad
+		}
+	}
+
+	posicion := make(map[uint]int, len(atributos))
+	for i, a := range atributos {
+		posicion[a.ID] = i
+		matriz.Atributos = append(matriz.Atributos, AtributoMatriz{ID: a.ID, Nombre: a.Nombre, Valores: []string{}})
+	}
+	vistos := make([]map[string]bool, len(atributos))
+	for i := range vistos {
+		vistos[i] = make(map[string]bool)
+	}
+	for _, v := range variantes {
+		celda := CeldaVariante{
+			SKU:     v.SKU,
+			Nombre:  v.Nombre,
+			Valores: make([]string, len(atributos)),
+			Precio:  v.Precio,
+			Estado:  v.Estado,
+			Stock:   stock[v.SKU],
+		}
+		for _, valor := range v.Variante {
+			i, ok := posicion[valor.AtributoID]
+			if !ok {
+				continue
+			}
+			celda.Valores[i] = valor.Valor
+			if !vistos[i][valor.Valor] {
+				vistos[i][valor.Valor] = true
+				matriz.Atributos[i].Valores = append(matriz.Atributos[i].Valores, valor.Valor)
+			}
+		}
+		matriz.Variantes = append(matriz.Variantes, celda)
+	}
+	for i := range matriz.Atributos {
+		valores := matriz.Atributos[i].Valores
+		sort.SliceStable(valores, func(a, b int) bool { return valorAtributoMenor(valores[a], valores[b]) })
+	}
+	return matriz, nil
+}
+
+// valorAtributoMenor compara valores de atributo: por el número inicial si ambos lo tienen
+// ("20 mm" antes que "110 mm") y si no, alfabéticamente
+func valorAtributoMenor(a, b string) bool {
+	na, okA := numeroInicial(a)
+	nb, okB := numeroInicial(b)
+	if okA && okB && na != nb {
+		return na < nb
+	}
+	return strings.ToLower(a) < strings.ToLower(b)
+}
+
+func numeroInicial(s string) (float64, bool) {
+	fin := 0
+	for fin < len(s) && (s[fin] >= '0' && s[fin] <= '9' || s[fin] == '.' || s[fin] == ',') {
+		fin++
+	}
+	n, err := strconv.ParseFloat(strings.ReplaceAll(s[:fin], ",", "."), 64)
+	return n, err == nil
+}
+
+// rangoPrecios devuelve el menor y el mayor precio de los productos
+func rangoPrecios(productos []modelos.Producto) (float64, float64) {
+	if len(productos) == 0 {
+		return 0, 0
+	}
+	desde, hasta := productos[0].Precio, productos[0].Precio
+	for _, p := range productos[1:] {
+		desde = min(desde, p.Precio)
+		hasta = max(hasta, p.Precio)
+	}
+	return desde, hasta
+}
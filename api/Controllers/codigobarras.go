@@ -0,0 +1,202 @@
+package Controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Codificación de códigos de barras EAN-13 y Code 128 en módulos (barras y espacios de ancho
+// unitario), para validarlos y dibujarlos en las etiquetas sin depender de otra librería.
+
+// Tipos de código de barras aceptados
+const (
+	CodigoEAN13   = "EAN13"
+	CodigoCode128 = "CODE128"
+)
+
+// largoMaximoCode128 coincide con el tamaño de la columna y con lo que cabe en una etiqueta
+const largoMaximoCode128 = 48
+
+// normalizarCodigoBarras limpia el código y valida que corresponda al tipo. Sin tipo, 13 dígitos
+// se toman como EAN-13 y cualquier otro código como Code 128
+func normalizarCodigoBarras(codigo, tipo string) (string, string, error) {
+	codigo = strings.TrimSpace(codigo)
+	tipo = strings.ToUpper(strings.TrimSpace(tipo))
+	if codigo == "" {
+		return "", "", errors.New("el código de barras es obligatorio")
+	}
+	if tipo == "" {
+		tipo = CodigoCode128
+		if len(codigo) == 13 && soloDigitos(codigo) {
+			tipo = CodigoEAN13
+		}
+	}
+	switch tipo {
+	case CodigoEAN13:
+		if len(codigo) != 13 || !soloDigitos(codigo) {
+			return "", "", errors.New("un código EAN-13 debe tener 13 dígitos")
+		}
+		if verificador := digitoVerificadorEAN13(codigo[:12]); codigo[12] != verificador {
+			return "", "", fmt.Errorf("dígito verificador EAN-13 inválido: debería ser %c", verificador)
+		}
+	case CodigoCode128:
+		if len(codigo) > largoMaximoCode128 {
+			return "", "", fmt.Errorf("un código Code 128 no puede superar los %d caracteres", largoMaximoCode128)
+		}
+		for _, r := range codigo {
+			if r < ' ' || r > '~' {
+				return "", "", errors.New("un código Code 128 solo admite caracteres ASCII imprimibles")
+			}
+		}
+	default:
+		return "", "", fmt.Errorf("tipo de código de barras inválido: %s", tipo)
+	}
+	return codigo, tipo, nil
+}
+
+func soloDigitos(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// digitoVerificadorEAN13 calcula el dígito verificador de los 12 primeros dígitos: pesos 1 y 3
+// alternados desde la izquierda
+func digitoVerificadorEAN13(digitos string) byte {
+	suma := 0
+	for i := 0; i < 12; i++ {
+		d := int(digitos[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		suma += d
+	}
+	return byte('0' + (10-suma%10)%10)
+}
+
+// Patrones EAN-13 de cada dígito (1 es barra) con paridad impar (L); los de paridad par (G) son
+// los R invertidos y los R son el complemento de los L
+var patronesEAN13L = [10]string{
+	"0001101", "0011001", "0010011", "0111101", "0100011",
+	"0110001", "0101111", "0111011", "0110111", "0001011",
+}
+
+// paridadesEAN13 indica, según el primer dígito, qué dígitos de la mitad izquierda van en paridad par
+var paridadesEAN13 = [10]string{
+	"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG",
+	"LGGLLG", "LGGGLL", "LGLGLG", "LGLGGL", "LGGLGL",
+}
+
+// modulosEAN13 codifica un EAN-13 ya validado en sus 95 módulos, sin zonas de silencio
+func modulosEAN13(codigo string) []bool {
+	modulos := make([]bool, 0, 95)
+	agregar := func(patron string) {
+		for _, m := range patron {
+			modulos = append(modulos, m == '1')
+		}
+	}
+	paridad := paridadesEAN13[codigo[0]-'0']
+	agregar("101")
+	for i := 1; i <= 6; i++ {
+		l := patronesEAN13L[codigo[i]-'0']
+		if paridad[i-1] == 'G' {
+			l = invertir(complemento(l))
+		}
+		agregar(l)
+	}
+	agregar("01010")
+	for i := 7; i <= 12; i++ {
+		agregar(complemento(patronesEAN13L[codigo[i]-'0']))
+	}
+	agregar("101")
+	return modulos
+}
+
+func complemento(patron string) string {
+	b := []byte(patron)
+	for i := range b {
+		if b[i] == '0' {
+			b[i] = '1'
+		} else {
+			b[i] = '0'
+		}
+	}
+	return string(b)
+}
+
+func invertir(patron string) string {
+	b := []byte(patron)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+// anchosCode128 son los anchos de barra y espacio alternados de cada símbolo Code 128 (0 a 105)
+var anchosCode128 = [106]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232",
+}
+
+const (
+	inicioCode128B = 104
+	inicioCode128C = 105
+	finCode128     = "2331112"
+)
+
+// modulosCode128 codifica un código ya validado. Los códigos numéricos de largo par usan el
+// conjunto C (dos dígitos por símbolo, más angosto) y el resto el conjunto B
+func modulosCode128(codigo string) []bool {
+	var valores []int
+	if len(codigo)%2 == 0 && soloDigitos(codigo) {
+		valores = append(valores, inicioCode128C)
+		for i := 0; i < len(codigo); i += 2 {
+			valores = append(valores, int(codigo[i]-'0')*10+int(codigo[i+1]-'0'))
+		}
+	} else {
+		valores = append(valores, inicioCode128B)
+		for i := 0; i < len(codigo); i++ {
+			valores = append(valores, int(codigo[i])-' ')
+		}
+	}
+	suma := valores[0]
+	for i, v := range valores[1:] {
+		suma += (i + 1) * v
+	}
+	valores = append(valores, suma%103)
+
+	var modulos []bool
+	agregar := func(anchos string) {
+		for i, a := range anchos {
+			for n := 0; n < int(a-'0'); n++ {
+				modulos = append(modulos, i%2 == 0)
+			}
+		}
+	}
+	for _, v := range valores {
+		agregar(anchosCode128[v])
+	}
+	agregar(finCode128)
+	return modulos
+}
+
+// modulosCodigoBarras codifica un código del tipo indicado
+func modulosCodigoBarras(codigo, tipo string) []bool {
+	if tipo == CodigoEAN13 {
+		return modulosEAN13(codigo)
+	}
+	return modulosCode128(codigo)
+}
@@ -0,0 +1,141 @@
+package Controllers
+
+import (
+	"strings"
+	"testing"
+)
+
+// textoModulos escribe los módulos como 1 (barra) y 0 (espacio)
+func textoModulos(modulos []bool) string {
+	var b strings.Builder
+	for _, m := range modulos {
+		if m {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+func TestDigitoVerificadorEAN13(t *testing.T) {
+	casos := map[string]byte{
+		"400638133393": '1',
+		"590123412345": '7',
+		"978020137962": '4',
+		"000000000000": '0',
+	}
+	for digitos, esperado := range casos {
+		if obtenido := digitoVerificadorEAN13(digitos); obtenido != esperado {
+			t.Errorf("dígito verificador de %s: se esperaba %c y se obtuvo %c", digitos, esperado, obtenido)
+		}
+	}
+}
+
+func TestModulosEAN13(t *testing.T) {
+	// Armados con las tablas publicadas de patrones L, G y R
+	casos := []struct {
+		codigo  string
+		modulos []string
+	}{
+		{
+			// Primer dígito 5: paridad LGGLLG
+			codigo: "5901234123457",
+			modulos: []string{
+				"101",
+				"0001011", "0100111", "0110011", "0010011", "0111101", "0011101", // 9L 0G 1G 2L 3L 4G
+				"01010",
+				"1100110", "1101100", "1000010", "1011100", "1001110", "1000100", // 1 2 3 4 5 7 en R
+				"101",
+			},
+		},
+		{
+			// Primer dígito 4: paridad LGLLGG
+			codigo: "4006381333931",
+			modulos: []string{
+				"101",
+				"0001101", "0100111", "0101111", "0111101", "0001001", "0110011", // 0L 0G 6L 3L 8G 1G
+				"01010",
+				"1000010", "1000010", "1000010", "1110100", "1000010", "1100110", // 3 3 3 9 3 1 en R
+				"101",
+			},
+		},
+	}
+	for _, c := range casos {
+		esperado := strings.Join(c.modulos, "")
+		if obtenido := textoModulos(modulosEAN13(c.codigo)); obtenido != esperado {
+			t.Errorf("módulos de %s:\nse esperaba %s\nse obtuvo   %s", c.codigo, esperado, obtenido)
+		}
+	}
+}
+
+func TestNormalizarCodigoBarrasEAN13(t *testing.T) {
+	codigo, tipo, err := normalizarCodigoBarras(" 5901234123457 ", "")
+	if err != nil || codigo != "5901234123457" || tipo != CodigoEAN13 {
+		t.Errorf("se esperaba el EAN-13 5901234123457, se obtuvo %q %q %v", codigo, tipo, err)
+	}
+	if _, _, err := normalizarCodigoBarras("5901234123458", CodigoEAN13); err == nil {
+		t.Error("se esperaba error por dígito verificador incorrecto")
+	}
+}
+
+func TestModulosCode128(t *testing.T) {
+	// Patrones publicados de los símbolos de inicio, del fin y de los verificadores esperados
+	const (
+		inicioB = "11010010000"   // 104
+		inicioC = "11010011100"   // 105
+		fin     = "1100011101011" // stop
+	)
+	casos := []struct {
+		codigo      string
+		inicio      string
+		simbolos    int    // símbolos de datos
+		verificador string // patrón del dígito verificador
+	}{
+		// Conjunto B: 104 + 1·55 + 2·73 + 3·75 + 4·73 + 5·80 + 6·69 + 7·68 + 8·73 + 9·65 = 3281; 3281 mod 103 = 88
+		{codigo: "Wikipedia", inicio: inicioB, simbolos: 9, verificador: "11110010010"},
+		// Conjunto C: 105 + 1·12 + 2·34 + 3·56 = 353; 353 mod 103 = 44
+		{codigo: "123456", inicio: inicioC, simbolos: 3, verificador: "10001101110"},
+		// Conjunto B con el espacio (valor 0): 104 + 1·33 + 2·0 + 3·34 = 239; 239 mod 103 = 33
+		{codigo: "A B", inicio: inicioB, simbolos: 3, verificador: "10100011000"},
+	}
+	for _, c := range casos {
+		modulos := textoModulos(modulosCode128(c.codigo))
+		largo := (c.simbolos+2)*11 + len(fin)
+		if len(modulos) != largo {
+			t.Errorf("%s: se esperaban %d módulos y se obtuvieron %d", c.codigo, largo, len(modulos))
+			continue
+		}
+		if !strings.HasPrefix(modulos, c.inicio) {
+			t.Errorf("%s: inicio %s, se esperaba %s", c.codigo, modulos[:11], c.inicio)
+		}
+		if !strings.HasSuffix(modulos, fin) {
+			t.Errorf("%s: fin %s, se esperaba %s", c.codigo, modulos[len(modulos)-len(fin):], fin)
+		}
+		verificador := modulos[len(modulos)-len(fin)-11 : len(modulos)-len(fin)]
+		if verificador != c.verificador {
+			t.Errorf("%s: dígito verificador %s, se esperaba %s", c.codigo, verificador, c.verificador)
+		}
+	}
+}
+
+func TestAnchosCode128(t *testing.T) {
+	vistos := make(map[string]bool, len(anchosCode128))
+	for valor, anchos := range anchosCode128 {
+		total, barras := 0, 0
+		for i, a := range anchos {
+			total += int(a - '0')
+			if i%2 == 0 {
+				barras += int(a - '0')
+			}
+		}
+		// Cada símbolo mide 11 módulos y sus barras suman un número par
+		if total != 11 || barras%2 != 0 {
+			t.Errorf("símbolo %d (%s): %d módulos, %d de barra", valor, anchos, total, barras)
+		}
+		if vistos[anchos] {
+			t.Errorf("símbolo %d (%s) repetido", valor, anchos)
+		}
+		vistos[anchos] = true
+	}
+}
@@ -1,11 +1,13 @@
 package Controllers
 
 import (
+	modelos "backend-inventario/api/Models"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -277,6 +279,36 @@ func generarPDFEstructurado(pdf *gofpdf.Fpdf, tr func(string) string, despacho *
 		pdf.CellFormat(25, 8, fmt.Sprintf("%.2f", item.PesoTotal), "", 0, "R", true, 0, "")
 		pdf.CellFormat(25, 8, fmt.Sprintf("$%.2f", item.Precio), "", 0, "R", true, 0, "")
 		pdf.CellFormat(30, 8, fmt.Sprintf("$%.2f", item.PrecioTotal), "", 1, "R", true, 0, "")
+
+		// Lotes despachados del producto, debajo de su fila
+		if len(item.Lotes) > 0 {
+			pdf.SetFont("Arial", "I", 7)
+			pdf.CellFormat(15, 5, "", "", 0, "C", true, 0, "")
+			pdf.CellFormat(175, 5, tr(textoLotes(item.Lotes)), "", 1, "L", true, 0, "")
+			pdf.SetFont("Arial", "", 9)
+		}
+		if len(item.Series) > 0 {
+			pdf.SetFont("Arial", "I", 7)
+			pdf.CellFormat(15, 5, "", "", 0, "C", true, 0, "")
+			pdf.MultiCell(175, 5, tr(textoSeries(item.Series)), "", "L", true)
+			pdf.SetFont("Arial", "", 9)
+		}
+		// Componentes de los kits, cada uno con sus lotes y series
+		for _, comp := range item.Componentes {
+			pdf.SetFont("Arial", "I", 8)
+			pdf.CellFormat(15, 5, "", "", 0, "C", true, 0, "")
+			pdf.CellFormat(175, 5, tr(fmt.Sprintf("- %s %s x %d", comp.SKU, comp.Nombre, comp.Cantidad)), "", 1, "L", true, 0, "")
+			pdf.SetFont("Arial", "I", 7)
+			if len(comp.Lotes) > 0 {
+				pdf.CellFormat(20, 5, "", "", 0, "C", true, 0, "")
+				pdf.CellFormat(170, 5, tr(textoLotes(comp.Lotes)), "", 1, "L", true, 0, "")
+			}
+			if len(comp.Series) > 0 {
+				pdf.CellFormat(20, 5, "", "", 0, "C", true, 0, "")
+				pdf.MultiCell(170, 5, tr(textoSeries(comp.Series)), "", "L", true)
+			}
+			pdf.SetFont("Arial", "", 9)
+		}
 	}
 
 	// 7. Línea bajo la tabla
@@ -381,6 +413,28 @@ func generarPDFEstructurado(pdf *gofpdf.Fpdf, tr func(string) string, despacho *
 	return nil
 }
 
+// textoLotes resume los lotes de una línea de despacho para la guía: número, cantidad y vencimiento
+func textoLotes(lotes []modelos.ProductosDespachoLote) string {
+	partes := make([]string, 0, len(lotes))
+	for _, l := range lotes {
+		texto := fmt.Sprintf("%s (%d)", l.Lote, l.Cantidad)
+		if l.FechaVencimiento != nil {
+			texto = fmt.Sprintf("%s (%d, vence %s)", l.Lote, l.Cantidad, formatDate(*l.FechaVencimiento))
+		}
+		partes = append(partes, texto)
+	}
+	return "Lotes: " + strings.Join(partes, "; ")
+}
+
+// textoSeries lista los números de serie despachados en una línea
+func textoSeries(series []modelos.ProductosDespachoSerie) string {
+	numeros := make([]string, 0, len(series))
+	for _, s := range series {
+		numeros = append(numeros, s.Serie)
+	}
+	return "Series: " + strings.Join(numeros, ", ")
+}
+
 func generarPieDespacho(pdf *gofpdf.Fpdf, tr func(string) string, despacho *DespachoConTotales) {
 	pageWidth, pageHeight := pdf.GetPageSize()
 
@@ -0,0 +1,7 @@
+package Controllers
+
+import "errors"
+
+// ErrConflictoVersion indica que el registro cambió desde que el cliente lo leyó
+// (la versión enviada en If-Match ya no es la vigente)
+var ErrConflictoVersion = errors.New("el registro fue modificado por otro usuario; vuelva a cargarlo e intente nuevamente")
@@ -0,0 +1,264 @@
+package Controllers
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Lectura y escritura mínima de planillas XLSX (Office Open XML): solo la primera hoja,
+// con textos y números, que es lo que necesitan las cargas y descargas de stock.
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		RID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelaciones struct {
+	Relaciones []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxTextoRico struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxTextoRico) texto() string {
+	if len(t.R) == 0 {
+		return t.T
+	}
+	var sb strings.Builder
+	for _, r := range t.R {
+		sb.WriteString(r.T)
+	}
+	return sb.String()
+}
+
+type xlsxHoja struct {
+	Filas []struct {
+		Celdas []struct {
+			Ref    string        `xml:"r,attr"`
+			Tipo   string        `xml:"t,attr"`
+			Valor  string        `xml:"v"`
+			Inline xlsxTextoRico `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// leerXLSX devuelve las celdas de la primera hoja como texto, fila por fila
+func leerXLSX(r io.ReaderAt, size int64) ([][]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.New("el archivo no es un XLSX válido")
+	}
+	archivos := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		archivos[f.Name] = f
+	}
+
+	hojaPath, err := primeraHojaXLSX(archivos)
+	if err != nil {
+		return nil, err
+	}
+
+	var compartidos []string
+	if f, ok := archivos["xl/sharedStrings.xml"]; ok {
+		var sst struct {
+			Items []xlsxTextoRico `xml:"si"`
+		}
+		if err := decodificarXML(f, &sst); err != nil {
+			return nil, err
+		}
+		compartidos = make([]string, len(sst.Items))
+		for i, it := range sst.Items {
+			compartidos[i] = it.texto()
+		}
+	}
+
+	f, ok := archivos[hojaPath]
+	if !ok {
+		return nil, errors.New("el XLSX no contiene hojas")
+	}
+	var hoja xlsxHoja
+	if err := decodificarXML(f, &hoja); err != nil {
+		return nil, err
+	}
+
+	filas := make([][]string, 0, len(hoja.Filas))
+	for _, fila := range hoja.Filas {
+		var valores []string
+		for i, celda := range fila.Celdas {
+			col := i
+			if celda.Ref != "" {
+				col = columnaXLSX(celda.Ref)
+			}
+			var valor string
+			switch celda.Tipo {
+			case "s":
+				idx, err := strconv.Atoi(celda.Valor)
+				if err != nil || idx < 0 || idx >= len(compartidos) {
+					return nil, fmt.Errorf("celda %s con texto compartido inválido", celda.Ref)
+				}
+				valor = compartidos[idx]
+			case "inlineStr":
+				valor = celda.Inline.texto()
+			default:
+				valor = celda.Valor
+			}
+			for len(valores) <= col {
+				valores = append(valores, "")
+			}
+			valores[col] = valor
+		}
+		filas = append(filas, valores)
+	}
+	return filas, nil
+}
+
+func primeraHojaXLSX(archivos map[string]*zip.File) (string, error) {
+	const porDefecto = "xl/worksheets/sheet1.xml"
+	wb, ok := archivos["xl/workbook.xml"]
+	rels, okRels := archivos["xl/_rels/workbook.xml.rels"]
+	if !ok || !okRels {
+		return porDefecto, nil
+	}
+	var libro xlsxWorkbook
+	if err := decodificarXML(wb, &libro); err != nil {
+		return "", err
+	}
+	var relaciones xlsxRelaciones
+	if err := decodificarXML(rels, &relaciones); err != nil {
+		return "", err
+	}
+	if len(libro.Sheets) == 0 {
+		return "", errors.New("el XLSX no contiene hojas")
+	}
+	for _, r := range relaciones.Relaciones {
+		if r.ID == libro.Sheets[0].RID {
+			if strings.HasPrefix(r.Target, "/") {
+				return strings.TrimPrefix(r.Target, "/"), nil
+			}
+			return path.Join("xl", r.Target), nil
+		}
+	}
+	return porDefecto, nil
+}
+
+func decodificarXML(f *zip.File, destino interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(destino); err != nil {
+		return fmt.Errorf("error al leer %s: %v", f.Name, err)
+	}
+	return nil
+}
+
+// columnaXLSX convierte la referencia de celda (ej. "AB12") en el índice de columna desde 0
+func columnaXLSX(ref string) int {
+	col := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = col*26 + int(ch-'A'+1)
+	}
+	return col - 1
+}
+
+func nombreColumnaXLSX(col int) string {
+	nombre := ""
+	for col >= 0 {
+		nombre = string(rune('A'+col%26)) + nombre
+		col = col/26 - 1
+	}
+	return nombre
+}
+
+// escribirXLSX genera un libro con una sola hoja. Los valores numéricos se guardan como
+// números y el resto como texto
+func escribirXLSX(w io.Writer, nombreHoja string, filas [][]interface{}) error {
+	zw := zip.NewWriter(w)
+
+	fijos := []struct{ nombre, contenido string }{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`},
+	}
+	for _, f := range fijos {
+		fw, err := zw.Create(f.nombre)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, f.contenido); err != nil {
+			return err
+		}
+	}
+
+	fw, err := zw.Create("xl/workbook.xml")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(fw, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`, escaparXML(nombreHoja))
+
+	fw, err = zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sb.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, fila := range filas {
+		fmt.Fprintf(&sb, `<row r="%d">`, i+1)
+		for j, valor := range fila {
+			ref := fmt.Sprintf("%s%d", nombreColumnaXLSX(j), i+1)
+			switch v := valor.(type) {
+			case int, int64, uint, float64:
+				fmt.Fprintf(&sb, `<c r="%s"><v>%v</v></c>`, ref, v)
+			default:
+				fmt.Fprintf(&sb, `<c r="%s" t="inlineStr"><is><t>%s</t></is></c>`, ref, escaparXML(fmt.Sprint(v)))
+			}
+		}
+		sb.WriteString(`</row>`)
+	}
+	sb.WriteString(`</sheetData></worksheet>`)
+	if _, err := io.WriteString(fw, sb.String()); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func escaparXML(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
@@ -0,0 +1,155 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetCategoriasHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categorias, err := Controllers.GetCategorias(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener categorías", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, categorias)
+	}
+}
+
+// GetArbolCategoriasHandler devuelve el árbol de categorías con sus conteos de productos; ?raiz
+// limita el resultado al subárbol de esa categoría
+func GetArbolCategoriasHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raizID, err := parseIDQuery(c, "raiz")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		arbol, err := Controllers.GetArbolCategorias(db, raizID)
+		if errors.Is(err, Controllers.ErrCategoriaNoEncontrada) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Categoría no encontrada"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener el árbol de categorías", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, arbol)
+	}
+}
+
+func GetCategoriaByIDHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		categoria, err := Controllers.GetCategoriaByID(db, uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Categoría no encontrada", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, categoria)
+	}
+}
+
+func CreateCategoriaHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var nueva modelos.Categoria
+		if err := c.ShouldBindJSON(&nueva); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		if err := Controllers.CreateCategoria(db, &nueva); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo crear la categoría", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, nueva)
+	}
+}
+
+func UpdateCategoriaHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		var actualizada modelos.Categoria
+		if err := c.ShouldBindJSON(&actualizada); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		categoria, err := Controllers.UpdateCategoria(db, uint(id), &actualizada)
+		if errors.Is(err, Controllers.ErrCategoriaNoEncontrada) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Categoría no encontrada"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo actualizar la categoría", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, categoria)
+	}
+}
+
+// MoverCategoriaHandler cambia el padre de una categoría y su subárbol; padre_id nulo la deja como raíz
+func MoverCategoriaHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		var body struct {
+			PadreID *uint `json:"padre_id"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		categoria, err := Controllers.MoverCategoria(db, uint(id), body.PadreID)
+		if errors.Is(err, Controllers.ErrCategoriaNoEncontrada) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Categoría no encontrada"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo mover la categoría", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, categoria)
+	}
+}
+
+// DeleteCategoriaHandler elimina una categoría; ?productos=padre (por defecto) pasa sus productos a
+// la categoría padre y ?productos=nulo los deja sin categoría
+func DeleteCategoriaHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		err = Controllers.DeleteCategoria(db, uint(id), c.Query("productos"))
+		if errors.Is(err, Controllers.ErrCategoriaNoEncontrada) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Categoría no encontrada"})
+			return
+		}
+		if errors.Is(err, Controllers.ErrCategoriaConPromociones) {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo eliminar la categoría", "details": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo eliminar la categoría", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,58 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetCierresInventarioHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cierres, err := Controllers.GetCierresInventario(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener cierres de inventario", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, cierres)
+	}
+}
+
+func GetCierreInventarioByIDHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		cierre, err := Controllers.GetCierreInventarioByID(db, uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Cierre de inventario no encontrado", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, cierre)
+	}
+}
+
+// CreateCierreInventarioHandler cierra el mes indicado en el body como {"periodo": "AAAA-MM"}
+func CreateCierreInventarioHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Periodo string `json:"periodo" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+
+		cierre, err := Controllers.CreateCierreInventario(db, body.Periodo, usuarioRequest(c))
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo cerrar el período", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, cierre)
+	}
+}
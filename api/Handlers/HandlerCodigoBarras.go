@@ -0,0 +1,118 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	modelos "backend-inventario/api/Models"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetCodigosBarrasProductoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		codigos, err := Controllers.GetCodigosBarrasProducto(db, c.Param("sku"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, codigos)
+	}
+}
+
+// CreateCodigoBarrasHandler agrega un código EAN13 o CODE128; sin tipo se deduce del código
+func CreateCodigoBarrasHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var nuevo modelos.CodigoBarras
+		if err := c.ShouldBindJSON(&nuevo); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		if err := Controllers.CreateCodigoBarras(db, c.Param("sku"), &nuevo); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo agregar el código de barras", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, nuevo)
+	}
+}
+
+func MarcarCodigoBarrasPrincipalHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		codigo, err := Controllers.MarcarCodigoBarrasPrincipal(db, c.Param("sku"), uint(id))
+		if errors.Is(err, Controllers.ErrCodigoBarrasNoEncontrado) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Código de barras no encontrado"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar el código de barras", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, codigo)
+	}
+}
+
+func DeleteCodigoBarrasHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		err = Controllers.DeleteCodigoBarras(db, c.Param("sku"), uint(id))
+		if errors.Is(err, Controllers.ErrCodigoBarrasNoEncontrado) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Código de barras no encontrado"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al eliminar el código de barras", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+// GetProductoPorCodigoBarrasHandler busca el producto de un código escaneado
+func GetProductoPorCodigoBarrasHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		producto, err := Controllers.GetProductoPorCodigoBarras(db, c.Param("code"))
+		if errors.Is(err, Controllers.ErrCodigoBarrasNoEncontrado) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ningún producto tiene ese código de barras"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al buscar el código de barras", "details": err.Error()})
+			return
+		}
+		escribirETag(c, producto.Version)
+		c.JSON(http.StatusOK, producto)
+	}
+}
+
+// GenerarEtiquetasHandler descarga en PDF las hojas de etiquetas de los productos indicados
+func GenerarEtiquetasHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var solicitud Controllers.SolicitudEtiquetas
+		if err := c.ShouldBindJSON(&solicitud); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		// Se arma completo antes de responder para poder informar los errores como JSON
+		var pdf bytes.Buffer
+		if err := Controllers.GenerarEtiquetasProductos(db, solicitud, &pdf); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudieron generar las etiquetas", "details": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=etiquetas_%s.pdf", time.Now().Format("20060102")))
+		c.Data(http.StatusOK, "application/pdf", pdf.Bytes())
+	}
+}
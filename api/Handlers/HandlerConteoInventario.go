@@ -0,0 +1,155 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetConteosInventarioHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var sucursalID uint64
+		if valor := c.Query("sucursal_id"); valor != "" {
+			id, err := strconv.ParseUint(valor, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+				return
+			}
+			sucursalID = id
+		}
+
+		conteos, err := Controllers.GetConteosInventario(db, uint(sucursalID), c.Query("estado"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener conteos de inventario", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, conteos)
+	}
+}
+
+func GetConteoInventarioByIDHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		conteo, err := Controllers.GetConteoInventarioByID(db, uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conteo de inventario no encontrado", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, conteo)
+	}
+}
+
+func CreateConteoInventarioHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var nuevo Controllers.NuevoConteo
+		if err := c.ShouldBindJSON(&nuevo); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+
+		conteo, err := Controllers.CreateConteoInventario(db, nuevo, usuarioRequest(c))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo abrir el conteo de inventario", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, conteo)
+	}
+}
+
+func RegistrarConteoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		var req struct {
+			Contador string                       `json:"contador"`
+			Lineas   []Controllers.LineaRecepcion `json:"lineas" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		// Si no se indica el contador se usa el usuario que hace la petición
+		if req.Contador == "" {
+			req.Contador = usuarioRequest(c)
+		}
+
+		conteo, err := Controllers.RegistrarConteo(db, uint(id), req.Contador, req.Lineas)
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo registrar el conteo", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, conteo)
+	}
+}
+
+func GetDiferenciasConteoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		reporte, err := Controllers.GetDiferenciasConteo(db, uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conteo de inventario no encontrado", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, reporte)
+	}
+}
+
+func AprobarConteoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		// motivo se aplica a todas las diferencias; motivos permite indicarlo por SKU
+		var req struct {
+			Motivo  string            `json:"motivo"`
+			Motivos map[string]string `json:"motivos"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+
+		reporte, err := Controllers.AprobarConteo(db, uint(id), req.Motivo, req.Motivos, usuarioRequest(c))
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo aprobar el conteo", "details": err.Error(), "motivos_validos": Controllers.MotivosAjusteConteo})
+			return
+		}
+		c.JSON(http.StatusOK, reporte)
+	}
+}
+
+func CancelarConteoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		if err := Controllers.CancelarConteo(db, uint(id)); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo cancelar el conteo", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Conteo cancelado correctamente"})
+	}
+}
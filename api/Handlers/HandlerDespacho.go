@@ -3,6 +3,7 @@ package Handlers
 import (
 	"backend-inventario/api/Controllers"
 	modelos "backend-inventario/api/Models"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -26,7 +27,15 @@ func CreateDespachoHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		if err := Controllers.CreateDespacho(db, &request.Despacho, request.Productos); err != nil {
+		validacion, err := Controllers.CreateDespacho(db, &request.Despacho, request.Productos, usuarioRequest(c))
+		if errors.Is(err, Controllers.ErrStockInsuficiente) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":     err.Error(),
+				"faltantes": validacion.Faltantes,
+			})
+			return
+		}
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error":   "No se pudo registrar el despacho.",
 				"details": err.Error(),
@@ -41,6 +50,31 @@ func CreateDespachoHandler(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
+// ValidarDespachoHandler revisa si la sucursal de origen cubre todas las líneas de un despacho,
+// sin registrar nada. Recibe el mismo cuerpo que la creación del despacho
+func ValidarDespachoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request DespachoRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Los datos del despacho enviados no son válidos.",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		validacion, err := Controllers.ValidarDespacho(db, &request.Despacho, request.Productos)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "No se pudo validar el despacho.",
+				"details": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, validacion)
+	}
+}
+
 func GetDespachosHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		despachos, err := Controllers.GetDespachos(db)
@@ -72,6 +106,7 @@ func GetDespachoByIDHandler(db *gorm.DB) gin.HandlerFunc {
 			})
 			return
 		}
+		escribirETag(c, despacho.Version)
 		c.JSON(http.StatusOK, despacho)
 	}
 }
@@ -85,6 +120,11 @@ func UpdateDespachoHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		version, ok := versionIfMatch(c)
+		if !ok {
+			return
+		}
+
 		var actualizado modelos.Despacho
 		if err := c.ShouldBindJSON(&actualizado); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -94,13 +134,21 @@ func UpdateDespachoHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		if err := Controllers.UpdateDespacho(db, uint(id), &actualizado); err != nil {
+		if err := Controllers.UpdateDespacho(db, uint(id), &actualizado, version); err != nil {
+			if errors.Is(err, Controllers.ErrConflictoVersion) {
+				c.JSON(http.StatusPreconditionFailed, gin.H{
+					"error":   "El despacho fue modificado por otro usuario.",
+					"details": err.Error(),
+				})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error":   "No se pudo actualizar el despacho.",
 				"details": err.Error(),
 			})
 			return
 		}
+		escribirETag(c, actualizado.Version)
 		c.JSON(http.StatusOK, gin.H{"message": "Despacho actualizado exitosamente"})
 	}
 }
@@ -114,7 +162,7 @@ func DeleteDespachoHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		if err := Controllers.DeleteDespacho(db, uint(id)); err != nil {
+		if err := Controllers.DeleteDespacho(db, uint(id), usuarioRequest(c)); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error":   "No se pudo eliminar el despacho.",
 				"details": err.Error(),
@@ -0,0 +1,77 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DisponibilidadRequest es la consulta por lote de SKU
+type DisponibilidadRequest struct {
+	SKUs         []string `json:"skus" binding:"required,min=1,max=500,dive,required"`
+	DirClienteID uint     `json:"dir_cliente_id"`
+}
+
+func GetDisponibilidadHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var dirClienteID uint64
+		if valor := c.Query("dir_cliente_id"); valor != "" {
+			id, err := strconv.ParseUint(valor, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+				return
+			}
+			dirClienteID = id
+		}
+
+		disponibilidad, noEncontrados, err := Controllers.GetDisponibilidad(db, []string{c.Param("sku")}, uint(dirClienteID))
+		if errorDisponibilidad(c, err) {
+			return
+		}
+		if len(noEncontrados) > 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado"})
+			return
+		}
+		c.JSON(http.StatusOK, disponibilidad[0])
+	}
+}
+
+func GetDisponibilidadLoteHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request DisponibilidadRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+
+		disponibilidad, noEncontrados, err := Controllers.GetDisponibilidad(db, request.SKUs, request.DirClienteID)
+		if errorDisponibilidad(c, err) {
+			return
+		}
+		if noEncontrados == nil {
+			noEncontrados = []string{}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"productos":      disponibilidad,
+			"no_encontrados": noEncontrados,
+		})
+	}
+}
+
+// errorDisponibilidad responde el error de la consulta, si lo hay: 400 si la consulta no es válida
+// y 500 en cualquier otro caso
+func errorDisponibilidad(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	status := http.StatusInternalServerError
+	if errors.Is(err, Controllers.ErrSinSKUs) || errors.Is(err, Controllers.ErrDirClienteNoEncontrada) {
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, gin.H{"error": "Error al consultar disponibilidad", "details": err.Error()})
+	return true
+}
@@ -0,0 +1,144 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	"backend-inventario/services"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetImagenesProductoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		imagenes, err := Controllers.GetImagenesProducto(db, c.Param("sku"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, imagenes)
+	}
+}
+
+// SubirImagenProductoHandler recibe la imagen en el campo "imagen" de un formulario multipart
+func SubirImagenProductoHandler(db *gorm.DB, almacen services.Almacenamiento) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		archivo, err := c.FormFile("imagen")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Debe adjuntar la imagen en el campo 'imagen'", "details": err.Error()})
+			return
+		}
+		if archivo.Size > Controllers.TamanoMaximoImagen {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("La imagen supera el máximo de %d MB", Controllers.TamanoMaximoImagen>>20)})
+			return
+		}
+		f, err := archivo.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo leer la imagen", "details": err.Error()})
+			return
+		}
+		defer f.Close()
+		contenido, err := io.ReadAll(io.LimitReader(f, Controllers.TamanoMaximoImagen+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo leer la imagen", "details": err.Error()})
+			return
+		}
+
+		imagen, err := Controllers.SubirImagenProducto(db, almacen, c.Param("sku"), archivo.Filename, contenido, usuarioRequest(c))
+		if errors.Is(err, Controllers.ErrImagenInvalida) {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Formato de imagen no soportado", "details": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo guardar la imagen", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, imagen)
+	}
+}
+
+// OrdenarImagenesProductoHandler recibe {"ids": [...]} con todas las imágenes del producto en el orden deseado
+func OrdenarImagenesProductoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			IDs []uint `json:"ids" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		imagenes, err := Controllers.OrdenarImagenesProducto(db, c.Param("sku"), body.IDs)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo ordenar las imágenes", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, imagenes)
+	}
+}
+
+func DeleteImagenProductoHandler(db *gorm.DB, almacen services.Almacenamiento) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		if err := Controllers.DeleteImagenProducto(db, almacen, c.Param("sku"), uint(id)); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No se pudo eliminar la imagen", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+// ServirImagenProductoHandler entrega el archivo de la imagen, o su miniatura. El contenido de una
+// imagen no cambia nunca (reemplazarla crea otra con otro ID), así que se puede cachear indefinidamente
+func ServirImagenProductoHandler(db *gorm.DB, almacen services.Almacenamiento, miniatura bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		// La imagen se busca antes de revisar la caché, para que una eliminada o de otro SKU dé 404
+		imagen, err := Controllers.GetImagenProducto(db, c.Param("sku"), uint(id))
+		if errors.Is(err, Controllers.ErrImagenNoEncontrada) {
+			c.Header("Cache-Control", "no-store")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Imagen no encontrada"})
+			return
+		}
+		if err != nil {
+			c.Header("Cache-Control", "no-store")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al buscar la imagen", "details": err.Error()})
+			return
+		}
+
+		etag := fmt.Sprintf("\"imagen-%d\"", id)
+		if miniatura {
+			etag = fmt.Sprintf("\"imagen-%d-min\"", id)
+		}
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		c.Header("ETag", etag)
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		contenido, tipo, err := Controllers.LeerImagenProducto(almacen, imagen, miniatura)
+		if errors.Is(err, Controllers.ErrImagenNoEncontrada) {
+			c.Header("Cache-Control", "no-store")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Imagen no encontrada"})
+			return
+		}
+		if err != nil {
+			c.Header("Cache-Control", "no-store")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al leer la imagen", "details": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, tipo, contenido)
+	}
+}
@@ -0,0 +1,124 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// tamanoMaximoImportacion limita el archivo de carga masiva a 10 MB
+const tamanoMaximoImportacion = 10 << 20
+
+// ImportarStockHandler recibe el archivo en el campo "archivo". Sin ?aplicar=true solo
+// devuelve la vista previa; con aplicar=true escribe todo o nada
+func ImportarStockHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		archivo, err := c.FormFile("archivo")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Debe adjuntar el archivo en el campo 'archivo'", "details": err.Error()})
+			return
+		}
+		if archivo.Size > tamanoMaximoImportacion {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "El archivo supera el máximo de 10 MB"})
+			return
+		}
+		aplicar, err := strconv.ParseBool(c.DefaultQuery("aplicar", "false"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parámetro 'aplicar' inválido"})
+			return
+		}
+
+		f, err := archivo.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo leer el archivo", "details": err.Error()})
+			return
+		}
+		defer f.Close()
+		contenido, err := io.ReadAll(f)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo leer el archivo", "details": err.Error()})
+			return
+		}
+
+		filas, formato, err := Controllers.LeerArchivoStock(archivo.Filename, contenido)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Archivo inválido", "details": err.Error()})
+			return
+		}
+
+		if !aplicar {
+			resultado, err := Controllers.ValidarImportacionStock(db, archivo.Filename, formato, filas)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al validar el archivo", "details": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, resultado)
+			return
+		}
+
+		resultado, err := Controllers.ImportarStock(db, archivo.Filename, formato, filas, usuarioRequest(c))
+		if errors.Is(err, Controllers.ErrImportacionConErrores) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "resultado": resultado})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al aplicar la importación", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, resultado)
+	}
+}
+
+func GetImportacionesStockHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		importaciones, err := Controllers.GetImportacionesStock(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener importaciones de stock", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, importaciones)
+	}
+}
+
+// ExportarStockHandler descarga el stock vigente en ?formato=csv (por defecto) o xlsx
+func ExportarStockHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		formato := c.DefaultQuery("formato", Controllers.FormatoCSV)
+		if formato != Controllers.FormatoCSV && formato != Controllers.FormatoXLSX {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Formato inválido, use csv o xlsx"})
+			return
+		}
+		var sucursalID uint64
+		if valor := c.Query("sucursal_id"); valor != "" {
+			id, err := strconv.ParseUint(valor, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+				return
+			}
+			sucursalID = id
+		}
+
+		filas, err := Controllers.GetFilasExportacionStock(db, uint(sucursalID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al exportar stock", "details": err.Error()})
+			return
+		}
+
+		contentType := "text/csv; charset=utf-8"
+		if formato == Controllers.FormatoXLSX {
+			contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		}
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=stock_%s.%s", time.Now().Format("20060102"), formato))
+		if err := Controllers.EscribirExportacionStock(c.Writer, formato, filas); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo generar el archivo"})
+		}
+	}
+}
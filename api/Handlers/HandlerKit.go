@@ -0,0 +1,40 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	modelos "backend-inventario/api/Models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetComponentesKitHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		componentes, err := Controllers.GetComponentesKit(db, c.Param("sku"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No se pudo obtener la lista de materiales", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, componentes)
+	}
+}
+
+// SetComponentesKitHandler reemplaza la lista de materiales del producto; una lista vacía lo deja de tratar como kit
+func SetComponentesKitHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Componentes []modelos.KitComponente `json:"componentes"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		componentes, err := Controllers.SetComponentesKit(db, c.Param("sku"), body.Componentes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo guardar la lista de materiales", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, componentes)
+	}
+}
@@ -0,0 +1,60 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetLotesStockHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sku := c.Param("sku")
+		sucursalID, err := strconv.ParseUint(c.Param("sucursal_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		lotes, err := Controllers.GetLotesStock(db, sku, uint(sucursalID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener lotes", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, lotes)
+	}
+}
+
+// GetLotesPorVencerHandler lista los lotes que vencen en los próximos ?dias (30 por defecto)
+func GetLotesPorVencerHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dias := 30
+		if valor := c.Query("dias"); valor != "" {
+			d, err := strconv.Atoi(valor)
+			if err != nil || d < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Cantidad de días inválida"})
+				return
+			}
+			dias = d
+		}
+
+		var sucursalID uint64
+		if valor := c.Query("sucursal_id"); valor != "" {
+			id, err := strconv.ParseUint(valor, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+				return
+			}
+			sucursalID = id
+		}
+
+		lotes, err := Controllers.GetLotesPorVencer(db, dias, uint(sucursalID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener lotes por vencer", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, lotes)
+	}
+}
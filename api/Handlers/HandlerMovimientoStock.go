@@ -0,0 +1,135 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	modelos "backend-inventario/api/Models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// usuarioRequest obtiene el email del usuario que realiza la operación desde el header X-Usuario
+func usuarioRequest(c *gin.Context) string {
+	return c.GetHeader("X-Usuario")
+}
+
+// parseFechaQuery lee un parámetro de fecha (YYYY-MM-DD) opcional de la query
+func parseFechaQuery(c *gin.Context, nombre string) (*time.Time, error) {
+	valor := c.Query(nombre)
+	if valor == "" {
+		return nil, nil
+	}
+	fecha, err := time.ParseInLocation("2006-01-02", valor, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &fecha, nil
+}
+
+// parseIDQuery lee un parámetro de ID opcional de la query; 0 si no viene
+func parseIDQuery(c *gin.Context, nombre string) (uint, error) {
+	valor := c.Query(nombre)
+	if valor == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(valor, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
+
+func GetKardexHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sku := c.Param("sku")
+		sucursalID, err := strconv.ParseUint(c.Param("sucursal_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		desde, err := parseFechaQuery(c, "desde")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha 'desde' inválida, use el formato YYYY-MM-DD"})
+			return
+		}
+		hasta, err := parseFechaQuery(c, "hasta")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha 'hasta' inválida, use el formato YYYY-MM-DD"})
+			return
+		}
+		if hasta != nil {
+			// La fecha 'hasta' es inclusiva
+			fin := hasta.AddDate(0, 0, 1)
+			hasta = &fin
+		}
+
+		kardex, err := Controllers.GetKardex(db, uint(sucursalID), sku, desde, hasta)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener movimientos de stock", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, kardex)
+	}
+}
+
+func CreateMovimientoStockHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sku := c.Param("sku")
+		sucursalID, err := strconv.ParseUint(c.Param("sucursal_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		var req struct {
+			Tipo       string `json:"tipo" binding:"required"`
+			Cantidad   int    `json:"cantidad" binding:"required"`
+			Referencia string `json:"referencia"`
+
+			// Lote que ingresa o del que se saca, para productos que manejan lotes
+			Lote             string     `json:"lote"`
+			FechaFabricacion *time.Time `json:"fecha_fabricacion"`
+			FechaVencimiento *time.Time `json:"fecha_vencimiento"`
+
+			// Series de las unidades que entran o salen, para productos serializados
+			Series []string `json:"series"`
+
+			// Costo unitario de lo que ingresa; si no se indica se usa el promedio vigente
+			CostoUnitario float64 `json:"costo_unitario" binding:"min=0"`
+
+			// Unidad en que vienen la cantidad y el costo (pallet, caja); por defecto la unidad base
+			Unidad string `json:"unidad"`
+
+			// Ubicación a la que entra o de la que sale; por defecto recepción o el recorrido de picking
+			UbicacionID *uint `json:"ubicacion_id"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+
+		mov := modelos.MovimientoStock{
+			SKU:              sku,
+			SucursalID:       uint(sucursalID),
+			Tipo:             req.Tipo,
+			Cantidad:         req.Cantidad,
+			Usuario:          usuarioRequest(c),
+			Referencia:       req.Referencia,
+			Lote:             req.Lote,
+			FechaFabricacion: req.FechaFabricacion,
+			FechaVencimiento: req.FechaVencimiento,
+			NumerosSerie:     req.Series,
+			CostoUnitario:    req.CostoUnitario,
+			UbicacionID:      req.UbicacionID,
+		}
+		if err := Controllers.CreateMovimientoStockEnUnidad(db, &mov, req.Unidad); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo registrar el movimiento de stock", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, mov)
+	}
+}
@@ -0,0 +1,155 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	modelos "backend-inventario/api/Models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetOrdenesCompraHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var proveedorID uint64
+		if valor := c.Query("proveedor_id"); valor != "" {
+			id, err := strconv.ParseUint(valor, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ID de proveedor inválido"})
+				return
+			}
+			proveedorID = id
+		}
+
+		ordenes, err := Controllers.GetOrdenesCompra(db, c.Query("estado"), uint(proveedorID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener órdenes de compra", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ordenes)
+	}
+}
+
+func GetOrdenCompraByIDHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		orden, err := Controllers.GetOrdenCompraByID(db, uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Orden de compra no encontrada", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, orden)
+	}
+}
+
+func CreateOrdenCompraHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var nueva modelos.OrdenCompra
+		if err := c.ShouldBindJSON(&nueva); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		nueva.Usuario = usuarioRequest(c)
+
+		if err := Controllers.CreateOrdenCompra(db, &nueva); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo crear la orden de compra", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, nueva)
+	}
+}
+
+func UpdateOrdenCompraHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		var actualizada modelos.OrdenCompra
+		if err := c.ShouldBindJSON(&actualizada); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+
+		orden, err := Controllers.UpdateOrdenCompra(db, uint(id), &actualizada)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo actualizar la orden de compra", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, orden)
+	}
+}
+
+func DeleteOrdenCompraHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		if err := Controllers.DeleteOrdenCompra(db, uint(id)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo eliminar la orden de compra", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+// CambiarEstadoOrdenCompraHandler emite o cancela una orden de compra según el estado indicado
+func CambiarEstadoOrdenCompraHandler(db *gorm.DB, estado string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		orden, err := Controllers.CambiarEstadoOrdenCompra(db, uint(id), estado)
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo cambiar el estado de la orden de compra", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, orden)
+	}
+}
+
+func RecibirOrdenCompraHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		var req struct {
+			Lineas      []Controllers.LineaRecepcion `json:"lineas"`
+			Cerrar      bool                         `json:"cerrar"`
+			Observacion string                       `json:"observacion"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+
+		if err := Controllers.ValidarLineasRecepcion(req.Lineas); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+
+		orden, err := Controllers.RecibirOrdenCompra(db, uint(id), req.Lineas, req.Cerrar, req.Observacion, usuarioRequest(c))
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo registrar la recepción", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, orden)
+	}
+}
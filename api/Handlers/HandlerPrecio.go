@@ -0,0 +1,130 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	modelos "backend-inventario/api/Models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetHistorialPreciosHandler lista los tramos de precio de un producto, incluidos los programados
+func GetHistorialPreciosHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		historial, err := Controllers.GetHistorialPrecios(db, c.Param("sku"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, historial)
+	}
+}
+
+// GetPrecioEnFechaHandler entrega el precio de lista vigente en ?fecha (por defecto, ahora)
+func GetPrecioEnFechaHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fecha, err := parseMomentoQuery(c, "fecha")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha inválida"})
+			return
+		}
+		precio, err := Controllers.GetPrecioEnFecha(db, c.Param("sku"), fecha)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, precio)
+	}
+}
+
+// GetCambiosPrecioHandler lista los cambios de precio; ?estado filtra por programado, aplicado o cancelado
+func GetCambiosPrecioHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cambios, err := Controllers.GetCambiosPrecio(db, c.Query("estado"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener cambios de precio", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, cambios)
+	}
+}
+
+func GetCambioPrecioByIDHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		cambio, err := Controllers.GetCambioPrecioByID(db, uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Cambio de precio no encontrado"})
+			return
+		}
+		c.JSON(http.StatusOK, cambio)
+	}
+}
+
+// ProgramarCambioPrecioHandler registra un cambio de precio para un SKU, una categoría o un
+// proveedor; sin vigente_desde se aplica de inmediato
+func ProgramarCambioPrecioHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var cambio modelos.CambioPrecio
+		if err := c.ShouldBindJSON(&cambio); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		programado, err := Controllers.ProgramarCambioPrecio(db, &cambio, usuarioRequest(c))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo programar el cambio de precio", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, programado)
+	}
+}
+
+func CancelarCambioPrecioHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		cambio, err := Controllers.CancelarCambioPrecio(db, uint(id))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo cancelar el cambio de precio", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, cambio)
+	}
+}
+
+// RepreciarCotizacionHandler valoriza una cotización con los precios vigentes en ?fecha (por
+// defecto, su fecha de creación) y la compara con los precios actuales
+func RepreciarCotizacionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		var fecha *time.Time
+		if c.Query("fecha") != "" {
+			momento, err := parseMomentoQuery(c, "fecha")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha inválida"})
+				return
+			}
+			fecha = &momento
+		}
+		resultado, err := Controllers.RepreciarCotizacion(db, uint(id), fecha)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No se pudo repreciar la cotización", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, resultado)
+	}
+}
@@ -3,20 +3,105 @@ package Handlers
 import (
 	"backend-inventario/api/Controllers"
 	modelos "backend-inventario/api/Models"
+	"backend-inventario/services"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
+// GetProductosHandler busca productos. Parámetros opcionales: q (texto en nombre, descripción o
+// prefijo de SKU), categoria_id (incluye subcategorías), proveedor_id, estado, precio_min,
+// precio_max, orden (relevancia, nombre, precio, sku), dir (asc, desc), limite y cursor. Con
+// agrupar=padre las variantes vienen agrupadas bajo su producto padre, con la matriz de variantes.
+// Siempre responde una página. formato=lista (obsoleto, se eliminará) entrega el catálogo completo
+// como arreglo, como antes de la paginación, para los clientes que aún no migran
 func GetProductosHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		productos, err := Controllers.GetProductos(db)
+		if c.Query("formato") == "lista" {
+			c.Header("Deprecation", "true")
+			c.Header("Warning", `299 - "formato=lista está obsoleto; use la respuesta paginada"`)
+			pagina, err := Controllers.BuscarProductos(db, Controllers.FiltroProductos{Todos: true})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener productos", "details": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, pagina.Productos)
+			return
+		}
+		filtro := Controllers.FiltroProductos{
+			Texto:       strings.TrimSpace(c.Query("q")),
+			Orden:       c.Query("orden"),
+			Descendente: strings.EqualFold(c.Query("dir"), "desc"),
+			Cursor:      c.Query("cursor"),
+		}
+		switch filtro.Orden {
+		case "", Controllers.OrdenNombre, Controllers.OrdenPrecio, Controllers.OrdenSKU:
+		case Controllers.OrdenRelevancia:
+			if filtro.Texto == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "El orden por relevancia requiere el parámetro q"})
+				return
+			}
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Orden inválido"})
+			return
+		}
+		var err error
+		if filtro.CategoriaID, err = parseIDQuery(c, "categoria_id"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		if filtro.ProveedorID, err = parseIDQuery(c, "proveedor_id"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		if valor := c.Query("estado"); valor != "" {
+			estado, err := strconv.ParseBool(valor)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Estado inválido"})
+				return
+			}
+			filtro.Estado = &estado
+		}
+		for nombre, destino := range map[string]**float64{"precio_min": &filtro.PrecioMin, "precio_max": &filtro.PrecioMax} {
+			if valor := c.Query(nombre); valor != "" {
+				precio, err := strconv.ParseFloat(valor, 64)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Precio inválido", "details": nombre})
+					return
+				}
+				*destino = &precio
+			}
+		}
+		if valor := c.Query("limite"); valor != "" {
+			if filtro.Limite, err = strconv.Atoi(valor); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Límite inválido"})
+				return
+			}
+		}
+
+		var pagina interface{}
+		switch c.Query("agrupar") {
+		case "":
+			pagina, err = Controllers.BuscarProductos(db, filtro)
+		case "padre":
+			pagina, err = Controllers.BuscarProductosAgrupados(db, filtro)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Agrupación inválida, use padre"})
+			return
+		}
+		if errors.Is(err, Controllers.ErrCursorInvalido) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cursor inválido"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener productos", "details": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, productos)
+		c.JSON(http.StatusOK, pagina)
 	}
 }
 
@@ -28,6 +113,7 @@ func GetProductoBySKUHandler(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado"})
 			return
 		}
+		escribirETag(c, producto.Version)
 		c.JSON(http.StatusOK, producto)
 	}
 }
@@ -39,7 +125,7 @@ func CreateProductoHandler(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos"})
 			return
 		}
-		if err := Controllers.CreateProducto(db, &nuevo); err != nil {
+		if err := Controllers.CreateProducto(db, &nuevo, usuarioRequest(c)); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al crear producto", "details": err.Error()})
 			return
 		}
@@ -50,27 +136,47 @@ func CreateProductoHandler(db *gorm.DB) gin.HandlerFunc {
 func UpdateProductoHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		sku := c.Param("sku")
-		var actualizado modelos.Producto
+		version, ok := versionIfMatch(c)
+		if !ok {
+			return
+		}
+		var actualizado Controllers.ActualizacionProducto
 		if err := c.ShouldBindJSON(&actualizado); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
 			return
 		}
-		producto, err := Controllers.UpdateProducto(db, sku, &actualizado)
+		producto, err := Controllers.UpdateProducto(db, sku, &actualizado, version, usuarioRequest(c))
+		if errors.Is(err, Controllers.ErrConflictoVersion) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "El producto fue modificado por otro usuario", "details": err.Error()})
+			return
+		}
+		if errors.Is(err, Controllers.ErrCambioLotesConStock) || errors.Is(err, Controllers.ErrCambioSerializadoConStock) {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo actualizar", "details": err.Error()})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar", "details": err.Error()})
 			return
 		}
+		escribirETag(c, producto.Version)
 		c.JSON(http.StatusOK, producto)
 	}
 }
 
-func DeleteProductoHandler(db *gorm.DB) gin.HandlerFunc {
+func DeleteProductoHandler(db *gorm.DB, almacen services.Almacenamiento) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		sku := c.Param("sku")
+		imagenes, err := Controllers.GetImagenesProducto(db, sku)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado"})
+			return
+		}
 		if err := Controllers.DeleteProducto(db, sku); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al eliminar producto", "details": err.Error()})
 			return
 		}
+		// Las filas de imágenes se borran en cascada con el producto; sus archivos, aquí
+		Controllers.EliminarArchivosImagenesProducto(almacen, imagenes)
 		c.JSON(http.StatusOK, gin.H{"message": "Producto eliminado exitosamente"})
 	}
 }
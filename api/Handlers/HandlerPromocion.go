@@ -0,0 +1,147 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	modelos "backend-inventario/api/Models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// parseMomentoQuery lee un instante opcional de la query, como fecha y hora RFC 3339 o como fecha
+// (YYYY-MM-DD, al inicio del día); sin el parámetro devuelve el momento actual
+func parseMomentoQuery(c *gin.Context, nombre string) (time.Time, error) {
+	valor := c.Query(nombre)
+	if valor == "" {
+		return time.Now(), nil
+	}
+	if momento, err := time.Parse(time.RFC3339, valor); err == nil {
+		return momento, nil
+	}
+	fecha, err := parseFechaQuery(c, nombre)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return *fecha, nil
+}
+
+// GetPromocionesHandler lista las promociones; ?sku filtra por producto y ?vigentes=true deja
+// solo las vigentes ahora (o en ?fecha)
+func GetPromocionesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var vigentesEn *time.Time
+		if c.Query("vigentes") == "true" {
+			momento, err := parseMomentoQuery(c, "fecha")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha inválida"})
+				return
+			}
+			vigentesEn = &momento
+		}
+		promociones, err := Controllers.GetPromociones(db, c.Query("sku"), vigentesEn)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener promociones", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, promociones)
+	}
+}
+
+func GetPromocionByIDHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		promocion, err := Controllers.GetPromocionByID(db, uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Promoción no encontrada"})
+			return
+		}
+		c.JSON(http.StatusOK, promocion)
+	}
+}
+
+func CreatePromocionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nueva := modelos.Promocion{Activa: true}
+		if err := c.ShouldBindJSON(&nueva); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		if err := Controllers.CreatePromocion(db, &nueva, usuarioRequest(c)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo crear la promoción", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, nueva)
+	}
+}
+
+func UpdatePromocionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		actualizada := modelos.Promocion{Activa: true}
+		if err := c.ShouldBindJSON(&actualizada); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		promocion, err := Controllers.UpdatePromocion(db, uint(id), &actualizada)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo actualizar la promoción", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, promocion)
+	}
+}
+
+func DeletePromocionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		if err := Controllers.DeletePromocion(db, uint(id)); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No se pudo eliminar la promoción", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+// GetPrecioEfectivoHandler calcula el precio de un producto con las promociones que le aplican
+// en ?sucursal_id, para ?rut_cliente o ?tipo_cliente_id, en ?fecha (por defecto ahora)
+func GetPrecioEfectivoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sucursalID, err := parseIDQuery(c, "sucursal_id")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		tipoClienteID, err := parseIDQuery(c, "tipo_cliente_id")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		fecha, err := parseMomentoQuery(c, "fecha")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha inválida"})
+			return
+		}
+
+		precio, err := Controllers.GetPrecioEfectivo(db, c.Param("sku"), sucursalID, c.Query("rut_cliente"), tipoClienteID, fecha)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No se pudo calcular el precio", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, precio)
+	}
+}
@@ -0,0 +1,54 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func UpdateParametrosReposicionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sku := c.Param("sku")
+		sucursalID, err := strconv.ParseUint(c.Param("sucursal_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		var parametros Controllers.ParametrosReposicion
+		if err := c.ShouldBindJSON(&parametros); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+
+		if err := Controllers.UpdateParametrosReposicion(db, sku, uint(sucursalID), parametros); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudieron actualizar los parámetros de reposición", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Parámetros de reposición actualizados correctamente"})
+	}
+}
+
+func GetSugerenciasReposicionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var sucursalID uint64
+		if valor := c.Query("sucursal_id"); valor != "" {
+			id, err := strconv.ParseUint(valor, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+				return
+			}
+			sucursalID = id
+		}
+
+		sugerencias, err := Controllers.GetSugerenciasReposicion(db, uint(sucursalID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al calcular sugerencias de reposición", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, sugerencias)
+	}
+}
@@ -0,0 +1,77 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetReservasHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var cotizacionID uint64
+		if valor := c.Query("cotizacion_id"); valor != "" {
+			id, err := strconv.ParseUint(valor, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ID de cotización inválido"})
+				return
+			}
+			cotizacionID = id
+		}
+
+		reservas, err := Controllers.GetReservas(db, uint(cotizacionID), c.Query("estado"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener reservas", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, reservas)
+	}
+}
+
+func ReservarCotizacionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID de cotización inválido"})
+			return
+		}
+
+		// La vigencia es opcional; si no se envía se usa la configurada
+		var req struct {
+			VigenciaHoras int `json:"vigencia_horas"`
+		}
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+				return
+			}
+		}
+
+		vigencia := time.Duration(req.VigenciaHoras) * time.Hour
+		reservas, err := Controllers.ReservarCotizacion(db, uint(id), vigencia, usuarioRequest(c))
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo reservar el stock de la cotización", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, reservas)
+	}
+}
+
+func LiberarReservasCotizacionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID de cotización inválido"})
+			return
+		}
+
+		if err := Controllers.LiberarReservasCotizacion(db, uint(id)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al liberar reservas", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Reservas liberadas correctamente"})
+	}
+}
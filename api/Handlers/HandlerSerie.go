@@ -0,0 +1,67 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetSeriesStockHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sku := c.Param("sku")
+		sucursalID, err := strconv.ParseUint(c.Param("sucursal_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		series, err := Controllers.GetSeriesStock(db, sku, uint(sucursalID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener números de serie", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, series)
+	}
+}
+
+// RegistrarSeriesExistentesHandler asigna series al stock que ya estaba en la sucursal
+func RegistrarSeriesExistentesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sku := c.Param("sku")
+		sucursalID, err := strconv.ParseUint(c.Param("sucursal_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		var req struct {
+			Series []string `json:"series" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+
+		series, err := Controllers.RegistrarSeriesExistentes(db, sku, uint(sucursalID), req.Series)
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudieron registrar los números de serie", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, series)
+	}
+}
+
+// GetHistorialSerieHandler devuelve la historia de una unidad; ?sku acota la búsqueda a un producto
+func GetHistorialSerieHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		historial, err := Controllers.GetHistorialSerie(db, c.Param("serie"), c.Query("sku"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Número de serie no encontrado", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, historial)
+	}
+}
@@ -3,6 +3,7 @@ package Handlers
 import (
 	"backend-inventario/api/Controllers"
 	modelos "backend-inventario/api/Models"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -10,8 +11,24 @@ import (
 	"gorm.io/gorm"
 )
 
+// GetStockSucursalHandler devuelve el stock vigente, o el saldo al cierre del día ?as_of=AAAA-MM-DD
 func GetStockSucursalHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		asOf, err := parseFechaQuery(c, "as_of")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha 'as_of' inválida, use el formato YYYY-MM-DD"})
+			return
+		}
+		if asOf != nil {
+			historico, err := Controllers.GetStockAFecha(db, *asOf, 0, "")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Error al obtener stock a la fecha", "details": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, historico)
+			return
+		}
+
 		stocks, err := Controllers.GetStockSucursal(db)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener stock por sucursal", "details": err.Error()})
@@ -31,11 +48,31 @@ func GetStockSucursalByIDHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		asOf, err := parseFechaQuery(c, "as_of")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha 'as_of' inválida, use el formato YYYY-MM-DD"})
+			return
+		}
+		if asOf != nil {
+			historico, err := Controllers.GetStockAFecha(db, *asOf, uint(sucursalID), sku)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Error al obtener stock a la fecha", "details": err.Error()})
+				return
+			}
+			if len(historico) == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Registro de stock no encontrado a esa fecha"})
+				return
+			}
+			c.JSON(http.StatusOK, historico[0])
+			return
+		}
+
 		stock, err := Controllers.GetStockSucursalByID(db, uint(sucursalID), sku)
 		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Registro de stock no encontrado", "details": err.Error()})
 			return
 		}
+		escribirETag(c, stock.Version)
 		c.JSON(http.StatusOK, stock)
 	}
 }
@@ -47,7 +84,7 @@ func CreateStockSucursalHandler(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		if err := Controllers.CreateStockSucursal(db, &nuevo); err != nil {
+		if err := Controllers.CreateStockSucursal(db, &nuevo, usuarioRequest(c)); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al crear registro de stock", "details": err.Error()})
 			return
 		}
@@ -65,17 +102,40 @@ func UpdateStockSucursalHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		var actualizado modelos.StockSucursal
+		version, ok := versionIfMatch(c)
+		if !ok {
+			return
+		}
+
+		var actualizado Controllers.ActualizacionStockSucursal
 		if err := c.ShouldBindJSON(&actualizado); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
 			return
 		}
 
-		if err := Controllers.UpdateStockSucursal(db, sku, uint(sucursalID), &actualizado); err != nil {
+		if err := Controllers.UpdateStockSucursal(db, sku, uint(sucursalID), &actualizado, version, usuarioRequest(c)); err != nil {
+			if errors.Is(err, Controllers.ErrCampoStockNoEditable) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":   "Solo se puede cambiar la cantidad",
+					"details": "use PUT /api/stock-sucursal/:sucursal_id/:sku/reposicion para stock_minimo, stock_maximo y punto_reorden; el costo_promedio cambia con las compras y los movimientos con costo",
+				})
+				return
+			}
+			if errors.Is(err, Controllers.ErrConflictoVersion) {
+				c.JSON(http.StatusPreconditionFailed, gin.H{"error": "El registro de stock fue modificado por otro usuario", "details": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar registro de stock", "details": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"message": "Registro de stock actualizado correctamente"})
+
+		stock, err := Controllers.GetStockSucursalByID(db, uint(sucursalID), sku)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener el registro de stock actualizado", "details": err.Error()})
+			return
+		}
+		escribirETag(c, stock.Version)
+		c.JSON(http.StatusOK, stock)
 	}
 }
 
@@ -90,6 +150,14 @@ func DeleteStockSucursalHandler(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		if err := Controllers.DeleteStockSucursal(db, sku, uint(sucursalID)); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Registro de stock no encontrado"})
+				return
+			}
+			if errors.Is(err, Controllers.ErrStockConSaldo) {
+				c.JSON(http.StatusConflict, gin.H{"error": "No se puede eliminar el registro de stock", "details": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al eliminar registro de stock", "details": err.Error()})
 			return
 		}
@@ -32,18 +32,21 @@ func GetBodegasHandler(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-// Handler para debug - ver todos los tipos de sucursal disponibles
-func GetTiposSucursalDebugHandler(db *gorm.DB) gin.HandlerFunc {
+// GetUtilizacionBodegasHandler informa volumen, peso y pallets usados frente a la capacidad de las
+// bodegas, o de la sucursal indicada en ?sucursal_id
+func GetUtilizacionBodegasHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tipos, err := Controllers.GetTipoSucursal(db)
+		sucursalID, err := parseIDQuery(c, "sucursal_id")
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener tipos", "details": err.Error()})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		utilizacion, err := Controllers.GetUtilizacionBodegas(db, sucursalID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al calcular la utilización", "details": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{
-			"tipos_disponibles": tipos,
-			"message":           "Estos son todos los tipos de sucursal en la base de datos",
-		})
+		c.JSON(http.StatusOK, utilizacion)
 	}
 }
 
@@ -0,0 +1,188 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	modelos "backend-inventario/api/Models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetTransferenciasHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		transferencias, err := Controllers.GetTransferencias(db, c.Query("estado"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener transferencias", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, transferencias)
+	}
+}
+
+func GetTransferenciaByIDHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		transferencia, err := Controllers.GetTransferenciaByID(db, uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transferencia no encontrada", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, transferencia)
+	}
+}
+
+func CreateTransferenciaHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var nueva modelos.Transferencia
+		if err := c.ShouldBindJSON(&nueva); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		nueva.Usuario = usuarioRequest(c)
+
+		if err := Controllers.CreateTransferencia(db, &nueva); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo crear la transferencia", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, nueva)
+	}
+}
+
+func UpdateTransferenciaHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		var actualizada modelos.Transferencia
+		if err := c.ShouldBindJSON(&actualizada); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+
+		transferencia, err := Controllers.UpdateTransferencia(db, uint(id), &actualizada)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo actualizar la transferencia", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, transferencia)
+	}
+}
+
+func DeleteTransferenciaHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		if err := Controllers.DeleteTransferencia(db, uint(id)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo eliminar la transferencia", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+func DespacharTransferenciaHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		// Series a trasladar por SKU, obligatorias para productos serializados
+		var req struct {
+			Series map[string][]string `json:"series"`
+		}
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+				return
+			}
+		}
+
+		transferencia, err := Controllers.DespacharTransferencia(db, uint(id), req.Series, usuarioRequest(c))
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo despachar la transferencia", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, transferencia)
+	}
+}
+
+func MarcarTransferenciaEnTransitoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		transferencia, err := Controllers.MarcarTransferenciaEnTransito(db, uint(id))
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo actualizar la transferencia", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, transferencia)
+	}
+}
+
+func RecibirTransferenciaHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+
+		var req struct {
+			Lineas []Controllers.LineaRecepcion `json:"lineas"`
+			Cerrar bool                         `json:"cerrar"`
+			Motivo string                       `json:"motivo"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+
+		transferencia, err := Controllers.RecibirTransferencia(db, uint(id), req.Lineas, req.Cerrar, req.Motivo, usuarioRequest(c))
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo registrar la recepción", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, transferencia)
+	}
+}
+
+func GetStockEnTransitoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var sucursalID uint64
+		if valor := c.Query("sucursal_id"); valor != "" {
+			id, err := strconv.ParseUint(valor, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+				return
+			}
+			sucursalID = id
+		}
+
+		stock, err := Controllers.GetStockEnTransito(db, uint(sucursalID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener stock en tránsito", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, stock)
+	}
+}
@@ -0,0 +1,165 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	modelos "backend-inventario/api/Models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetUbicacionesHandler lista las ubicaciones, filtrando por ?sucursal_id, ?zona y ?activas=true
+func GetUbicacionesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sucursalID, err := parseIDQuery(c, "sucursal_id")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		ubicaciones, err := Controllers.GetUbicaciones(db, sucursalID, c.Query("zona"), c.Query("activas") == "true")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener ubicaciones", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ubicaciones)
+	}
+}
+
+func GetUbicacionByIDHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		ubicacion, err := Controllers.GetUbicacionByID(db, uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ubicación no encontrada"})
+			return
+		}
+		c.JSON(http.StatusOK, ubicacion)
+	}
+}
+
+func CreateUbicacionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var nueva modelos.Ubicacion
+		if err := c.ShouldBindJSON(&nueva); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		if err := Controllers.CreateUbicacion(db, &nueva); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo crear la ubicación", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, nueva)
+	}
+}
+
+func UpdateUbicacionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		// Si el cuerpo no trae "activa" la ubicación sigue activa
+		actualizada := modelos.Ubicacion{Activa: true}
+		if err := c.ShouldBindJSON(&actualizada); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		ubicacion, err := Controllers.UpdateUbicacion(db, uint(id), &actualizada)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo actualizar la ubicación", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ubicacion)
+	}
+}
+
+func DeleteUbicacionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		if err := Controllers.DeleteUbicacion(db, uint(id)); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo eliminar la ubicación", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+// GetStockUbicacionesHandler muestra dónde está el stock, filtrando por ?sku, ?sucursal_id y ?ubicacion_id
+func GetStockUbicacionesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sucursalID, err := parseIDQuery(c, "sucursal_id")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		ubicacionID, err := parseIDQuery(c, "ubicacion_id")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		stock, err := Controllers.GetStockUbicaciones(db, c.Query("sku"), sucursalID, ubicacionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener stock por ubicación", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, stock)
+	}
+}
+
+func GetTrasladosUbicacionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sucursalID, err := parseIDQuery(c, "sucursal_id")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		traslados, err := Controllers.GetTrasladosUbicacion(db, sucursalID, c.Query("sku"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener traslados", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, traslados)
+	}
+}
+
+// TrasladarStockHandler mueve stock entre ubicaciones de una sucursal. Sin origen_id se ubica
+// lo que está en recepción
+func TrasladarStockHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			SKU        string `json:"sku" binding:"required"`
+			SucursalID uint   `json:"sucursal_id" binding:"required"`
+			OrigenID   uint   `json:"origen_id"`
+			DestinoID  uint   `json:"destino_id" binding:"required"`
+			Cantidad   int    `json:"cantidad" binding:"required,gt=0"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		traslado := modelos.TrasladoUbicacion{
+			SKU:        req.SKU,
+			SucursalID: req.SucursalID,
+			OrigenID:   req.OrigenID,
+			DestinoID:  req.DestinoID,
+			Cantidad:   req.Cantidad,
+			Usuario:    usuarioRequest(c),
+		}
+		if err := Controllers.TrasladarStock(db, &traslado); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo trasladar el stock", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, traslado)
+	}
+}
@@ -0,0 +1,90 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	modelos "backend-inventario/api/Models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetUnidadesProductoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		unidades, err := Controllers.GetUnidadesProducto(db, c.Param("sku"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener unidades del producto", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, unidades)
+	}
+}
+
+func CreateUnidadProductoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var nueva modelos.ProductoUnidad
+		if err := c.ShouldBindJSON(&nueva); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		if err := Controllers.CreateUnidadProducto(db, c.Param("sku"), &nueva); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo crear la unidad", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, nueva)
+	}
+}
+
+func UpdateUnidadProductoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		var actualizada modelos.ProductoUnidad
+		if err := c.ShouldBindJSON(&actualizada); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		unidad, err := Controllers.UpdateUnidadProducto(db, c.Param("sku"), uint(id), &actualizada)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo actualizar la unidad", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, unidad)
+	}
+}
+
+func DeleteUnidadProductoHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		if err := Controllers.DeleteUnidadProducto(db, c.Param("sku"), uint(id)); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No se pudo eliminar la unidad", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+// ConvertirUnidadHandler convierte ?cantidad desde la unidad ?de a la unidad ?a (por defecto la base)
+func ConvertirUnidadHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cantidad, err := strconv.ParseFloat(c.Query("cantidad"), 64)
+		if err != nil || cantidad < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cantidad inválida"})
+			return
+		}
+		conversion, err := Controllers.ConvertirCantidad(db, c.Param("sku"), cantidad, c.Query("de"), c.Query("a"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo convertir la cantidad", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, conversion)
+	}
+}
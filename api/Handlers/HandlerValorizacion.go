@@ -0,0 +1,47 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetValorizacionHandler valoriza el inventario al cierre de ?fecha (hoy por defecto) con ?metodo
+// promedio o fifo, opcionalmente filtrado por ?sucursal_id y ?categoria_id
+func GetValorizacionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fecha, err := parseFechaQuery(c, "fecha")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha inválida, use el formato AAAA-MM-DD"})
+			return
+		}
+		if fecha == nil {
+			ahora := time.Now()
+			hoy := time.Date(ahora.Year(), ahora.Month(), ahora.Day(), 0, 0, 0, 0, time.Local)
+			fecha = &hoy
+		}
+
+		filtros := make(map[string]uint)
+		for _, nombre := range []string{"sucursal_id", "categoria_id"} {
+			if valor := c.Query(nombre); valor != "" {
+				id, err := strconv.ParseUint(valor, 10, 64)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+					return
+				}
+				filtros[nombre] = uint(id)
+			}
+		}
+
+		reporte, err := Controllers.GetValorizacion(db, *fecha, c.Query("metodo"), filtros["sucursal_id"], filtros["categoria_id"])
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo valorizar el inventario", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, reporte)
+	}
+}
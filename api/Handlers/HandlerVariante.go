@@ -0,0 +1,170 @@
+package Handlers
+
+import (
+	"backend-inventario/api/Controllers"
+	modelos "backend-inventario/api/Models"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetProductosPadreHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		padres, err := Controllers.GetProductosPadre(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener productos padre", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, padres)
+	}
+}
+
+func GetProductoPadreByIDHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		padre, err := Controllers.GetProductoPadreByID(db, uint(id))
+		if errors.Is(err, Controllers.ErrProductoPadreNoEncontrado) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Producto padre no encontrado"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener producto padre", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, padre)
+	}
+}
+
+// GetMatrizVariantesHandler entrega las variantes de un producto padre ordenadas por sus atributos
+func GetMatrizVariantesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		matriz, err := Controllers.GetMatrizVariantes(db, uint(id))
+		if errors.Is(err, Controllers.ErrProductoPadreNoEncontrado) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Producto padre no encontrado"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al armar la matriz de variantes", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, matriz)
+	}
+}
+
+func CreateProductoPadreHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var nuevo modelos.ProductoPadre
+		if err := c.ShouldBindJSON(&nuevo); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		if err := Controllers.CreateProductoPadre(db, &nuevo); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo crear el producto padre", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, nuevo)
+	}
+}
+
+func UpdateProductoPadreHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		var actualizado modelos.ProductoPadre
+		if err := c.ShouldBindJSON(&actualizado); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		padre, err := Controllers.UpdateProductoPadre(db, uint(id), &actualizado)
+		if errors.Is(err, Controllers.ErrProductoPadreNoEncontrado) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Producto padre no encontrado"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo actualizar el producto padre", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, padre)
+	}
+}
+
+// DeleteProductoPadreHandler elimina el padre; sus variantes quedan como productos sueltos
+func DeleteProductoPadreHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		err = Controllers.DeleteProductoPadre(db, uint(id))
+		if errors.Is(err, Controllers.ErrProductoPadreNoEncontrado) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Producto padre no encontrado"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al eliminar el producto padre", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+// AsignarVarianteHandler hace del producto :sku una variante del padre. El cuerpo trae el valor de
+// cada atributo del padre, por ejemplo {"atributos": {"color": "rojo", "tamaño": "1 gal"}}
+func AsignarVarianteHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		var body struct {
+			Atributos map[string]string `json:"atributos" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos", "details": err.Error()})
+			return
+		}
+		producto, err := Controllers.AsignarVariante(db, uint(id), c.Param("sku"), body.Atributos)
+		if errors.Is(err, Controllers.ErrProductoPadreNoEncontrado) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Producto padre no encontrado"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No se pudo asignar la variante", "details": err.Error()})
+			return
+		}
+		escribirETag(c, producto.Version)
+		c.JSON(http.StatusOK, producto)
+	}
+}
+
+func QuitarVarianteHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID invalido"})
+			return
+		}
+		if err := Controllers.QuitarVariante(db, uint(id), c.Param("sku")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No se pudo quitar la variante", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,32 @@
+package Handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// escribirETag publica la versión del registro en la cabecera ETag
+func escribirETag(c *gin.Context, version uint) {
+	c.Header("ETag", fmt.Sprintf("\"%d\"", version))
+}
+
+// versionIfMatch lee la versión esperada desde If-Match. Si falta o no es válida
+// responde al cliente y devuelve false
+func versionIfMatch(c *gin.Context) (uint, bool) {
+	valor := strings.TrimSpace(c.GetHeader("If-Match"))
+	if valor == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "Debe enviar la cabecera If-Match con el ETag del registro"})
+		return 0, false
+	}
+	valor = strings.Trim(strings.TrimPrefix(valor, "W/"), "\"")
+	version, err := strconv.ParseUint(valor, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cabecera If-Match inválida", "details": err.Error()})
+		return 0, false
+	}
+	return uint(version), true
+}
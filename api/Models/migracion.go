@@ -1,34 +1,243 @@
 package modelos
 
 import (
+	"fmt"
 	"log"
 
 	"gorm.io/gorm"
 )
 
 func MigrarTablas(db *gorm.DB) {
+	marcarBodegas := db.Migrator().HasTable(&TipoSucursal{}) && !db.Migrator().HasColumn(&TipoSucursal{}, "es_bodega")
+
 	err := db.AutoMigrate(
+		&Categoria{},
+		&ProductoPadre{},
 		&Producto{},
+		&AtributoVariante{},
+		&VarianteValor{},
+		&ProductoUnidad{},
+		&ProductoImagen{},
+		&CodigoBarras{},
+		&CambioPrecio{},
+		&PrecioProducto{},
+		&KitComponente{},
 		&Proveedor{},
 		&StockProveedor{},
 		&TipoSucursal{},
 		&Sucursal{},
 		&StockSucursal{},
+		&Ubicacion{},
+		&StockUbicacion{},
+		&StockLote{},
+		&MovimientoStock{},
+		&CapaCosto{},
+		&MovimientoLote{},
+		&MovimientoUbicacion{},
+		&TrasladoUbicacion{},
+		&NumeroSerie{},
+		&MovimientoSerie{},
+		&Transferencia{},
+		&TransferenciaLinea{},
+		&DiscrepanciaTransferencia{},
+		&OrdenCompra{},
+		&OrdenCompraLinea{},
+		&RecepcionCompra{},
+		&RecepcionCompraLinea{},
+		&ConteoInventario{},
+		&ConteoInventarioLinea{},
+		&RegistroConteo{},
+		&ImportacionStock{},
+		&CierreInventario{},
+		&CierreInventarioLinea{},
 		&Rol{},
 		&Usuario{},
 		&TipoCliente{},
 		&Cliente{},
+		&Promocion{},
 		&DirCliente{},
 		&Cotizacion{},
 		&CotizacionItem{},
+		&ReservaStock{},
 		&TipoCamion{},
 		&Camion{},
 		&Despacho{},
 		&ProductosDespacho{},
+		&ProductosDespachoLote{},
+		&ProductosDespachoSerie{},
+		&ProductosDespachoComponente{},
 	)
 	if err != nil {
 		log.Fatal("Error al migrar la base de datos:", err)
 	}
+	if err := migrarDescuentosStock(db); err != nil {
+		log.Fatal("Error al migrar los descuentos de stock a promociones:", err)
+	}
+	if err := migrarHistorialPrecios(db); err != nil {
+		log.Fatal("Error al iniciar el historial de precios:", err)
+	}
+	// Una recepción puede traer un SKU en varios lotes
+	if err := migrarClavePrimaria(db, "recepcion_compra_linea", "lote", "recepcion_id, sku, lote"); err != nil {
+		log.Fatal("Error al actualizar la clave de las líneas de recepción:", err)
+	}
+	// Un componente puede salir suelto y dentro de kits en el mismo despacho, del mismo lote
+	if err := migrarClavePrimaria(db, "productos_despacho_lote", "kit_sku", "despacho_id, sku, kit_sku, lote_id"); err != nil {
+		log.Fatal("Error al actualizar la clave de los lotes despachados:", err)
+	}
+	if err := migrarDespachoMovimientos(db); err != nil {
+		log.Fatal("Error al enlazar los movimientos con sus despachos:", err)
+	}
+	if err := migrarRestriccionKardex(db); err != nil {
+		log.Fatal("Error al proteger el kardex de eliminaciones en cascada:", err)
+	}
+	if err := migrarBusquedaProductos(db); err != nil {
+		log.Fatal("Error al preparar la búsqueda de productos:", err)
+	}
+	if marcarBodegas {
+		if err := migrarTiposBodega(db); err != nil {
+			log.Fatal("Error al marcar los tipos de sucursal bodega:", err)
+		}
+	}
 	log.Println("Migraciones de base de datos completadas")
 
 }
+
+// migrarDescuentosStock convierte el antiguo descuento fijo de stock_sucursal en promociones
+// indefinidas por SKU y sucursal. Las promociones rigen desde antes de cualquier dato del sistema,
+// para que el precio efectivo de fechas pasadas siga incluyendo el descuento. La columna ya no se
+// lee pero se conserva hasta verificar las promociones migradas; se eliminará en una versión
+// posterior. Es idempotente: no vuelve a migrar un SKU y sucursal que ya tiene su promoción
+func migrarDescuentosStock(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&StockSucursal{}, "descuento") {
+		return nil
+	}
+	res := db.Exec(`INSERT INTO promociones (nombre, descuento, sku, sucursal_id, inicio, prioridad, acumulable, activa, usuario, fecha_crea)
+		SELECT 'Descuento de sucursal (migrado)', s.descuento, s.sku, s.sucursal_id, TIMESTAMP '2000-01-01', 0, false, true, 'migracion', NOW()
+		FROM stock_sucursal s
+		WHERE s.descuento > 0
+			AND NOT EXISTS (
+				SELECT 1 FROM promociones p
+				WHERE p.usuario = 'migracion' AND p.nombre = 'Descuento de sucursal (migrado)'
+					AND p.sku = s.sku AND p.sucursal_id = s.sucursal_id
+			)`)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		log.Printf("Descuentos de stock migrados a promociones: %d", res.RowsAffected)
+	}
+	return nil
+}
+
+// migrarTiposBodega marca como bodega los tipos de sucursal existentes cuyo nombre lo indica.
+// Solo se ejecuta al crear la columna es_bodega; después se administra desde /api/tipos-sucursal
+func migrarTiposBodega(db *gorm.DB) error {
+	res := db.Model(&TipoSucursal{}).Where("nombre ILIKE ?", "%bodega%").Update("es_bodega", true)
+	if res.Error != nil {
+		return res.Error
+	}
+	log.Printf("Tipos de sucursal marcados como bodega: %d", res.RowsAffected)
+	return nil
+}
+
+// migrarClavePrimaria agrega una columna a la clave primaria de una tabla existente; AutoMigrate
+// agrega la columna pero no cambia la clave. No hace nada si la clave ya la incluye
+func migrarClavePrimaria(db *gorm.DB, tabla, columna, clave string) error {
+	return db.Exec(fmt.Sprintf(`DO $$
+	BEGIN
+		IF NOT EXISTS (
+			SELECT 1 FROM pg_index i
+			JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+			WHERE i.indrelid = '%[1]s'::regclass AND i.indisprimary AND a.attname = '%[2]s'
+		) THEN
+			ALTER TABLE %[1]s DROP CONSTRAINT IF EXISTS %[1]s_pkey;
+			ALTER TABLE %[1]s ADD PRIMARY KEY (%[3]s);
+		END IF;
+	END
+	$$`, tabla, columna, clave)).Error
+}
+
+// migrarDespachoMovimientos completa el despacho de los movimientos registrados antes de la
+// columna despacho_id, a partir de su referencia ("despacho #N" o "anulación despacho #N")
+func migrarDespachoMovimientos(db *gorm.DB) error {
+	res := db.Exec(`UPDATE movimientos_stock
+		SET despacho_id = substring(referencia FROM 'despacho #([0-9]+)$')::bigint
+		WHERE despacho_id IS NULL AND tipo IN ('despacho', 'devolucion') AND referencia ~ 'despacho #[0-9]+$'`)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		log.Printf("Movimientos enlazados con su despacho: %d", res.RowsAffected)
+	}
+	return nil
+}
+
+// migrarRestriccionKardex cambia a RESTRICT las claves foráneas del kardex hacia productos y
+// sucursales que se crearon con ON DELETE CASCADE; AutoMigrate no modifica una restricción que ya
+// existe. Es idempotente
+func migrarRestriccionKardex(db *gorm.DB) error {
+	return db.Exec(`DO $$
+	DECLARE
+		r RECORD;
+	BEGIN
+		FOR r IN
+			SELECT c.conname, pg_get_constraintdef(c.oid) AS definicion
+			FROM pg_constraint c
+			WHERE c.conrelid = 'movimientos_stock'::regclass AND c.contype = 'f' AND c.confdeltype = 'c'
+				AND c.confrelid IN ('productos'::regclass, 'sucursales'::regclass)
+		LOOP
+			EXECUTE format('ALTER TABLE movimientos_stock DROP CONSTRAINT %I', r.conname);
+			EXECUTE format('ALTER TABLE movimientos_stock ADD CONSTRAINT %I %s', r.conname,
+				replace(r.definicion, 'ON DELETE CASCADE', 'ON DELETE RESTRICT'));
+		END LOOP;
+	END
+	$$`).Error
+}
+
+// migrarBusquedaProductos prepara la búsqueda de texto completo de productos: una configuración en
+// español que ignora tildes (es_unaccent) y una columna tsvector generada a partir del nombre (con
+// más peso) y la descripción, con su índice GIN. Es idempotente
+func migrarBusquedaProductos(db *gorm.DB) error {
+	sentencias := []string{
+		`CREATE EXTENSION IF NOT EXISTS unaccent`,
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'es_unaccent') THEN
+				CREATE TEXT SEARCH CONFIGURATION es_unaccent (COPY = spanish);
+				ALTER TEXT SEARCH CONFIGURATION es_unaccent
+					ALTER MAPPING FOR hword, hword_part, word WITH unaccent, spanish_stem;
+			END IF;
+		END
+		$$`,
+		`ALTER TABLE productos ADD COLUMN IF NOT EXISTS busqueda tsvector
+			GENERATED ALWAYS AS (
+				setweight(to_tsvector('es_unaccent', COALESCE(nombre, '')), 'A') ||
+				setweight(to_tsvector('es_unaccent', COALESCE(descripcion, '')), 'B')
+			) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_productos_busqueda ON productos USING GIN (busqueda)`,
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, sentencia := range sentencias {
+			if err := tx.Exec(sentencia).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrarHistorialPrecios abre el historial de precios de los productos que aún no tienen uno, con
+// su precio actual vigente desde la migración
+func migrarHistorialPrecios(db *gorm.DB) error {
+	res := db.Exec(`INSERT INTO precios_producto (sku, precio, vigente_desde, usuario, motivo, fecha_crea)
+		SELECT p.sku, p.precio, NOW(), 'migracion', 'precio inicial', NOW()
+		FROM productos p
+		WHERE NOT EXISTS (SELECT 1 FROM precios_producto pp WHERE pp.sku = p.sku)`)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		log.Printf("Historial de precios iniciado para %d productos", res.RowsAffected)
+	}
+	return nil
+}
@@ -14,18 +14,196 @@ type Producto struct {
 	Precio      float64 `gorm:"type:numeric(10,2);not null" json:"precio"`
 	CategoriaID *uint   `gorm:"column:categoria_id" json:"categoria_id"`
 	Estado      bool    `gorm:"default:true" json:"estado"`
+	ManejaLotes bool    `gorm:"not null;default:false" json:"maneja_lotes"`       // stock por lote con vencimiento
+	Serializado bool    `gorm:"not null;default:false" json:"serializado"`        // cada unidad con número de serie
+	Version     uint    `gorm:"not null;default:1" json:"version"`                // control de concurrencia optimista
+	UnidadBase  string  `gorm:"size:20;not null;default:'UN'" json:"unidad_base"` // unidad en que se lleva el stock
+	EsKit       bool    `gorm:"not null;default:false" json:"es_kit"`             // se arma con otros productos y no lleva stock propio
+	PadreID     *uint   `gorm:"column:padre_id;index" json:"padre_id,omitempty"`  // producto padre si es una variante
 
-	Proveedor Proveedor `gorm:"foreignKey:ProveedorID;references:ID;constraint:OnDelete:CASCADE" json:"proveedor"`
-	Categoria Categoria `gorm:"foreignKey:CategoriaID;references:ID;constraint:OnDelete:SET NULL" json:"categoria,omitempty"`
+	Proveedor   Proveedor        `gorm:"foreignKey:ProveedorID;references:ID;constraint:OnDelete:CASCADE" json:"proveedor"`
+	Categoria   Categoria        `gorm:"foreignKey:CategoriaID;references:ID;constraint:OnDelete:SET NULL" json:"categoria,omitempty"`
+	Unidades    []ProductoUnidad `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:CASCADE" json:"unidades,omitempty"`
+	Componentes []KitComponente  `gorm:"foreignKey:KitSKU;references:SKU;constraint:OnDelete:CASCADE" json:"componentes,omitempty"`
+	Imagenes    []ProductoImagen `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:CASCADE" json:"imagenes,omitempty"`
+
+	CodigosBarras []CodigoBarras  `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:CASCADE" json:"codigos_barras,omitempty"`
+	Variante      []VarianteValor `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:CASCADE" json:"variante,omitempty"`
 }
 
 func (Producto) TableName() string {
 	return "productos"
 }
 
+// ProductoPadre agrupa las variantes de un mismo artículo (la misma pintura en varios colores, el
+// mismo tubo en varios diámetros). Cada variante es un Producto con su propio SKU, stock, precio y
+// dimensiones, que se distingue de sus hermanas por los valores de los atributos del padre
+type ProductoPadre struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Nombre      string    `gorm:"size:100;not null" json:"nombre" binding:"required"`
+	Descripcion string    `gorm:"type:text" json:"descripcion"`
+	CategoriaID *uint     `gorm:"column:categoria_id" json:"categoria_id"`
+	FechaCrea   time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha_crea"`
+
+	Categoria Categoria          `gorm:"foreignKey:CategoriaID;references:ID;constraint:OnDelete:SET NULL" json:"categoria,omitempty"`
+	Atributos []AtributoVariante `gorm:"foreignKey:PadreID;references:ID;constraint:OnDelete:CASCADE" json:"atributos"`
+	Variantes []Producto         `gorm:"foreignKey:PadreID;references:ID;constraint:OnDelete:SET NULL" json:"variantes,omitempty"`
+}
+
+func (ProductoPadre) TableName() string {
+	return "productos_padre"
+}
+
+// AtributoVariante es un eje en que varían los productos de un padre, como color, talla o diámetro
+type AtributoVariante struct {
+	ID      uint   `gorm:"primaryKey" json:"id"`
+	PadreID uint   `gorm:"column:padre_id;not null;uniqueIndex:idx_atributo_variante" json:"padre_id"`
+	Nombre  string `gorm:"size:50;not null;uniqueIndex:idx_atributo_variante" json:"nombre" binding:"required"`
+	Orden   int    `gorm:"not null;default:0" json:"orden"`
+}
+
+func (AtributoVariante) TableName() string {
+	return "atributos_variante"
+}
+
+// VarianteValor es el valor de una variante en un atributo de su padre (color "rojo")
+type VarianteValor struct {
+	SKU        string `gorm:"primaryKey;size:20;column:sku" json:"-"`
+	AtributoID uint   `gorm:"primaryKey;column:atributo_id" json:"atributo_id"`
+	Valor      string `gorm:"size:50;not null" json:"valor"`
+
+	Atributo *AtributoVariante `gorm:"foreignKey:AtributoID;references:ID;constraint:OnDelete:CASCADE" json:"atributo,omitempty"`
+}
+
+func (VarianteValor) TableName() string {
+	return "variante_valores"
+}
+
+// ProductoUnidad es una unidad alternativa de venta o embalaje de un producto (saco, pallet,
+// caja, m²). Factor es cuántas unidades base contiene. Los embalajes son unidades físicas con
+// peso y dimensiones propias, que se usan al planificar la carga de los despachos
+type ProductoUnidad struct {
+	ID       uint    `gorm:"primaryKey" json:"id"`
+	SKU      string  `gorm:"size:20;column:sku;not null;uniqueIndex:idx_producto_unidad" json:"sku"`
+	Codigo   string  `gorm:"size:20;not null;uniqueIndex:idx_producto_unidad" json:"codigo" binding:"required"`
+	Nombre   string  `gorm:"size:50;not null" json:"nombre"`
+	Factor   float64 `gorm:"type:numeric(12,4);not null;check:factor > 0" json:"factor" binding:"required,gt=0"`
+	Embalaje bool    `gorm:"not null;default:false" json:"embalaje"`
+	Peso     float64 `gorm:"type:numeric(10,2);not null;default:0" json:"peso" binding:"min=0"`
+	Largo    float64 `gorm:"type:numeric(10,2);not null;default:0" json:"largo" binding:"min=0"`
+	Ancho    float64 `gorm:"type:numeric(10,2);not null;default:0" json:"ancho" binding:"min=0"`
+	Alto     float64 `gorm:"type:numeric(10,2);not null;default:0" json:"alto" binding:"min=0"`
+}
+
+func (ProductoUnidad) TableName() string {
+	return "producto_unidades"
+}
+
+// PrecioProducto es un tramo del historial de precios de lista de un producto: el precio rige desde
+// VigenteDesde hasta VigenteHasta (sin fin si es nulo). Los tramos de un producto son contiguos y
+// los que empiezan en el futuro son cambios programados
+type PrecioProducto struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	SKU          string     `gorm:"size:20;column:sku;not null;index:idx_precio_producto_vigencia" json:"sku"`
+	Precio       float64    `gorm:"type:numeric(10,2);not null;check:precio >= 0" json:"precio"`
+	VigenteDesde time.Time  `gorm:"not null;index:idx_precio_producto_vigencia" json:"vigente_desde"`
+	VigenteHasta *time.Time `json:"vigente_hasta,omitempty"`
+	CambioID     *uint      `gorm:"column:cambio_id;index" json:"cambio_id,omitempty"` // cambio programado que lo creó
+	Usuario      string     `gorm:"size:100" json:"usuario"`
+	Motivo       string     `gorm:"size:200" json:"motivo,omitempty"`
+	FechaCrea    time.Time  `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha_crea"`
+
+	Producto Producto `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (PrecioProducto) TableName() string {
+	return "precios_producto"
+}
+
+// CambioPrecio es un cambio de precio programado para una fecha: de un SKU (a un precio o en un
+// porcentaje) o masivo por categoría (con sus subcategorías) o proveedor, siempre en porcentaje
+type CambioPrecio struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	SKU           *string    `gorm:"size:20;column:sku" json:"sku,omitempty"`
+	CategoriaID   *uint      `gorm:"column:categoria_id" json:"categoria_id,omitempty"`
+	ProveedorID   *uint      `gorm:"column:proveedor_id" json:"proveedor_id,omitempty"`
+	Precio        *float64   `gorm:"type:numeric(10,2)" json:"precio,omitempty"`
+	Porcentaje    *float64   `gorm:"type:numeric(6,2)" json:"porcentaje,omitempty"` // +5 sube un 5 %, -10 baja un 10 %
+	VigenteDesde  time.Time  `gorm:"not null" json:"vigente_desde"`
+	Estado        string     `gorm:"size:20;not null;default:'programado'" json:"estado"`
+	Productos     int        `gorm:"not null;default:0" json:"productos"` // productos afectados
+	Usuario       string     `gorm:"size:100" json:"usuario"`
+	Motivo        string     `gorm:"size:200" json:"motivo,omitempty"`
+	FechaCrea     time.Time  `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha_crea"`
+	FechaAplicado *time.Time `json:"fecha_aplicado,omitempty"`
+
+	Precios []PrecioProducto `gorm:"foreignKey:CambioID;references:ID;constraint:OnDelete:SET NULL" json:"precios,omitempty"`
+}
+
+func (CambioPrecio) TableName() string {
+	return "cambios_precio"
+}
+
+// ProductoImagen es una imagen de un producto. El archivo original y su miniatura se guardan en el
+// almacenamiento de archivos bajo sus claves; la primera según Orden es la imagen principal
+type ProductoImagen struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	SKU            string    `gorm:"size:20;column:sku;not null;index" json:"sku"`
+	Nombre         string    `gorm:"size:200;not null" json:"nombre"` // nombre del archivo subido
+	ContentType    string    `gorm:"size:50;not null" json:"content_type"`
+	Tamano         int64     `gorm:"not null" json:"tamano"` // bytes
+	Ancho          int       `gorm:"not null" json:"ancho"`
+	Alto           int       `gorm:"not null" json:"alto"`
+	Clave          string    `gorm:"size:200;not null" json:"-"`
+	ClaveMiniatura string    `gorm:"size:200;not null" json:"-"`
+	Orden          int       `gorm:"not null;default:0" json:"orden"`
+	Usuario        string    `gorm:"size:100" json:"usuario"`
+	FechaCrea      time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha_crea"`
+
+	URL          string `gorm:"-" json:"url"`
+	URLMiniatura string `gorm:"-" json:"url_miniatura"`
+}
+
+func (ProductoImagen) TableName() string {
+	return "producto_imagenes"
+}
+
+// CodigoBarras es un código de barras que identifica a un producto al escanearlo. Un producto
+// puede tener varios (el del fabricante y uno interno), pero cada código es de un solo producto
+type CodigoBarras struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	SKU       string    `gorm:"size:20;column:sku;not null;index" json:"sku"`
+	Codigo    string    `gorm:"size:48;not null;uniqueIndex" json:"codigo" binding:"required"`
+	Tipo      string    `gorm:"size:10;not null" json:"tipo"`            // EAN13 o CODE128
+	Principal bool      `gorm:"not null;default:false" json:"principal"` // el que se imprime en las etiquetas
+	FechaCrea time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha_crea"`
+}
+
+func (CodigoBarras) TableName() string {
+	return "codigos_barras"
+}
+
+// KitComponente es una línea de la lista de materiales de un kit: cuántas unidades base del
+// componente lleva cada kit
+type KitComponente struct {
+	KitSKU        string `gorm:"primaryKey;size:20;column:kit_sku" json:"kit_sku"`
+	ComponenteSKU string `gorm:"primaryKey;size:20;column:componente_sku" json:"componente_sku" binding:"required"`
+	Cantidad      int    `gorm:"not null;check:cantidad > 0" json:"cantidad" binding:"required,gt=0"`
+
+	Componente Producto `gorm:"foreignKey:ComponenteSKU;references:SKU;constraint:OnDelete:RESTRICT" json:"componente,omitempty"`
+}
+
+func (KitComponente) TableName() string {
+	return "kit_componentes"
+}
+
+// Categoria agrupa productos en un árbol: una categoría sin padre es raíz
 type Categoria struct {
-	ID     uint   `gorm:"primaryKey" json:"id"`
-	Nombre string `gorm:"size:100;not null;unique" json:"nombre"`
+	ID      uint   `gorm:"primaryKey" json:"id"`
+	Nombre  string `gorm:"size:100;not null;unique" json:"nombre"`
+	PadreID *uint  `gorm:"column:padre_id;index" json:"padre_id"`
+
+	Hijas []Categoria `gorm:"foreignKey:PadreID;references:ID;constraint:OnDelete:RESTRICT" json:"hijas,omitempty"`
 }
 
 type Proveedor struct {
@@ -55,8 +233,9 @@ func (StockProveedor) TableName() string {
 }
 
 type TipoSucursal struct {
-	ID     uint   `gorm:"primaryKey" json:"id"`
-	Nombre string `gorm:"size:50;not null" json:"nombre"`
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	Nombre   string `gorm:"size:50;not null" json:"nombre"`
+	EsBodega bool   `gorm:"not null;default:false" json:"es_bodega"` // las sucursales de este tipo almacenan mercadería
 }
 
 func (TipoSucursal) TableName() string {
@@ -72,6 +251,11 @@ type Sucursal struct {
 	Ciudad    string `gorm:"size:100;not null" json:"ciudad"`
 	TipoID    uint   `gorm:"column:tipo_id;not null" json:"tipo_id"`
 
+	// Capacidad de almacenamiento; 0 es sin límite informado
+	CapacidadVolumen float64 `gorm:"type:numeric(12,2);not null;default:0" json:"capacidad_volumen" binding:"min=0"` // m³
+	CapacidadPeso    float64 `gorm:"type:numeric(12,2);not null;default:0" json:"capacidad_peso" binding:"min=0"`    // kg
+	PosicionesPallet int     `gorm:"not null;default:0" json:"posiciones_pallet" binding:"min=0"`
+
 	Tipo TipoSucursal `gorm:"foreignKey:TipoID;references:ID;constraint:OnDelete:CASCADE" json:"tipo"`
 }
 
@@ -80,10 +264,18 @@ func (Sucursal) TableName() string {
 }
 
 type StockSucursal struct {
-	SKU        string  `gorm:"primaryKey;size:20;column:sku" json:"sku" binding:"required"`
-	SucursalID uint    `gorm:"primaryKey;column:sucursal_id" json:"sucursal_id" binding:"required"`
-	Cantidad   int     `gorm:"not null" json:"cantidad" binding:"required,min=0"`
-	Descuento  float64 `gorm:"type:numeric(5,2);default:0;check:descuento >= 0 AND descuento <= 100" json:"descuento" binding:"min=0,max=100"`
+	SKU        string `gorm:"primaryKey;size:20;column:sku" json:"sku" binding:"required"`
+	SucursalID uint   `gorm:"primaryKey;column:sucursal_id" json:"sucursal_id" binding:"required"`
+	Cantidad   int    `gorm:"not null;check:cantidad >= 0" json:"cantidad" binding:"required,min=0"`
+	Version    uint   `gorm:"not null;default:1" json:"version"` // control de concurrencia optimista
+
+	// Parámetros de reposición
+	StockMinimo  int `gorm:"not null;default:0" json:"stock_minimo" binding:"min=0"`
+	StockMaximo  int `gorm:"not null;default:0" json:"stock_maximo" binding:"min=0"`
+	PuntoReorden int `gorm:"not null;default:0" json:"punto_reorden" binding:"min=0"`
+
+	// Costo promedio ponderado vigente de las unidades en la sucursal
+	CostoPromedio float64 `gorm:"type:numeric(12,4);not null;default:0" json:"costo_promedio" binding:"min=0"`
 
 	Producto Producto `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:CASCADE" json:"producto,omitempty"`
 	Sucursal Sucursal `gorm:"foreignKey:SucursalID;references:ID;constraint:OnDelete:CASCADE" json:"sucursal,omitempty"`
@@ -93,6 +285,445 @@ func (StockSucursal) TableName() string {
 	return "stock_sucursal"
 }
 
+// Promocion es una regla de descuento vigente entre Inicio y Fin (sin fin, indefinida). Cada filtro
+// vacío aplica a todos: SKU, categoría, sucursal y tipo de cliente. Entre las promociones que
+// aplican manda la de mayor prioridad; las acumulables se suman sobre otras acumulables
+type Promocion struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Nombre        string     `gorm:"size:100;not null" json:"nombre" binding:"required"`
+	Descuento     float64    `gorm:"type:numeric(5,2);not null;check:descuento > 0 AND descuento <= 100" json:"descuento" binding:"required,gt=0,max=100"`
+	SKU           *string    `gorm:"size:20;column:sku;index" json:"sku,omitempty"`
+	CategoriaID   *uint      `gorm:"column:categoria_id;index" json:"categoria_id,omitempty"`
+	SucursalID    *uint      `gorm:"column:sucursal_id;index" json:"sucursal_id,omitempty"`
+	TipoClienteID *uint      `gorm:"column:tipo_cliente_id" json:"tipo_cliente_id,omitempty"`
+	Inicio        time.Time  `gorm:"not null;index" json:"inicio" binding:"required"`
+	Fin           *time.Time `gorm:"index" json:"fin,omitempty"`
+	Prioridad     int        `gorm:"not null;default:0" json:"prioridad"`
+	Acumulable    bool       `gorm:"not null;default:false" json:"acumulable"`
+	Activa        bool       `gorm:"not null;default:true" json:"activa"`
+	Usuario       string     `gorm:"size:100" json:"usuario"`
+	FechaCrea     time.Time  `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha_crea"`
+
+	Producto    *Producto    `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:CASCADE" json:"producto,omitempty"`
+	Categoria   *Categoria   `gorm:"foreignKey:CategoriaID;references:ID;constraint:OnDelete:CASCADE" json:"categoria,omitempty"`
+	Sucursal    *Sucursal    `gorm:"foreignKey:SucursalID;references:ID;constraint:OnDelete:CASCADE" json:"sucursal,omitempty"`
+	TipoCliente *TipoCliente `gorm:"foreignKey:TipoClienteID;references:ID;constraint:OnDelete:CASCADE" json:"tipo_cliente,omitempty"`
+}
+
+func (Promocion) TableName() string {
+	return "promociones"
+}
+
+// MovimientoStock registra cada cambio de stock de un SKU en una sucursal (kardex)
+type MovimientoStock struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	SKU             string    `gorm:"size:20;not null;column:sku;index:idx_movimientos_stock_sku_sucursal" json:"sku"`
+	SucursalID      uint      `gorm:"not null;column:sucursal_id;index:idx_movimientos_stock_sku_sucursal" json:"sucursal_id"`
+	Tipo            string    `gorm:"size:20;not null" json:"tipo"`
+	Cantidad        int       `gorm:"not null" json:"cantidad"` // positivo entra, negativo sale
+	SaldoResultante int       `gorm:"not null" json:"saldo_resultante"`
+	Usuario         string    `gorm:"size:100" json:"usuario"`
+	Referencia      string    `gorm:"size:100" json:"referencia"`
+	Fecha           time.Time `gorm:"not null;default:CURRENT_TIMESTAMP;index" json:"fecha"`
+	CostoUnitario   float64   `gorm:"type:numeric(12,4);not null;default:0" json:"costo_unitario"` // costo de entrada, o costo de lo que salió
+	DespachoID      *uint     `gorm:"column:despacho_id;index" json:"despacho_id,omitempty"`       // despacho de la salida o de su anulación
+
+	// Lote que ingresa, o del que se quiere sacar, en productos que manejan lotes
+	Lote             string     `gorm:"size:50" json:"lote,omitempty"`
+	FechaFabricacion *time.Time `gorm:"-" json:"-"`
+	FechaVencimiento *time.Time `gorm:"-" json:"-"`
+
+	// Números de serie de las unidades que entran o salen, en productos serializados
+	NumerosSerie []string `gorm:"-" json:"-"`
+
+	// Ubicación dentro de la sucursal a la que entra, o de la que se quiere sacar, la mercadería
+	UbicacionID *uint `gorm:"-" json:"-"`
+
+	Advertencias []string `gorm:"-" json:"advertencias,omitempty"` // capacidad excedida en la sucursal al ingresar
+
+	// El kardex es el registro de auditoría: no se puede eliminar un producto o sucursal con movimientos
+	Producto    Producto              `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:RESTRICT" json:"-"`
+	Sucursal    Sucursal              `gorm:"foreignKey:SucursalID;references:ID;constraint:OnDelete:RESTRICT" json:"-"`
+	Lotes       []MovimientoLote      `gorm:"foreignKey:MovimientoID;references:ID;constraint:OnDelete:CASCADE" json:"lotes,omitempty"`
+	Series      []MovimientoSerie     `gorm:"foreignKey:MovimientoID;references:ID;constraint:OnDelete:CASCADE" json:"series,omitempty"`
+	Ubicaciones []MovimientoUbicacion `gorm:"foreignKey:MovimientoID;references:ID;constraint:OnDelete:CASCADE" json:"ubicaciones,omitempty"`
+}
+
+func (MovimientoStock) TableName() string {
+	return "movimientos_stock"
+}
+
+// StockLote es la parte del stock de un SKU en una sucursal que pertenece a un lote
+type StockLote struct {
+	ID               uint       `gorm:"primaryKey" json:"id"`
+	SKU              string     `gorm:"size:20;not null;column:sku;uniqueIndex:idx_stock_lote" json:"sku"`
+	SucursalID       uint       `gorm:"not null;column:sucursal_id;uniqueIndex:idx_stock_lote" json:"sucursal_id"`
+	Lote             string     `gorm:"size:50;not null;uniqueIndex:idx_stock_lote" json:"lote"`
+	FechaFabricacion *time.Time `json:"fecha_fabricacion,omitempty"`
+	FechaVencimiento *time.Time `gorm:"index" json:"fecha_vencimiento,omitempty"`
+	Cantidad         int        `gorm:"not null;default:0" json:"cantidad"`
+	FechaIngreso     time.Time  `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha_ingreso"`
+
+	Producto Producto `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:CASCADE" json:"producto,omitempty"`
+	Sucursal Sucursal `gorm:"foreignKey:SucursalID;references:ID;constraint:OnDelete:CASCADE" json:"sucursal,omitempty"`
+}
+
+func (StockLote) TableName() string {
+	return "stock_lote"
+}
+
+// CapaCosto es una entrada de stock con su costo, consumida en orden FIFO por las salidas
+type CapaCosto struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	SKU           string    `gorm:"size:20;not null;column:sku;index:idx_capas_costo_sku_sucursal" json:"sku"`
+	SucursalID    uint      `gorm:"not null;column:sucursal_id;index:idx_capas_costo_sku_sucursal" json:"sucursal_id"`
+	Fecha         time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha"`
+	Cantidad      int       `gorm:"not null" json:"cantidad"`
+	Restante      int       `gorm:"not null" json:"restante"`
+	CostoUnitario float64   `gorm:"type:numeric(12,4);not null" json:"costo_unitario"`
+}
+
+func (CapaCosto) TableName() string {
+	return "capas_costo"
+}
+
+// MovimientoLote detalla en qué lotes se repartió un movimiento de stock
+type MovimientoLote struct {
+	ID           uint `gorm:"primaryKey" json:"id"`
+	MovimientoID uint `gorm:"column:movimiento_id;not null;index" json:"movimiento_id"`
+	LoteID       uint `gorm:"column:lote_id;not null;index" json:"lote_id"`
+	Cantidad     int  `gorm:"not null" json:"cantidad"` // mismo signo que el movimiento
+
+	Lote StockLote `gorm:"foreignKey:LoteID;references:ID;constraint:OnDelete:CASCADE" json:"lote,omitempty"`
+}
+
+func (MovimientoLote) TableName() string {
+	return "movimiento_lote"
+}
+
+// Ubicacion es una posición física dentro de una sucursal: zona, pasillo, rack y nivel. Solo la
+// zona es obligatoria; el código se arma con las partes informadas (ej. "A-03-R2-N1")
+type Ubicacion struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	SucursalID uint   `gorm:"not null;column:sucursal_id;uniqueIndex:idx_ubicacion_codigo" json:"sucursal_id" binding:"required"`
+	Codigo     string `gorm:"size:50;not null;uniqueIndex:idx_ubicacion_codigo" json:"codigo"`
+	Zona       string `gorm:"size:20;not null" json:"zona" binding:"required"`
+	Pasillo    string `gorm:"size:20" json:"pasillo"`
+	Rack       string `gorm:"size:20" json:"rack"`
+	Nivel      string `gorm:"size:20" json:"nivel"`
+	Recepcion  bool   `gorm:"not null;default:false" json:"recepcion"` // área donde queda lo recibido hasta ubicarlo
+	Activa     bool   `gorm:"not null;default:true" json:"activa"`
+
+	Sucursal Sucursal `gorm:"foreignKey:SucursalID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (Ubicacion) TableName() string {
+	return "ubicaciones"
+}
+
+// StockUbicacion es la parte del stock de un SKU en una sucursal que está en una ubicación
+type StockUbicacion struct {
+	SKU         string `gorm:"primaryKey;size:20;column:sku" json:"sku"`
+	UbicacionID uint   `gorm:"primaryKey;column:ubicacion_id" json:"ubicacion_id"`
+	SucursalID  uint   `gorm:"not null;column:sucursal_id;index" json:"sucursal_id"`
+	Cantidad    int    `gorm:"not null;default:0;check:cantidad >= 0" json:"cantidad"`
+
+	Producto  Producto  `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:CASCADE" json:"producto,omitempty"`
+	Ubicacion Ubicacion `gorm:"foreignKey:UbicacionID;references:ID;constraint:OnDelete:RESTRICT" json:"ubicacion"`
+}
+
+func (StockUbicacion) TableName() string {
+	return "stock_ubicacion"
+}
+
+// MovimientoUbicacion detalla de qué ubicaciones salió, o a cuáles entró, un movimiento de stock
+type MovimientoUbicacion struct {
+	ID           uint `gorm:"primaryKey" json:"id"`
+	MovimientoID uint `gorm:"column:movimiento_id;not null;index" json:"movimiento_id"`
+	UbicacionID  uint `gorm:"column:ubicacion_id;not null;index" json:"ubicacion_id"`
+	Cantidad     int  `gorm:"not null" json:"cantidad"` // mismo signo que el movimiento
+
+	Ubicacion Ubicacion `gorm:"foreignKey:UbicacionID;references:ID;constraint:OnDelete:RESTRICT" json:"ubicacion,omitempty"`
+}
+
+func (MovimientoUbicacion) TableName() string {
+	return "movimiento_ubicacion"
+}
+
+// TrasladoUbicacion registra un cambio de ubicación dentro de una sucursal: el ubicado de lo
+// recibido o un movimiento entre ubicaciones. No cambia el stock de la sucursal
+type TrasladoUbicacion struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	SKU        string    `gorm:"size:20;not null;column:sku;index" json:"sku"`
+	SucursalID uint      `gorm:"not null;column:sucursal_id;index" json:"sucursal_id"`
+	OrigenID   uint      `gorm:"not null;column:origen_id" json:"origen_id"`
+	DestinoID  uint      `gorm:"not null;column:destino_id" json:"destino_id"`
+	Cantidad   int       `gorm:"not null;check:cantidad > 0" json:"cantidad"`
+	Tipo       string    `gorm:"size:20;not null" json:"tipo"` // ubicado o traslado
+	Usuario    string    `gorm:"size:100" json:"usuario"`
+	Fecha      time.Time `gorm:"not null;default:CURRENT_TIMESTAMP;index" json:"fecha"`
+
+	Origen  Ubicacion `gorm:"foreignKey:OrigenID;references:ID;constraint:OnDelete:RESTRICT" json:"origen"`
+	Destino Ubicacion `gorm:"foreignKey:DestinoID;references:ID;constraint:OnDelete:RESTRICT" json:"destino"`
+}
+
+func (TrasladoUbicacion) TableName() string {
+	return "traslados_ubicacion"
+}
+
+// NumeroSerie es una unidad de un producto serializado; SucursalID es donde está hoy
+// (nil si salió de las sucursales por despacho, tránsito o baja)
+type NumeroSerie struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	SKU          string    `gorm:"size:20;not null;column:sku;uniqueIndex:idx_numero_serie" json:"sku"`
+	Serie        string    `gorm:"size:100;not null;uniqueIndex:idx_numero_serie" json:"serie"`
+	SucursalID   *uint     `gorm:"column:sucursal_id;index" json:"sucursal_id"`
+	Estado       string    `gorm:"size:20;not null;default:'disponible'" json:"estado"`
+	FechaIngreso time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha_ingreso"`
+
+	Producto Producto  `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:CASCADE" json:"producto,omitempty"`
+	Sucursal *Sucursal `gorm:"foreignKey:SucursalID;references:ID;constraint:OnDelete:SET NULL" json:"sucursal,omitempty"`
+}
+
+func (NumeroSerie) TableName() string {
+	return "numeros_serie"
+}
+
+// MovimientoSerie indica qué unidades serializadas se movieron en un movimiento de stock
+type MovimientoSerie struct {
+	MovimientoID uint `gorm:"primaryKey;column:movimiento_id" json:"movimiento_id"`
+	SerieID      uint `gorm:"primaryKey;column:serie_id;index" json:"serie_id"`
+
+	Serie NumeroSerie `gorm:"foreignKey:SerieID;references:ID;constraint:OnDelete:CASCADE" json:"serie,omitempty"`
+}
+
+func (MovimientoSerie) TableName() string {
+	return "movimiento_serie"
+}
+
+// Transferencia es una orden de traslado de stock entre dos sucursales
+type Transferencia struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	OrigenID       uint       `gorm:"column:origen_id;not null" json:"origen_id"`
+	DestinoID      uint       `gorm:"column:destino_id;not null" json:"destino_id"`
+	Estado         string     `gorm:"size:20;not null;default:'borrador'" json:"estado"`
+	Usuario        string     `gorm:"size:100" json:"usuario"`
+	Observacion    string     `gorm:"type:text" json:"observacion"`
+	FechaCrea      time.Time  `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha_crea"`
+	FechaDespacho  *time.Time `json:"fecha_despacho,omitempty"`
+	FechaRecepcion *time.Time `json:"fecha_recepcion,omitempty"`
+
+	Origen        Sucursal                    `gorm:"foreignKey:OrigenID;references:ID;constraint:OnDelete:CASCADE" json:"origen"`
+	Destino       Sucursal                    `gorm:"foreignKey:DestinoID;references:ID;constraint:OnDelete:CASCADE" json:"destino"`
+	Lineas        []TransferenciaLinea        `gorm:"foreignKey:TransferenciaID;references:ID;constraint:OnDelete:CASCADE" json:"lineas"`
+	Discrepancias []DiscrepanciaTransferencia `gorm:"foreignKey:TransferenciaID;references:ID;constraint:OnDelete:CASCADE" json:"discrepancias,omitempty"`
+
+	Advertencias []string `gorm:"-" json:"advertencias,omitempty"` // capacidad excedida en el destino al recibir
+}
+
+func (Transferencia) TableName() string {
+	return "transferencias"
+}
+
+type TransferenciaLinea struct {
+	TransferenciaID  uint   `gorm:"primaryKey;column:transferencia_id" json:"transferencia_id"`
+	SKU              string `gorm:"primaryKey;size:20;column:sku" json:"sku" binding:"required"`
+	Cantidad         int    `gorm:"not null" json:"cantidad" binding:"required,min=1"`
+	CantidadRecibida int    `gorm:"not null;default:0" json:"cantidad_recibida"`
+
+	Producto Producto `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:CASCADE" json:"producto,omitempty"`
+}
+
+func (TransferenciaLinea) TableName() string {
+	return "transferencia_linea"
+}
+
+// DiscrepanciaTransferencia registra la diferencia entre lo despachado y lo recibido de una línea
+type DiscrepanciaTransferencia struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	TransferenciaID uint      `gorm:"column:transferencia_id;not null;index" json:"transferencia_id"`
+	SKU             string    `gorm:"size:20;column:sku;not null" json:"sku"`
+	Cantidad        int       `gorm:"not null" json:"cantidad"` // recibido - despachado
+	Motivo          string    `gorm:"type:text" json:"motivo"`
+	Usuario         string    `gorm:"size:100" json:"usuario"`
+	Fecha           time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha"`
+}
+
+func (DiscrepanciaTransferencia) TableName() string {
+	return "discrepancia_transferencia"
+}
+
+// OrdenCompra es un pedido de productos a un proveedor con destino a una sucursal
+type OrdenCompra struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	ProveedorID   uint       `gorm:"column:proveedor_id;not null" json:"proveedor_id"`
+	SucursalID    uint       `gorm:"column:sucursal_id;not null" json:"sucursal_id"`
+	Estado        string     `gorm:"size:20;not null;default:'borrador'" json:"estado"`
+	FechaCrea     time.Time  `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha_crea"`
+	FechaEsperada *time.Time `json:"fecha_esperada,omitempty"`
+	Usuario       string     `gorm:"size:100" json:"usuario"`
+	Observacion   string     `gorm:"type:text" json:"observacion"`
+
+	Proveedor   Proveedor          `gorm:"foreignKey:ProveedorID;references:ID;constraint:OnDelete:CASCADE" json:"proveedor"`
+	Sucursal    Sucursal           `gorm:"foreignKey:SucursalID;references:ID;constraint:OnDelete:CASCADE" json:"sucursal"`
+	Lineas      []OrdenCompraLinea `gorm:"foreignKey:OrdenCompraID;references:ID;constraint:OnDelete:CASCADE" json:"lineas"`
+	Recepciones []RecepcionCompra  `gorm:"foreignKey:OrdenCompraID;references:ID;constraint:OnDelete:CASCADE" json:"recepciones,omitempty"`
+
+	Advertencias []string `gorm:"-" json:"advertencias,omitempty"` // capacidad excedida en la sucursal al recibir
+}
+
+func (OrdenCompra) TableName() string {
+	return "ordenes_compra"
+}
+
+type OrdenCompraLinea struct {
+	OrdenCompraID    uint    `gorm:"primaryKey;column:orden_compra_id" json:"orden_compra_id"`
+	SKU              string  `gorm:"primaryKey;size:20;column:sku" json:"sku"`
+	Cantidad         int     `gorm:"not null" json:"cantidad"`
+	CantidadRecibida int     `gorm:"not null;default:0" json:"cantidad_recibida"`
+	CostoUnitario    float64 `gorm:"type:numeric(10,2);not null;default:0" json:"costo_unitario"`
+
+	Producto Producto `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:CASCADE" json:"producto,omitempty"`
+}
+
+func (OrdenCompraLinea) TableName() string {
+	return "orden_compra_linea"
+}
+
+// RecepcionCompra registra una entrega (total o parcial) de una orden de compra
+type RecepcionCompra struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	OrdenCompraID uint      `gorm:"column:orden_compra_id;not null;index" json:"orden_compra_id"`
+	Fecha         time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha"`
+	Usuario       string    `gorm:"size:100" json:"usuario"`
+	Observacion   string    `gorm:"type:text" json:"observacion"`
+
+	Lineas []RecepcionCompraLinea `gorm:"foreignKey:RecepcionID;references:ID;constraint:OnDelete:CASCADE" json:"lineas"`
+}
+
+func (RecepcionCompra) TableName() string {
+	return "recepcion_compra"
+}
+
+// RecepcionCompraLinea es lo recibido de un SKU en una recepción; un mismo SKU puede llegar en
+// varias líneas si trae lotes distintos
+type RecepcionCompraLinea struct {
+	RecepcionID uint   `gorm:"primaryKey;column:recepcion_id" json:"recepcion_id"`
+	SKU         string `gorm:"primaryKey;size:20;column:sku" json:"sku"`
+	Lote        string `gorm:"primaryKey;size:50;not null;default:''" json:"lote,omitempty"`
+	Cantidad    int    `gorm:"not null" json:"cantidad"`
+}
+
+func (RecepcionCompraLinea) TableName() string {
+	return "recepcion_compra_linea"
+}
+
+// ConteoInventario es una sesión de toma de inventario físico en una sucursal. Al abrirla se
+// congelan las cantidades esperadas de StockSucursal para los productos incluidos.
+type ConteoInventario struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	SucursalID      uint       `gorm:"column:sucursal_id;not null;index" json:"sucursal_id"`
+	CategoriaID     *uint      `gorm:"column:categoria_id" json:"categoria_id,omitempty"`
+	Estado          string     `gorm:"size:20;not null;default:'abierto'" json:"estado"`
+	Usuario         string     `gorm:"size:100" json:"usuario"`
+	Observacion     string     `gorm:"type:text" json:"observacion"`
+	FechaCrea       time.Time  `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha_crea"`
+	FechaAprobacion *time.Time `json:"fecha_aprobacion,omitempty"`
+	AprobadoPor     string     `gorm:"size:100" json:"aprobado_por,omitempty"`
+
+	Sucursal  Sucursal                `gorm:"foreignKey:SucursalID;references:ID;constraint:OnDelete:CASCADE" json:"sucursal"`
+	Lineas    []ConteoInventarioLinea `gorm:"foreignKey:ConteoID;references:ID;constraint:OnDelete:CASCADE" json:"lineas"`
+	Registros []RegistroConteo        `gorm:"foreignKey:ConteoID;references:ID;constraint:OnDelete:CASCADE" json:"registros,omitempty"`
+}
+
+func (ConteoInventario) TableName() string {
+	return "conteos_inventario"
+}
+
+// ConteoInventarioLinea guarda la cantidad esperada congelada y la contada de un SKU
+type ConteoInventarioLinea struct {
+	ConteoID         uint   `gorm:"primaryKey;column:conteo_id" json:"conteo_id"`
+	SKU              string `gorm:"primaryKey;size:20;column:sku" json:"sku"`
+	CantidadEsperada int    `gorm:"not null" json:"cantidad_esperada"`
+	CantidadContada  *int   `json:"cantidad_contada"` // nil mientras nadie lo haya contado
+	MotivoAjuste     string `gorm:"size:30" json:"motivo_ajuste,omitempty"`
+
+	Producto Producto `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:CASCADE" json:"producto,omitempty"`
+}
+
+func (ConteoInventarioLinea) TableName() string {
+	return "conteo_inventario_linea"
+}
+
+// RegistroConteo es la cantidad que un contador informó para un SKU dentro de un conteo
+type RegistroConteo struct {
+	ID       uint      `gorm:"primaryKey" json:"id"`
+	ConteoID uint      `gorm:"column:conteo_id;not null;uniqueIndex:idx_registro_conteo_contador" json:"conteo_id"`
+	SKU      string    `gorm:"size:20;column:sku;not null;uniqueIndex:idx_registro_conteo_contador" json:"sku"`
+	Contador string    `gorm:"size:100;not null;uniqueIndex:idx_registro_conteo_contador" json:"contador"`
+	Cantidad int       `gorm:"not null" json:"cantidad"`
+	Fecha    time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha"`
+}
+
+func (RegistroConteo) TableName() string {
+	return "registro_conteo"
+}
+
+// ImportacionStock registra cada carga masiva de stock aplicada, como auditoría
+type ImportacionStock struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Archivo       string    `gorm:"size:200;not null" json:"archivo"`
+	Formato       string    `gorm:"size:10;not null" json:"formato"` // csv, xlsx
+	Filas         int       `gorm:"not null" json:"filas"`
+	Creados       int       `gorm:"not null;default:0" json:"creados"`     // registros de stock nuevos
+	Ajustados     int       `gorm:"not null;default:0" json:"ajustados"`   // registros con diferencia de cantidad
+	SinCambios    int       `gorm:"not null;default:0" json:"sin_cambios"` // filas que ya coincidían
+	UnidadesNetas int       `gorm:"not null;default:0" json:"unidades_netas"`
+	Usuario       string    `gorm:"size:100" json:"usuario"`
+	Fecha         time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha"`
+}
+
+func (ImportacionStock) TableName() string {
+	return "importaciones_stock"
+}
+
+// CierreInventario congela el stock valorizado al último día de un mes. Sus líneas no dependen
+// de stock_sucursal ni de productos, para que ediciones o eliminaciones posteriores no cambien
+// las cifras ya reportadas
+type CierreInventario struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Periodo       string    `gorm:"size:7;not null;uniqueIndex" json:"periodo"` // AAAA-MM
+	FechaCorte    time.Time `gorm:"not null;index" json:"fecha_corte"`          // primer instante del mes siguiente
+	Metodo        string    `gorm:"size:20;not null" json:"metodo"`             // promedio, fifo
+	TotalUnidades int       `gorm:"not null;default:0" json:"total_unidades"`
+	TotalValor    float64   `gorm:"type:numeric(14,2);not null;default:0" json:"total_valor"`
+	Usuario       string    `gorm:"size:100" json:"usuario"`
+	Fecha         time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha"`
+
+	Lineas []CierreInventarioLinea `gorm:"foreignKey:CierreID;references:ID;constraint:OnDelete:CASCADE" json:"lineas,omitempty"`
+}
+
+func (CierreInventario) TableName() string {
+	return "cierres_inventario"
+}
+
+type CierreInventarioLinea struct {
+	CierreID      uint    `gorm:"primaryKey;column:cierre_id" json:"cierre_id"`
+	SKU           string  `gorm:"primaryKey;size:20;column:sku" json:"sku"`
+	SucursalID    uint    `gorm:"primaryKey;column:sucursal_id" json:"sucursal_id"`
+	Nombre        string  `gorm:"size:100" json:"nombre"`
+	Sucursal      string  `gorm:"size:100" json:"sucursal"`
+	CategoriaID   *uint   `gorm:"column:categoria_id" json:"categoria_id,omitempty"`
+	Categoria     string  `gorm:"size:100" json:"categoria,omitempty"`
+	Cantidad      int     `gorm:"not null" json:"cantidad"`
+	CostoUnitario float64 `gorm:"type:numeric(12,4);not null;default:0" json:"costo_unitario"`
+	Valor         float64 `gorm:"type:numeric(14,2);not null;default:0" json:"valor"`
+}
+
+func (CierreInventarioLinea) TableName() string {
+	return "cierre_inventario_linea"
+}
+
 type Rol struct {
 	ID     uint   `gorm:"primaryKey" json:"id"`
 	Nombre string `gorm:"size:50;not null" json:"nombre"`
@@ -166,7 +797,11 @@ type CotizacionItem struct {
 	CotizacionID uint   `gorm:"primaryKey;column:cotizacion_id" json:"cotizacion_id"`
 	ProductoID   string `gorm:"primaryKey;size:20;column:producto_id" json:"producto_id"`
 	SucursalID   uint   `gorm:"primaryKey;column:sucursal_id" json:"sucursal_id"`
-	Cantidad     int    `gorm:"not null" json:"cantidad"`
+	Cantidad     int    `gorm:"not null" json:"cantidad"` // en unidad base del producto
+
+	// Unidad en que se cotizó y la cantidad en esa unidad, si no fue la unidad base
+	Unidad         string  `gorm:"size:20" json:"unidad,omitempty"`
+	CantidadUnidad float64 `gorm:"type:numeric(12,4)" json:"cantidad_unidad,omitempty"`
 
 	Cotizacion Cotizacion `gorm:"foreignKey:CotizacionID;references:ID;constraint:OnDelete:CASCADE" json:"cotizacion"`
 	Producto   Producto   `gorm:"foreignKey:ProductoID;references:SKU;constraint:OnDelete:CASCADE" json:"producto"`
@@ -177,6 +812,28 @@ func (CotizacionItem) TableName() string {
 	return "cotizacion_item"
 }
 
+// ReservaStock retiene stock de una sucursal para un ítem de cotización hasta que se despacha, libera o expira
+type ReservaStock struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	CotizacionID uint      `gorm:"column:cotizacion_id;not null;index" json:"cotizacion_id"`
+	SKU          string    `gorm:"size:20;column:sku;not null;index:idx_reservas_stock_sku_sucursal" json:"sku"`
+	SucursalID   uint      `gorm:"column:sucursal_id;not null;index:idx_reservas_stock_sku_sucursal" json:"sucursal_id"`
+	Cantidad     int       `gorm:"not null" json:"cantidad"`
+	Estado       string    `gorm:"size:20;not null;default:'activa'" json:"estado"`
+	DespachoID   *uint     `gorm:"column:despacho_id" json:"despacho_id,omitempty"`
+	Usuario      string    `gorm:"size:100" json:"usuario"`
+	FechaCrea    time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"fecha_crea"`
+	FechaExpira  time.Time `gorm:"not null" json:"fecha_expira"`
+
+	Cotizacion Cotizacion `gorm:"foreignKey:CotizacionID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+	Producto   Producto   `gorm:"foreignKey:SKU;references:SKU;constraint:OnDelete:CASCADE" json:"-"`
+	Sucursal   Sucursal   `gorm:"foreignKey:SucursalID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (ReservaStock) TableName() string {
+	return "reservas_stock"
+}
+
 type TipoCamion struct {
 	ID         uint    `gorm:"primaryKey" json:"id"`
 	Volumen    float64 `gorm:"type:numeric(10,2);not null" json:"volumen"`
@@ -211,6 +868,7 @@ type Despacho struct {
 	Estado             string    `gorm:"size:20;not null;default:'pendiente'" json:"estado"`
 	DistanciaCalculada *string   `gorm:"size:50" json:"distancia_calculada,omitempty"`
 	TiempoEstimado     *string   `gorm:"size:50" json:"tiempo_estimado,omitempty"`
+	Version            uint      `gorm:"not null;default:1" json:"version"` // control de concurrencia optimista
 
 	Cotizacion        Cotizacion          `gorm:"foreignKey:CotizacionID;references:ID;constraint:OnDelete:CASCADE" json:"cotizacion"`
 	Camion            Camion              `gorm:"foreignKey:CamionID;references:ID;constraint:OnDelete:CASCADE" json:"camion"`
@@ -224,18 +882,77 @@ func (Despacho) TableName() string {
 }
 
 type ProductosDespacho struct {
-	DespachoID uint   `gorm:"primaryKey;column:despacho_id" json:"despacho_id"`
-	ProductoID string `gorm:"primaryKey;size:20;column:sku" json:"producto_id"`
-	Cantidad   int    `gorm:"not null" json:"cantidad"`
+	DespachoID uint    `gorm:"primaryKey;column:despacho_id" json:"despacho_id"`
+	ProductoID string  `gorm:"primaryKey;size:20;column:sku" json:"producto_id"`
+	Cantidad   int     `gorm:"not null" json:"cantidad"`                                 // en unidad base del producto
+	CostoTotal float64 `gorm:"type:numeric(12,2);not null;default:0" json:"costo_total"` // costo de la mercadería despachada
+
+	// Unidad en que se pidió la línea y la cantidad en esa unidad, si no fue la unidad base
+	Unidad         string  `gorm:"size:20" json:"unidad,omitempty"`
+	CantidadUnidad float64 `gorm:"type:numeric(12,4)" json:"cantidad_unidad,omitempty"`
 
-	Despacho Despacho `gorm:"foreignKey:DespachoID;references:ID;constraint:OnDelete:CASCADE" json:"despacho"`
-	Producto Producto `gorm:"foreignKey:ProductoID;references:SKU;constraint:OnDelete:CASCADE" json:"producto"`
+	// Unidades que salieron como componente de kits despachados en el mismo despacho. Una línea
+	// con Cantidad 0 existe solo para registrar esos componentes con sus lotes y series
+	CantidadEnKits int `gorm:"not null;default:0" json:"cantidad_en_kits"`
+
+	Despacho    Despacho                      `gorm:"foreignKey:DespachoID;references:ID;constraint:OnDelete:CASCADE" json:"despacho"`
+	Producto    Producto                      `gorm:"foreignKey:ProductoID;references:SKU;constraint:OnDelete:CASCADE" json:"producto"`
+	Lotes       []ProductosDespachoLote       `gorm:"foreignKey:DespachoID,SKU;references:DespachoID,ProductoID;constraint:OnDelete:CASCADE" json:"lotes,omitempty"`
+	Series      []ProductosDespachoSerie      `gorm:"foreignKey:DespachoID,SKU;references:DespachoID,ProductoID;constraint:OnDelete:CASCADE" json:"series,omitempty"`
+	Componentes []ProductosDespachoComponente `gorm:"foreignKey:DespachoID,KitSKU;references:DespachoID,ProductoID;constraint:OnDelete:CASCADE" json:"componentes,omitempty"`
+
+	// Series a despachar de productos serializados (solo en la solicitud)
+	NumerosSerie []string `gorm:"-" json:"numeros_serie,omitempty"`
 }
 
 func (ProductosDespacho) TableName() string {
 	return "productos_despacho"
 }
 
+// ProductosDespachoLote registra los lotes de los que salió una línea de despacho
+type ProductosDespachoLote struct {
+	DespachoID       uint       `gorm:"primaryKey;column:despacho_id" json:"despacho_id"`
+	SKU              string     `gorm:"primaryKey;size:20;column:sku" json:"sku"`
+	KitSKU           string     `gorm:"primaryKey;size:20;column:kit_sku;not null;default:''" json:"kit_sku,omitempty"` // kit en que salió; vacío si salió suelto
+	LoteID           uint       `gorm:"primaryKey;column:lote_id" json:"lote_id"`
+	Lote             string     `gorm:"size:50;not null" json:"lote"`
+	FechaVencimiento *time.Time `json:"fecha_vencimiento,omitempty"`
+	Cantidad         int        `gorm:"not null" json:"cantidad"`
+}
+
+func (ProductosDespachoLote) TableName() string {
+	return "productos_despacho_lote"
+}
+
+// ProductosDespachoSerie registra las unidades serializadas que salieron en una línea de despacho
+type ProductosDespachoSerie struct {
+	DespachoID uint   `gorm:"primaryKey;column:despacho_id" json:"despacho_id"`
+	SKU        string `gorm:"primaryKey;size:20;column:sku" json:"sku"`
+	SerieID    uint   `gorm:"primaryKey;column:serie_id;index" json:"serie_id"`
+	Serie      string `gorm:"size:100;not null" json:"serie"`
+	KitSKU     string `gorm:"size:20;column:kit_sku;not null;default:''" json:"kit_sku,omitempty"` // kit en que salió; vacío si salió suelto
+}
+
+func (ProductosDespachoSerie) TableName() string {
+	return "productos_despacho_serie"
+}
+
+// ProductosDespachoComponente guarda la composición con que salió un kit en un despacho, para que
+// la guía no dependa de cambios posteriores en la lista de materiales
+type ProductosDespachoComponente struct {
+	DespachoID uint    `gorm:"primaryKey;column:despacho_id" json:"despacho_id"`
+	KitSKU     string  `gorm:"primaryKey;size:20;column:kit_sku" json:"kit_sku"`
+	SKU        string  `gorm:"primaryKey;size:20;column:sku" json:"sku"`
+	Cantidad   int     `gorm:"not null" json:"cantidad"`                                 // unidades del componente en total
+	CostoTotal float64 `gorm:"type:numeric(12,2);not null;default:0" json:"costo_total"` // incluido en el costo de la línea del kit
+
+	Componente Producto `gorm:"foreignKey:SKU;references:SKU" json:"componente"`
+}
+
+func (ProductosDespachoComponente) TableName() string {
+	return "productos_despacho_componente"
+}
+
 // DespachoDistanciaResponse es la estructura de respuesta para los endpoints de rutas
 type DespachoDistanciaResponse struct {
 	ID                 uint                         `json:"id"`
@@ -3,6 +3,7 @@ package Routes
 import (
 	"backend-inventario/api/Controllers"
 	"backend-inventario/api/Handlers"
+	"backend-inventario/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -12,12 +13,70 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 	// Grupo de rutas para la API
 	api := router.Group("/api")
 
+	// Archivos subidos (imágenes de productos)
+	almacen := services.NewAlmacenamiento()
+
 	// Rutas para Productos
 	api.GET("/productos", Handlers.GetProductosHandler(db))
 	api.GET("/productos/:sku", Handlers.GetProductoBySKUHandler(db))
+	api.GET("/productos/barcode/:code", Handlers.GetProductoPorCodigoBarrasHandler(db))
+	api.POST("/productos/etiquetas", Handlers.GenerarEtiquetasHandler(db))
 	api.POST("/productos", Handlers.CreateProductoHandler(db))
 	api.PUT("/productos/:sku", Handlers.UpdateProductoHandler(db))
-	api.DELETE("/productos/:sku", Handlers.DeleteProductoHandler(db))
+	api.DELETE("/productos/:sku", Handlers.DeleteProductoHandler(db, almacen))
+	api.GET("/productos/:sku/unidades", Handlers.GetUnidadesProductoHandler(db))
+	api.POST("/productos/:sku/unidades", Handlers.CreateUnidadProductoHandler(db))
+	api.PUT("/productos/:sku/unidades/:id", Handlers.UpdateUnidadProductoHandler(db))
+	api.DELETE("/productos/:sku/unidades/:id", Handlers.DeleteUnidadProductoHandler(db))
+	api.GET("/productos/:sku/componentes", Handlers.GetComponentesKitHandler(db))
+	api.PUT("/productos/:sku/componentes", Handlers.SetComponentesKitHandler(db))
+	api.GET("/productos/:sku/conversion", Handlers.ConvertirUnidadHandler(db))
+	api.GET("/productos/:sku/precio-efectivo", Handlers.GetPrecioEfectivoHandler(db))
+	api.GET("/productos/:sku/precio", Handlers.GetPrecioEnFechaHandler(db))
+	api.GET("/productos/:sku/precios", Handlers.GetHistorialPreciosHandler(db))
+	api.GET("/productos/:sku/imagenes", Handlers.GetImagenesProductoHandler(db))
+	api.POST("/productos/:sku/imagenes", Handlers.SubirImagenProductoHandler(db, almacen))
+	api.PUT("/productos/:sku/imagenes/orden", Handlers.OrdenarImagenesProductoHandler(db))
+	api.GET("/productos/:sku/imagenes/:id", Handlers.ServirImagenProductoHandler(db, almacen, false))
+	api.GET("/productos/:sku/imagenes/:id/miniatura", Handlers.ServirImagenProductoHandler(db, almacen, true))
+	api.DELETE("/productos/:sku/imagenes/:id", Handlers.DeleteImagenProductoHandler(db, almacen))
+	api.GET("/productos/:sku/codigos-barras", Handlers.GetCodigosBarrasProductoHandler(db))
+	api.POST("/productos/:sku/codigos-barras", Handlers.CreateCodigoBarrasHandler(db))
+	api.PUT("/productos/:sku/codigos-barras/:id/principal", Handlers.MarcarCodigoBarrasPrincipalHandler(db))
+	api.DELETE("/productos/:sku/codigos-barras/:id", Handlers.DeleteCodigoBarrasHandler(db))
+
+	// Rutas para Productos padre y sus variantes
+	api.GET("/productos-padre", Handlers.GetProductosPadreHandler(db))
+	api.GET("/productos-padre/:id", Handlers.GetProductoPadreByIDHandler(db))
+	api.GET("/productos-padre/:id/matriz", Handlers.GetMatrizVariantesHandler(db))
+	api.POST("/productos-padre", Handlers.CreateProductoPadreHandler(db))
+	api.PUT("/productos-padre/:id", Handlers.UpdateProductoPadreHandler(db))
+	api.DELETE("/productos-padre/:id", Handlers.DeleteProductoPadreHandler(db))
+	api.PUT("/productos-padre/:id/variantes/:sku", Handlers.AsignarVarianteHandler(db))
+	api.DELETE("/productos-padre/:id/variantes/:sku", Handlers.QuitarVarianteHandler(db))
+
+	// Rutas para Categorías de productos
+	api.GET("/categorias", Handlers.GetCategoriasHandler(db))
+	api.GET("/categorias/arbol", Handlers.GetArbolCategoriasHandler(db))
+	api.GET("/categorias/:id", Handlers.GetCategoriaByIDHandler(db))
+	api.POST("/categorias", Handlers.CreateCategoriaHandler(db))
+	api.PUT("/categorias/:id", Handlers.UpdateCategoriaHandler(db))
+	api.PUT("/categorias/:id/padre", Handlers.MoverCategoriaHandler(db))
+	api.DELETE("/categorias/:id", Handlers.DeleteCategoriaHandler(db))
+
+	// Rutas para Promociones programadas
+	api.GET("/promociones", Handlers.GetPromocionesHandler(db))
+	api.GET("/promociones/:id", Handlers.GetPromocionByIDHandler(db))
+	api.POST("/promociones", Handlers.CreatePromocionHandler(db))
+	api.PUT("/promociones/:id", Handlers.UpdatePromocionHandler(db))
+	api.DELETE("/promociones/:id", Handlers.DeletePromocionHandler(db))
+
+	// Rutas para Cambios de precio programados
+	api.GET("/precios/cambios", Handlers.GetCambiosPrecioHandler(db))
+	api.GET("/precios/cambios/:id", Handlers.GetCambioPrecioByIDHandler(db))
+	api.POST("/precios/cambios", Handlers.ProgramarCambioPrecioHandler(db))
+	api.POST("/precios/cambios/:id/cancelar", Handlers.CancelarCambioPrecioHandler(db))
+	api.GET("/precios/cotizacion/:id", Handlers.RepreciarCotizacionHandler(db))
 
 	// Rutas para Sucursales
 	api.GET("/sucursales", Handlers.GetSucursalesHandler(db))
@@ -28,16 +87,70 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 
 	// Rutas específicas para Bodegas
 	api.GET("/bodegas", Handlers.GetBodegasHandler(db))
-
-	// Ruta temporal de debug para ver tipos de sucursal
-	api.GET("/debug/tipos-sucursal", Handlers.GetTiposSucursalDebugHandler(db))
+	api.GET("/bodegas/utilizacion", Handlers.GetUtilizacionBodegasHandler(db))
 
 	// Rutas para Stock por Sucursal
 	api.GET("/stock-sucursal", Handlers.GetStockSucursalHandler(db))
+	api.GET("/stock-sucursal/exportar", Handlers.ExportarStockHandler(db))
+	api.GET("/stock-sucursal/importaciones", Handlers.GetImportacionesStockHandler(db))
+	api.POST("/stock-sucursal/importar", Handlers.ImportarStockHandler(db))
 	api.GET("/stock-sucursal/:sucursal_id/:sku", Handlers.GetStockSucursalByIDHandler(db))
 	api.POST("/stock-sucursal", Handlers.CreateStockSucursalHandler(db))
 	api.PUT("/stock-sucursal/:sucursal_id/:sku", Handlers.UpdateStockSucursalHandler(db))
 	api.DELETE("/stock-sucursal/:sucursal_id/:sku", Handlers.DeleteStockSucursalHandler(db))
+	api.GET("/stock-sucursal/:sucursal_id/:sku/movimientos", Handlers.GetKardexHandler(db))
+	api.POST("/stock-sucursal/:sucursal_id/:sku/movimientos", Handlers.CreateMovimientoStockHandler(db))
+	api.PUT("/stock-sucursal/:sucursal_id/:sku/reposicion", Handlers.UpdateParametrosReposicionHandler(db))
+	api.GET("/stock-sucursal/:sucursal_id/:sku/lotes", Handlers.GetLotesStockHandler(db))
+	api.GET("/stock-sucursal/:sucursal_id/:sku/series", Handlers.GetSeriesStockHandler(db))
+	api.POST("/stock-sucursal/:sucursal_id/:sku/series", Handlers.RegistrarSeriesExistentesHandler(db))
+
+	// Rutas para Ubicaciones dentro de las sucursales (zona, pasillo, rack, nivel)
+	api.GET("/ubicaciones", Handlers.GetUbicacionesHandler(db))
+	api.GET("/ubicaciones/stock", Handlers.GetStockUbicacionesHandler(db))
+	api.GET("/ubicaciones/traslados", Handlers.GetTrasladosUbicacionHandler(db))
+	api.POST("/ubicaciones/traslados", Handlers.TrasladarStockHandler(db))
+	api.GET("/ubicaciones/:id", Handlers.GetUbicacionByIDHandler(db))
+	api.POST("/ubicaciones", Handlers.CreateUbicacionHandler(db))
+	api.PUT("/ubicaciones/:id", Handlers.UpdateUbicacionHandler(db))
+	api.DELETE("/ubicaciones/:id", Handlers.DeleteUbicacionHandler(db))
+
+	// Rutas para Lotes con vencimiento
+	api.GET("/lotes/por-vencer", Handlers.GetLotesPorVencerHandler(db))
+
+	// Rutas para Números de serie
+	api.GET("/series/:serie", Handlers.GetHistorialSerieHandler(db))
+
+	// Rutas para Valorización de inventario
+	api.GET("/valorizacion", Handlers.GetValorizacionHandler(db))
+
+	// Rutas para Cierres mensuales de inventario
+	api.GET("/cierres-inventario", Handlers.GetCierresInventarioHandler(db))
+	api.GET("/cierres-inventario/:id", Handlers.GetCierreInventarioByIDHandler(db))
+	api.POST("/cierres-inventario", Handlers.CreateCierreInventarioHandler(db))
+
+	// Rutas para Disponibilidad de stock en la red
+	api.GET("/disponibilidad/:sku", Handlers.GetDisponibilidadHandler(db))
+	api.POST("/disponibilidad", Handlers.GetDisponibilidadLoteHandler(db))
+
+	// Rutas para Reposición
+	api.GET("/reposicion/sugerencias", Handlers.GetSugerenciasReposicionHandler(db))
+
+	// Rutas para Reservas de stock por cotización
+	api.GET("/reservas", Handlers.GetReservasHandler(db))
+	api.POST("/reservas/cotizacion/:id", Handlers.ReservarCotizacionHandler(db))
+	api.DELETE("/reservas/cotizacion/:id", Handlers.LiberarReservasCotizacionHandler(db))
+
+	// Rutas para Transferencias entre Sucursales
+	api.GET("/transferencias", Handlers.GetTransferenciasHandler(db))
+	api.GET("/transferencias/en-transito", Handlers.GetStockEnTransitoHandler(db))
+	api.GET("/transferencias/:id", Handlers.GetTransferenciaByIDHandler(db))
+	api.POST("/transferencias", Handlers.CreateTransferenciaHandler(db))
+	api.PUT("/transferencias/:id", Handlers.UpdateTransferenciaHandler(db))
+	api.DELETE("/transferencias/:id", Handlers.DeleteTransferenciaHandler(db))
+	api.POST("/transferencias/:id/despachar", Handlers.DespacharTransferenciaHandler(db))
+	api.POST("/transferencias/:id/en-transito", Handlers.MarcarTransferenciaEnTransitoHandler(db))
+	api.POST("/transferencias/:id/recibir", Handlers.RecibirTransferenciaHandler(db))
 
 	// Rutas para Tipo de Sucursal
 	api.GET("/tipos-sucursal", Handlers.GetTipoSucursalHandler(db))
@@ -53,6 +166,7 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 	api.PUT("/despachos/:id", Handlers.UpdateDespachoHandler(db))
 	api.DELETE("/despachos/:id", Handlers.DeleteDespachoHandler(db))
 	api.POST("/despachos/calcular", Handlers.CalcularDespachoHandler(db))
+	api.POST("/despachos/validar", Handlers.ValidarDespachoHandler(db))
 	api.GET("/despachos/cotizacion/:id", Handlers.GetDespachosPorCotizacionHandler(db))
 	api.POST("/despachos/aprobar", Handlers.AprobarDespachoHandler(db))
 	// Nuevo endpoint para cambiar el estado de los despachos asociados a una cotización
@@ -129,6 +243,25 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 	api.PUT("/stock-proveedor/:proveedor_id/:producto_id", Handlers.UpdateStockProveedorHandler(db))
 	api.DELETE("/stock-proveedor/:proveedor_id/:producto_id", Handlers.DeleteStockProveedorHandler(db))
 
+	// Rutas para Órdenes de Compra a Proveedores
+	api.GET("/ordenes-compra", Handlers.GetOrdenesCompraHandler(db))
+	api.GET("/ordenes-compra/:id", Handlers.GetOrdenCompraByIDHandler(db))
+	api.POST("/ordenes-compra", Handlers.CreateOrdenCompraHandler(db))
+	api.PUT("/ordenes-compra/:id", Handlers.UpdateOrdenCompraHandler(db))
+	api.DELETE("/ordenes-compra/:id", Handlers.DeleteOrdenCompraHandler(db))
+	api.POST("/ordenes-compra/:id/emitir", Handlers.CambiarEstadoOrdenCompraHandler(db, Controllers.OrdenCompraEmitida))
+	api.POST("/ordenes-compra/:id/cancelar", Handlers.CambiarEstadoOrdenCompraHandler(db, Controllers.OrdenCompraCancelada))
+	api.POST("/ordenes-compra/:id/recepciones", Handlers.RecibirOrdenCompraHandler(db))
+
+	// Rutas para Conteos de Inventario físico
+	api.GET("/conteos", Handlers.GetConteosInventarioHandler(db))
+	api.GET("/conteos/:id", Handlers.GetConteoInventarioByIDHandler(db))
+	api.POST("/conteos", Handlers.CreateConteoInventarioHandler(db))
+	api.POST("/conteos/:id/registros", Handlers.RegistrarConteoHandler(db))
+	api.GET("/conteos/:id/diferencias", Handlers.GetDiferenciasConteoHandler(db))
+	api.POST("/conteos/:id/aprobar", Handlers.AprobarConteoHandler(db))
+	api.POST("/conteos/:id/cancelar", Handlers.CancelarConteoHandler(db))
+
 	// Rutas para Productos de Despacho
 	api.GET("/productos_despacho", Handlers.GetProductosDespachoHandler(db))
 	api.GET("/productos_despacho/detallado", Handlers.GetProductosDespachoDetalladoHandler(db))
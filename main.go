@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
-	//	modelos "backend-inventario/api/Models"
+	"backend-inventario/api/Controllers"
+	modelos "backend-inventario/api/Models"
 	"backend-inventario/api/Routes"
 	"backend-inventario/api/db"
 	"backend-inventario/config"
@@ -31,8 +33,15 @@ func main() {
 
 	services.InitFirebase()
 
-	//	modelos.MigrarTablas(database)
-	//	fmt.Println("Migración de tablas exitosa")
+	// Las migraciones crean las tablas y columnas nuevas al partir; con MIGRAR_TABLAS=false se
+	// omiten y deben aplicarse a mano antes de desplegar (ver README)
+	if os.Getenv("MIGRAR_TABLAS") != "false" {
+		modelos.MigrarTablas(database)
+		fmt.Println("Migración de tablas exitosa")
+	}
+
+	// Lleva al catálogo los cambios de precio programados cuando entran en vigencia
+	Controllers.ProgramarAplicacionPrecios(database, time.Minute)
 
 	router := gin.Default()
 
@@ -52,8 +61,8 @@ func main() {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Usuario", "If-Match"},
+		ExposeHeaders:    []string{"Content-Length", "ETag"},
 		AllowCredentials: true,
 	}))
 	router.POST("/auth/verify", handlers.VerifyToken) //Ruta para autenticacion firebase
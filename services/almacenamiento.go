@@ -0,0 +1,94 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrArchivoNoEncontrado indica que no hay un archivo guardado con la clave pedida
+var ErrArchivoNoEncontrado = errors.New("archivo no encontrado")
+
+// Almacenamiento guarda archivos binarios (imágenes de productos) identificados por una clave
+// relativa con "/" como separador, por ejemplo "productos/15.jpg". Permite cambiar el disco local
+// por un almacenamiento de objetos sin tocar los controladores
+type Almacenamiento interface {
+	Guardar(clave string, contenido []byte) error
+	Leer(clave string) ([]byte, error)
+	Eliminar(clave string) error
+}
+
+// AlmacenamientoLocal guarda los archivos bajo un directorio del sistema de archivos
+type AlmacenamientoLocal struct {
+	Directorio string
+}
+
+// NewAlmacenamiento crea el almacenamiento configurado. Por ahora solo existe el local, en el
+// directorio ALMACENAMIENTO_DIR (por defecto ./almacenamiento)
+func NewAlmacenamiento() Almacenamiento {
+	directorio := os.Getenv("ALMACENAMIENTO_DIR")
+	if directorio == "" {
+		directorio = "./almacenamiento"
+	}
+	return &AlmacenamientoLocal{Directorio: directorio}
+}
+
+// ruta traduce la clave a una ruta dentro del directorio, sin permitir salir de él
+func (a *AlmacenamientoLocal) ruta(clave string) (string, error) {
+	limpia := path.Clean("/" + clave)
+	if clave == "" || limpia == "/" || strings.Contains(clave, "\\") {
+		return "", fmt.Errorf("clave de archivo inválida: %q", clave)
+	}
+	return filepath.Join(a.Directorio, filepath.FromSlash(strings.TrimPrefix(limpia, "/"))), nil
+}
+
+// Guardar escribe el archivo en un temporal y lo renombra, para no dejar archivos a medias
+func (a *AlmacenamientoLocal) Guardar(clave string, contenido []byte) error {
+	ruta, err := a.ruta(clave)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ruta), 0o755); err != nil {
+		return err
+	}
+	temporal, err := os.CreateTemp(filepath.Dir(ruta), ".subiendo-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporal.Name())
+	if _, err := temporal.Write(contenido); err != nil {
+		temporal.Close()
+		return err
+	}
+	if err := temporal.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temporal.Name(), ruta)
+}
+
+func (a *AlmacenamientoLocal) Leer(clave string) ([]byte, error) {
+	ruta, err := a.ruta(clave)
+	if err != nil {
+		return nil, err
+	}
+	contenido, err := os.ReadFile(ruta)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrArchivoNoEncontrado
+	}
+	return contenido, err
+}
+
+// Eliminar borra el archivo; no es un error que ya no exista
+func (a *AlmacenamientoLocal) Eliminar(clave string) error {
+	ruta, err := a.ruta(clave)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(ruta); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -37,9 +37,11 @@ type DistanceMatrixResponse struct {
 
 // DistanciaCalculada representa el resultado del cálculo de distancia
 type DistanciaCalculada struct {
-	Distancia      string `json:"distancia"`
-	Duracion       string `json:"duracion"`
-	RutaOptimizada bool   `json:"ruta_optimizada"`
+	Distancia        string `json:"distancia"`
+	Duracion         string `json:"duracion"`
+	DistanciaMetros  int    `json:"distancia_metros"`
+	DuracionSegundos int    `json:"duracion_segundos"`
+	RutaOptimizada   bool   `json:"ruta_optimizada"`
 }
 
 // NewGoogleMapsService crea una nueva instancia del servicio
@@ -110,9 +112,11 @@ func (g *GoogleMapsService) CalcularDistancia(origen, destino string) (*Distanci
 
 	// Crear el resultado
 	resultado := &DistanciaCalculada{
-		Distancia:      element.Distance.Text,
-		Duracion:       element.Duration.Text,
-		RutaOptimizada: true, // Asumimos que Google Maps siempre devuelve la ruta optimizada
+		Distancia:        element.Distance.Text,
+		Duracion:         element.Duration.Text,
+		DistanciaMetros:  element.Distance.Value,
+		DuracionSegundos: element.Duration.Value,
+		RutaOptimizada:   true, // Asumimos que Google Maps siempre devuelve la ruta optimizada
 	}
 
 	return resultado, nil